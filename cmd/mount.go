@@ -506,7 +506,7 @@ var mountCmd = &cobra.Command{
 				signal.Notify(sigusr2, syscall.SIGUSR2)
 
 			} else { // execute in child only
-				daemon.SetSigHandler(sigusrHandler(pipeline, ctx), syscall.SIGUSR1, syscall.SIGUSR2)
+				daemon.SetSigHandler(sigusrHandler(pipeline, ctx), syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP)
 				go func() {
 					_ = daemon.ServeSignals()
 				}()
@@ -676,8 +676,9 @@ func sigusrHandler(pipeline *internal.Pipeline, ctx context.Context) daemon.Sign
 		log.Crit("Mount::sigusrHandler : Signal %d received", sig)
 
 		var err error
-		if sig == syscall.SIGUSR1 {
-			log.Crit("Mount::sigusrHandler : SIGUSR1 received")
+		if sig == syscall.SIGUSR1 || sig == syscall.SIGHUP {
+			// SIGHUP is the conventional reload signal, operators use it to apply rotated credentials (e.g. account key)
+			log.Crit("Mount::sigusrHandler : Config reload signal received")
 			config.OnConfigChange()
 		}
 
@@ -56,6 +56,7 @@ import (
 type containerListingOptions struct {
 	AllowList        []string `config:"container-allowlist"`
 	DenyList         []string `config:"container-denylist"`
+	Prefix           string   `config:"container-prefix"`
 	blobfuse2BinPath string
 }
 
@@ -208,7 +209,7 @@ func getContainerList() ([]string, error) {
 	}
 
 	// Get the list of containers from the component
-	containerList, err = azComponent.ListContainers()
+	containerList, err = azComponent.ListContainers(mountAllOpts.Prefix)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get container list from storage [%s]", err.Error())
 	}
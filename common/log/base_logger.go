@@ -125,6 +125,11 @@ func (l *BaseLogger) Crit(format string, args ...interface{}) {
 	}
 }
 
+// Log : Log at given level irrespective of the configured level
+func (l *BaseLogger) Log(level common.LogLevel, format string, args ...interface{}) {
+	l.logEvent(level.String(), format, args...)
+}
+
 func (l *BaseLogger) SetLogFile(name string) error {
 	l.fileConfig.LogFile = name
 	if l.logFileHandle != nil {
@@ -37,6 +37,7 @@ import (
 	"errors"
 	"log"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Azure/azure-storage-fuse/v2/common"
@@ -61,6 +62,7 @@ type Logger interface {
 	Warn(format string, args ...interface{})
 	Err(format string, args ...interface{})
 	Crit(format string, args ...interface{})
+	Log(level common.LogLevel, format string, args ...interface{})
 	LogRotate() error
 }
 
@@ -105,6 +107,9 @@ func NewLogger(name string, config common.LogConfig) (Logger, error) {
 var logObj Logger
 var timeTracker bool
 
+// Log level overrides keyed on lower cased operation name
+var operationLevels atomic.Pointer[map[string]common.LogLevel]
+
 // ------------------ Public methods to use logging lib ------------------
 
 func GetLoggerObj() *log.Logger {
@@ -183,6 +188,42 @@ func SetLogLevel(lvl common.LogLevel) {
 	}
 }
 
+// SetOperationLogLevels : Log the given operations at their own level instead of the global one.
+// Operation is the method name in the "Class::Method : message" prefix of a log line, matched case insensitive.
+func SetOperationLogLevels(levels map[string]common.LogLevel) {
+	if len(levels) == 0 {
+		operationLevels.Store(nil)
+		return
+	}
+
+	overrides := make(map[string]common.LogLevel, len(levels))
+	for op, lvl := range levels {
+		overrides[strings.ToLower(op)] = lvl
+	}
+	operationLevels.Store(&overrides)
+}
+
+// operationLevel : Level override for the operation which logged this message, if any
+func operationLevel(msg string) (common.LogLevel, bool) {
+	overrides := operationLevels.Load()
+	if overrides == nil {
+		return common.ELogLevel.INVALID(), false
+	}
+
+	end := strings.Index(msg, " : ")
+	if end < 0 {
+		return common.ELogLevel.INVALID(), false
+	}
+
+	op := msg[:end]
+	if i := strings.LastIndex(op, "::"); i >= 0 {
+		op = op[i+2:]
+	}
+
+	lvl, ok := (*overrides)[strings.ToLower(op)]
+	return lvl, ok
+}
+
 // Destroy : DeInitialize the logging library
 func Destroy() error {
 	return logObj.Destroy()
@@ -192,31 +233,67 @@ func Destroy() error {
 
 // Debug : Debug message logging
 func Debug(msg string, args ...interface{}) {
+	if lvl, ok := operationLevel(msg); ok {
+		if lvl >= common.ELogLevel.LOG_DEBUG() {
+			logObj.Log(common.ELogLevel.LOG_DEBUG(), msg, args...)
+		}
+		return
+	}
 	logObj.Debug(msg, args...)
 }
 
 // Trace : Trace message logging
 func Trace(msg string, args ...interface{}) {
+	if lvl, ok := operationLevel(msg); ok {
+		if lvl >= common.ELogLevel.LOG_TRACE() {
+			logObj.Log(common.ELogLevel.LOG_TRACE(), msg, args...)
+		}
+		return
+	}
 	logObj.Trace(msg, args...)
 }
 
 // Info : Info message logging
 func Info(msg string, args ...interface{}) {
+	if lvl, ok := operationLevel(msg); ok {
+		if lvl >= common.ELogLevel.LOG_INFO() {
+			logObj.Log(common.ELogLevel.LOG_INFO(), msg, args...)
+		}
+		return
+	}
 	logObj.Info(msg, args...)
 }
 
 // Warn : Warning message logging
 func Warn(msg string, args ...interface{}) {
+	if lvl, ok := operationLevel(msg); ok {
+		if lvl >= common.ELogLevel.LOG_WARNING() {
+			logObj.Log(common.ELogLevel.LOG_WARNING(), msg, args...)
+		}
+		return
+	}
 	logObj.Warn(msg, args...)
 }
 
 // Err : Error message logging
 func Err(msg string, args ...interface{}) {
+	if lvl, ok := operationLevel(msg); ok {
+		if lvl >= common.ELogLevel.LOG_ERR() {
+			logObj.Log(common.ELogLevel.LOG_ERR(), msg, args...)
+		}
+		return
+	}
 	logObj.Err(msg, args...)
 }
 
 // Crit : Critical message logging
 func Crit(msg string, args ...interface{}) {
+	if lvl, ok := operationLevel(msg); ok {
+		if lvl >= common.ELogLevel.LOG_CRIT() {
+			logObj.Log(common.ELogLevel.LOG_CRIT(), msg, args...)
+		}
+		return
+	}
 	logObj.Crit(msg, args...)
 }
 
@@ -34,6 +34,8 @@
 package log
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/Azure/azure-storage-fuse/v2/common"
@@ -110,6 +112,43 @@ func (lts *LoggerTestSuite) TestBaseLogger() {
 	assert.Nil(err, "Failed to release base logger")
 }
 
+func (lts *LoggerTestSuite) TestOperationLogLevel() {
+	assert := assert.New(lts.T())
+
+	logFile := filepath.Join(lts.T().TempDir(), "oplog.txt")
+	cfg := common.LogConfig{
+		FilePath: logFile,
+		Level:    common.ELogLevel.LOG_INFO(),
+	}
+	err := SetDefaultLogger("base", cfg)
+	assert.Nil(err, "Failed to set base logger")
+
+	SetOperationLogLevels(map[string]common.LogLevel{
+		"List":    common.ELogLevel.LOG_DEBUG(),
+		"GetAttr": common.ELogLevel.LOG_ERR(),
+	})
+	defer SetOperationLogLevels(nil)
+
+	Debug("BlockBlob::List : overridden debug")
+	Debug("BlockBlob::ReadDir : global debug")
+	Info("BlockBlob::ReadDir : global info")
+	Info("Datalake::GetAttr : quieted info")
+	Err("Datalake::GetAttr : quieted err")
+
+	err = Destroy()
+	assert.Nil(err, "Failed to release base logger")
+
+	data, err := os.ReadFile(logFile)
+	assert.Nil(err)
+	out := string(data)
+	assert.Contains(out, "LOG_DEBUG [logger_test.go")
+	assert.Contains(out, "overridden debug")
+	assert.NotContains(out, "global debug")
+	assert.Contains(out, "global info")
+	assert.NotContains(out, "quieted info")
+	assert.Contains(out, "quieted err")
+}
+
 func (lts *LoggerTestSuite) TestSilentLogger() {
 	assert := assert.New(lts.T())
 
@@ -78,6 +78,10 @@ func (*SilentLogger) Crit(_ string, _ ...interface{}) {
 
 }
 
+func (*SilentLogger) Log(_ common.LogLevel, _ string, _ ...interface{}) {
+
+}
+
 func (*SilentLogger) LogRotate() error {
 	return nil
 }
@@ -159,6 +159,11 @@ func (l *SysLogger) Crit(format string, args ...interface{}) {
 	}
 }
 
+// Log : Log at given level irrespective of the configured level
+func (l *SysLogger) Log(level common.LogLevel, format string, args ...interface{}) {
+	l.write(level.String(), format, args...)
+}
+
 // Methods not needed for syslog based logging
 func (l *SysLogger) SetLogFile(name string) error {
 	return nil
@@ -63,10 +63,13 @@ const (
 	DefaultDirectoryPermissionBits  os.FileMode = 0775
 	DefaultAllowOtherPermissionBits os.FileMode = 0777
 
-	MbToBytes     = 1024 * 1024
-	GbToBytes     = 1024 * MbToBytes
-	BfuseStats    = "blobfuse_stats"
-	BlockIDLength = 16
+	MbToBytes  = 1024 * 1024
+	GbToBytes  = 1024 * MbToBytes
+	BfuseStats = "blobfuse_stats"
+
+	DefaultBlockIDLength = 16
+	MinBlockIDLength     = 8
+	MaxBlockIDLength     = 64
 
 	FuseAllowedFlags = "invalid FUSE options. Allowed FUSE configurations are: `-o attr_timeout=TIMEOUT`, `-o negative_timeout=TIMEOUT`, `-o entry_timeout=TIMEOUT` `-o allow_other`, `-o allow_root`, `-o umask=PERMISSIONS -o default_permissions`, `-o ro`"
 
@@ -237,7 +240,8 @@ func (bol BlockOffsetList) FindBlocks(offset, length int64) ([]*Block, bool) {
 		return blocks, false
 	}
 	for _, blk := range bol.BlockList[index:] {
-		if blk.StartIndex > offset+length {
+		// blocks starting at or after the end of the range are not touched by it
+		if blk.StartIndex > offset && blk.StartIndex >= offset+length {
 			break
 		}
 		if currentBlockOffset >= blk.StartIndex && currentBlockOffset < blk.EndIndex && currentBlockOffset <= offset+length {
@@ -260,7 +264,8 @@ func (bol BlockOffsetList) FindBlocksToModify(offset, length int64) (int, int64,
 	}
 	// after the binary search just iterate to find the remaining blocks
 	for _, blk := range bol.BlockList[index:] {
-		if blk.StartIndex > offset+length {
+		// blocks starting at or after the end of the range are not touched by it
+		if blk.StartIndex > offset && blk.StartIndex >= offset+length {
 			break
 		}
 		if currentBlockOffset >= blk.StartIndex && currentBlockOffset < blk.EndIndex && currentBlockOffset <= offset+length {
@@ -288,7 +293,8 @@ func NewUUIDWithLength(length int64) []byte {
 	u := make([]byte, length)
 	// Set all bits to randomly (or pseudo-randomly) chosen values.
 	_, err := rand.Read(u[:])
-	if err == nil {
+	// ids shorter than a uuid stay fully random
+	if err == nil && length > 8 {
 		u[8] = (u[8] | 0x40) & 0x7F // u.setVariant(ReservedRFC4122)
 		var version byte = 4
 		u[6] = (u[6] & 0xF) | (version << 4) // u.setVersion(4)
@@ -309,6 +315,9 @@ func NewUUID() (u uuid) {
 	return
 }
 
+// Length in bytes (before base64 encoding) of block ids generated for new blocks, set from block-id-length config
+var BlockIDLength int64 = DefaultBlockIDLength
+
 // returns block id of given length
 func GetBlockID(len int64) string {
 	return base64.StdEncoding.EncodeToString(NewUUIDWithLength(len))
@@ -98,6 +98,40 @@ func (suite *typesTestSuite) TestFindBlocksToModify() {
 	suite.assert.Equal(appendOnly, true)
 }
 
+func (suite *typesTestSuite) TestFindBlocksToModifyStraddle() {
+	blocksList := []*Block{
+		{StartIndex: 0, EndIndex: 4},
+		{StartIndex: 4, EndIndex: 8},
+		{StartIndex: 8, EndIndex: 12},
+		{StartIndex: 12, EndIndex: 16},
+	}
+	bol := BlockOffsetList{
+		BlockList: blocksList,
+	}
+
+	// range ending exactly on a block boundary does not touch the next block
+	index, size, largerThanFile, appendOnly := bol.FindBlocksToModify(4, 4)
+	suite.assert.Equal(1, index)
+	suite.assert.Equal(int64(4), size)
+	suite.assert.False(largerThanFile)
+	suite.assert.False(appendOnly)
+	suite.assert.False(blocksList[2].Dirty())
+
+	// range straddling a boundary touches only the two adjacent blocks
+	index, size, largerThanFile, _ = bol.FindBlocksToModify(6, 4)
+	suite.assert.Equal(1, index)
+	suite.assert.Equal(int64(8), size)
+	suite.assert.False(largerThanFile)
+	suite.assert.False(blocksList[0].Dirty())
+	suite.assert.True(blocksList[1].Dirty())
+	suite.assert.True(blocksList[2].Dirty())
+	suite.assert.False(blocksList[3].Dirty())
+
+	blocks, found := bol.FindBlocks(6, 4)
+	suite.assert.True(found)
+	suite.assert.Len(blocks, 2)
+}
+
 func (suite *typesTestSuite) TestDefaultWorkDir() {
 	val, err := os.UserHomeDir()
 	suite.assert.Nil(err)
@@ -55,8 +55,10 @@ const defaultAttrCacheTimeout uint32 = (120)
 type AttrCache struct {
 	internal.BaseComponent
 	cacheTimeout uint32
+	revalidate   uint32
 	noSymlinks   bool
 	maxFiles     int
+	disabled     bool // every GetAttr goes to next component and nothing is cached
 	cacheMap     map[string]*attrCacheItem
 	cacheLock    sync.RWMutex
 }
@@ -64,8 +66,10 @@ type AttrCache struct {
 // Structure defining your config parameters
 type AttrCacheOptions struct {
 	Timeout       uint32 `config:"timeout-sec" yaml:"timeout-sec,omitempty"`
+	Revalidate    uint32 `config:"revalidate-after-sec" yaml:"revalidate-after-sec,omitempty"`
 	NoCacheOnList bool   `config:"no-cache-on-list" yaml:"no-cache-on-list,omitempty"`
 	NoSymlinks    bool   `config:"no-symlinks" yaml:"no-symlinks,omitempty"`
+	Enabled       bool   `config:"attr-cache-enabled" yaml:"attr-cache-enabled,omitempty"`
 
 	//maximum file attributes overall to be cached
 	MaxFiles int `config:"max-files" yaml:"max-files,omitempty"`
@@ -149,6 +153,12 @@ func (ac *AttrCache) Configure(_ bool) error {
 		ac.cacheTimeout = defaultAttrCacheTimeout
 	}
 
+	if config.IsSet(compName + ".revalidate-after-sec") {
+		ac.revalidate = conf.Revalidate
+	} else {
+		ac.revalidate = 0
+	}
+
 	if config.IsSet(compName + ".max-files") {
 		ac.maxFiles = conf.MaxFiles
 	} else {
@@ -159,8 +169,11 @@ func (ac *AttrCache) Configure(_ bool) error {
 		ac.noSymlinks = conf.NoSymlinks
 	}
 
-	log.Crit("AttrCache::Configure : cache-timeout %d, symlink %t, max-files %d",
-		ac.cacheTimeout, ac.noSymlinks, ac.maxFiles)
+	// Caching is on unless explicitly turned off
+	ac.disabled = config.IsSet(compName+".attr-cache-enabled") && !conf.Enabled
+
+	log.Crit("AttrCache::Configure : cache-timeout %d, revalidate-after %d, symlink %t, max-files %d, disabled %t",
+		ac.cacheTimeout, ac.revalidate, ac.noSymlinks, ac.maxFiles, ac.disabled)
 
 	return nil
 }
@@ -265,6 +278,10 @@ func (ac *AttrCache) CreateDir(options internal.CreateDirOptions) error {
 func (ac *AttrCache) DeleteDir(options internal.DeleteDirOptions) error {
 	log.Trace("AttrCache::DeleteDir : %s", options.Name)
 
+	if options.DryRun {
+		return ac.NextComponent().DeleteDir(options)
+	}
+
 	deletionTime := time.Now()
 	err := ac.NextComponent().DeleteDir(options)
 
@@ -304,7 +321,7 @@ func (ac *AttrCache) StreamDir(options internal.StreamDirOptions) ([]*internal.O
 // cacheAttributes : On dir listing cache the attributes for all files
 func (ac *AttrCache) cacheAttributes(pathList []*internal.ObjAttr) {
 	// Check whether or not we are supposed to cache on list
-	if len(pathList) > 0 {
+	if !ac.disabled && len(pathList) > 0 {
 		// Putting this inside loop is heavy as for each item we will do a kernel call to get current time
 		// If there are millions of blobs then cost of this is very high.
 		currTime := time.Now()
@@ -328,6 +345,10 @@ func (ac *AttrCache) cacheAttributes(pathList []*internal.ObjAttr) {
 func (ac *AttrCache) RenameDir(options internal.RenameDirOptions) error {
 	log.Trace("AttrCache::RenameDir : %s -> %s", options.Src, options.Dst)
 
+	if options.DryRun {
+		return ac.NextComponent().RenameDir(options)
+	}
+
 	deletionTime := time.Now()
 	err := ac.NextComponent().RenameDir(options)
 
@@ -491,18 +512,28 @@ func (ac *AttrCache) SyncDir(options internal.SyncDirOptions) error {
 // GetAttr : Try to serve the request from the attribute cache, otherwise cache attributes of the path returned by next component
 func (ac *AttrCache) GetAttr(options internal.GetAttrOptions) (*internal.ObjAttr, error) {
 	log.Trace("AttrCache::GetAttr : %s", options.Name)
+	if ac.disabled {
+		return ac.NextComponent().GetAttr(options)
+	}
 	truncatedPath := internal.TruncateDirName(options.Name)
 
+	// Revalidation refreshes cachedAt of the item under the lock, so its age is taken under the lock as well
 	ac.cacheLock.RLock()
 	value, found := ac.cacheMap[truncatedPath]
+	var age time.Duration
+	if found {
+		age = time.Since(value.cachedAt)
+	}
 	ac.cacheLock.RUnlock()
 
 	// Try to serve the request from the attribute cache
-	if found && value.valid() && time.Since(value.cachedAt).Seconds() < float64(ac.cacheTimeout) {
+	if found && value.valid() && age.Seconds() < float64(ac.cacheTimeout) {
 		if value.isDeleted() {
 			log.Debug("AttrCache::GetAttr : %s served from cache", options.Name)
 			// no entry if path does not exist
 			return &internal.ObjAttr{}, syscall.ENOENT
+		} else if ac.needsRevalidation(value, age) {
+			return ac.revalidateAttr(options, truncatedPath, value)
 		} else {
 			log.Debug("AttrCache::GetAttr : %s served from cache", options.Name)
 			return value.getAttr(), nil
@@ -530,6 +561,46 @@ func (ac *AttrCache) GetAttr(options internal.GetAttrOptions) (*internal.ObjAttr
 	return pathAttr, err
 }
 
+// needsRevalidation : Cached item is older than revalidate-after-sec and has an ETag to validate against
+func (ac *AttrCache) needsRevalidation(value *attrCacheItem, age time.Duration) bool {
+	return ac.revalidate > 0 &&
+		age.Seconds() >= float64(ac.revalidate) &&
+		value.getAttr().ETag != ""
+}
+
+// revalidateAttr : Conditionally fetch the attributes using the cached ETag.
+// If object is unchanged the cached item is refreshed, otherwise new attributes are cached.
+func (ac *AttrCache) revalidateAttr(options internal.GetAttrOptions, truncatedPath string, value *attrCacheItem) (*internal.ObjAttr, error) {
+	cached := value.getAttr()
+	options.IfNoneMatch = cached.ETag
+
+	pathAttr, err := ac.NextComponent().GetAttr(options)
+
+	ac.cacheLock.Lock()
+	defer ac.cacheLock.Unlock()
+
+	switch err {
+	case internal.ErrNotModified:
+		log.Debug("AttrCache::GetAttr : %s not modified, served from cache", options.Name)
+		value.cachedAt = time.Now()
+		return cached, nil
+
+	case nil:
+		log.Debug("AttrCache::GetAttr : %s changed, refreshing cache", options.Name)
+		ac.cacheMap[truncatedPath] = newAttrCacheItem(pathAttr, true, time.Now())
+		return pathAttr, nil
+
+	case syscall.ENOENT:
+		ac.cacheMap[truncatedPath] = newAttrCacheItem(&internal.ObjAttr{}, false, time.Now())
+		return pathAttr, err
+
+	default:
+		// Cached item is still within timeout, serve it rather than failing the call
+		log.Err("AttrCache::GetAttr : Failed to revalidate %s, served from cache [%s]", options.Name, err.Error())
+		return cached, nil
+	}
+}
+
 // CreateLink : Mark the link and target invalid
 func (ac *AttrCache) CreateLink(options internal.CreateLinkOptions) error {
 	log.Trace("AttrCache::CreateLink : Create symlink %s -> %s", options.Name, options.Target)
@@ -578,6 +649,21 @@ func (ac *AttrCache) Chmod(options internal.ChmodOptions) error {
 	return err
 }
 
+// SetXAttr : Mark the path invalid as its metadata changed
+func (ac *AttrCache) SetXAttr(options internal.SetXAttrOptions) error {
+	log.Trace("AttrCache::SetXAttr : Set %s of %s", options.Attr, options.Name)
+
+	err := ac.NextComponent().SetXAttr(options)
+
+	if err == nil {
+		ac.cacheLock.RLock()
+		defer ac.cacheLock.RUnlock()
+		ac.invalidatePath(options.Name)
+	}
+
+	return err
+}
+
 // Chown : Update the file with its new owner and group (when datalake chown is implemented)
 func (ac *AttrCache) Chown(options internal.ChownOptions) error {
 	log.Trace("AttrCache::Chown : Change owner of file/directory %s", options.Name)
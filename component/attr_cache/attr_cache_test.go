@@ -42,6 +42,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
@@ -263,6 +264,37 @@ func (suite *attrCacheTestSuite) TestConfigZero() {
 	suite.assert.Equal(suite.attrCache.noSymlinks, true)
 }
 
+// Tests every GetAttr reaches next component when caching is disabled
+func (suite *attrCacheTestSuite) TestCacheDisabled() {
+	defer suite.cleanupTest()
+	suite.cleanupTest() // clean up the default attr cache generated
+	config := "attr_cache:\n  attr-cache-enabled: false"
+	suite.setupTestHelper(config) // setup a new attr cache with a custom config (clean up will occur after the test as usual)
+	suite.assert.True(suite.attrCache.disabled)
+
+	path := "a"
+	listOptions := internal.ReadDirOptions{Name: path}
+	suite.mock.EXPECT().ReadDir(listOptions).Return(generateNestedPathAttr(path, 1024, os.FileMode(0)), nil)
+	_, err := suite.attrCache.ReadDir(listOptions)
+	suite.assert.Nil(err)
+	suite.assert.Empty(suite.attrCache.cacheMap)
+
+	options := internal.GetAttrOptions{Name: path + "/b"}
+	suite.mock.EXPECT().GetAttr(options).Return(getPathAttr(options.Name, 1024, 0644, false), nil).Times(3)
+	for i := 0; i < 3; i++ {
+		_, err = suite.attrCache.GetAttr(options)
+		suite.assert.Nil(err)
+	}
+
+	missing := internal.GetAttrOptions{Name: path + "/missing"}
+	suite.mock.EXPECT().GetAttr(missing).Return(nil, syscall.ENOENT).Times(2)
+	for i := 0; i < 2; i++ {
+		_, err = suite.attrCache.GetAttr(missing)
+		suite.assert.Equal(syscall.ENOENT, err)
+	}
+	suite.assert.Empty(suite.attrCache.cacheMap)
+}
+
 // Tests Create Directory
 func (suite *attrCacheTestSuite) TestCreateDir() {
 	defer suite.cleanupTest()
@@ -352,6 +384,27 @@ func (suite *attrCacheTestSuite) TestDeleteDir() {
 	}
 }
 
+func (suite *attrCacheTestSuite) TestDirOpDryRun() {
+	defer suite.cleanupTest()
+	path := "a"
+	a, ab, ac := addDirectoryToCache(suite.assert, suite.attrCache, path, false)
+
+	deleteOptions := internal.DeleteDirOptions{Name: path, DryRun: true, Preview: &internal.DirOpPreview{}}
+	suite.mock.EXPECT().DeleteDir(deleteOptions).Return(nil)
+	renameOptions := internal.RenameDirOptions{Src: path, Dst: "z", DryRun: true, Preview: &internal.DirOpPreview{}}
+	suite.mock.EXPECT().RenameDir(renameOptions).Return(nil)
+
+	suite.assert.Nil(suite.attrCache.DeleteDir(deleteOptions))
+	suite.assert.Nil(suite.attrCache.RenameDir(renameOptions))
+
+	// Nothing was deleted so nothing cached is touched
+	a.PushBackList(ab)
+	a.PushBackList(ac)
+	for p := a.Front(); p != nil; p = p.Next() {
+		assertUntouched(suite, internal.TruncateDirName(p.Value.(string)))
+	}
+}
+
 // Tests Read Directory
 func (suite *attrCacheTestSuite) TestReadDirDoesNotExist() {
 	defer suite.cleanupTest()
@@ -557,6 +610,29 @@ func (suite *attrCacheTestSuite) TestCreateFile() {
 	assertInvalid(suite, path)
 }
 
+// Tests SetXAttr
+func (suite *attrCacheTestSuite) TestSetXAttr() {
+	defer suite.cleanupTest()
+	path := "a"
+
+	options := internal.SetXAttrOptions{Name: path, Attr: "user.tag", Value: []byte("v")}
+
+	// Error keeps the cached entry
+	addPathToCache(suite.assert, suite.attrCache, path, false)
+	suite.mock.EXPECT().SetXAttr(options).Return(errors.New("Failed to set xattr"))
+
+	err := suite.attrCache.SetXAttr(options)
+	suite.assert.NotNil(err)
+	assertUntouched(suite, path)
+
+	// Success invalidates it as metadata changed
+	suite.mock.EXPECT().SetXAttr(options).Return(nil)
+
+	err = suite.attrCache.SetXAttr(options)
+	suite.assert.Nil(err)
+	assertInvalid(suite, path)
+}
+
 // Tests Delete File
 func (suite *attrCacheTestSuite) TestDeleteFile() {
 	defer suite.cleanupTest()
@@ -1069,6 +1145,102 @@ func (suite *attrCacheTestSuite) TestCacheTimeout() {
 	suite.assert.Nil(err)
 }
 
+// Tests conditional revalidation of cached attributes
+func (suite *attrCacheTestSuite) TestCacheRevalidateNotModified() {
+	defer suite.cleanupTest()
+	suite.cleanupTest() // clean up the default attr cache generated
+	config := "attr_cache:\n  timeout-sec: 120\n  revalidate-after-sec: 1"
+	suite.setupTestHelper(config) // setup a new attr cache with a custom config (clean up will occur after the test as usual)
+	suite.assert.EqualValues(1, suite.attrCache.revalidate)
+
+	path := "a"
+	options := internal.GetAttrOptions{Name: path}
+	attr := getPathAttr(path, defaultSize, fs.FileMode(defaultMode), true)
+	attr.ETag = "etag1"
+	suite.mock.EXPECT().GetAttr(options).Return(attr, nil)
+
+	_, err := suite.attrCache.GetAttr(options)
+	suite.assert.Nil(err)
+
+	// Within revalidate interval, served from cache without calling next component
+	_, err = suite.attrCache.GetAttr(options)
+	suite.assert.Nil(err)
+
+	time.Sleep(time.Second)
+
+	// Conditional check returns not modified, cached attributes are served and refreshed
+	suite.mock.EXPECT().GetAttr(internal.GetAttrOptions{Name: path, IfNoneMatch: "etag1"}).Return(nil, internal.ErrNotModified)
+	result, err := suite.attrCache.GetAttr(options)
+	suite.assert.Nil(err)
+	suite.assert.Equal(attr, result)
+	suite.assert.Less(time.Since(suite.attrCache.cacheMap[path].cachedAt), time.Second)
+
+	// Cache item was refreshed, so no further call is needed
+	_, err = suite.attrCache.GetAttr(options)
+	suite.assert.Nil(err)
+}
+
+// Tests concurrent lookups of an item which is being revalidated
+func (suite *attrCacheTestSuite) TestCacheRevalidateConcurrent() {
+	defer suite.cleanupTest()
+	suite.cleanupTest() // clean up the default attr cache generated
+	config := "attr_cache:\n  timeout-sec: 120\n  revalidate-after-sec: 1"
+	suite.setupTestHelper(config) // setup a new attr cache with a custom config (clean up will occur after the test as usual)
+
+	path := "a"
+	options := internal.GetAttrOptions{Name: path}
+	attr := getPathAttr(path, defaultSize, fs.FileMode(defaultMode), true)
+	attr.ETag = "etag1"
+	suite.mock.EXPECT().GetAttr(options).Return(attr, nil)
+
+	_, err := suite.attrCache.GetAttr(options)
+	suite.assert.Nil(err)
+
+	time.Sleep(time.Second)
+
+	suite.mock.EXPECT().GetAttr(internal.GetAttrOptions{Name: path, IfNoneMatch: "etag1"}).Return(nil, internal.ErrNotModified).MinTimes(1)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := suite.attrCache.GetAttr(options)
+			suite.assert.Nil(err)
+			suite.assert.Equal(attr, result)
+		}()
+	}
+	wg.Wait()
+}
+
+func (suite *attrCacheTestSuite) TestCacheRevalidateModified() {
+	defer suite.cleanupTest()
+	suite.cleanupTest() // clean up the default attr cache generated
+	config := "attr_cache:\n  timeout-sec: 120\n  revalidate-after-sec: 1"
+	suite.setupTestHelper(config) // setup a new attr cache with a custom config (clean up will occur after the test as usual)
+
+	path := "a"
+	options := internal.GetAttrOptions{Name: path}
+	attr := getPathAttr(path, defaultSize, fs.FileMode(defaultMode), true)
+	attr.ETag = "etag1"
+	suite.mock.EXPECT().GetAttr(options).Return(attr, nil)
+
+	_, err := suite.attrCache.GetAttr(options)
+	suite.assert.Nil(err)
+
+	time.Sleep(time.Second)
+
+	// Blob was modified by another client
+	changed := getPathAttr(path, defaultSize*2, fs.FileMode(defaultMode), true)
+	changed.ETag = "etag2"
+	suite.mock.EXPECT().GetAttr(internal.GetAttrOptions{Name: path, IfNoneMatch: "etag1"}).Return(changed, nil)
+
+	result, err := suite.attrCache.GetAttr(options)
+	suite.assert.Nil(err)
+	suite.assert.EqualValues(defaultSize*2, result.Size)
+	suite.assert.EqualValues("etag2", suite.attrCache.cacheMap[path].attr.ETag)
+	suite.assert.EqualValues(defaultSize*2, suite.attrCache.cacheMap[path].attr.Size)
+}
+
 // Tests CreateLink
 func (suite *attrCacheTestSuite) TestCreateLink() {
 	defer suite.cleanupTest()
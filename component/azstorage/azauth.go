@@ -65,11 +65,20 @@ type azAuthConfig struct {
 	OAuthTokenFilePath      string
 	WorkloadIdentityToken   string
 	ActiveDirectoryEndpoint string
+	ClientCertPath          string
+	ClientCertPassword      string
 
 	// Client assertions config
 	// This will need ApplicationID, TenantID and ClientID as well
 	UserAssertion string
 
+	// Federated token file, used for client assertions instead of ApplicationID when set
+	TokenFilePath string
+
+	// On disk token cache for MSI and SPN, encrypted with a key derived from the passphrase
+	TokenCachePath       string
+	TokenCachePassphrase string
+
 	// Auth resource / security scope for OAuth
 	AuthResource string
 
@@ -35,6 +35,11 @@ package azstorage
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
@@ -53,9 +58,68 @@ type azAuthWorkloadIdentity struct {
 	azOAuthBase
 }
 
+// federatedTokenTTL : Assertion read from the token file is reused for this long before the file is read again
+const federatedTokenTTL = time.Minute
+
+// federatedTokenFile : Client assertion coming from a projected service account token file.
+// Kubernetes rotates the file periodically, so it is read again once the cached assertion is older than ttl.
+type federatedTokenFile struct {
+	path   string
+	ttl    time.Duration
+	lock   sync.Mutex
+	token  string
+	readAt time.Time
+}
+
+func (f *federatedTokenFile) getAssertion(context.Context) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.token != "" && time.Since(f.readAt) < f.ttl {
+		return f.token, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		log.Err("federatedTokenFile::getAssertion : Failed to read token file %s [%s]", f.path, err.Error())
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		log.Err("federatedTokenFile::getAssertion : Token file %s is empty", f.path)
+		return "", fmt.Errorf("token file %s is empty", f.path)
+	}
+
+	f.token = token
+	f.readAt = time.Now()
+	return f.token, nil
+}
+
+// validateTokenFile : Token file is read only when a token is needed, so check upfront that it can be used
+func validateTokenFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read token-file-path %s [%s]", path, err.Error())
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return fmt.Errorf("token-file-path %s is empty", path)
+	}
+	return nil
+}
+
 func (azWorkloadIdentity *azAuthWorkloadIdentity) getTokenCredential() (azcore.TokenCredential, error) {
 	opts := azWorkloadIdentity.getAzIdentityClientOptions(&azWorkloadIdentity.config)
 
+	if azWorkloadIdentity.config.TokenFilePath != "" {
+		log.Trace("azAuthWorkloadIdentity::getTokenCredential : Using federated token file %s", azWorkloadIdentity.config.TokenFilePath)
+		tokenFile := &federatedTokenFile{
+			path: azWorkloadIdentity.config.TokenFilePath,
+			ttl:  federatedTokenTTL,
+		}
+		return azWorkloadIdentity.getAssertionCredential(opts, tokenFile.getAssertion)
+	}
+
 	// Create MSI cred to fetch token
 	msiOpts := &azidentity.ManagedIdentityCredentialOptions{
 		ClientOptions: opts,
@@ -85,6 +149,11 @@ func (azWorkloadIdentity *azAuthWorkloadIdentity) getTokenCredential() (azcore.T
 		return token.Token, nil
 	}
 
+	return azWorkloadIdentity.getAssertionCredential(opts, getClientAssertions)
+}
+
+// getAssertionCredential : exchange the client assertion for a token, on behalf of the user if a user assertion is configured
+func (azWorkloadIdentity *azAuthWorkloadIdentity) getAssertionCredential(opts azcore.ClientOptions, getClientAssertions func(context.Context) (string, error)) (azcore.TokenCredential, error) {
 	if azWorkloadIdentity.config.UserAssertion == "" {
 		assertOpts := &azidentity.ClientAssertionCredentialOptions{
 			ClientOptions: opts,
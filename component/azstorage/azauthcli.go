@@ -34,7 +34,11 @@
 package azstorage
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
 	serviceBfs "github.com/Azure/azure-sdk-for-go/sdk/storage/azdatalake/service"
@@ -50,8 +54,31 @@ type azAuthCLI struct {
 }
 
 func (azcli *azAuthCLI) getTokenCredential() (azcore.TokenCredential, error) {
-	cred, err := azidentity.NewAzureCLICredential(nil)
-	return cred, err
+	opts := &azidentity.AzureCLICredentialOptions{}
+	if azcli.config.TenantID != "" {
+		opts.TenantID = azcli.config.TenantID
+	}
+
+	cred, err := azidentity.NewAzureCLICredential(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &azCLICredential{cred: cred}, nil
+}
+
+// azCLICredential : Token is fetched by running the CLI on first use, which happens on TestPipeline.
+// The error coming back from the CLI is annotated so that a missing install or login is easy to spot.
+type azCLICredential struct {
+	cred azcore.TokenCredential
+}
+
+func (c *azCLICredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	token, err := c.cred.GetToken(ctx, opts)
+	if err != nil {
+		log.Err("azCLICredential::GetToken : Failed to get token from Azure CLI [%s]", err.Error())
+		return token, fmt.Errorf("failed to get token from Azure CLI, make sure it is installed and 'az login' is done: %w", err)
+	}
+	return token, nil
 }
 
 type azAuthBlobCLI struct {
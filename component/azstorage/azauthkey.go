@@ -51,6 +51,13 @@ type azAuthKey struct {
 	azAuthBase
 }
 
+// setOption : Update the account key, used when the key is rotated without a remount
+func (azkey *azAuthKey) setOption(key, value string) {
+	if key == "accountkey" {
+		azkey.config.AccountKey = value
+	}
+}
+
 type azAuthBlobKey struct {
 	azAuthKey
 }
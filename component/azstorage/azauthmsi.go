@@ -70,7 +70,11 @@ func (azmsi *azAuthMSI) getTokenCredential() (azcore.TokenCredential, error) {
 	}
 
 	cred, err := azidentity.NewManagedIdentityCredential(msiOpts)
-	return cred, err
+	if err != nil {
+		return nil, err
+	}
+
+	return withTokenCache(cred, &azmsi.config, azmsi.config.ApplicationID+azmsi.config.ResourceID+azmsi.config.ObjectID), nil
 }
 
 /*
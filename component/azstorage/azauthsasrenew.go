@@ -0,0 +1,207 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2025 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package azstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+)
+
+// Fraction of the SAS lifetime after which a new SAS is fetched
+const sasRenewAt = 0.9
+
+// Time allowed for the renew command to produce the new SAS
+const sasRenewCommandTimeout = time.Minute
+
+// Backoff between failed renew attempts, doubled on every failure till the max
+var sasRenewRetryMin = 10 * time.Second
+var sasRenewRetryMax = 5 * time.Minute
+
+// sasLifetime : Get the start and expiry time of the SAS from its "st" and "se" query params.
+// Start time is optional in a SAS, zero time is returned when it is not present.
+func sasLifetime(sas string) (time.Time, time.Time, error) {
+	values, err := url.ParseQuery(strings.TrimLeft(sas, "?"))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	parse := func(key string) (time.Time, error) {
+		value := values.Get(key)
+		if value == "" {
+			return time.Time{}, nil
+		}
+		// Service accepts both full ISO 8601 time and date only values
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z", "2006-01-02"} {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid %s in SAS [%s]", key, value)
+	}
+
+	start, err := parse("st")
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	expiry, err := parse("se")
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if expiry.IsZero() {
+		return time.Time{}, time.Time{}, errors.New("SAS does not have an expiry time")
+	}
+
+	return start, expiry, nil
+}
+
+// sasRenewDelay : Time to wait from now before the SAS shall be renewed
+func sasRenewDelay(sas string, now time.Time) (time.Duration, error) {
+	start, expiry, err := sasLifetime(sas)
+	if err != nil {
+		return 0, err
+	}
+
+	// Without a start time the lifetime is counted from the moment the SAS is seen
+	if start.IsZero() || start.After(now) {
+		start = now
+	}
+
+	renewAt := start.Add(time.Duration(float64(expiry.Sub(start)) * sasRenewAt))
+	if renewAt.Before(now) {
+		return 0, nil
+	}
+	return renewAt.Sub(now), nil
+}
+
+// runSASRenewCommand : Run the user provided command and read the new SAS from its stdout
+func runSASRenewCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sasRenewCommandTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "/bin/sh", "-c", command).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("%s [%s]", err.Error(), strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+
+	sas := sanitizeSASKey(strings.TrimSpace(string(out)))
+	if sas == "" {
+		return "", errors.New("command did not print a SAS")
+	}
+
+	// SAS has to live long enough to schedule the next renewal, otherwise the command would be run back to back
+	delay, err := sasRenewDelay(sas, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if delay == 0 {
+		return "", errors.New("command printed a SAS which is already due for renewal")
+	}
+
+	return sas, nil
+}
+
+// renewSAS : Fetch a new SAS and rebuild the storage clients with it
+func (az *AzStorage) renewSAS() error {
+	sas, err := runSASRenewCommand(az.stConfig.sasRenewCommand)
+	if err != nil {
+		return err
+	}
+
+	az.sasLock.Lock()
+	defer az.sasLock.Unlock()
+
+	err = az.storage.UpdateServiceClient("saskey", sas)
+	if err != nil {
+		_ = az.storage.UpdateServiceClient("saskey", az.stConfig.authConfig.SASKey)
+		return err
+	}
+
+	az.stConfig.authConfig.SASKey = sas
+	return nil
+}
+
+// currentSAS : SAS the storage clients are built with
+func (az *AzStorage) currentSAS() string {
+	az.sasLock.Lock()
+	defer az.sasLock.Unlock()
+	return az.stConfig.authConfig.SASKey
+}
+
+// sasRenewLoop : Renew the SAS before it expires till the component is stopped.
+// Failures are retried with a backoff, the mount keeps using the current SAS meanwhile.
+func (az *AzStorage) sasRenewLoop(stop <-chan struct{}) {
+	retry := sasRenewRetryMin
+	for {
+		delay, err := sasRenewDelay(az.currentSAS(), time.Now())
+		if err != nil {
+			log.Err("AzStorage::sasRenewLoop : Failed to get expiry of current SAS, renewing now [%s]", err.Error())
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+
+		for {
+			err = az.renewSAS()
+			if err == nil {
+				break
+			}
+
+			log.Err("AzStorage::sasRenewLoop : Failed to renew SAS, retrying in %v [%s]", retry, err.Error())
+			select {
+			case <-stop:
+				return
+			case <-time.After(retry):
+			}
+			retry = min(2*retry, sasRenewRetryMax)
+		}
+
+		log.Info("AzStorage::sasRenewLoop : SAS renewed")
+		retry = sasRenewRetryMin
+	}
+}
@@ -35,6 +35,7 @@ package azstorage
 
 import (
 	"context"
+	"os"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
@@ -85,6 +86,29 @@ func (azspn *azAuthSPN) getTokenCredential() (azcore.TokenCredential, error) {
 			log.Err("AzAuthSPN::getTokenCredential : Failed to generate token for SPN [%s]", err.Error())
 			return nil, err
 		}
+	} else if azspn.config.ClientCertPath != "" {
+		log.Trace("AzAuthSPN::getTokenCredential : Using client certificate %s for fetching token", azspn.config.ClientCertPath)
+
+		data, err := os.ReadFile(azspn.config.ClientCertPath)
+		if err != nil {
+			log.Err("AzAuthSPN::getTokenCredential : Failed to read client certificate %s [%s]", azspn.config.ClientCertPath, err.Error())
+			return nil, err
+		}
+
+		// Handles both PEM and PKCS#12 (PFX) encoded files
+		certs, key, err := azidentity.ParseCertificates(data, []byte(azspn.config.ClientCertPassword))
+		if err != nil {
+			log.Err("AzAuthSPN::getTokenCredential : Failed to parse client certificate %s [%s]", azspn.config.ClientCertPath, err.Error())
+			return nil, err
+		}
+
+		cred, err = azidentity.NewClientCertificateCredential(azspn.config.TenantID, azspn.config.ClientID, certs, key, &azidentity.ClientCertificateCredentialOptions{
+			ClientOptions: clOpts,
+		})
+		if err != nil {
+			log.Err("AzAuthSPN::getTokenCredential : Failed to generate token for SPN [%s]", err.Error())
+			return nil, err
+		}
 	} else {
 		log.Trace("AzAuthSPN::getTokenCredential : Using client secret for fetching token")
 
@@ -97,7 +121,7 @@ func (azspn *azAuthSPN) getTokenCredential() (azcore.TokenCredential, error) {
 		}
 	}
 
-	return cred, err
+	return withTokenCache(cred, &azspn.config, azspn.config.ClientID), nil
 }
 
 type azAuthBlobSPN struct {
@@ -0,0 +1,183 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2025 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package azstorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+)
+
+// tokenCacheExpiryMargin : Cached token is not handed out when it expires within this duration,
+// so that the caller has enough time to use it
+const tokenCacheExpiryMargin = 5 * time.Minute
+
+// cachedToken : On disk representation of a token, stored encrypted
+type cachedToken struct {
+	Token     string    `json:"token"`
+	ExpiresOn time.Time `json:"expires_on"`
+	Scopes    []string  `json:"scopes"`
+}
+
+// cachedTokenCredential : Token credential which persists the token it gets on disk, so that a remount
+// can reuse a still valid token instead of going to the token endpoint again
+type cachedTokenCredential struct {
+	cred azcore.TokenCredential
+	path string
+	key  []byte
+
+	lock  sync.Mutex
+	token *cachedToken
+}
+
+// Verify that cachedTokenCredential implements the TokenCredential interface
+var _ azcore.TokenCredential = &cachedTokenCredential{}
+
+// newCachedTokenCredential : Wrap the credential with a cache file under dir named after the identity.
+// Token from an earlier mount is loaded right away.
+func newCachedTokenCredential(cred azcore.TokenCredential, dir string, identity string, passphrase string) *cachedTokenCredential {
+	name := sha256.Sum256([]byte(identity))
+	key := sha256.Sum256([]byte(passphrase))
+
+	c := &cachedTokenCredential{
+		cred: cred,
+		path: filepath.Join(dir, hex.EncodeToString(name[:])+".token"),
+		key:  key[:],
+	}
+	c.token = c.load()
+	return c
+}
+
+func (c *cachedTokenCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.token != nil && slices.Equal(c.token.Scopes, opts.Scopes) && time.Until(c.token.ExpiresOn) > tokenCacheExpiryMargin {
+		log.Debug("cachedTokenCredential::GetToken : Using cached token valid till %s", c.token.ExpiresOn.String())
+		return azcore.AccessToken{Token: c.token.Token, ExpiresOn: c.token.ExpiresOn}, nil
+	}
+
+	token, err := c.cred.GetToken(ctx, opts)
+	if err != nil {
+		return token, err
+	}
+
+	c.token = &cachedToken{
+		Token:     token.Token,
+		ExpiresOn: token.ExpiresOn,
+		Scopes:    opts.Scopes,
+	}
+	c.save()
+
+	return token, nil
+}
+
+// load : Read the token cached by an earlier mount, nil is returned if there is no usable one
+func (c *cachedTokenCredential) load() *cachedToken {
+	cipherData, err := os.ReadFile(c.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("cachedTokenCredential::load : Failed to read token cache %s [%s]", c.path, err.Error())
+		}
+		return nil
+	}
+
+	data, err := common.DecryptData(cipherData, c.key)
+	if err != nil {
+		log.Warn("cachedTokenCredential::load : Failed to decrypt token cache %s [%s]", c.path, err.Error())
+		return nil
+	}
+
+	token := &cachedToken{}
+	err = json.Unmarshal(data, token)
+	if err != nil {
+		log.Warn("cachedTokenCredential::load : Failed to parse token cache %s [%s]", c.path, err.Error())
+		return nil
+	}
+
+	log.Info("cachedTokenCredential::load : Loaded cached token valid till %s", token.ExpiresOn.String())
+	return token
+}
+
+// save : Persist the current token, failure is only logged as the token is still usable for this mount
+func (c *cachedTokenCredential) save() {
+	data, err := json.Marshal(c.token)
+	if err != nil {
+		log.Warn("cachedTokenCredential::save : Failed to serialize token [%s]", err.Error())
+		return
+	}
+
+	cipherData, err := common.EncryptData(data, c.key)
+	if err != nil {
+		log.Warn("cachedTokenCredential::save : Failed to encrypt token [%s]", err.Error())
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(c.path), 0700)
+	if err != nil {
+		log.Warn("cachedTokenCredential::save : Failed to create token cache directory [%s]", err.Error())
+		return
+	}
+
+	// Write to a temp file and rename so that a concurrent mount never reads a partial file
+	tmpPath := c.path + ".tmp"
+	err = os.WriteFile(tmpPath, cipherData, 0600)
+	if err == nil {
+		err = os.Rename(tmpPath, c.path)
+	}
+	if err != nil {
+		log.Warn("cachedTokenCredential::save : Failed to write token cache %s [%s]", c.path, err.Error())
+	}
+}
+
+// withTokenCache : Wrap the credential with the on disk token cache when one is configured
+func withTokenCache(cred azcore.TokenCredential, config *azAuthConfig, clientID string) azcore.TokenCredential {
+	if config.TokenCachePath == "" {
+		return cred
+	}
+
+	identity := config.AccountName + "/" + config.TenantID + "/" + clientID
+	return newCachedTokenCredential(cred, config.TokenCachePath, identity, config.TokenCachePassphrase)
+}
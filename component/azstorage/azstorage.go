@@ -34,13 +34,19 @@
 package azstorage
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/config"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
@@ -58,8 +64,37 @@ type AzStorage struct {
 	stConfig    AzStorageConfig
 	startTime   time.Time
 	listBlocked bool
+
+	// Last known result of the health check
+	healthLock      sync.Mutex
+	healthCheckedAt time.Time
+	healthErr       error
+
+	// Closed on stop to end the SAS renewal, stop waits on the group till the renewal has returned
+	sasRenewStop chan struct{}
+	sasRenewWg   sync.WaitGroup
+
+	// Guards the SAS in stConfig, it is replaced by the renewal and by a config reload
+	sasLock sync.Mutex
+
+	// Attributes of paths seen by GetAttr, revalidated by ETag while younger than attr-cache-ttl-sec
+	attrCacheLock sync.Mutex
+	attrCache     map[string]cachedAttr
+
+	// Guards creation of the cache object holding the read-ahead buffer of a handle
+	readAheadLock    sync.Mutex
+	readAheadBuffers *bufferPool
 }
 
+// cachedAttr : Attributes of a path as last returned by the service
+type cachedAttr struct {
+	attr     *internal.ObjAttr
+	cachedAt time.Time
+}
+
+// Most paths kept in the attribute cache, new paths are not cached once it is full of live entries
+const maxCachedAttrs = 100000
+
 const compName = "azstorage"
 
 // Verification to check satisfaction criteria with Component Interface
@@ -97,6 +132,7 @@ reconfigure:
 		log.Err("AzStorage::Configure : Config validation failed [%s]", err.Error())
 		return fmt.Errorf("config error in %s [%s]", az.Name(), err.Error())
 	}
+	az.readAheadBuffers = newBufferPool(az.stConfig.readAheadSize)
 
 	err = az.configureAndTest(isParent)
 	if err != nil {
@@ -146,9 +182,51 @@ func (az *AzStorage) OnConfigChange() {
 	setSDKLogListener()
 }
 
+// UpdateAccountKey : Rebuild the storage clients with a rotated account key, without a remount
+func (az *AzStorage) UpdateAccountKey(newKey string) error {
+	log.Trace("AzStorage::UpdateAccountKey : %s", az.Name())
+
+	if newKey == "" {
+		return fmt.Errorf("account key not provided")
+	}
+
+	oldKey := az.stConfig.authConfig.AccountKey
+	az.stConfig.authConfig.AccountKey = newKey
+	if err := az.storage.UpdateServiceClient("accountkey", newKey); err != nil {
+		log.Err("AzStorage::UpdateAccountKey : Failed to update account key [%s]", err.Error())
+		az.stConfig.authConfig.AccountKey = oldKey
+		_ = az.storage.UpdateServiceClient("accountkey", oldKey)
+		return err
+	}
+
+	log.Info("AzStorage::UpdateAccountKey : Account key updated")
+	return nil
+}
+
 func (az *AzStorage) configureAndTest(isParent bool) error {
+	// Invalid name would otherwise only fail on the first operation with an obscure service error
+	if !az.stConfig.mountAllContainers {
+		for _, name := range []string{az.stConfig.container, az.stConfig.failoverContainer} {
+			if name == "" {
+				continue
+			}
+			err := validateContainerName(name)
+			if err != nil {
+				log.Err("AzStorage::configureAndTest : %s", err.Error())
+				return err
+			}
+		}
+	}
+
 	az.storage = NewAzStorageConnection(az.stConfig)
 
+	if az.stConfig.failoverContainer != "" {
+		secondaryConfig := az.stConfig
+		secondaryConfig.authConfig.Endpoint = az.stConfig.failoverEndpoint
+		secondaryConfig.container = az.stConfig.failoverContainer
+		az.storage = newFailoverConnection(az.storage, NewAzStorageConnection(secondaryConfig), az.stConfig)
+	}
+
 	err := az.storage.SetupPipeline()
 	if err != nil {
 		log.Err("AzStorage::configureAndTest : Failed to create container URL [%s]", err.Error())
@@ -186,19 +264,65 @@ func (az *AzStorage) Start(ctx context.Context) error {
 	// create stats collector for azstorage
 	azStatsCollector = stats_manager.NewStatsCollector(az.Name())
 
+	if az.stConfig.authConfig.AuthMode == EAuthType.SAS() && az.stConfig.sasRenewCommand != "" {
+		az.sasRenewStop = make(chan struct{})
+		az.sasRenewWg.Add(1)
+		go func(stop <-chan struct{}) {
+			defer az.sasRenewWg.Done()
+			az.sasRenewLoop(stop)
+		}(az.sasRenewStop)
+	}
+
 	return nil
 }
 
 // Stop : Disconnect all running operations here
 func (az *AzStorage) Stop() error {
 	log.Trace("AzStorage::Stop : Stopping component %s", az.Name())
+	if az.sasRenewStop != nil {
+		close(az.sasRenewStop)
+		az.sasRenewWg.Wait()
+		az.sasRenewStop = nil
+	}
 	azStatsCollector.Destroy()
 	return nil
 }
 
+// Ping : Confirm the storage account is reachable with the given credentials using a single request,
+// nothing is cached so every call reaches the service
+func (az *AzStorage) Ping(ctx context.Context) error {
+	return az.storage.Ping(ctx)
+}
+
+// HealthCheck : Validate the storage account is reachable with the given credentials.
+// Result of the last check is served till health-check-interval-sec expires, so that frequent
+// probes (e.g. kubernetes liveness/readiness) do not hammer the service.
+func (az *AzStorage) HealthCheck() error {
+	az.healthLock.Lock()
+	defer az.healthLock.Unlock()
+
+	if !az.healthCheckedAt.IsZero() && time.Since(az.healthCheckedAt) < az.stConfig.healthCheckInterval {
+		return az.healthErr
+	}
+
+	az.healthErr = az.storage.Ping(context.Background())
+	az.healthCheckedAt = time.Now()
+
+	if az.healthErr != nil {
+		log.Err("AzStorage::HealthCheck : Storage is not reachable [%s]", az.healthErr.Error())
+	}
+
+	return az.healthErr
+}
+
 // ------------------------- Container listing -------------------------------------------
-func (az *AzStorage) ListContainers() ([]string, error) {
-	return az.storage.ListContainers()
+// ListContainers : List containers of the account, only those whose name starts with prefix when it is not empty
+func (az *AzStorage) ListContainers(prefix string) ([]string, error) {
+	return az.storage.ListContainers(prefix)
+}
+
+func (az *AzStorage) ListContainersDetailed() ([]ContainerInfo, error) {
+	return az.storage.ListContainersDetailed()
 }
 
 // ------------------------- Core Operations -------------------------------------------
@@ -220,6 +344,10 @@ func (az *AzStorage) CreateDir(options internal.CreateDirOptions) error {
 func (az *AzStorage) DeleteDir(options internal.DeleteDirOptions) error {
 	log.Trace("AzStorage::DeleteDir : %s", options.Name)
 
+	if options.DryRun {
+		return az.previewDirOp(internal.TruncateDirName(options.Name), options.Preview)
+	}
+
 	err := az.storage.DeleteDirectory(internal.TruncateDirName(options.Name))
 
 	if err == nil {
@@ -230,6 +358,54 @@ func (az *AzStorage) DeleteDir(options internal.DeleteDirOptions) error {
 	return err
 }
 
+// previewDirOp : Enumerate the directory and everything under it without changing anything, so the caller can
+// confirm a delete or rename before doing it
+func (az *AzStorage) previewDirOp(name string, preview *internal.DirOpPreview) error {
+	if preview == nil {
+		log.Err("AzStorage::previewDirOp : Dry run of %s has no preview to fill", name)
+		return syscall.EINVAL
+	}
+
+	attr, err := az.storage.GetAttr(name)
+	if err != nil {
+		return err
+	}
+	if !attr.IsDir() {
+		return syscall.ENOTDIR
+	}
+
+	*preview = internal.DirOpPreview{Paths: []string{name}}
+	pending := []string{name}
+	for len(pending) > 0 {
+		dir := pending[0]
+		pending = pending[1:]
+
+		marker := ""
+		for {
+			list, next, err := az.storage.List(formatListDirName(dir), &marker, 0)
+			if err != nil {
+				log.Err("AzStorage::previewDirOp : Failed to list %s [%s]", dir, err.Error())
+				return err
+			}
+			for _, attr := range list {
+				preview.Paths = append(preview.Paths, attr.Path)
+				if attr.IsDir() {
+					pending = append(pending, attr.Path)
+				} else {
+					preview.TotalBytes += attr.Size
+				}
+			}
+			if next == nil || *next == "" {
+				break
+			}
+			marker = *next
+		}
+	}
+
+	log.Info("AzStorage::previewDirOp : %s has %d paths with %d bytes", name, len(preview.Paths), preview.TotalBytes)
+	return nil
+}
+
 func formatListDirName(path string) string {
 	// If we check the root directory, make sure we pass "" instead of "/"
 	// If we aren't checking the root directory, then we want to extend the directory name so List returns all children and does not include the path itself.
@@ -291,6 +467,17 @@ func (az *AzStorage) ReadDir(options internal.ReadDirOptions) ([]*internal.ObjAt
 	return blobList, nil
 }
 
+// deletedDuringList : Directory went away after the first page was listed, end the listing or fail as configured
+func (az *AzStorage) deletedDuringList(path string, listed []*internal.ObjAttr) ([]*internal.ObjAttr, string, error) {
+	if az.stConfig.endDeletedList {
+		log.Warn("AzStorage::StreamDir : Dir %s deleted while listing, ending listing with %d objects", path, len(listed))
+		return listed, "", nil
+	}
+
+	log.Err("AzStorage::StreamDir : Dir %s deleted while listing, %d objects listed before it went away", path, len(listed))
+	return nil, "", syscall.ENOENT
+}
+
 func (az *AzStorage) StreamDir(options internal.StreamDirOptions) ([]*internal.ObjAttr, string, error) {
 	log.Trace("AzStorage::StreamDir : Path %s, offset %d, count %d", options.Name, options.Offset, options.Count)
 
@@ -307,12 +494,31 @@ func (az *AzStorage) StreamDir(options internal.StreamDirOptions) ([]*internal.O
 
 	path := formatListDirName(options.Name)
 
-	new_list, new_marker, err := az.storage.List(path, &options.Token, options.Count)
+	// Service returns at most MaxDirListCount items in a page, so a larger count is fulfilled over multiple pages
+	new_list, new_marker, err := az.storage.List(path, &options.Token, min(options.Count, common.MaxDirListCount))
+	if err == syscall.ENOENT && options.Token != "" {
+		return az.deletedDuringList(path, new_list)
+	}
 	if err != nil {
 		log.Err("AzStorage::StreamDir : Failed to read dir [%s]", err)
 		return new_list, "", err
 	}
 
+	for options.Count > common.MaxDirListCount && int32(len(new_list)) < options.Count &&
+		new_marker != nil && *new_marker != "" {
+		page, page_marker, err := az.storage.List(path, new_marker, min(options.Count-int32(len(new_list)), common.MaxDirListCount))
+		if err == syscall.ENOENT {
+			return az.deletedDuringList(path, new_list)
+		}
+		if err != nil {
+			// Return what is listed so far, the caller resumes from the last good marker
+			log.Warn("AzStorage::StreamDir : Failed to read next page of dir, returning %d objects [%s]", len(new_list), err)
+			break
+		}
+		new_list = append(new_list, page...)
+		new_marker = page_marker
+	}
+
 	log.Debug("AzStorage::StreamDir : Retrieved %d objects with %s marker for Path %s", len(new_list), options.Token, path)
 
 	if new_marker == nil {
@@ -344,11 +550,43 @@ func (az *AzStorage) StreamDir(options internal.StreamDirOptions) ([]*internal.O
 	return new_list, *new_marker, nil
 }
 
+// GetDirUsage : Total byte size, file count and directory count of everything under a directory.
+// Enumeration stops early with the context error when ctx is cancelled.
+func (az *AzStorage) GetDirUsage(ctx context.Context, name string) (size int64, fileCount int64, dirCount int64, err error) {
+	log.Trace("AzStorage::GetDirUsage : %s", name)
+	return az.storage.GetDirUsage(ctx, internal.TruncateDirName(name))
+}
+
+// ListFlat : List all descendants of a directory in a flat enumeration, one page per call.
+// Much faster than walking the tree with StreamDir for deep recursive scans.
+func (az *AzStorage) ListFlat(name string, marker string, count int32) ([]*internal.ObjAttr, string, error) {
+	log.Trace("AzStorage::ListFlat : %s marker %s count %d", name, marker, count)
+
+	if count > common.MaxDirListCount {
+		count = common.MaxDirListCount
+	}
+
+	newList, nextMarker, err := az.storage.ListFlat(formatListDirName(name), &marker, count)
+	if err != nil {
+		log.Err("AzStorage::ListFlat : Failed to read dir [%s]", err)
+		return newList, "", err
+	}
+
+	if nextMarker == nil {
+		return newList, "", nil
+	}
+	return newList, *nextMarker, nil
+}
+
 func (az *AzStorage) RenameDir(options internal.RenameDirOptions) error {
 	log.Trace("AzStorage::RenameDir : %s to %s", options.Src, options.Dst)
 	options.Src = internal.TruncateDirName(options.Src)
 	options.Dst = internal.TruncateDirName(options.Dst)
 
+	if options.DryRun {
+		return az.previewDirOp(options.Src, options.Preview)
+	}
+
 	err := az.storage.RenameDirectory(options.Src, options.Dst)
 
 	if err == nil {
@@ -370,7 +608,7 @@ func (az *AzStorage) CreateFile(options internal.CreateFileOptions) (*handlemap.
 		return nil, syscall.EFAULT
 	}
 
-	err := az.storage.CreateFile(options.Name, options.Mode)
+	err := az.storage.CreateFile(options.Name, options.Mode, options.ContentType)
 	if err != nil {
 		return nil, err
 	}
@@ -402,6 +640,11 @@ func (az *AzStorage) OpenFile(options internal.OpenFileOptions) (*handlemap.Hand
 	handle.Size = int64(attr.Size)
 	handle.Mtime = attr.Mtime
 
+	// Writes through a handle opened for reading are rejected instead of failing later at commit
+	if options.Flags&syscall.O_ACCMODE == syscall.O_RDONLY {
+		handle.Flags.Set(handlemap.HandleFlagReadOnly)
+	}
+
 	// increment open file handles count
 	azStatsCollector.UpdateStats(stats_manager.Increment, openHandles, (int64)(1))
 
@@ -421,6 +664,9 @@ func (az *AzStorage) DeleteFile(options internal.DeleteFileOptions) error {
 	log.Trace("AzStorage::DeleteFile : %s", options.Name)
 
 	err := az.storage.DeleteFile(options.Name)
+	if link, ok := az.suffixLinkName(options.Name, err); ok {
+		err = az.storage.DeleteFile(link)
+	}
 
 	if err == nil {
 		azStatsCollector.PushEvents(deleteFile, options.Name, nil)
@@ -430,10 +676,33 @@ func (az *AzStorage) DeleteFile(options internal.DeleteFileOptions) error {
 	return err
 }
 
+// UndeleteFile : Restore a soft-deleted file along with its soft-deleted snapshots
+func (az *AzStorage) UndeleteFile(name string) error {
+	log.Trace("AzStorage::UndeleteFile : %s", name)
+	return az.storage.UndeleteFile(name)
+}
+
 func (az *AzStorage) RenameFile(options internal.RenameFileOptions) error {
 	log.Trace("AzStorage::RenameFile : %s to %s", options.Src, options.Dst)
 
 	err := az.storage.RenameFile(options.Src, options.Dst, options.SrcAttr)
+	movedLink := false
+	if link, ok := az.suffixLinkName(options.Src, err); ok {
+		err = az.storage.RenameFile(link, options.Dst+symlinkSuffix, options.SrcAttr)
+		movedLink = err == nil
+	}
+
+	// In suffix symlink format a link and a file of the same name are different blobs, the one replaced by the rename
+	// would otherwise still answer for the name
+	if err == nil && az.stConfig.symlinkFormat == SymlinkFormatSuffix && !strings.HasSuffix(options.Dst, symlinkSuffix) {
+		replaced := options.Dst + symlinkSuffix
+		if movedLink {
+			replaced = options.Dst
+		}
+		if derr := az.storage.DeleteFile(replaced); derr != nil && derr != syscall.ENOENT {
+			log.Warn("AzStorage::RenameFile : Failed to delete %s replaced by the rename [%s]", replaced, derr.Error())
+		}
+	}
 
 	if err == nil {
 		azStatsCollector.PushEvents(renameFile, options.Src, map[string]interface{}{src: options.Src, dest: options.Dst})
@@ -477,21 +746,236 @@ func (az *AzStorage) ReadInBuffer(options internal.ReadInBufferOptions) (length
 		return 0, nil
 	}
 
-	length = int(dataLen)
-	err = az.storage.ReadInBuffer(path, options.Offset, dataLen, options.Data, options.Etag)
+	if options.Handle != nil && options.VersionID == "" && az.stConfig.readAheadSize > 0 {
+		return az.readAhead(options, size, dataLen)
+	}
+
+	if options.VersionID != "" {
+		err = az.storage.ReadVersionInBuffer(path, options.VersionID, options.Offset, dataLen, options.Data)
+	} else {
+		err = az.storage.ReadInBuffer(path, options.Offset, dataLen, options.Data, options.Etag)
+	}
+	return readResult(path, options.Offset, dataLen, options.Data, err)
+}
+
+// readResult : Bytes read into the buffer of ReadInBuffer or the error it failed with
+func readResult(path string, offset int64, dataLen int64, data []byte, err error) (int, error) {
+	if err == syscall.ERANGE {
+		// Offset is within the file size but beyond the end of blob, i.e. file was grown without
+		// writing the data yet. Such region is sparse and reads as zeros.
+		log.Debug("AzStorage::ReadInBuffer : Offset %d is beyond end of blob %s, returning zeros", offset, path)
+		clear(data[:dataLen])
+		err = nil
+	}
+
 	if err != nil {
 		log.Err("AzStorage::ReadInBuffer : Failed to read %s [%s]", path, err.Error())
-		length = 0
+		return 0, err
+	}
+
+	return int(dataLen), nil
+}
+
+// Read-ahead buffer is the only block kept in the cache object of a handle
+const readAheadKey = 0
+
+// readAhead : Reads through a handle which continue where the previous one ended fetch read-ahead-kb at once into
+// the cache object of the handle, the following reads are then served from memory till they run past it.
+// Reads elsewhere go to the service as they are and only move the point sequential access is expected at.
+func (az *AzStorage) readAhead(options internal.ReadInBufferOptions, size int64, dataLen int64) (int, error) {
+	handle := options.Handle
+	cache := az.readAheadCache(handle)
+	cache.Lock()
+	defer cache.Unlock()
+
+	offset := options.Offset
+	end := offset + dataLen
+	sequential := offset == cache.NextOffset
+	cache.NextOffset = end
+
+	if block, found := cache.Get(readAheadKey); found && offset >= block.StartIndex && end <= block.EndIndex {
+		copy(options.Data[:dataLen], block.Data[offset-block.StartIndex:end-block.StartIndex])
+		if options.Etag != nil {
+			*options.Etag = block.Id
+		}
+		return int(dataLen), nil
+	}
+
+	if !sequential {
+		err := az.storage.ReadInBuffer(handle.Path, offset, dataLen, options.Data, options.Etag)
+		return readResult(handle.Path, offset, dataLen, options.Data, err)
+	}
+
+	fetchLen := min(max(dataLen, az.stConfig.readAheadSize), size-offset)
+	block := &common.Block{StartIndex: offset, EndIndex: offset + fetchLen, Data: az.readAheadBuffers.get(fetchLen)}
+	err := az.storage.ReadInBuffer(handle.Path, offset, fetchLen, block.Data, &block.Id)
+	az.releaseReadAhead(cache)
+	if err != nil {
+		// Region beyond the end of blob or a failed prefetch, the read itself gets its own request
+		az.readAheadBuffers.put(block.Data)
+		err = az.storage.ReadInBuffer(handle.Path, offset, dataLen, options.Data, options.Etag)
+		return readResult(handle.Path, offset, dataLen, options.Data, err)
+	}
+
+	cache.Put(readAheadKey, block)
+	copy(options.Data[:dataLen], block.Data[:dataLen])
+	if options.Etag != nil {
+		*options.Etag = block.Id
+	}
+	return int(dataLen), nil
+}
+
+// readAheadCache : Cache object of the handle holding its read-ahead buffer, created on first read
+func (az *AzStorage) readAheadCache(handle *handlemap.Handle) *handlemap.Cache {
+	az.readAheadLock.Lock()
+	defer az.readAheadLock.Unlock()
+	if handle.CacheObj == nil {
+		handlemap.CreateCacheObject(az.stConfig.readAheadSize, handle)
+	}
+	return handle.CacheObj
+}
+
+// releaseReadAhead : Drop the read-ahead buffer of the cache object and return it to the pool, cache object is locked
+func (az *AzStorage) releaseReadAhead(cache *handlemap.Cache) {
+	if block, found := cache.Get(readAheadKey); found {
+		data := block.Data
+		cache.Remove(readAheadKey)
+		az.readAheadBuffers.put(data)
+	}
+}
+
+// dropReadAhead : Data written through the handle makes its read-ahead buffer stale
+func (az *AzStorage) dropReadAhead(handle *handlemap.Handle) {
+	if handle == nil || handle.CacheObj == nil {
+		return
+	}
+	handle.CacheObj.Lock()
+	az.releaseReadAhead(handle.CacheObj)
+	handle.CacheObj.Unlock()
+}
+
+// Range : Part of a blob read by ReadRanges
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// ReadRanges : Read several, possibly scattered, ranges of a blob each into its own buffer in one call.
+// Ranges are fetched in parallel bounded by max-concurrency and the bytes read per range are returned.
+// Range ending beyond the end of blob is read up to the end, one starting beyond it reads nothing.
+func (az *AzStorage) ReadRanges(name string, ranges []Range, buffers [][]byte) ([]int, error) {
+	log.Trace("AzStorage::ReadRanges : Read %d ranges of %s", len(ranges), name)
+
+	if len(ranges) != len(buffers) {
+		log.Err("AzStorage::ReadRanges : %d ranges given with %d buffers for %s", len(ranges), len(buffers), name)
+		return nil, syscall.EINVAL
+	}
+	for i, r := range ranges {
+		if r.Offset < 0 || r.Length < 0 || r.Length > int64(len(buffers[i])) {
+			log.Err("AzStorage::ReadRanges : Invalid range %d of %s, offset %d, length %d, buffer %d", i, name, r.Offset, r.Length, len(buffers[i]))
+			return nil, syscall.EINVAL
+		}
+	}
+
+	attr, err := az.storage.GetAttr(name)
+	if err != nil {
+		log.Err("AzStorage::ReadRanges : Failed to get properties of %s [%s]", name, err.Error())
+		return nil, err
+	}
+
+	concurrency := az.stConfig.maxConcurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var readErr error
+	read := make([]int, len(ranges))
+	sem := make(chan struct{}, concurrency)
+
+	for i, r := range ranges {
+		length := min(r.Length, attr.Size-r.Offset)
+		if length <= 0 {
+			continue
+		}
+
+		sem <- struct{}{}
+
+		// Do not start more ranges once one has failed
+		lock.Lock()
+		failed := readErr != nil
+		lock.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, offset int64, length int64) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := az.storage.ReadInBuffer(name, offset, length, buffers[i][:length], nil)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if readErr == nil {
+					readErr = err
+				}
+				return
+			}
+			read[i] = int(length)
+		}(i, r.Offset, length)
 	}
+	wg.Wait()
 
-	return
+	if readErr != nil {
+		log.Err("AzStorage::ReadRanges : Failed to read %s [%s]", name, readErr.Error())
+		return nil, readErr
+	}
+
+	return read, nil
 }
 
 func (az *AzStorage) WriteFile(options internal.WriteFileOptions) (int, error) {
+	if options.Handle != nil && options.Handle.ReadOnly() {
+		log.Err("AzStorage::WriteFile : %s was opened read-only", options.Handle.Path)
+		return 0, syscall.EBADF
+	}
+	if az.stConfig.readAheadSize > 0 {
+		az.dropReadAhead(options.Handle)
+	}
 	err := az.storage.Write(options)
 	return len(options.Data), err
 }
 
+// CreateImmutable : Create a blob only if it does not already exist, returns EEXIST otherwise.
+// Legal hold and immutability policy (expiry) are applied only when requested.
+func (az *AzStorage) CreateImmutable(name string, data []byte, legalHold bool, expiry *time.Time) error {
+	log.Trace("AzStorage::CreateImmutable : %s", name)
+	return az.storage.CreateImmutable(name, data, legalHold, expiry)
+}
+
+// SetImmutabilityPolicy : Keep the blob immutable till the given time, mode is unlocked or locked
+func (az *AzStorage) SetImmutabilityPolicy(name string, until time.Time, mode string) error {
+	log.Trace("AzStorage::SetImmutabilityPolicy : %s", name)
+	return az.storage.SetImmutabilityPolicy(name, until, mode)
+}
+
+// ClearImmutabilityPolicy : Remove the unlocked immutability policy of the blob
+func (az *AzStorage) ClearImmutabilityPolicy(name string) error {
+	log.Trace("AzStorage::ClearImmutabilityPolicy : %s", name)
+	return az.storage.ClearImmutabilityPolicy(name)
+}
+
+// SetLegalHold : Put the blob under legal hold or release it
+func (az *AzStorage) SetLegalHold(name string, on bool) error {
+	log.Trace("AzStorage::SetLegalHold : %s, legal-hold %t", name, on)
+	return az.storage.SetLegalHold(name, on)
+}
+
 func (az *AzStorage) GetFileBlockOffsets(options internal.GetFileBlockOffsetsOptions) (*common.BlockOffsetList, error) {
 	return az.storage.GetFileBlockOffsets(options.Name)
 
@@ -510,12 +994,91 @@ func (az *AzStorage) TruncateFile(options internal.TruncateFileOptions) error {
 
 func (az *AzStorage) CopyToFile(options internal.CopyToFileOptions) error {
 	log.Trace("AzStorage::CopyToFile : Read file %s", options.Name)
-	return az.storage.ReadToFile(options.Name, options.Offset, options.Count, options.File)
+	return az.storage.ReadToFile(options)
 }
 
 func (az *AzStorage) CopyFromFile(options internal.CopyFromFileOptions) error {
 	log.Trace("AzStorage::CopyFromFile : Upload file %s", options.Name)
-	return az.storage.WriteFromFile(options.Name, options.Metadata, options.File)
+	return az.storage.WriteFromFile(options)
+}
+
+// UploadMismatch : Describes an uploaded blob which does not match its local source
+type UploadMismatch struct {
+	Name      string
+	LocalPath string
+	Reason    string
+}
+
+// VerifyUploads : Compare each uploaded blob (key) against its local source file (value).
+// Size is always compared, MD5 is compared only when the blob has one stored.
+// Checks run in parallel bounded by max-concurrency and all mismatches are returned.
+func (az *AzStorage) VerifyUploads(files map[string]string) []UploadMismatch {
+	log.Trace("AzStorage::VerifyUploads : Verifying %d files", len(files))
+
+	concurrency := az.stConfig.maxConcurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	mismatches := make([]UploadMismatch, 0)
+	sem := make(chan struct{}, concurrency)
+
+	for name, localPath := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(name string, localPath string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			reason := az.verifyUpload(name, localPath)
+			if reason != "" {
+				log.Err("AzStorage::VerifyUploads : %s does not match %s [%s]", name, localPath, reason)
+				lock.Lock()
+				mismatches = append(mismatches, UploadMismatch{Name: name, LocalPath: localPath, Reason: reason})
+				lock.Unlock()
+			}
+		}(name, localPath)
+	}
+	wg.Wait()
+
+	return mismatches
+}
+
+// verifyUpload : Returns the reason of mismatch between the blob and the local file, empty if they match
+func (az *AzStorage) verifyUpload(name string, localPath string) string {
+	attr, err := az.storage.GetAttr(name)
+	if err != nil {
+		return fmt.Sprintf("failed to get blob properties: %s", err.Error())
+	}
+
+	fi, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Sprintf("failed to open local file: %s", err.Error())
+	}
+	defer fi.Close()
+
+	stat, err := fi.Stat()
+	if err != nil {
+		return fmt.Sprintf("failed to stat local file: %s", err.Error())
+	}
+
+	if stat.Size() != attr.Size {
+		return fmt.Sprintf("size mismatch, local %d, blob %d", stat.Size(), attr.Size)
+	}
+
+	if len(attr.MD5) > 0 {
+		localMD5, err := common.GetMD5(fi)
+		if err != nil {
+			return fmt.Sprintf("failed to compute md5 of local file: %s", err.Error())
+		}
+		if !bytes.Equal(localMD5, attr.MD5) {
+			return "md5 mismatch"
+		}
+	}
+
+	return ""
 }
 
 // Symlink operations
@@ -534,6 +1097,9 @@ func (az *AzStorage) CreateLink(options internal.CreateLinkOptions) error {
 func (az *AzStorage) ReadLink(options internal.ReadLinkOptions) (string, error) {
 	log.Trace("AzStorage::ReadLink : Read symlink %s", options.Name)
 	data, err := az.storage.ReadBuffer(options.Name, 0, options.Size)
+	if link, ok := az.suffixLinkName(options.Name, err); ok {
+		data, err = az.storage.ReadBuffer(link, 0, options.Size)
+	}
 
 	if err != nil {
 		azStatsCollector.PushEvents(readLink, options.Name, nil)
@@ -543,10 +1109,137 @@ func (az *AzStorage) ReadLink(options internal.ReadLinkOptions) (string, error)
 	return string(data), err
 }
 
+// suffixLinkName : In suffix symlink format a name which is not found may be a link stored as <name>.symlink
+func (az *AzStorage) suffixLinkName(name string, err error) (string, bool) {
+	if err != syscall.ENOENT || az.stConfig.symlinkFormat != SymlinkFormatSuffix || strings.HasSuffix(name, symlinkSuffix) {
+		return "", false
+	}
+	return name + symlinkSuffix, true
+}
+
 // Attribute operations
 func (az *AzStorage) GetAttr(options internal.GetAttrOptions) (attr *internal.ObjAttr, err error) {
 	//log.Trace("AzStorage::GetAttr : Get attributes of file %s", name)
-	return az.storage.GetAttr(options.Name)
+
+	// A trailing slash asserts the path is a directory, so "file/" shall not resolve to "file"
+	name := options.Name
+	dirExpected := len(name) > 1 && strings.HasSuffix(name, "/")
+	if dirExpected {
+		name = strings.TrimRight(name, "/")
+	}
+
+	// Path is resolved with a single request whatever its depth, the cap only guards against runaway paths
+	if az.stConfig.maxPathDepth > 0 && pathDepth(name) > int(az.stConfig.maxPathDepth) {
+		log.Err("AzStorage::GetAttr : %s has more than %d segments", name, az.stConfig.maxPathDepth)
+		return nil, syscall.ENAMETOOLONG
+	}
+
+	if options.IfNoneMatch != "" {
+		attr, err = az.storage.GetAttrIfModified(name, options.IfNoneMatch)
+	} else if az.stConfig.attrCacheTTL > 0 {
+		attr, err = az.getAttrCached(name)
+	} else {
+		attr, err = az.storage.GetAttr(name)
+	}
+
+	// Only a symlink may answer for the name it was created with
+	if link, ok := az.suffixLinkName(name, err); ok && !dirExpected {
+		if linkAttr, linkErr := az.storage.GetAttr(link); linkErr == nil && linkAttr.IsSymlink() {
+			attr, err = linkAttr, nil
+		}
+	}
+
+	if err == nil && options.FollowSymlink && attr.IsSymlink() {
+		attr, err = az.followLink(name, attr)
+	}
+
+	if err == nil && dirExpected && !attr.IsDir() {
+		log.Debug("AzStorage::GetAttr : %s is not a directory", options.Name)
+		return nil, syscall.ENOTDIR
+	}
+
+	return attr, err
+}
+
+// followLink : Attributes of the immediate target of a link, a target which is itself a link is not resolved further.
+// Targets are resolved relative to the directory of the link, absolute targets and those leaving the container are
+// outside what the mount can see so the link itself is returned.
+func (az *AzStorage) followLink(name string, link *internal.ObjAttr) (*internal.ObjAttr, error) {
+	target, err := az.ReadLink(internal.ReadLinkOptions{Name: name, Size: link.Size})
+	if err != nil {
+		log.Err("AzStorage::GetAttr : Failed to read link %s [%s]", name, err.Error())
+		return nil, err
+	}
+
+	if target == "" || strings.HasPrefix(target, "/") {
+		log.Debug("AzStorage::GetAttr : Target %s of link %s is not resolved", target, name)
+		return link, nil
+	}
+
+	resolved := path.Join(path.Dir(name), target)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		log.Debug("AzStorage::GetAttr : Target %s of link %s is outside the container", target, name)
+		return link, nil
+	}
+
+	return az.storage.GetAttr(resolved)
+}
+
+// getAttrCached : Attributes cached for the path within attr-cache-ttl-sec are revalidated with a conditional
+// request, so an unchanged path costs a 304 and no parsing. Otherwise attributes are fetched and cached.
+func (az *AzStorage) getAttrCached(name string) (*internal.ObjAttr, error) {
+	az.attrCacheLock.Lock()
+	entry, found := az.attrCache[name]
+	az.attrCacheLock.Unlock()
+
+	var attr *internal.ObjAttr
+	var err error
+	if found && time.Since(entry.cachedAt) < az.stConfig.attrCacheTTL && entry.attr.ETag != "" {
+		attr, err = az.storage.GetAttrIfModified(name, entry.attr.ETag)
+		if err == internal.ErrNotModified {
+			log.Debug("AzStorage::GetAttr : %s not modified, served from cache", name)
+			az.storeCachedAttr(name, entry.attr)
+			// Callers may modify what they get, the cached copy stays as it was
+			copied := *entry.attr
+			return &copied, nil
+		}
+	} else {
+		attr, err = az.storage.GetAttr(name)
+	}
+
+	if err == nil {
+		copied := *attr
+		az.storeCachedAttr(name, &copied)
+	} else {
+		az.attrCacheLock.Lock()
+		delete(az.attrCache, name)
+		az.attrCacheLock.Unlock()
+	}
+
+	return attr, err
+}
+
+// storeCachedAttr : Cache attributes of the path, expired entries are dropped when the cache is full
+func (az *AzStorage) storeCachedAttr(name string, attr *internal.ObjAttr) {
+	az.attrCacheLock.Lock()
+	defer az.attrCacheLock.Unlock()
+
+	if az.attrCache == nil {
+		az.attrCache = make(map[string]cachedAttr)
+	}
+
+	if _, found := az.attrCache[name]; !found && len(az.attrCache) >= maxCachedAttrs {
+		for key, value := range az.attrCache {
+			if time.Since(value.cachedAt) >= az.stConfig.attrCacheTTL {
+				delete(az.attrCache, key)
+			}
+		}
+		if len(az.attrCache) >= maxCachedAttrs {
+			return
+		}
+	}
+
+	az.attrCache[name] = cachedAttr{attr: attr, cachedAt: time.Now()}
 }
 
 func (az *AzStorage) Chmod(options internal.ChmodOptions) error {
@@ -561,14 +1254,84 @@ func (az *AzStorage) Chmod(options internal.ChmodOptions) error {
 	return err
 }
 
+// ChmodRecursive : Change mode of a directory and everything under it in as few requests as the service allows
+func (az *AzStorage) ChmodRecursive(name string, perm os.FileMode) (RecursiveACLResult, error) {
+	log.Trace("AzStorage::ChmodRecursive : Change mod of %s and its children to %s", name, perm)
+	result, err := az.storage.ChmodRecursive(name, perm)
+
+	if err == nil {
+		azStatsCollector.PushEvents(chmod, name, map[string]interface{}{mode: perm.String()})
+		azStatsCollector.UpdateStats(stats_manager.Increment, chmod, (int64)(1))
+	}
+
+	return result, err
+}
+
+// SetTier : Change the access tier of a single file irrespective of the configured default tier
+func (az *AzStorage) SetTier(name string, tier blob.AccessTier) error {
+	log.Trace("AzStorage::SetTier : Change tier of file %s to %s", name, tier)
+	return az.storage.SetTier(name, tier)
+}
+
 func (az *AzStorage) Chown(options internal.ChownOptions) error {
 	log.Trace("AzStorage::Chown : Change ownership of file %s to %d-%d", options.Name, options.Owner, options.Group)
 	return az.storage.ChangeOwner(options.Name, options.Owner, options.Group)
 }
 
+// SetXAttr : Persist a configured extended attribute in metadata of the blob
+func (az *AzStorage) SetXAttr(options internal.SetXAttrOptions) error {
+	log.Trace("AzStorage::SetXAttr : Set %s of %s", options.Attr, options.Name)
+
+	key, found := az.stConfig.xattrKeys[options.Attr]
+	if !found {
+		return syscall.ENOTSUP
+	}
+
+	// Metadata values travel as http headers, so only printable ascii is kept as is
+	for _, c := range options.Value {
+		if c < 0x20 || c > 0x7e {
+			log.Err("AzStorage::SetXAttr : Value of %s for %s is not printable ascii", options.Attr, options.Name)
+			return syscall.EINVAL
+		}
+	}
+
+	if options.Flags&(internal.XAttrCreate|internal.XAttrReplace) != 0 {
+		value, err := az.storage.GetMetadataKey(options.Name, key)
+		if err != nil {
+			return err
+		}
+		if value != nil && options.Flags&internal.XAttrCreate != 0 {
+			return syscall.EEXIST
+		} else if value == nil && options.Flags&internal.XAttrReplace != 0 {
+			return syscall.ENODATA
+		}
+	}
+
+	return az.storage.SetMetadataKey(options.Name, key, to.Ptr(string(options.Value)))
+}
+
+// GetXAttr : Read a configured extended attribute back from metadata of the blob
+func (az *AzStorage) GetXAttr(options internal.GetXAttrOptions) ([]byte, error) {
+	log.Trace("AzStorage::GetXAttr : Get %s of %s", options.Attr, options.Name)
+
+	key, found := az.stConfig.xattrKeys[options.Attr]
+	if !found {
+		return nil, syscall.ENODATA
+	}
+
+	value, err := az.storage.GetMetadataKey(options.Name, key)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, syscall.ENODATA
+	}
+	return []byte(*value), nil
+}
+
 func (az *AzStorage) FlushFile(options internal.FlushFileOptions) error {
 	log.Trace("AzStorage::FlushFile : Flush file %s", options.Handle.Path)
-	return az.storage.StageAndCommit(options.Handle.Path, options.Handle.CacheObj.BlockOffsetList)
+	return az.storage.StageAndCommit(options.Handle.Path, options.Handle.CacheObj.BlockOffsetList, options.Concurrency)
 }
 
 func (az *AzStorage) GetCommittedBlockList(name string) (*internal.CommittedBlockList, error) {
@@ -576,11 +1339,29 @@ func (az *AzStorage) GetCommittedBlockList(name string) (*internal.CommittedBloc
 }
 
 func (az *AzStorage) StageData(opt internal.StageDataOptions) error {
+	idLen := common.GetIdLength(opt.Id)
+	if idLen < common.MinBlockIDLength || idLen > common.MaxBlockIDLength {
+		log.Err("AzStorage::StageData : Block id %s of %s has invalid length %d", opt.Id, opt.Name, idLen)
+		return syscall.EINVAL
+	}
 	return az.storage.StageBlock(opt.Name, opt.Data, opt.Id)
 }
 
 func (az *AzStorage) CommitData(opt internal.CommitDataOptions) error {
-	return az.storage.CommitBlocks(opt.Name, opt.List, opt.NewETag)
+	// Committing an empty list truncates the blob, that is only expected when the file is meant to be empty
+	if len(opt.List) == 0 && opt.Size > 0 {
+		log.Err("AzStorage::CommitData : Refusing to commit empty block list for %s of size %d", opt.Name, opt.Size)
+		return syscall.EIO
+	}
+
+	// Azure requires all blocks of a blob to have ids of the same length
+	for _, id := range opt.List {
+		if len(id) != len(opt.List[0]) {
+			log.Err("AzStorage::CommitData : Block ids of %s differ in length [%s, %s]", opt.Name, opt.List[0], id)
+			return syscall.EINVAL
+		}
+	}
+	return az.storage.CommitBlocks(opt.Name, opt.List, opt.NewETag, opt.Tags, opt.IfMatch, opt.ContentType)
 }
 
 // TODO : Below methods are pending to be implemented
@@ -596,9 +1377,10 @@ func NewazstorageComponent() internal.Component {
 	// Init the component with default config
 	az := &AzStorage{
 		stConfig: AzStorageConfig{
-			blockSize:      0,
-			maxConcurrency: 32,
-			defaultTier:    getAccessTierType(""),
+			blockSize:           0,
+			maxConcurrency:      32,
+			healthCheckInterval: DefaultHealthCheckInterval,
+			defaultTier:         getAccessTierType(""),
 			authConfig: azAuthConfig{
 				AuthMode: EAuthType.KEY(),
 				UseHTTP:  false,
@@ -0,0 +1,2103 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2025 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package azstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeConnection : AzConnection which counts the calls reaching the service, counters are guarded by lock.
+// Methods which are not overridden here will panic if called.
+type fakeConnection struct {
+	AzConnection
+	testPipelineCalls int
+	testPipelineErr   error
+	testPipelineWait  chan struct{}
+	containers        []string
+	pingCalls         int
+	pingErr           error
+	attrs             map[string]*internal.ObjAttr
+	getAttrCalls      int
+	getAttrErr        error
+	listItems         int
+	listCounts        []int32
+	listDeletedAfter  int
+	sasUpdates        []string
+	commitCalls       int
+
+	lock sync.Mutex
+}
+
+func (f *fakeConnection) CommitBlocks(name string, blockList []string, newEtag *string, tags map[string]string, _ string, _ string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.commitCalls++
+	return nil
+}
+
+func (f *fakeConnection) UpdateServiceClient(key, value string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if key == "saskey" {
+		f.sasUpdates = append(f.sasUpdates, value)
+	}
+	return nil
+}
+
+// TestPipeline : Blocks till testPipelineWait is closed, when set
+func (f *fakeConnection) TestPipeline() error {
+	f.lock.Lock()
+	f.testPipelineCalls++
+	wait := f.testPipelineWait
+	f.lock.Unlock()
+
+	if wait != nil {
+		<-wait
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.testPipelineErr
+}
+
+func (f *fakeConnection) pipelineCalls() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.testPipelineCalls
+}
+
+func (f *fakeConnection) ListContainers(prefix string) ([]string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.containers, nil
+}
+
+func (f *fakeConnection) Ping(ctx context.Context) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.pingCalls++
+	return f.pingErr
+}
+
+// List : Serves listItems entries in pages of the requested count, marker is the index of the next entry.
+// Directory is gone once listDeletedAfter pages are served.
+func (f *fakeConnection) List(prefix string, marker *string, count int32) ([]*internal.ObjAttr, *string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.listDeletedAfter > 0 && len(f.listCounts) >= f.listDeletedAfter {
+		return nil, nil, syscall.ENOENT
+	}
+	f.listCounts = append(f.listCounts, count)
+	start := 0
+	if marker != nil && *marker != "" {
+		start, _ = strconv.Atoi(*marker)
+	}
+
+	list := make([]*internal.ObjAttr, 0)
+	for i := start; i < f.listItems && i < start+int(count); i++ {
+		name := prefix + "file" + strconv.Itoa(i)
+		list = append(list, &internal.ObjAttr{Path: name, Name: filepath.Base(name)})
+	}
+
+	next := ""
+	if start+len(list) < f.listItems {
+		next = strconv.Itoa(start + len(list))
+	}
+	return list, &next, nil
+}
+
+func (f *fakeConnection) GetAttr(name string) (*internal.ObjAttr, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.getAttrCalls++
+	if f.getAttrErr != nil {
+		return nil, f.getAttrErr
+	}
+	attr, ok := f.attrs[name]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return attr, nil
+}
+
+type azStorageTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+}
+
+func (s *azStorageTestSuite) SetupTest() {
+	err := log.SetDefaultLogger("silent", common.LogConfig{Level: common.ELogLevel.LOG_DEBUG()})
+	if err != nil {
+		panic("Unable to set silent logger as default.")
+	}
+	s.assert = assert.New(s.T())
+}
+
+func (s *azStorageTestSuite) TestHealthCheckCached() {
+	conn := &fakeConnection{}
+	az := &AzStorage{storage: conn}
+	az.stConfig.healthCheckInterval = time.Minute
+
+	for i := 0; i < 10; i++ {
+		s.assert.Nil(az.HealthCheck())
+	}
+	s.assert.Equal(1, conn.pingCalls)
+}
+
+func (s *azStorageTestSuite) TestHealthCheckFailureCached() {
+	conn := &fakeConnection{pingErr: errors.New("ContainerNotFound")}
+	az := &AzStorage{storage: conn}
+	az.stConfig.healthCheckInterval = time.Minute
+
+	for i := 0; i < 5; i++ {
+		err := az.HealthCheck()
+		s.assert.NotNil(err)
+		s.assert.Contains(err.Error(), "ContainerNotFound")
+	}
+	s.assert.Equal(1, conn.pingCalls)
+}
+
+func (s *azStorageTestSuite) TestHealthCheckExpired() {
+	conn := &fakeConnection{}
+	az := &AzStorage{storage: conn}
+	az.stConfig.healthCheckInterval = 10 * time.Millisecond
+
+	s.assert.Nil(az.HealthCheck())
+	time.Sleep(20 * time.Millisecond)
+	conn.pingErr = errors.New("AuthenticationFailed")
+	s.assert.NotNil(az.HealthCheck())
+	s.assert.Equal(2, conn.pingCalls)
+}
+
+func (s *azStorageTestSuite) TestReadVersion() {
+	type version struct {
+		id   string
+		data []byte
+	}
+	var lock sync.Mutex
+	var versions []version
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		q := r.URL.Query()
+
+		if r.Method == http.MethodPut {
+			// Every write keeps the previous content as a version
+			data, _ := io.ReadAll(r.Body)
+			id := time.Date(2024, 1, 1, 0, 0, len(versions), 0, time.UTC).Format("2006-01-02T15:04:05.0000000Z")
+			versions = append(versions, version{id: id, data: data})
+			w.Header().Set("x-ms-version-id", id)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+
+		if q.Get("comp") == "list" {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusOK)
+			var body strings.Builder
+			for i, v := range versions {
+				body.WriteString(`<Blob><Name>a</Name><VersionId>` + v.id + `</VersionId><IsCurrentVersion>` + strconv.FormatBool(i == len(versions)-1) +
+					`</IsCurrentVersion><Properties><Content-Length>` + strconv.Itoa(len(v.data)) + `</Content-Length>` +
+					`<Last-Modified>Mon, 01 Jan 2024 00:00:0` + strconv.Itoa(i) + ` GMT</Last-Modified></Properties></Blob>`)
+			}
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Blobs>` + body.String() + `</Blobs><NextMarker/></EnumerationResults>`))
+			return
+		}
+
+		content := versions[len(versions)-1].data
+		for _, v := range versions {
+			if v.id == q.Get("versionid") {
+				content = v.data
+			}
+		}
+		start, end := 0, len(content)-1
+		if rng := r.Header.Get("x-ms-range"); rng != "" {
+			fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+			end = min(end, len(content)-1)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[start : end+1])
+	}))
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.downloadOptions = &blob.DownloadFileOptions{}
+	az := &AzStorage{storage: bb}
+
+	s.assert.Nil(bb.WriteFromBuffer("a", nil, []byte("first version")))
+	s.assert.Nil(bb.WriteFromBuffer("a", nil, []byte("second")))
+
+	list, err := bb.ListVersions("a")
+	s.assert.Nil(err)
+	s.assert.Len(list, 2)
+	s.assert.False(list[0].IsCurrent)
+	s.assert.True(list[1].IsCurrent)
+	s.assert.EqualValues(13, list[0].Size)
+	s.assert.True(list[0].Created.Before(list[1].Created))
+
+	// Current content is read unless a version is asked for
+	data := make([]byte, 6)
+	n, err := az.ReadInBuffer(internal.ReadInBufferOptions{Path: "a", Size: 6, Data: data})
+	s.assert.Nil(err)
+	s.assert.Equal("second", string(data[:n]))
+
+	data = make([]byte, 13)
+	n, err = az.ReadInBuffer(internal.ReadInBufferOptions{Path: "a", Size: list[0].Size, Data: data, VersionID: list[0].ID})
+	s.assert.Nil(err)
+	s.assert.Equal("first version", string(data[:n]))
+
+	f, err := os.CreateTemp(s.T().TempDir(), "version")
+	s.assert.Nil(err)
+	defer f.Close()
+	err = az.CopyToFile(internal.CopyToFileOptions{Name: "a", File: f, VersionID: list[0].ID})
+	s.assert.Nil(err)
+	content, err := os.ReadFile(f.Name())
+	s.assert.Nil(err)
+	s.assert.Equal("first version", string(content))
+}
+
+// sharedKeySigned : Whether the shared key signature of the request was made with the given account key
+func sharedKeySigned(r *http.Request, account string, key string) bool {
+	var msHeaders []string
+	for k, v := range r.Header {
+		if name := strings.ToLower(k); strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name+":"+strings.Join(v, ","))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	resource := "/" + account + r.URL.EscapedPath()
+	query := r.URL.Query()
+	params := make([]string, 0, len(query))
+	for k := range query {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		resource += "\n" + strings.ToLower(k) + ":" + strings.Join(query[k], ",")
+	}
+
+	contentLength := r.Header.Get("Content-Length")
+	if contentLength == "0" {
+		contentLength = ""
+	}
+	stringToSign := strings.Join([]string{
+		r.Method, r.Header.Get("Content-Encoding"), r.Header.Get("Content-Language"), contentLength,
+		r.Header.Get("Content-MD5"), r.Header.Get("Content-Type"), "", r.Header.Get("If-Modified-Since"),
+		r.Header.Get("If-Match"), r.Header.Get("If-None-Match"), r.Header.Get("If-Unmodified-Since"),
+		r.Header.Get("Range"), strings.Join(msHeaders, "\n"), resource,
+	}, "\n")
+
+	decoded, _ := base64.StdEncoding.DecodeString(key)
+	mac := hmac.New(sha256.New, decoded)
+	mac.Write([]byte(stringToSign))
+	return r.Header.Get("Authorization") == "SharedKey "+account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *azStorageTestSuite) TestUpdateAccountKey() {
+	oldKey := base64.StdEncoding.EncodeToString([]byte("old-key"))
+	newKey := base64.StdEncoding.EncodeToString([]byte("new-key"))
+	started := make(chan struct{})
+	rotated := make(chan struct{})
+	var lock sync.Mutex
+	var signedWith []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		if sharedKeySigned(r, "acct", oldKey) {
+			signedWith = append(signedWith, "old")
+		} else if sharedKeySigned(r, "acct", newKey) {
+			signedWith = append(signedWith, "new")
+		} else {
+			signedWith = append(signedWith, "unknown")
+		}
+		first := len(signedWith) == 1
+		lock.Unlock()
+
+		// Hold the first upload till the key is rotated, it has to complete on the old client
+		if first {
+			close(started)
+			<-rotated
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	cfg := AzStorageConfig{container: "cont"}
+	cfg.authConfig = azAuthConfig{
+		AuthMode:    EAuthType.KEY(),
+		AccountType: EAccountType.BLOCK(),
+		AccountName: "acct",
+		AccountKey:  oldKey,
+		Endpoint:    srv.URL + "/",
+	}
+	bb := &BlockBlob{}
+	s.assert.Nil(bb.Configure(cfg))
+	s.assert.Nil(bb.SetupPipeline())
+	az := &AzStorage{storage: bb, stConfig: cfg}
+
+	inFlight := make(chan error)
+	go func() {
+		err := bb.WriteFromBuffer("file0", nil, []byte("data"))
+		inFlight <- err
+	}()
+	<-started
+
+	s.assert.Nil(az.UpdateAccountKey(newKey))
+	s.assert.Equal(newKey, az.stConfig.authConfig.AccountKey)
+	close(rotated)
+	s.assert.Nil(<-inFlight)
+
+	// Writes after the rotation go through the rebuilt client signed with the new key
+	err := bb.WriteFromBuffer("file1", nil, []byte("data"))
+	s.assert.Nil(err)
+
+	// Key which can not be used is rejected and the previous one stays in use
+	s.assert.NotNil(az.UpdateAccountKey("not a base64 key"))
+	s.assert.Equal(newKey, az.stConfig.authConfig.AccountKey)
+	err = bb.WriteFromBuffer("file2", nil, []byte("data"))
+	s.assert.Nil(err)
+
+	// Upload in flight was signed with the old key, every later one with the new key
+	s.assert.Equal([]string{"old", "new", "new"}, signedWith)
+}
+
+func (s *azStorageTestSuite) TestSASRenewDelay() {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	// 90% of the 10 hour lifetime is over at 19:00
+	delay, err := sasRenewDelay("?sv=2022-11-02&st=2024-01-01T10:00:00Z&se=2024-01-01T20:00:00Z&sig=abc", now)
+	s.assert.Nil(err)
+	s.assert.Equal(9*time.Hour, delay)
+
+	// Without a start time lifetime starts now
+	delay, err = sasRenewDelay("sv=2022-11-02&se=2024-01-01T11:40:00Z&sig=abc", now)
+	s.assert.Nil(err)
+	s.assert.Equal(90*time.Minute, delay)
+
+	// Mostly used up or expired SAS is renewed right away
+	delay, err = sasRenewDelay("st=2024-01-01T00:00:00Z&se=2024-01-01T10:30:00Z", now)
+	s.assert.Nil(err)
+	s.assert.Equal(time.Duration(0), delay)
+
+	_, err = sasRenewDelay("sv=2022-11-02&sig=abc", now)
+	s.assert.NotNil(err)
+
+	_, err = sasRenewDelay("se=tomorrow", now)
+	s.assert.NotNil(err)
+}
+
+func (s *azStorageTestSuite) TestSASRenewCommand() {
+	oldRetry := sasRenewRetryMin
+	sasRenewRetryMin = 10 * time.Millisecond
+	defer func() { sasRenewRetryMin = oldRetry }()
+
+	// Renew script fails on the first attempt and succeeds afterwards
+	dir := s.T().TempDir()
+	newSas := "sv=2022-11-02&se=" + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + "&sig=new"
+	script := filepath.Join(dir, "renew.sh")
+	err := os.WriteFile(script, []byte("#!/bin/sh\n"+
+		"if [ ! -f "+dir+"/attempted ]; then touch "+dir+"/attempted; echo 'renew failed' >&2; exit 1; fi\n"+
+		"echo '"+newSas+"'\n"), 0700)
+	s.assert.Nil(err)
+
+	conn := &fakeConnection{}
+	az := &AzStorage{storage: conn}
+	az.stConfig.authConfig.AuthMode = EAuthType.SAS()
+	az.stConfig.sasRenewCommand = script
+	// Current SAS is past 90% of its lifetime so renewal starts right away
+	az.stConfig.authConfig.SASKey = "sv=2022-11-02&st=" + time.Now().Add(-time.Hour).UTC().Format(time.RFC3339) +
+		"&se=" + time.Now().Add(time.Minute).UTC().Format(time.RFC3339) + "&sig=old"
+
+	s.assert.Nil(az.Start(context.Background()))
+	s.assert.Eventually(func() bool {
+		conn.lock.Lock()
+		defer conn.lock.Unlock()
+		return len(conn.sasUpdates) > 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// Reload of the config while the renewal is running keeps the renewed SAS, not the initial one from config
+	opt := AzStorageOptions{AuthMode: "sas", SaSKey: "sv=2022-11-02&sig=old", SasRenewCommand: script}
+	s.assert.Nil(ParseAndReadDynamicConfig(az, opt, true))
+	s.assert.Equal("?"+newSas, az.currentSAS())
+	s.assert.Nil(az.Stop())
+
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	s.assert.Equal([]string{"?" + newSas}, conn.sasUpdates)
+	s.assert.FileExists(filepath.Join(dir, "attempted"))
+}
+
+func (s *azStorageTestSuite) TestListContainersPrefix() {
+	names := []string{"logs-2023", "logs-2024", "data", "logsbackup"}
+	var prefixes []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := r.URL.Query().Get("prefix")
+		prefixes = append(prefixes, prefix)
+
+		var body strings.Builder
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix) {
+				body.WriteString(`<Container><Name>` + name + `</Name><Properties><Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified><Etag>"0x1"</Etag></Properties></Container>`)
+			}
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ServiceEndpoint="http://` + r.Host + `/"><Containers>` +
+			body.String() + `</Containers><NextMarker/></EnumerationResults>`))
+	}))
+	defer srv.Close()
+
+	svcClient, err := service.NewClientWithNoCredential(srv.URL+"/", &service.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	s.assert.Nil(err)
+	az := &AzStorage{storage: &BlockBlob{Service: svcClient}}
+
+	// Filtering is done by the service, the prefix is sent with the request
+	containers, err := az.ListContainers("logs-")
+	s.assert.Nil(err)
+	s.assert.Equal([]string{"logs-2023", "logs-2024"}, containers)
+
+	containers, err = az.ListContainers("")
+	s.assert.Nil(err)
+	s.assert.Len(containers, len(names))
+	s.assert.Equal([]string{"logs-", ""}, prefixes)
+}
+
+func (s *azStorageTestSuite) TestListContainersDetailed() {
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ServiceEndpoint="http://` + r.Host + `/"><Containers>` +
+			`<Container><Name>private</Name><Properties><Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified><Etag>"0x1"</Etag></Properties></Container>` +
+			`<Container><Name>public</Name><Properties><Last-Modified>Tue, 02 Jan 2024 00:00:00 GMT</Last-Modified><Etag>"0x2"</Etag>` +
+			`<PublicAccess>blob</PublicAccess></Properties><Metadata><owner>team</owner></Metadata></Container>` +
+			`</Containers><NextMarker/></EnumerationResults>`))
+	}))
+	defer srv.Close()
+
+	svcClient, err := service.NewClientWithNoCredential(srv.URL+"/", &service.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	s.assert.Nil(err)
+	az := &AzStorage{storage: &BlockBlob{Service: svcClient}}
+
+	containers, err := az.ListContainersDetailed()
+	s.assert.Nil(err)
+	s.assert.Len(containers, 2)
+
+	s.assert.Equal("private", containers[0].Name)
+	s.assert.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), containers[0].LastModified.UTC())
+	s.assert.Equal("0x1", containers[0].ETag)
+	s.assert.Empty(containers[0].PublicAccess)
+	s.assert.Empty(containers[0].Metadata)
+
+	s.assert.Equal("public", containers[1].Name)
+	s.assert.Equal("0x2", containers[1].ETag)
+	s.assert.Equal("blob", containers[1].PublicAccess)
+	s.assert.Equal("team", *containers[1].Metadata["owner"])
+
+	// Metadata comes with the listing, no call per container
+	s.assert.Len(requests, 1)
+	s.assert.Equal("metadata", requests[0].URL.Query().Get("include"))
+}
+
+func (s *azStorageTestSuite) TestCommitDataEmptyListGuard() {
+	conn := &fakeConnection{}
+	az := &AzStorage{storage: conn}
+
+	// Empty list for a file which has data would truncate it
+	err := az.CommitData(internal.CommitDataOptions{Name: "file", List: []string{}, Size: 10})
+	s.assert.Equal(syscall.EIO, err)
+	s.assert.Equal(0, conn.commitCalls)
+
+	// File meant to be empty can still be committed with an empty list
+	err = az.CommitData(internal.CommitDataOptions{Name: "file", List: []string{}, Size: 0})
+	s.assert.Nil(err)
+	s.assert.Equal(1, conn.commitCalls)
+
+	err = az.CommitData(internal.CommitDataOptions{Name: "file", List: []string{"blk"}, Size: 10})
+	s.assert.Nil(err)
+	s.assert.Equal(2, conn.commitCalls)
+}
+
+func (s *azStorageTestSuite) TestBlockIDLength() {
+	conn := &fakeConnection{}
+	az := &AzStorage{storage: conn}
+
+	// Blocks of a blob with ids of different length can not be committed
+	err := az.CommitData(internal.CommitDataOptions{Name: "file", List: []string{common.GetBlockID(16), common.GetBlockID(20)}, Size: 10})
+	s.assert.Equal(syscall.EINVAL, err)
+	s.assert.Equal(0, conn.commitCalls)
+
+	err = az.CommitData(internal.CommitDataOptions{Name: "file", List: []string{common.GetBlockID(20), common.GetBlockID(20)}, Size: 10})
+	s.assert.Nil(err)
+	s.assert.Equal(1, conn.commitCalls)
+
+	// Ids outside the supported length are rejected before staging
+	for _, length := range []int64{4, 65} {
+		err = az.StageData(internal.StageDataOptions{Name: "file", Data: []byte("data"), Id: common.GetBlockID(length)})
+		s.assert.Equal(syscall.EINVAL, err)
+	}
+}
+
+// newTreeServer : Serves a container listing of the given blob names (sorted), honouring prefix, delimiter, marker and maxresults.
+// Names ending with "/" are served as directory marker blobs named without the trailing slash.
+func newTreeServer(names []string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		q := r.URL.Query()
+		prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+		maxResults, _ := strconv.Atoi(q.Get("maxresults"))
+		if maxResults == 0 {
+			maxResults = int(common.MaxDirListCount)
+		}
+		start, _ := strconv.Atoi(q.Get("marker"))
+
+		var body strings.Builder
+		next, seen := "", ""
+		count := 0
+		for i := start; i < len(names); i++ {
+			isDir := strings.HasSuffix(names[i], "/")
+			name := strings.TrimSuffix(names[i], "/")
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if count == maxResults {
+				next = strconv.Itoa(i)
+				break
+			}
+
+			if rest := name[len(prefix):]; delimiter != "" && strings.Contains(rest, delimiter) {
+				sub := prefix + rest[:strings.Index(rest, delimiter)+1]
+				if sub != seen {
+					seen = sub
+					count++
+					body.WriteString(`<BlobPrefix><Name>` + sub + `</Name></BlobPrefix>`)
+				}
+				continue
+			}
+
+			count++
+			body.WriteString(`<Blob><Name>` + name + `</Name><Properties><Content-Length>10</Content-Length>` +
+				`<Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified></Properties>`)
+			if isDir {
+				body.WriteString(`<Metadata><hdi_isfolder>true</hdi_isfolder></Metadata>`)
+			}
+			body.WriteString(`</Blob>`)
+		}
+
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Blobs>` +
+			body.String() + `</Blobs><NextMarker>` + next + `</NextMarker></EnumerationResults>`))
+	}))
+}
+
+func newTreeBlockBlob(srv *httptest.Server) (*BlockBlob, error) {
+	containerClient, err := container.NewClientWithNoCredential(srv.URL+"/cont", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bb := &BlockBlob{Container: containerClient}
+	bb.Config.virtualDirectory = true
+	bb.listDetails = container.ListBlobsInclude{Metadata: true}
+	return bb, nil
+}
+
+func (s *azStorageTestSuite) TestListFlat() {
+	srv := newTreeServer([]string{"data/a", "data/d1/", "data/d1/b", "data/d1/d2/c", "data/e", "other/f"})
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	az := &AzStorage{storage: bb}
+
+	// All descendants come back in one enumeration, directory detected from its marker blob
+	list, marker, err := az.ListFlat("data", "", 0)
+	s.assert.Nil(err)
+	s.assert.Empty(marker)
+	s.assert.Len(list, 5)
+	paths := make([]string, 0, len(list))
+	for _, attr := range list {
+		paths = append(paths, attr.Path)
+		s.assert.Equal(attr.Path == "data/d1", attr.IsDir())
+	}
+	s.assert.Equal([]string{"data/a", "data/d1", "data/d1/b", "data/d1/d2/c", "data/e"}, paths)
+
+	// Paged enumeration resumes from the marker
+	list, marker, err = az.ListFlat("data/d1", "", 1)
+	s.assert.Nil(err)
+	s.assert.Len(list, 1)
+	s.assert.Equal("data/d1/b", list[0].Path)
+	s.assert.NotEmpty(marker)
+
+	list, marker, err = az.ListFlat("data/d1", marker, 1)
+	s.assert.Nil(err)
+	s.assert.Len(list, 1)
+	s.assert.Equal("data/d1/d2/c", list[0].Path)
+	s.assert.Empty(marker)
+}
+
+func (s *azStorageTestSuite) TestGetDirUsage() {
+	srv := newTreeServer([]string{"data/a", "data/d1/", "data/d1/b", "data/v/w/c", "other/f"})
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	az := &AzStorage{storage: bb}
+
+	// Directories are counted from marker blobs as well as from the paths of the children
+	size, files, dirs, err := az.GetDirUsage(context.Background(), "data")
+	s.assert.Nil(err)
+	s.assert.EqualValues(30, size)
+	s.assert.EqualValues(3, files)
+	s.assert.EqualValues(3, dirs)
+
+	// Mount prefix is honoured
+	bb.Config.prefixPath = "data"
+	size, files, dirs, err = az.GetDirUsage(context.Background(), "v/")
+	s.assert.Nil(err)
+	s.assert.EqualValues(10, size)
+	s.assert.EqualValues(1, files)
+	s.assert.EqualValues(1, dirs)
+
+	_, _, _, err = az.GetDirUsage(context.Background(), "missing")
+	s.assert.Equal(syscall.ENOENT, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, _, err = az.GetDirUsage(ctx, "v")
+	s.assert.ErrorIs(err, context.Canceled)
+}
+
+// corruptingTransport : Flips a byte of every request body after it was hashed, like a faulty link would
+type corruptingTransport struct {
+	corrupt bool
+}
+
+func (t *corruptingTransport) Do(req *http.Request) (*http.Response, error) {
+	if t.corrupt && req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > 0 {
+			body[0] ^= 0xff
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func (s *azStorageTestSuite) TestInvalidContainerNameAtMount() {
+	az := &AzStorage{}
+	az.stConfig.container = "Invalid_Container"
+	err := az.configureAndTest(true)
+	s.assert.NotNil(err)
+	s.assert.Contains(err.Error(), `container name "Invalid_Container" must be lower case`)
+	s.assert.Nil(az.storage)
+
+	az.stConfig.container = "valid"
+	az.stConfig.failoverContainer = "x"
+	err = az.configureAndTest(true)
+	s.assert.NotNil(err)
+	s.assert.Contains(err.Error(), `container name "x" must be 3 to 63 characters long`)
+}
+
+func (s *azStorageTestSuite) TestSetTier() {
+	tiers := map[string]string{"/cont/file": "Hot"}
+	hns := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tier, found := tiers[r.URL.Path]
+		if !found {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodHead {
+			w.Header().Set("x-ms-access-tier", tier)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		s.assert.Equal("tier", r.URL.Query().Get("comp"))
+		newTier := r.Header.Get("x-ms-access-tier")
+		if hns {
+			w.Header().Set("x-ms-error-code", "FeatureNotSupported")
+			w.WriteHeader(http.StatusConflict)
+			return
+		} else if newTier != "Hot" && newTier != "Cool" && newTier != "Cold" && newTier != "Archive" {
+			w.Header().Set("x-ms-error-code", "InvalidBlobTier")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		tiers[r.URL.Path] = newTier
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	az := &AzStorage{storage: bb}
+
+	err = az.SetTier("file", blob.AccessTierCool)
+	s.assert.Nil(err)
+	props, err := bb.Container.NewBlobClient("file").GetProperties(context.Background(), nil)
+	s.assert.Nil(err)
+	s.assert.NotNil(props.AccessTier)
+	s.assert.EqualValues(blob.AccessTierCool, *props.AccessTier)
+
+	err = az.SetTier("file", blob.AccessTier("Warm"))
+	s.assert.Equal(syscall.EINVAL, err)
+	err = az.SetTier("missing", blob.AccessTierCool)
+	s.assert.Equal(syscall.ENOENT, err)
+
+	// Account with hierarchical namespace rejecting tiering
+	hns = true
+	dl := &Datalake{}
+	dl.BlockBlob.Container = bb.Container
+	err = dl.SetTier("file", blob.AccessTierCold)
+	s.assert.Equal(syscall.ENOTSUP, err)
+	s.assert.Equal("Cool", tiers["/cont/file"])
+}
+
+func (s *azStorageTestSuite) TestReadInBufferConcurrentTruncate() {
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	var lock sync.Mutex
+	size, etag := len(content), "\"v1\""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if match := r.Header.Get("If-Match"); match != "" && match != etag {
+			w.Header().Set("x-ms-error-code", "ConditionNotMet")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		var start, end int
+		_, _ = fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &start, &end)
+		if start >= size {
+			w.Header().Set("x-ms-error-code", "InvalidRange")
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		end = min(end, size-1)
+
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusPartialContent)
+		if size < len(content) {
+			w.Write(content[start : end+1])
+			return
+		}
+
+		// Blob is truncated while its data is being streamed
+		w.Write(content[start : start+5])
+		w.(http.Flusher).Flush()
+		size, etag = 12, "\"v2\""
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.Config.readStreamRetries = 3
+
+	// Read is clamped to the new size and rest of the buffer is zero
+	data := bytes.Repeat([]byte{'x'}, 20)
+	var readEtag string
+	err = bb.ReadInBuffer("file", 4, 20, data, &readEtag)
+	s.assert.Nil(err)
+	s.assert.Equal(content[4:12], data[:8])
+	s.assert.Equal(make([]byte, 12), data[8:])
+	s.assert.Equal("v2", readEtag)
+
+	// Truncated to before the offset, nothing is left to read
+	size, etag = len(content), "\"v1\""
+	err = bb.ReadInBuffer("file", 20, 10, data, nil)
+	s.assert.Equal(syscall.ERANGE, err)
+
+	// Which AzStorage reports as the sparse region beyond end of blob
+	size, etag = len(content), "\"v1\""
+	data = bytes.Repeat([]byte{'x'}, 10)
+	n, err := (&AzStorage{storage: bb}).ReadInBuffer(internal.ReadInBufferOptions{Path: "file", Size: int64(len(content)), Offset: 20, Data: data})
+	s.assert.Nil(err)
+	s.assert.Equal(10, n)
+	s.assert.Equal(make([]byte, 10), data)
+}
+
+func (s *azStorageTestSuite) TestCommitDataIfMatch() {
+	etag := "v1"
+	var conditions []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		condition := r.Header.Get("If-Match")
+		conditions = append(conditions, condition)
+		if condition != "" && condition != `"`+etag+`"` {
+			w.Header().Set("x-ms-error-code", "ConditionNotMet")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		etag = "v2"
+		w.Header().Set("ETag", `"`+etag+`"`)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	az := &AzStorage{storage: bb}
+
+	newEtag := ""
+	err = az.CommitData(internal.CommitDataOptions{Name: "file", List: []string{"blk"}, NewETag: &newEtag, IfMatch: "v1"})
+	s.assert.Nil(err)
+	s.assert.Equal("v2", newEtag)
+
+	// Blob was changed by another writer after v1 was seen
+	err = az.CommitData(internal.CommitDataOptions{Name: "file", List: []string{"blk"}, NewETag: &newEtag, IfMatch: "v1"})
+	s.assert.Equal(syscall.EBUSY, err)
+
+	err = az.CommitData(internal.CommitDataOptions{Name: "file", List: []string{"blk"}, NewETag: &newEtag})
+	s.assert.Nil(err)
+	s.assert.Equal([]string{`"v1"`, `"v1"`, ""}, conditions)
+}
+
+func (s *azStorageTestSuite) TestFlushFileConcurrencyOverride() {
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		if r.Method == http.MethodHead {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("comp") == "block" {
+			lock.Lock()
+			inFlight++
+			maxInFlight = max(maxInFlight, inFlight)
+			lock.Unlock()
+
+			time.Sleep(50 * time.Millisecond)
+
+			lock.Lock()
+			inFlight--
+			lock.Unlock()
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.Config.maxConcurrency = 1
+	az := &AzStorage{storage: bb}
+
+	flush := func(concurrency uint16) int {
+		h := handlemap.NewHandle("file")
+		handlemap.CreateCacheObject(80, h)
+		for i := int64(0); i < 8; i++ {
+			blk := &common.Block{Id: common.GetBlockID(common.BlockIDLength), StartIndex: i * 10, EndIndex: (i + 1) * 10, Data: make([]byte, 10)}
+			blk.Flags.Set(common.DirtyBlock)
+			h.CacheObj.BlockOffsetList.BlockList = append(h.CacheObj.BlockOffsetList.BlockList, blk)
+		}
+
+		maxInFlight = 0
+		err := az.FlushFile(internal.FlushFileOptions{Handle: h, Concurrency: concurrency})
+		s.assert.Nil(err)
+		for _, blk := range h.CacheObj.BlockOffsetList.BlockList {
+			s.assert.False(blk.Dirty())
+		}
+		return maxInFlight
+	}
+
+	// Without an override the configured max-concurrency stages one block at a time
+	s.assert.EqualValues(1, flush(0))
+
+	// Override raises the number of blocks staged at once, but never above it
+	s.assert.EqualValues(4, flush(4))
+}
+
+func (s *azStorageTestSuite) TestXAttrRoundTrip() {
+	var lock sync.Mutex
+	metadata := http.Header{"X-Ms-Meta-Owner": []string{"app"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.URL.Path != "/cont/file" {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodPut && r.URL.Query().Get("comp") == "metadata" {
+			metadata = http.Header{}
+			for k, v := range r.Header {
+				if strings.HasPrefix(strings.ToLower(k), "x-ms-meta-") {
+					metadata[k] = v
+				}
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		for k, v := range metadata {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Length", "4")
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	az := &AzStorage{storage: bb}
+	az.stConfig.xattrKeys = map[string]string{"user.app.tag": xattrMetadataKey("user.app.tag")}
+
+	// Only configured attributes are persisted
+	err = az.SetXAttr(internal.SetXAttrOptions{Name: "file", Attr: "user.other", Value: []byte("x")})
+	s.assert.Equal(syscall.ENOTSUP, err)
+	_, err = az.GetXAttr(internal.GetXAttrOptions{Name: "file", Attr: "user.app.tag"})
+	s.assert.Equal(syscall.ENODATA, err)
+	err = az.SetXAttr(internal.SetXAttrOptions{Name: "file", Attr: "user.app.tag", Value: []byte("v1"), Flags: internal.XAttrReplace})
+	s.assert.Equal(syscall.ENODATA, err)
+
+	err = az.SetXAttr(internal.SetXAttrOptions{Name: "file", Attr: "user.app.tag", Value: []byte("v1"), Flags: internal.XAttrCreate})
+	s.assert.Nil(err)
+	value, err := az.GetXAttr(internal.GetXAttrOptions{Name: "file", Attr: "user.app.tag"})
+	s.assert.Nil(err)
+	s.assert.Equal([]byte("v1"), value)
+
+	err = az.SetXAttr(internal.SetXAttrOptions{Name: "file", Attr: "user.app.tag", Value: []byte("v2"), Flags: internal.XAttrCreate})
+	s.assert.Equal(syscall.EEXIST, err)
+	err = az.SetXAttr(internal.SetXAttrOptions{Name: "file", Attr: "user.app.tag", Value: []byte("v2")})
+	s.assert.Nil(err)
+	err = az.SetXAttr(internal.SetXAttrOptions{Name: "file", Attr: "user.app.tag", Value: []byte{0x01}})
+	s.assert.Equal(syscall.EINVAL, err)
+
+	// Attribute shows up in metadata of GetAttr next to the existing metadata
+	attr, err := az.GetAttr(internal.GetAttrOptions{Name: "file"})
+	s.assert.Nil(err)
+	s.assert.Len(attr.Metadata, 2)
+	found := false
+	for k, v := range attr.Metadata {
+		if strings.EqualFold(k, "xattr_user_app_tag") {
+			found = true
+			s.assert.Equal("v2", *v)
+		}
+	}
+	s.assert.True(found)
+
+	_, err = az.GetXAttr(internal.GetXAttrOptions{Name: "missing", Attr: "user.app.tag"})
+	s.assert.Equal(syscall.ENOENT, err)
+}
+
+// storedBlob : Content and headers of a blob kept by fakeBlobStore
+type storedBlob struct {
+	data   []byte
+	header http.Header
+}
+
+// fakeBlobStore : Keeps whole blobs with their metadata and content type, supports put, synchronous copy,
+// get, head, delete and listing
+type fakeBlobStore struct {
+	lock  sync.Mutex
+	blobs map[string]storedBlob
+}
+
+func (s *azStorageTestSuite) TestXAttrKeptOnOverwrite() {
+	var lock sync.Mutex
+	var data []byte
+	metadata := http.Header{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		if r.Method == http.MethodPut {
+			comp := r.URL.Query().Get("comp")
+			if comp != "block" {
+				// Put blob, commit and set metadata all replace the whole metadata of the blob
+				metadata = http.Header{}
+				for k, v := range r.Header {
+					if strings.HasPrefix(strings.ToLower(k), "x-ms-meta-") {
+						metadata[k] = v
+					}
+				}
+			}
+			if comp == "" {
+				data = body
+			}
+			if comp == "metadata" {
+				w.WriteHeader(http.StatusOK)
+			} else {
+				w.WriteHeader(http.StatusCreated)
+			}
+			return
+		}
+		if data == nil {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for k, v := range metadata {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.Config.xattrKeys = map[string]string{"user.app.tag": xattrMetadataKey("user.app.tag")}
+	az := &AzStorage{storage: bb}
+	az.stConfig.xattrKeys = bb.Config.xattrKeys
+
+	f, err := os.CreateTemp("", "xattr")
+	s.assert.Nil(err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	_, err = f.WriteString("data")
+	s.assert.Nil(err)
+
+	s.assert.Nil(az.CopyFromFile(internal.CopyFromFileOptions{Name: "file", File: f}))
+	s.assert.Nil(az.SetXAttr(internal.SetXAttrOptions{Name: "file", Attr: "user.app.tag", Value: []byte("v1")}))
+
+	// Upload of new content, as file cache does on flush, keeps the attribute
+	s.assert.Nil(az.CopyFromFile(internal.CopyFromFileOptions{Name: "file", File: f}))
+	value, err := az.GetXAttr(internal.GetXAttrOptions{Name: "file", Attr: "user.app.tag"})
+	s.assert.Nil(err)
+	s.assert.Equal([]byte("v1"), value)
+
+	// So does a commit of staged blocks, as block cache does on flush
+	id := base64.StdEncoding.EncodeToString([]byte("block-00"))
+	s.assert.Nil(az.CommitData(internal.CommitDataOptions{Name: "file", List: []string{id}, Size: 4}))
+	value, err = az.GetXAttr(internal.GetXAttrOptions{Name: "file", Attr: "user.app.tag"})
+	s.assert.Nil(err)
+	s.assert.Equal([]byte("v1"), value)
+}
+
+func newFakeBlobStore() *fakeBlobStore {
+	return &fakeBlobStore{blobs: map[string]storedBlob{}}
+}
+
+func (f *fakeBlobStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if r.URL.Query().Get("comp") == "list" {
+		f.list(w, r)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/cont/")
+
+	switch r.Method {
+	case http.MethodPut:
+		if source := r.Header.Get("x-ms-copy-source"); source != "" {
+			u, _ := url.Parse(source)
+			blob, ok := f.blobs[strings.TrimPrefix(u.Path, "/cont/")]
+			if !ok {
+				w.Header().Set("x-ms-error-code", "CannotVerifyCopySource")
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			f.blobs[name] = blob
+			w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+			w.Header().Set("ETag", `"etag"`)
+			w.Header().Set("x-ms-copy-status", "success")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		header := http.Header{}
+		for k, v := range r.Header {
+			if strings.HasPrefix(strings.ToLower(k), "x-ms-meta-") {
+				header[k] = v
+			}
+		}
+		header.Set("Content-Type", r.Header.Get("x-ms-blob-content-type"))
+		f.blobs[name] = storedBlob{data: body, header: header}
+		w.WriteHeader(http.StatusCreated)
+		return
+	case http.MethodDelete:
+		if _, ok := f.blobs[name]; ok {
+			delete(f.blobs, name)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+	}
+
+	blob, ok := f.blobs[name]
+	if !ok {
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	for k, v := range blob.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(blob.data)))
+	w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+	w.Header().Set("ETag", `"etag"`)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(blob.data)
+	}
+}
+
+// list : Single page listing of the stored blobs honouring prefix and delimiter, names are escaped as xml text
+func (f *fakeBlobStore) list(w http.ResponseWriter, r *http.Request) {
+	prefix, delimiter := r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter")
+	names := make([]string, 0, len(f.blobs))
+	for name := range f.blobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var body bytes.Buffer
+	seen := ""
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if rest := name[len(prefix):]; delimiter != "" && strings.Contains(rest, delimiter) {
+			if sub := prefix + rest[:strings.Index(rest, delimiter)+1]; sub != seen {
+				seen = sub
+				body.WriteString(`<BlobPrefix><Name>`)
+				_ = xml.EscapeText(&body, []byte(sub))
+				body.WriteString(`</Name></BlobPrefix>`)
+			}
+			continue
+		}
+		body.WriteString(`<Blob><Name>`)
+		_ = xml.EscapeText(&body, []byte(name))
+		body.WriteString(`</Name><Properties><Content-Length>` + strconv.Itoa(len(f.blobs[name].data)) + `</Content-Length>` +
+			`<Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified></Properties></Blob>`)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Blobs>` +
+		body.String() + `</Blobs><NextMarker/></EnumerationResults>`))
+}
+
+func (s *azStorageTestSuite) TestSymlinkFormat() {
+	store := newFakeBlobStore()
+	srv := httptest.NewServer(store)
+	defer srv.Close()
+
+	for _, format := range []string{SymlinkFormatMetadata, SymlinkFormatSuffix, SymlinkFormatContentOnly} {
+		bb, err := newTreeBlockBlob(srv)
+		s.assert.Nil(err)
+		bb.Config.symlinkFormat = format
+		bb.downloadOptions = &blob.DownloadFileOptions{}
+		az := &AzStorage{storage: bb}
+		az.stConfig.symlinkFormat = format
+
+		link := format + "/link"
+		s.assert.Nil(az.CreateLink(internal.CreateLinkOptions{Name: link, Target: "target/file"}))
+
+		attr, err := az.GetAttr(internal.GetAttrOptions{Name: link})
+		s.assert.Nil(err, format)
+		s.assert.True(attr.IsSymlink(), format)
+		s.assert.Equal(link, attr.Path, format)
+
+		target, err := az.ReadLink(internal.ReadLinkOptions{Name: link, Size: attr.Size})
+		s.assert.Nil(err, format)
+		s.assert.Equal("target/file", target, format)
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	blobs := store.blobs
+	s.assert.Contains(blobs["metadata/link"].header, "X-Ms-Meta-Is_symlink")
+	s.assert.Contains(blobs, "suffix/link.symlink")
+	s.assert.NotContains(blobs, "suffix/link")
+	s.assert.Equal("inode/symlink", blobs["content-only/link"].header.Get("Content-Type"))
+	s.assert.Len(blobs["content-only/link"].header, 1)
+}
+
+func (s *azStorageTestSuite) TestRenameSymlink() {
+	store := newFakeBlobStore()
+	srv := httptest.NewServer(store)
+	defer srv.Close()
+
+	for _, format := range []string{SymlinkFormatMetadata, SymlinkFormatSuffix, SymlinkFormatContentOnly} {
+		bb, err := newTreeBlockBlob(srv)
+		s.assert.Nil(err)
+		bb.Config.symlinkFormat = format
+		bb.downloadOptions = &blob.DownloadFileOptions{}
+		az := &AzStorage{storage: bb}
+		az.stConfig.symlinkFormat = format
+
+		link, moved := format+"/link", format+"/moved"
+		s.assert.Nil(az.CreateLink(internal.CreateLinkOptions{Name: link, Target: "target/file"}))
+		// Link replaces a regular file of the name it moves to
+		s.assert.Nil(bb.WriteFromBuffer(moved, nil, []byte("regular")))
+
+		attr, err := az.GetAttr(internal.GetAttrOptions{Name: link})
+		s.assert.Nil(err, format)
+		s.assert.Nil(az.RenameFile(internal.RenameFileOptions{Src: link, Dst: moved, SrcAttr: attr}), format)
+
+		_, err = az.GetAttr(internal.GetAttrOptions{Name: link})
+		s.assert.Equal(syscall.ENOENT, err, format)
+
+		// Link itself moved, its target is not followed
+		attr, err = az.GetAttr(internal.GetAttrOptions{Name: moved})
+		s.assert.Nil(err, format)
+		s.assert.True(attr.IsSymlink(), format)
+		target, err := az.ReadLink(internal.ReadLinkOptions{Name: moved, Size: attr.Size})
+		s.assert.Nil(err, format)
+		s.assert.Equal("target/file", target, format)
+	}
+
+	store.lock.Lock()
+	s.assert.NotContains(store.blobs, "suffix/moved")
+	s.assert.Contains(store.blobs, "suffix/moved.symlink")
+	store.lock.Unlock()
+
+	// A file replaces a link of the name it moves to
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.Config.symlinkFormat = SymlinkFormatSuffix
+	az := &AzStorage{storage: bb}
+	az.stConfig.symlinkFormat = SymlinkFormatSuffix
+	s.assert.Nil(bb.WriteFromBuffer("suffix/file", nil, []byte("regular")))
+	s.assert.Nil(az.RenameFile(internal.RenameFileOptions{Src: "suffix/file", Dst: "suffix/moved"}))
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	s.assert.Contains(store.blobs, "suffix/moved")
+	s.assert.NotContains(store.blobs, "suffix/moved.symlink")
+}
+
+func (s *azStorageTestSuite) TestGetAttrDeepPath() {
+	deep := strings.Repeat("d/", 199) + "file"
+	conn := &fakeConnection{attrs: map[string]*internal.ObjAttr{deep: {Path: deep, Name: "file"}}}
+	az := &AzStorage{storage: conn}
+
+	// Resolved directly, no parent of the path is looked up
+	attr, err := az.GetAttr(internal.GetAttrOptions{Name: deep})
+	s.assert.Nil(err)
+	s.assert.Equal(deep, attr.Path)
+	s.assert.Equal(1, conn.getAttrCalls)
+
+	az.stConfig.maxPathDepth = 200
+	_, err = az.GetAttr(internal.GetAttrOptions{Name: deep})
+	s.assert.Nil(err)
+	s.assert.Equal(2, conn.getAttrCalls)
+
+	// Beyond the cap the service is not asked at all
+	az.stConfig.maxPathDepth = 199
+	_, err = az.GetAttr(internal.GetAttrOptions{Name: deep})
+	s.assert.Equal(syscall.ENAMETOOLONG, err)
+	_, err = az.GetAttr(internal.GetAttrOptions{Name: deep + "/"})
+	s.assert.Equal(syscall.ENAMETOOLONG, err)
+	s.assert.Equal(2, conn.getAttrCalls)
+}
+
+func (s *azStorageTestSuite) TestDirOpDryRun() {
+	tree := newTreeServer([]string{"data/a", "data/d1/", "data/d1/b", "data/d1/d2/c", "data/e", "other/f"})
+	defer tree.Close()
+	var lock sync.Mutex
+	writes := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			lock.Lock()
+			writes++
+			lock.Unlock()
+		}
+		tree.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	az := &AzStorage{storage: bb}
+
+	preview := &internal.DirOpPreview{}
+	err = az.DeleteDir(internal.DeleteDirOptions{Name: "data/", DryRun: true, Preview: preview})
+	s.assert.Nil(err)
+	s.assert.ElementsMatch([]string{"data", "data/a", "data/d1", "data/d1/b", "data/d1/d2", "data/d1/d2/c", "data/e"}, preview.Paths)
+	s.assert.EqualValues(40, preview.TotalBytes)
+
+	preview = &internal.DirOpPreview{}
+	err = az.RenameDir(internal.RenameDirOptions{Src: "data/d1", Dst: "moved", DryRun: true, Preview: preview})
+	s.assert.Nil(err)
+	s.assert.ElementsMatch([]string{"data/d1", "data/d1/b", "data/d1/d2", "data/d1/d2/c"}, preview.Paths)
+	s.assert.EqualValues(20, preview.TotalBytes)
+
+	err = az.DeleteDir(internal.DeleteDirOptions{Name: "missing", DryRun: true, Preview: preview})
+	s.assert.Equal(syscall.ENOENT, err)
+	err = az.DeleteDir(internal.DeleteDirOptions{Name: "data", DryRun: true})
+	s.assert.Equal(syscall.EINVAL, err)
+
+	// Nothing was deleted or renamed
+	lock.Lock()
+	defer lock.Unlock()
+	s.assert.Zero(writes)
+}
+
+func (s *azStorageTestSuite) TestReadRanges() {
+	content := make([]byte, 10000)
+	_, _ = cryptorand.Read(content)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		var start, end int
+		_, _ = fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &start, &end)
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(content[start : end+1])
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	az := &AzStorage{storage: bb}
+	az.stConfig.maxConcurrency = 2
+
+	// Scattered ranges, the last one running past the end of blob
+	ranges := []Range{{Offset: 5000, Length: 100}, {Offset: 10, Length: 50}, {Offset: 9950, Length: 200}}
+	buffers := [][]byte{make([]byte, 100), make([]byte, 64), make([]byte, 200)}
+	read, err := az.ReadRanges("file", ranges, buffers)
+	s.assert.Nil(err)
+	s.assert.Equal([]int{100, 50, 50}, read)
+	s.assert.Equal(content[5000:5100], buffers[0])
+	s.assert.Equal(content[10:60], buffers[1][:50])
+	s.assert.Equal(content[9950:], buffers[2][:50])
+	s.assert.EqualValues(4, requests.Load())
+
+	// Range beyond the end reads nothing, buffer smaller than its range is rejected
+	read, err = az.ReadRanges("file", []Range{{Offset: 20000, Length: 10}}, [][]byte{make([]byte, 10)})
+	s.assert.Nil(err)
+	s.assert.Equal([]int{0}, read)
+	_, err = az.ReadRanges("file", []Range{{Offset: 0, Length: 10}}, [][]byte{make([]byte, 5)})
+	s.assert.Equal(syscall.EINVAL, err)
+}
+
+func (s *azStorageTestSuite) TestGetAttrCacheRevalidates() {
+	var lock sync.Mutex
+	etag := `"etag1"`
+	var plain, conditional int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if match := r.Header.Get("If-None-Match"); match != "" {
+			conditional++
+			if match == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		} else {
+			plain++
+		}
+		w.Header().Set("Content-Length", "10")
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	az := &AzStorage{storage: bb}
+	az.stConfig.attrCacheTTL = time.Minute
+
+	attr, err := az.GetAttr(internal.GetAttrOptions{Name: "file"})
+	s.assert.Nil(err)
+	s.assert.Equal("etag1", attr.ETag)
+	s.assert.Equal(1, plain)
+	s.assert.Equal(0, conditional)
+
+	// Second call within TTL is a conditional request answered from cache on 304
+	attr.Size = 0
+	attr, err = az.GetAttr(internal.GetAttrOptions{Name: "file"})
+	s.assert.Nil(err)
+	s.assert.EqualValues(10, attr.Size)
+	s.assert.Equal(1, plain)
+	s.assert.Equal(1, conditional)
+
+	// Changed blob is fetched again by the conditional request
+	lock.Lock()
+	etag = `"etag2"`
+	lock.Unlock()
+	attr, err = az.GetAttr(internal.GetAttrOptions{Name: "file"})
+	s.assert.Nil(err)
+	s.assert.Equal("etag2", attr.ETag)
+	s.assert.Equal(2, conditional)
+
+	// Expired entry is fetched without condition
+	az.attrCache["file"] = cachedAttr{attr: attr, cachedAt: time.Now().Add(-time.Hour)}
+	_, err = az.GetAttr(internal.GetAttrOptions{Name: "file"})
+	s.assert.Nil(err)
+	s.assert.Equal(2, plain)
+	s.assert.Equal(2, conditional)
+}
+
+func (s *azStorageTestSuite) TestReservedCharacterNames() {
+	store := newFakeBlobStore()
+	srv := httptest.NewServer(store)
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	az := &AzStorage{storage: bb}
+
+	names := []string{"a#b", "a%20b", "a%b", "a.", "a ", " a", "a?b", "a+b", "a&b=c", "a$b", "ünïcødé", "日本語.txt", "a b/c"}
+	for _, name := range names {
+		path := "dir/" + name
+
+		_, err = az.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0644})
+		s.assert.Nil(err, name)
+
+		store.lock.Lock()
+		_, stored := store.blobs[path]
+		store.lock.Unlock()
+		s.assert.True(stored, name)
+
+		attr, err := az.GetAttr(internal.GetAttrOptions{Name: path})
+		s.assert.Nil(err, name)
+		s.assert.Equal(path, attr.Path, name)
+
+		// Listing the parent of the topmost segment returns the same name
+		top, _, nested := strings.Cut(name, "/")
+		list, err := az.ReadDir(internal.ReadDirOptions{Name: "dir"})
+		s.assert.Nil(err, name)
+		s.assert.Len(list, 1, name)
+		s.assert.Equal("dir/"+top, list[0].Path, name)
+		s.assert.Equal(top, list[0].Name, name)
+		s.assert.Equal(nested, list[0].IsDir(), name)
+
+		s.assert.Nil(az.DeleteFile(internal.DeleteFileOptions{Name: path}), name)
+		_, err = az.GetAttr(internal.GetAttrOptions{Name: path})
+		s.assert.Equal(syscall.ENOENT, err, name)
+	}
+}
+
+// newRangeServer : Serves content of a single blob, each range request is delayed to stand for network latency
+func newRangeServer(content []byte, delay time.Duration, ranges *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("ETag", `"etag"`)
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		ranges.Add(1)
+		time.Sleep(delay)
+		var start, end int
+		_, _ = fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &start, &end)
+		end = min(end, len(content)-1)
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(content[start : end+1])
+	}))
+}
+
+func (s *azStorageTestSuite) TestListTierFromListing() {
+	var lock sync.Mutex
+	var methods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		methods = append(methods, r.Method)
+		lock.Unlock()
+		if r.Method != http.MethodGet || r.URL.Query().Get("comp") != "list" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Prefix>dir/</Prefix><Blobs>` +
+			`<Blob><Name>dir/cool</Name><Properties><Content-Length>1</Content-Length><AccessTier>Cool</AccessTier></Properties></Blob>` +
+			`<Blob><Name>dir/hot</Name><Properties><Content-Length>1</Content-Length><AccessTier>Hot</AccessTier></Properties></Blob>` +
+			`<Blob><Name>dir/none</Name><Properties><Content-Length>1</Content-Length></Properties></Blob>` +
+			`</Blobs><NextMarker/></EnumerationResults>`))
+	}))
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	az := &AzStorage{storage: bb}
+
+	entries, _, err := az.StreamDir(internal.StreamDirOptions{Name: "dir"})
+	s.assert.Nil(err)
+	s.assert.Len(entries, 3)
+
+	tiers := make(map[string]*string)
+	for _, e := range entries {
+		tiers[e.Name] = e.Metadata[accessTierKey]
+	}
+	s.assert.NotNil(tiers["cool"])
+	s.assert.Equal("Cool", *tiers["cool"])
+	s.assert.NotNil(tiers["hot"])
+	s.assert.Equal("Hot", *tiers["hot"])
+	s.assert.Nil(tiers["none"])
+
+	// Tier came from the listing alone, no property lookup per blob
+	s.assert.Equal([]string{http.MethodGet}, methods)
+}
+
+func (s *azStorageTestSuite) TestLinkToLink() {
+	store := newFakeBlobStore()
+	srv := httptest.NewServer(store)
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.downloadOptions = &blob.DownloadFileOptions{}
+	az := &AzStorage{storage: bb}
+
+	s.assert.Nil(bb.WriteFromBuffer("dir/file", nil, []byte("content")))
+	s.assert.Nil(az.CreateLink(internal.CreateLinkOptions{Name: "dir/first", Target: "file"}))
+	s.assert.Nil(az.CreateLink(internal.CreateLinkOptions{Name: "dir/second", Target: "first"}))
+
+	// Target is stored as given, not resolved through the link it names
+	attr, err := az.GetAttr(internal.GetAttrOptions{Name: "dir/second"})
+	s.assert.Nil(err)
+	s.assert.True(attr.IsSymlink())
+	target, err := az.ReadLink(internal.ReadLinkOptions{Name: "dir/second", Size: attr.Size})
+	s.assert.Nil(err)
+	s.assert.Equal("first", target)
+
+	// Following resolves a single level and lands on the intermediate link
+	attr, err = az.GetAttr(internal.GetAttrOptions{Name: "dir/second", FollowSymlink: true})
+	s.assert.Nil(err)
+	s.assert.Equal("dir/first", attr.Path)
+	s.assert.True(attr.IsSymlink())
+
+	attr, err = az.GetAttr(internal.GetAttrOptions{Name: "dir/first", FollowSymlink: true})
+	s.assert.Nil(err)
+	s.assert.Equal("dir/file", attr.Path)
+	s.assert.False(attr.IsSymlink())
+	s.assert.EqualValues(len("content"), attr.Size)
+
+	// Links leaving the container are returned as they are
+	s.assert.Nil(az.CreateLink(internal.CreateLinkOptions{Name: "dir/outside", Target: "../../etc"}))
+	attr, err = az.GetAttr(internal.GetAttrOptions{Name: "dir/outside", FollowSymlink: true})
+	s.assert.Nil(err)
+	s.assert.Equal("dir/outside", attr.Path)
+	s.assert.True(attr.IsSymlink())
+}
+
+func (s *azStorageTestSuite) TestReadAheadSequential() {
+	content := make([]byte, 10000)
+	_, _ = cryptorand.Read(content)
+	var downloads atomic.Int32
+	srv := newRangeServer(content, 0, &downloads)
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	az := &AzStorage{storage: bb}
+	az.stConfig.readAheadSize = 4096
+
+	handle := handlemap.NewHandle("file")
+	handle.Size = int64(len(content))
+
+	// Sequential reads are served from three prefetches covering the whole file
+	reads := 0
+	data := make([]byte, 512)
+	for offset := 0; offset < len(content); offset += len(data) {
+		n, err := az.ReadInBuffer(internal.ReadInBufferOptions{Handle: handle, Offset: int64(offset), Data: data})
+		s.assert.Nil(err)
+		s.assert.Equal(min(len(data), len(content)-offset), n)
+		s.assert.Equal(content[offset:offset+n], data[:n])
+		reads++
+	}
+	s.assert.Equal(20, reads)
+	s.assert.EqualValues(3, downloads.Load())
+
+	// Read elsewhere is fetched as it is, prefetch resumes once reads continue from it
+	downloads.Store(0)
+	n, err := az.ReadInBuffer(internal.ReadInBufferOptions{Handle: handle, Offset: 100, Data: data})
+	s.assert.Nil(err)
+	s.assert.Equal(content[100:100+n], data[:n])
+	s.assert.EqualValues(1, downloads.Load())
+
+	for offset := 612; offset < 4096; offset += len(data) {
+		n, err = az.ReadInBuffer(internal.ReadInBufferOptions{Handle: handle, Offset: int64(offset), Data: data})
+		s.assert.Nil(err)
+		s.assert.Equal(content[offset:offset+n], data[:n])
+	}
+	s.assert.EqualValues(2, downloads.Load())
+}
+
+func (s *azStorageTestSuite) TestWriteReadOnlyHandle() {
+	store := newFakeBlobStore()
+	srv := httptest.NewServer(store)
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.downloadOptions = &blob.DownloadFileOptions{}
+	az := &AzStorage{storage: bb}
+	s.assert.Nil(bb.WriteFromBuffer("file", nil, []byte("content")))
+
+	// Write is refused right away and the blob is left as it was
+	handle, err := az.OpenFile(internal.OpenFileOptions{Name: "file", Flags: os.O_RDONLY})
+	s.assert.Nil(err)
+	s.assert.True(handle.ReadOnly())
+	n, err := az.WriteFile(internal.WriteFileOptions{Handle: handle, Offset: 0, Data: []byte("CONTENT")})
+	s.assert.Equal(syscall.EBADF, err)
+	s.assert.Equal(0, n)
+
+	store.lock.Lock()
+	s.assert.Equal("content", string(store.blobs["file"].data))
+	store.lock.Unlock()
+
+	// Handle opened for writing is not marked
+	handle, err = az.OpenFile(internal.OpenFileOptions{Name: "file", Flags: os.O_RDWR})
+	s.assert.Nil(err)
+	s.assert.False(handle.ReadOnly())
+}
+
+func (s *azStorageTestSuite) TestAzCLICredentialNotInstalled() {
+	// CLI can not be found on an empty path, same as it not being installed
+	s.T().Setenv("PATH", "")
+
+	azcli := &azAuthCLI{azAuthBase{config: azAuthConfig{AuthMode: EAuthType.AZCLI(), TenantID: "tenant"}}}
+	cred, err := azcli.getTokenCredential()
+	s.assert.Nil(err)
+	s.assert.NotNil(cred)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{"https://storage.azure.com/.default"}})
+	s.assert.NotNil(err)
+	s.assert.Contains(err.Error(), "az login")
+}
+
+func (s *azStorageTestSuite) TestStreamDirCountOverServiceMax() {
+	conn := &fakeConnection{listItems: 3*common.MaxDirListCount + 10}
+	az := &AzStorage{storage: conn}
+
+	// More than the service page max is requested, all of it comes back in one call
+	count := int32(2*common.MaxDirListCount + 100)
+	list, marker, err := az.StreamDir(internal.StreamDirOptions{Name: "dir", Count: count})
+	s.assert.Nil(err)
+	s.assert.Len(list, int(count))
+	s.assert.Equal(strconv.Itoa(int(count)), marker)
+	s.assert.Equal([]int32{common.MaxDirListCount, common.MaxDirListCount, 100}, conn.listCounts)
+
+	// Rest of the directory is shorter than the count
+	conn.listCounts = nil
+	list, marker, err = az.StreamDir(internal.StreamDirOptions{Name: "dir", Token: marker, Count: count})
+	s.assert.Nil(err)
+	s.assert.Len(list, conn.listItems-int(count))
+	s.assert.Empty(marker)
+	s.assert.Equal([]int32{common.MaxDirListCount}, conn.listCounts)
+}
+
+func (s *azStorageTestSuite) TestStreamDirDeletedBetweenPages() {
+	conn := &fakeConnection{listItems: 10, listDeletedAfter: 1}
+	az := &AzStorage{storage: conn}
+
+	list, marker, err := az.StreamDir(internal.StreamDirOptions{Name: "dir", Count: 4})
+	s.assert.Nil(err)
+	s.assert.Len(list, 4)
+	s.assert.Equal("4", marker)
+
+	// By default the next page fails clearly instead of with a raw service error
+	list, marker, err = az.StreamDir(internal.StreamDirOptions{Name: "dir", Token: marker, Count: 4})
+	s.assert.Equal(syscall.ENOENT, err)
+	s.assert.Nil(list)
+	s.assert.Empty(marker)
+
+	// Configured to end the listing, the directory listing simply completes
+	az.stConfig.endDeletedList = true
+	list, marker, err = az.StreamDir(internal.StreamDirOptions{Name: "dir", Token: "4", Count: 4})
+	s.assert.Nil(err)
+	s.assert.Empty(list)
+	s.assert.Empty(marker)
+
+	// Deleted while a count larger than a page is being fulfilled, what was listed is returned
+	conn = &fakeConnection{listItems: 3 * common.MaxDirListCount, listDeletedAfter: 1}
+	az = &AzStorage{storage: conn}
+	az.stConfig.endDeletedList = true
+	list, marker, err = az.StreamDir(internal.StreamDirOptions{Name: "dir", Count: 2 * common.MaxDirListCount})
+	s.assert.Nil(err)
+	s.assert.Len(list, common.MaxDirListCount)
+	s.assert.Empty(marker)
+
+	az.stConfig.endDeletedList = false
+	conn.listCounts = nil
+	_, _, err = az.StreamDir(internal.StreamDirOptions{Name: "dir", Count: 2 * common.MaxDirListCount})
+	s.assert.Equal(syscall.ENOENT, err)
+}
+
+func (s *azStorageTestSuite) TestFederatedTokenFileRotation() {
+	path := filepath.Join(s.T().TempDir(), "token")
+	s.assert.Nil(os.WriteFile(path, []byte("token1\n"), 0600))
+
+	tokenFile := &federatedTokenFile{path: path, ttl: time.Minute}
+	token, err := tokenFile.getAssertion(context.Background())
+	s.assert.Nil(err)
+	s.assert.Equal("token1", token)
+
+	// Rotated file is not read again till the cached assertion expires
+	s.assert.Nil(os.WriteFile(path, []byte("token2"), 0600))
+	token, err = tokenFile.getAssertion(context.Background())
+	s.assert.Nil(err)
+	s.assert.Equal("token1", token)
+
+	tokenFile.readAt = time.Now().Add(-2 * time.Minute)
+	token, err = tokenFile.getAssertion(context.Background())
+	s.assert.Nil(err)
+	s.assert.Equal("token2", token)
+}
+
+// fakeTokenCredential : hands out tokens expiring after the given duration and counts the calls
+type fakeTokenCredential struct {
+	calls    int
+	validFor time.Duration
+}
+
+func (f *fakeTokenCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	f.calls++
+	return azcore.AccessToken{Token: "token" + strconv.Itoa(f.calls), ExpiresOn: time.Now().Add(f.validFor)}, nil
+}
+
+func (s *azStorageTestSuite) TestTokenCacheValidToken() {
+	dir := s.T().TempDir()
+	opts := policy.TokenRequestOptions{Scopes: []string{"https://storage.azure.com/.default"}}
+
+	first := &fakeTokenCredential{validFor: time.Hour}
+	token, err := newCachedTokenCredential(first, dir, "account/tenant/client", "secret").GetToken(context.Background(), opts)
+	s.assert.Nil(err)
+	s.assert.Equal("token1", token.Token)
+	s.assert.Equal(1, first.calls)
+
+	// Remount picks up the token from disk without going to the token endpoint
+	second := &fakeTokenCredential{validFor: time.Hour}
+	token, err = newCachedTokenCredential(second, dir, "account/tenant/client", "secret").GetToken(context.Background(), opts)
+	s.assert.Nil(err)
+	s.assert.Equal("token1", token.Token)
+	s.assert.Equal(0, second.calls)
+
+	// Token is stored encrypted
+	files, _ := filepath.Glob(filepath.Join(dir, "*.token"))
+	s.assert.Len(files, 1)
+	data, _ := os.ReadFile(files[0])
+	s.assert.NotContains(string(data), "token1")
+
+	// Different identity or passphrase can not use it
+	other := &fakeTokenCredential{validFor: time.Hour}
+	_, err = newCachedTokenCredential(other, dir, "account/tenant/other", "secret").GetToken(context.Background(), opts)
+	s.assert.Nil(err)
+	_, err = newCachedTokenCredential(other, dir, "account/tenant/client", "wrong").GetToken(context.Background(), opts)
+	s.assert.Nil(err)
+	s.assert.Equal(2, other.calls)
+}
+
+func (s *azStorageTestSuite) TestTokenCacheExpiredToken() {
+	dir := s.T().TempDir()
+	opts := policy.TokenRequestOptions{Scopes: []string{"https://storage.azure.com/.default"}}
+
+	// Token cached by the earlier mount expires within the margin
+	first := &fakeTokenCredential{validFor: time.Minute}
+	_, err := newCachedTokenCredential(first, dir, "account/tenant/client", "secret").GetToken(context.Background(), opts)
+	s.assert.Nil(err)
+
+	second := &fakeTokenCredential{validFor: time.Hour}
+	cred := newCachedTokenCredential(second, dir, "account/tenant/client", "secret")
+	s.assert.NotNil(cred.token)
+	token, err := cred.GetToken(context.Background(), opts)
+	s.assert.Nil(err)
+	s.assert.Equal("token1", token.Token)
+	s.assert.Equal(1, second.calls)
+
+	// Refreshed token is what the next mount gets
+	third := &fakeTokenCredential{validFor: time.Hour}
+	token, err = newCachedTokenCredential(third, dir, "account/tenant/client", "secret").GetToken(context.Background(), opts)
+	s.assert.Nil(err)
+	s.assert.Equal("token1", token.Token)
+	s.assert.Equal(0, third.calls)
+	s.assert.True(time.Until(token.ExpiresOn) > 30*time.Minute)
+}
+
+// writeSelfSignedCert : write a PEM file holding a self signed certificate and its private key
+func writeSelfSignedCert(path string) error {
+	key, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "blobfuse2-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})...)
+	return os.WriteFile(path, data, 0600)
+}
+
+func (s *azStorageTestSuite) TestSPNClientCertificate() {
+	path := filepath.Join(s.T().TempDir(), "spn.pem")
+	s.assert.Nil(writeSelfSignedCert(path))
+
+	azspn := &azAuthSPN{azAuthBase: azAuthBase{config: azAuthConfig{
+		AuthMode:       EAuthType.SPN(),
+		TenantID:       "00000000-0000-0000-0000-000000000000",
+		ClientID:       "client",
+		ClientCertPath: path,
+		Endpoint:       "https://account.blob.core.windows.net/",
+	}}}
+	cred, err := azspn.getTokenCredential()
+	s.assert.Nil(err)
+	s.assert.IsType(&azidentity.ClientCertificateCredential{}, cred)
+
+	// Not a certificate
+	s.assert.Nil(os.WriteFile(path, []byte("junk"), 0600))
+	_, err = azspn.getTokenCredential()
+	s.assert.NotNil(err)
+
+	azspn.config.ClientCertPath = filepath.Join(s.T().TempDir(), "missing.pem")
+	_, err = azspn.getTokenCredential()
+	s.assert.NotNil(err)
+}
+
+func (s *azStorageTestSuite) TestVerifyUploads() {
+	dir := s.T().TempDir()
+	data := []byte("verify upload data")
+
+	files := make(map[string]string)
+	attrs := make(map[string]*internal.ObjAttr)
+	for _, name := range []string{"good", "goodmd5", "corrupted", "badmd5", "missing"} {
+		path := filepath.Join(dir, name)
+		s.assert.Nil(os.WriteFile(path, data, 0644))
+		files[name] = path
+	}
+
+	sum := md5.Sum(data)
+	attrs["good"] = &internal.ObjAttr{Size: int64(len(data))}
+	attrs["goodmd5"] = &internal.ObjAttr{Size: int64(len(data)), MD5: sum[:]}
+	attrs["corrupted"] = &internal.ObjAttr{Size: int64(len(data) - 1)}
+	attrs["badmd5"] = &internal.ObjAttr{Size: int64(len(data)), MD5: []byte("0123456789abcdef")}
+
+	az := &AzStorage{storage: &fakeConnection{attrs: attrs}}
+	az.stConfig.maxConcurrency = 2
+
+	mismatches := az.VerifyUploads(files)
+	s.assert.Len(mismatches, 3)
+
+	reasons := make(map[string]string)
+	for _, m := range mismatches {
+		s.assert.Equal(files[m.Name], m.LocalPath)
+		reasons[m.Name] = m.Reason
+	}
+	s.assert.Contains(reasons["corrupted"], "size mismatch")
+	s.assert.Contains(reasons["badmd5"], "md5 mismatch")
+	s.assert.Contains(reasons["missing"], "failed to get blob properties")
+}
+
+func (s *azStorageTestSuite) TestGetAttrTrailingSlash() {
+	conn := &fakeConnection{attrs: map[string]*internal.ObjAttr{
+		"foo": {Path: "foo", Name: "foo", Flags: internal.NewFileBitMap()},
+		"dir": {Path: "dir", Name: "dir", Flags: internal.NewDirBitMap()},
+	}}
+	az := &AzStorage{storage: conn}
+
+	attr, err := az.GetAttr(internal.GetAttrOptions{Name: "foo"})
+	s.assert.Nil(err)
+	s.assert.False(attr.IsDir())
+
+	_, err = az.GetAttr(internal.GetAttrOptions{Name: "foo/"})
+	s.assert.Equal(syscall.ENOTDIR, err)
+
+	attr, err = az.GetAttr(internal.GetAttrOptions{Name: "dir/"})
+	s.assert.Nil(err)
+	s.assert.True(attr.IsDir())
+
+	_, err = az.GetAttr(internal.GetAttrOptions{Name: "missing/"})
+	s.assert.Equal(syscall.ENOENT, err)
+}
+
+func TestAzStorageTestSuite(t *testing.T) {
+	suite.Run(t, new(azStorageTestSuite))
+}
+
+func BenchmarkBufferPool(b *testing.B) {
+	_ = log.SetDefaultLogger("silent", common.LogConfig{})
+	const blockSize = common.MbToBytes
+	content := make([]byte, 8*blockSize)
+
+	upload := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upload.Close()
+	var ranges atomic.Int32
+	download := newRangeServer(content, 0, &ranges)
+	defer download.Close()
+
+	uploadBB, err := newTreeBlockBlob(upload)
+	if err != nil {
+		b.Fatal(err)
+	}
+	downloadBB, err := newTreeBlockBlob(download)
+	if err != nil {
+		b.Fatal(err)
+	}
+	az := &AzStorage{storage: downloadBB}
+	az.stConfig.readAheadSize = blockSize
+
+	// Nil pool allocates every buffer as it was done before pooling
+	for _, pooled := range []bool{false, true} {
+		name := "unpooled"
+		uploadBB.buffers, az.readAheadBuffers = nil, nil
+		if pooled {
+			name = "pooled"
+			uploadBB.buffers, az.readAheadBuffers = newBufferPool(blockSize), newBufferPool(blockSize)
+		}
+
+		b.Run("stage/"+name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(content)))
+			blobClient := uploadBB.Container.NewBlockBlobClient("file")
+			for i := 0; i < b.N; i++ {
+				err := uploadBB.streamReaderAtToBlockBlob(context.Background(), blobClient, bytes.NewReader(content), int64(len(content)),
+					&blockblob.UploadFileOptions{BlockSize: blockSize, Concurrency: 4})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run("readahead/"+name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(content)))
+			data := make([]byte, 128*1024)
+			for i := 0; i < b.N; i++ {
+				handle := handlemap.NewHandle("file")
+				handle.Size = int64(len(content))
+				for offset := 0; offset < len(content); offset += len(data) {
+					_, err := az.ReadInBuffer(internal.ReadInBufferOptions{Handle: handle, Offset: int64(offset), Data: data})
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
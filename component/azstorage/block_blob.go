@@ -36,21 +36,33 @@ package azstorage
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc64"
 	"io"
 	"math"
+	"mime"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
@@ -62,20 +74,69 @@ import (
 )
 
 const (
-	folderKey           = "hdi_isfolder"
-	symlinkKey          = "is_symlink"
+	folderKey   = "hdi_isfolder"
+	symlinkKey  = "is_symlink"
+	unixModeKey = "mode"
+
+	// Name suffix and content type identifying symlinks in the suffix and content-only formats
+	symlinkSuffix      = ".symlink"
+	symlinkContentType = "inode/symlink"
+
+	// Keys under which GetAttr reports the copy state, named after the headers so they never clash with user metadata
+	copyStatusKey       = "x-ms-copy-status"
+	copyProgressKey     = "x-ms-copy-progress"
+	copySourceKey       = "x-ms-copy-source"
+	incrementalCopyKey  = "x-ms-incremental-copy"
 	max_context_timeout = 5
+
+	// Keys under which GetAttr reports the legal hold and immutability policy of a blob
+	legalHoldKey        = "x-ms-legal-hold"
+	immutableUntilKey   = "x-ms-immutability-policy-until-date"
+	immutabilityModeKey = "x-ms-immutability-policy-mode"
+
+	// Key under which List reports the access tier returned in the listing
+	accessTierKey = "x-ms-access-tier"
+
+	// Largest number of sub-requests the service accepts in one blob batch
+	maxBatchDeleteSize = 256
+
+	// Size of pooled staging buffers when no block size is configured
+	defaultStagingBufferSize = 16 * 1024 * 1024
 )
 
+// Wait before the first status check of a server side copy, doubled after every check up to maxCopyPollInterval
+var copyPollInterval = 500 * time.Millisecond
+
+const maxCopyPollInterval = 8 * time.Second
+
 type BlockBlob struct {
 	AzStorageConnection
 	Auth            azAuth
 	Service         *service.Client
 	Container       *container.Client
+	clientLock      sync.RWMutex // guards Service and Container, replaced on credential rotation
 	blobCPKOpt      *blob.CPKInfo
 	downloadOptions *blob.DownloadFileOptions
 	listDetails     container.ListBlobsInclude
 	blockLocks      common.KeyedMutex
+
+	// Malformed mode in metadata is logged only for the first blob it is seen on
+	malformedModeLogged atomic.Bool
+
+	// Progress of directory renames which did not complete, keyed by source and target
+	dirRenames sync.Map
+
+	// Container has an immutability policy so uploads carry the configured one, detected at mount
+	immutableContainer bool
+
+	// Block sized staging buffers shared by uploads, nil when the connection is not configured
+	buffers *bufferPool
+}
+
+// dirRenameProgress : Source blobs of a directory rename which were copied but are not yet deleted
+type dirRenameProgress struct {
+	lock   sync.Mutex
+	copied map[string]bool
 }
 
 // Verify that BlockBlob implements AzConnection interface
@@ -100,33 +161,48 @@ func (bb *BlockBlob) Configure(cfg AzStorageConfig) error {
 		BlockSize:   bb.Config.blockSize,
 		Concurrency: bb.Config.maxConcurrency,
 		CPKInfo:     bb.blobCPKOpt,
+		RetryReaderOptionsPerBlock: blob.RetryReaderOptions{
+			MaxRetries: bb.Config.readStreamRetries,
+		},
 	}
 
 	bb.listDetails = container.ListBlobsInclude{
 		Metadata:    true,
-		Deleted:     false,
+		Deleted:     bb.Config.listDeleted,
 		Snapshots:   false,
 		Permissions: false, //Added to get permissions, acl, group, owner for HNS accounts
 	}
 
+	// Without a configured block size streamed writes stage 16MB blocks
+	poolSize := bb.Config.blockSize
+	if poolSize == 0 {
+		poolSize = defaultStagingBufferSize
+	}
+	bb.buffers = newBufferPool(poolSize)
+
 	return nil
 }
 
 // For dynamic config update the config here
 func (bb *BlockBlob) UpdateConfig(cfg AzStorageConfig) error {
-	bb.Config.blockSize = cfg.blockSize
+	bb.Config.blockSize = clampBlockSize(cfg.blockSize, cfg.allowTinyBlocks)
 	bb.Config.maxConcurrency = cfg.maxConcurrency
 	bb.Config.defaultTier = cfg.defaultTier
 	bb.Config.ignoreAccessModifiers = cfg.ignoreAccessModifiers
+	bb.Config.listDirMarker = cfg.listDirMarker
 	return nil
 }
 
-// UpdateServiceClient : Update the SAS specified by the user and create new service client
+// UpdateServiceClient : Update the SAS or account key specified by the user and create new service client
+// Operations already in flight complete on the old client, new ones pick up the rebuilt client.
 func (bb *BlockBlob) UpdateServiceClient(key, value string) (err error) {
-	if key == "saskey" {
+	if key == "saskey" || key == "accountkey" {
+		bb.clientLock.Lock()
+		defer bb.clientLock.Unlock()
+
 		bb.Auth.setOption(key, value)
 
-		// get the service client with updated SAS
+		// get the service client with updated credential
 		svcClient, err := bb.Auth.getServiceClient(&bb.Config)
 		if err != nil {
 			log.Err("BlockBlob::UpdateServiceClient : Failed to get service client [%s]", err.Error())
@@ -142,6 +218,20 @@ func (bb *BlockBlob) UpdateServiceClient(key, value string) (err error) {
 	return nil
 }
 
+// serviceClient : Service client in use, it is replaced when the credential is rotated
+func (bb *BlockBlob) serviceClient() *service.Client {
+	bb.clientLock.RLock()
+	defer bb.clientLock.RUnlock()
+	return bb.Service
+}
+
+// containerClient : Container client in use, it is replaced when the credential is rotated
+func (bb *BlockBlob) containerClient() *container.Client {
+	bb.clientLock.RLock()
+	defer bb.clientLock.RUnlock()
+	return bb.Container
+}
+
 // createServiceClient : Create the service client
 func (bb *BlockBlob) createServiceClient() (*service.Client, error) {
 	log.Trace("BlockBlob::createServiceClient : Getting service client")
@@ -182,16 +272,24 @@ func (bb *BlockBlob) SetupPipeline() error {
 func (bb *BlockBlob) TestPipeline() error {
 	log.Trace("BlockBlob::TestPipeline : Validating")
 
+	if bb.Config.authConfig.AuthMode == EAuthType.WORKLOADIDENTITY() && bb.Config.authConfig.TokenFilePath != "" {
+		err := validateTokenFile(bb.Config.authConfig.TokenFilePath)
+		if err != nil {
+			log.Err("BlockBlob::TestPipeline : %s", err.Error())
+			return err
+		}
+	}
+
 	if bb.Config.mountAllContainers {
 		return nil
 	}
 
-	if bb.Container == nil || bb.Container.URL() == "" {
+	if bb.containerClient() == nil || bb.containerClient().URL() == "" {
 		log.Err("BlockBlob::TestPipeline : Container Client is not built, check your credentials")
 		return nil
 	}
 
-	listBlobPager := bb.Container.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
+	listBlobPager := bb.containerClient().NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
 		MaxResults: to.Ptr((int32)(2)),
 		Prefix:     &bb.Config.prefixPath,
 	})
@@ -208,15 +306,103 @@ func (bb *BlockBlob) TestPipeline() error {
 		return err
 	}
 
+	bb.checkSoftDelete()
+	return bb.checkImmutability()
+}
+
+// Ping : Confirm the service is reachable and still accepts the credentials with a single request which does not
+// change any state. Request is not retried and waits at most defaultPingTimeout unless ctx has a deadline.
+func (bb *BlockBlob) Ping(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultPingTimeout)
+		defer cancel()
+	}
+	ctx = policy.WithRetryOptions(ctx, policy.RetryOptions{MaxRetries: -1})
+
+	var err error
+	if bb.Config.mountAllContainers || bb.containerClient() == nil {
+		pager := bb.serviceClient().NewListContainersPager(&service.ListContainersOptions{
+			MaxResults: to.Ptr(int32(1)),
+		})
+		_, err = pager.NextPage(ctx)
+	} else {
+		_, err = bb.containerClient().GetProperties(ctx, nil)
+	}
+
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Err("BlockBlob::Ping : Timed out reaching the service [%s]", err.Error())
+			return syscall.ETIMEDOUT
+		}
+
+		log.Err("BlockBlob::Ping : Failed to reach the service [%s]", err.Error())
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) {
+			return fmt.Errorf("BlockBlob::Ping : [%s]", respErr.ErrorCode)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// checkImmutability : Uploads to a container with an immutability policy must carry one, so the mount fails early
+// when none is configured. Failure to read container properties does not fail the mount.
+func (bb *BlockBlob) checkImmutability() error {
+	props, err := bb.containerClient().GetProperties(context.Background(), nil)
+	if err != nil {
+		log.Warn("BlockBlob::TestPipeline : Failed to check immutability policy of the container [%s]", err.Error())
+		return nil
+	}
+
+	if (props.HasImmutabilityPolicy == nil || !*props.HasImmutabilityPolicy) &&
+		(props.IsImmutableStorageWithVersioningEnabled == nil || !*props.IsImmutableStorageWithVersioningEnabled) {
+		return nil
+	}
+
+	if bb.Config.immutabilityPeriod == 0 {
+		log.Err("BlockBlob::TestPipeline : Container %s has an immutability policy but immutability-period-days is not set", bb.Config.container)
+		return fmt.Errorf("container %s has an immutability policy, set immutability-period-days to upload to it", bb.Config.container)
+	}
+
+	log.Info("BlockBlob::TestPipeline : Container %s has an immutability policy, uploads are kept for %v", bb.Config.container, bb.Config.immutabilityPeriod)
+	bb.immutableContainer = true
 	return nil
 }
 
+// immutabilityPolicy : Expiry and mode of the policy an upload carries, nil when the container does not need one
+func (bb *BlockBlob) immutabilityPolicy() (*time.Time, *blob.ImmutabilityPolicySetting) {
+	if !bb.immutableContainer {
+		return nil, nil
+	}
+	return to.Ptr(time.Now().Add(bb.Config.immutabilityPeriod)), to.Ptr(bb.Config.immutabilityMode)
+}
+
+// checkSoftDelete : Listing of deleted blobs is of no use unless soft delete is enabled on the account,
+// failure to read service properties only means the credentials are not allowed to, so it does not fail the mount
+func (bb *BlockBlob) checkSoftDelete() {
+	if !bb.Config.listDeleted || bb.serviceClient() == nil {
+		return
+	}
+
+	props, err := bb.serviceClient().GetProperties(context.Background(), nil)
+	if err != nil {
+		log.Warn("BlockBlob::TestPipeline : Failed to check soft delete on the account [%s]", err.Error())
+		return
+	}
+
+	if props.DeleteRetentionPolicy == nil || props.DeleteRetentionPolicy.Enabled == nil || !*props.DeleteRetentionPolicy.Enabled {
+		log.Warn("BlockBlob::TestPipeline : list-deleted is set but soft delete is not enabled on the account, there is nothing to list or undelete")
+	}
+}
+
 // IsAccountADLS : Check account is ADLS or not
 func (bb *BlockBlob) IsAccountADLS() bool {
 	includeFields := bb.listDetails
 	includeFields.Permissions = true // for FNS account this property will return back error
 
-	listBlobPager := bb.Container.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
+	listBlobPager := bb.containerClient().NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
 		MaxResults: to.Ptr((int32)(2)),
 		Prefix:     &bb.Config.prefixPath,
 		Include:    includeFields,
@@ -245,11 +431,16 @@ func (bb *BlockBlob) IsAccountADLS() bool {
 	return false
 }
 
-func (bb *BlockBlob) ListContainers() ([]string, error) {
-	log.Trace("BlockBlob::ListContainers : Listing containers")
+// ListContainers : List containers of the account, prefix is filtered by the service so other containers are never sent
+func (bb *BlockBlob) ListContainers(prefix string) ([]string, error) {
+	log.Trace("BlockBlob::ListContainers : Listing containers with prefix %s", prefix)
 	cntList := make([]string, 0)
 
-	pager := bb.Service.NewListContainersPager(nil)
+	opt := &service.ListContainersOptions{}
+	if prefix != "" {
+		opt.Prefix = &prefix
+	}
+	pager := bb.serviceClient().NewListContainersPager(opt)
 	for pager.More() {
 		resp, err := pager.NextPage(context.Background())
 		if err != nil {
@@ -264,44 +455,122 @@ func (bb *BlockBlob) ListContainers() ([]string, error) {
 	return cntList, nil
 }
 
+// ListContainersDetailed : List containers along with their properties and metadata, all from the listing response
+func (bb *BlockBlob) ListContainersDetailed() ([]ContainerInfo, error) {
+	log.Trace("BlockBlob::ListContainersDetailed : Listing containers")
+	cntList := make([]ContainerInfo, 0)
+
+	pager := bb.serviceClient().NewListContainersPager(&service.ListContainersOptions{
+		Include: service.ListContainersInclude{Metadata: true},
+	})
+	for pager.More() {
+		resp, err := pager.NextPage(context.Background())
+		if err != nil {
+			log.Err("BlockBlob::ListContainersDetailed : Failed to get container list [%s]", err.Error())
+			return cntList, err
+		}
+		for _, v := range resp.ContainerItems {
+			info := ContainerInfo{
+				Name:     *v.Name,
+				Metadata: v.Metadata,
+			}
+			if v.Properties != nil {
+				if v.Properties.LastModified != nil {
+					info.LastModified = *v.Properties.LastModified
+				}
+				info.ETag = sanitizeEtag(v.Properties.ETag)
+				if v.Properties.PublicAccess != nil {
+					info.PublicAccess = string(*v.Properties.PublicAccess)
+				}
+			}
+			cntList = append(cntList, info)
+		}
+	}
+
+	return cntList, nil
+}
+
 func (bb *BlockBlob) SetPrefixPath(path string) error {
 	log.Trace("BlockBlob::SetPrefixPath : path %s", path)
-	bb.Config.prefixPath = path
+	bb.Config.prefixPath = normalizePrefixPath(path)
 	return nil
 }
 
 // CreateFile : Create a new file in the container/virtual directory
-func (bb *BlockBlob) CreateFile(name string, mode os.FileMode) error {
+func (bb *BlockBlob) CreateFile(name string, mode os.FileMode, contentType string) error {
 	log.Trace("BlockBlob::CreateFile : name %s", name)
 	var data []byte
-	return bb.WriteFromBuffer(name, nil, data)
+	return bb.uploadBuffer(name, nil, data, contentType)
 }
 
 // CreateDirectory : Create a new directory in the container/virtual directory
 func (bb *BlockBlob) CreateDirectory(name string) error {
 	log.Trace("BlockBlob::CreateDirectory : name %s", name)
 
-	var data []byte
 	metadata := make(map[string]*string)
 	metadata[folderKey] = to.Ptr("true")
 
-	return bb.WriteFromBuffer(name, metadata, data)
+	contentType := getContentType(name)
+	if bb.Config.dirContentType != "" {
+		contentType = bb.Config.dirContentType
+	}
+
+	// Create the marker only if nothing exists with this name, so an existing file is never overwritten
+	blobClient := bb.containerClient().NewBlockBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	_, err := blobClient.UploadBuffer(context.Background(), nil, &blockblob.UploadBufferOptions{
+		Metadata:   metadata,
+		AccessTier: bb.Config.defaultTier,
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobContentType: to.Ptr(contentType),
+		},
+		CPKInfo: bb.blobCPKOpt,
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{
+				IfNoneMatch: to.Ptr(azcore.ETagAny),
+			},
+		},
+	})
+
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobAlreadyExists, bloberror.ConditionNotMet) {
+			// Directory may have been created concurrently by another mount, which is not a failure
+			attr, attrErr := bb.getAttrUsingRest(name)
+			if attrErr == nil && attr.IsDir() {
+				log.Debug("BlockBlob::CreateDirectory : Directory %s already exists", name)
+				return nil
+			}
+			log.Err("BlockBlob::CreateDirectory : A file already exists with name %s", name)
+			return syscall.EEXIST
+		}
+		log.Err("BlockBlob::CreateDirectory : Failed to create directory %s [%s]", name, err.Error())
+		return err
+	}
+
+	return nil
 }
 
 // CreateLink : Create a symlink in the container/virtual directory
 func (bb *BlockBlob) CreateLink(source string, target string) error {
 	log.Trace("BlockBlob::CreateLink : %s -> %s", source, target)
 	data := []byte(target)
-	metadata := make(map[string]*string)
-	metadata[symlinkKey] = to.Ptr("true")
-	return bb.WriteFromBuffer(source, metadata, data)
+
+	switch bb.Config.symlinkFormat {
+	case SymlinkFormatSuffix:
+		return bb.WriteFromBuffer(source+symlinkSuffix, nil, data)
+	case SymlinkFormatContentOnly:
+		return bb.uploadBuffer(source, nil, data, symlinkContentType)
+	default:
+		metadata := make(map[string]*string)
+		metadata[symlinkKey] = to.Ptr("true")
+		return bb.WriteFromBuffer(source, metadata, data)
+	}
 }
 
 // DeleteFile : Delete a blob in the container/virtual directory
 func (bb *BlockBlob) DeleteFile(name string) (err error) {
 	log.Trace("BlockBlob::DeleteFile : name %s", name)
 
-	blobClient := bb.Container.NewBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
 	_, err = blobClient.Delete(context.Background(), &blob.DeleteOptions{
 		DeleteSnapshots: to.Ptr(blob.DeleteSnapshotsOptionTypeInclude),
 	})
@@ -313,6 +582,9 @@ func (bb *BlockBlob) DeleteFile(name string) (err error) {
 		} else if serr == BlobIsUnderLease {
 			log.Err("BlockBlob::DeleteFile : %s is under lease [%s]", name, err.Error())
 			return syscall.EIO
+		} else if serr == BlobImmutable {
+			log.Err("BlockBlob::DeleteFile : %s is under a legal hold or a locked immutability policy [%s]", name, err.Error())
+			return syscall.EPERM
 		} else {
 			log.Err("BlockBlob::DeleteFile : Failed to delete blob %s [%s]", name, err.Error())
 			return err
@@ -322,9 +594,40 @@ func (bb *BlockBlob) DeleteFile(name string) (err error) {
 	return nil
 }
 
+// UndeleteFile : Restore a soft-deleted blob along with its soft-deleted snapshots
+func (bb *BlockBlob) UndeleteFile(name string) error {
+	log.Trace("BlockBlob::UndeleteFile : name %s", name)
+
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	_, err := blobClient.Undelete(context.Background(), nil)
+	if err != nil {
+		serr := storeBlobErrToErr(err)
+		if serr == ErrFileNotFound {
+			log.Err("BlockBlob::UndeleteFile : %s has no soft-deleted or current version", name)
+			return syscall.ENOENT
+		} else if serr == InvalidPermission {
+			log.Err("BlockBlob::UndeleteFile : Insufficient permissions for %s [%s]", name, err.Error())
+			return syscall.EACCES
+		} else {
+			log.Err("BlockBlob::UndeleteFile : Failed to undelete blob %s [%s]", name, err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
 // DeleteDirectory : Delete a virtual directory in the container/virtual directory
 func (bb *BlockBlob) DeleteDirectory(name string) (err error) {
 	log.Trace("BlockBlob::DeleteDirectory : name %s", name)
+	if bb.Config.deleteDirBestEffort {
+		err = bb.deleteDirChildren(name)
+		if err != nil {
+			// Keep the marker so the directory stays visible for the caller to retry the failed paths
+			return err
+		}
+	}
+
 	err = bb.DeleteFile(name)
 	// libfuse deletes the files in the directory before this method is called.
 	// If the marker blob for directory is not present, ignore the ENOENT error.
@@ -334,6 +637,94 @@ func (bb *BlockBlob) DeleteDirectory(name string) (err error) {
 	return err
 }
 
+// deleteDirChildren : Delete every blob still present under the directory.
+// A failure on one child does not stop the rest, all failures are returned together as a DeleteDirError.
+func (bb *BlockBlob) deleteDirChildren(name string) error {
+	failed := make(map[string]error)
+	pager := bb.containerClient().NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix: to.Ptr(joinPrefixPath(bb.Config.prefixPath, name) + "/"),
+	})
+	for pager.More() {
+		listBlobResp, err := pager.NextPage(context.Background())
+		if err != nil {
+			log.Err("BlockBlob::deleteDirChildren : Failed to get list of blobs under %s [%s]", name, err.Error())
+			return err
+		}
+
+		paths := make([]string, 0, len(listBlobResp.Segment.BlobItems))
+		for _, blobInfo := range listBlobResp.Segment.BlobItems {
+			paths = append(paths, removePrefixPath(bb.Config.prefixPath, *blobInfo.Name))
+		}
+		bb.deleteBlobsInBatch(paths, failed)
+	}
+
+	if len(failed) > 0 {
+		log.Err("BlockBlob::deleteDirChildren : %d path(s) under %s could not be deleted", len(failed), name)
+		return &DeleteDirError{Dir: name, Failed: failed}
+	}
+	return nil
+}
+
+// deleteBlobsInBatch : Delete the blobs with blob batch requests of up to maxBatchDeleteSize deletes each.
+// Blobs which fail inside a batch, or every blob of a batch the service rejects, are deleted one at a time
+// so failure of each blob is recorded in failed.
+func (bb *BlockBlob) deleteBlobsInBatch(paths []string, failed map[string]error) {
+	for start := 0; start < len(paths); start += maxBatchDeleteSize {
+		batch := paths[start:min(start+maxBatchDeleteSize, len(paths))]
+		retry, err := bb.submitDeleteBatch(batch)
+		if err != nil {
+			log.Warn("BlockBlob::deleteBlobsInBatch : Batch delete failed, deleting %d blobs individually [%s]", len(batch), err.Error())
+			retry = batch
+		}
+
+		for _, childPath := range retry {
+			err = bb.DeleteFile(childPath)
+			if err != nil && err != syscall.ENOENT {
+				log.Err("BlockBlob::deleteBlobsInBatch : Failed to delete %s [%s]", childPath, err.Error())
+				failed[childPath] = err
+			}
+		}
+	}
+}
+
+// submitDeleteBatch : Delete the blobs in a single batch request, returns the blobs whose delete failed
+func (bb *BlockBlob) submitDeleteBatch(paths []string) ([]string, error) {
+	builder, err := bb.containerClient().NewBatchBuilder()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, childPath := range paths {
+		err = builder.Delete(joinPrefixPath(bb.Config.prefixPath, childPath), &container.BatchDeleteOptions{
+			DeleteOptions: blob.DeleteOptions{
+				DeleteSnapshots: to.Ptr(blob.DeleteSnapshotsOptionTypeInclude),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := bb.containerClient().SubmitBatch(context.Background(), builder, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	retry := make([]string, 0)
+	for _, item := range resp.Responses {
+		if item.Error == nil || storeBlobErrToErr(item.Error) == ErrFileNotFound {
+			continue
+		}
+		if item.ContentID == nil || *item.ContentID < 0 || *item.ContentID >= len(paths) {
+			return nil, fmt.Errorf("unexpected sub-response in batch [%s]", item.Error.Error())
+		}
+		retry = append(retry, paths[*item.ContentID])
+	}
+
+	log.Debug("BlockBlob::submitDeleteBatch : Deleted %d of %d blobs in batch", len(paths)-len(retry), len(paths))
+	return retry, nil
+}
+
 // RenameFile : Rename the file
 // Source file must exist in storage account before calling this method.
 // When the rename is success, Data, metadata, of the blob will be copied to the destination.
@@ -344,22 +735,46 @@ func (bb *BlockBlob) DeleteDirectory(name string) (err error) {
 func (bb *BlockBlob) RenameFile(source string, target string, srcAttr *internal.ObjAttr) error {
 	log.Trace("BlockBlob::RenameFile : %s -> %s", source, target)
 
-	blobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, source))
-	newBlobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, target))
+	err := bb.copyBlob(source, target, srcAttr)
+	if err != nil {
+		return err
+	}
+
+	return bb.deleteRenamedSource(source, target)
+}
+
+// copyBlob : Copy source to target, returns once the copy is no longer pending. Copy runs on the service
+// unless the mount uses a customer provided key.
+func (bb *BlockBlob) copyBlob(source string, target string, srcAttr *internal.ObjAttr) error {
+	blobClient := bb.containerClient().NewBlockBlobClient(joinPrefixPath(bb.Config.prefixPath, source))
+	newBlobClient := bb.containerClient().NewBlockBlobClient(joinPrefixPath(bb.Config.prefixPath, target))
+
+	if bb.blobCPKOpt != nil {
+		// Copy from url can not carry a customer provided key, so the service can not decrypt the source itself
+		return bb.copyBlobWithCPK(blobClient, newBlobClient, source, target, srcAttr)
+	}
+
+	// Keep the tier of the source unless a default tier is configured, an inferred tier is left to the account default
+	tier := bb.Config.defaultTier
+	if tier == nil {
+		srcProp, err := blobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
+			CPKInfo: bb.blobCPKOpt,
+		})
+		if err == nil && srcProp.AccessTier != nil && (srcProp.AccessTierInferred == nil || !*srcProp.AccessTierInferred) {
+			tier = to.Ptr(blob.AccessTier(*srcProp.AccessTier))
+		}
+	}
 
 	// not specifying source blob metadata, since passing empty metadata headers copies
-	// the source blob metadata to destination blob
+	// the source blob metadata to destination blob. This also keeps a symlink a link to the same target,
+	// whether it is flagged in metadata or by content type.
 	copyResponse, err := newBlobClient.StartCopyFromURL(context.Background(), blobClient.URL(), &blob.StartCopyFromURLOptions{
-		Tier: bb.Config.defaultTier,
+		Tier: tier,
 	})
 
 	if err != nil {
-		serr := storeBlobErrToErr(err)
-		if serr == ErrFileNotFound {
-			//Ideally this case doesn't hit as we are checking for the existence of src
-			//before making the call for RenameFile
-			log.Err("BlockBlob::RenameFile : Src Blob doesn't Exist %s [%s]", source, err.Error())
-			return syscall.ENOENT
+		if serr := copySourceErr(source, err); serr != nil {
+			return serr
 		}
 		log.Err("BlockBlob::RenameFile : Failed to start copy of file %s [%s]", source, err.Error())
 		return err
@@ -368,11 +783,14 @@ func (bb *BlockBlob) RenameFile(source string, target string, srcAttr *internal.
 	var dstLMT *time.Time = copyResponse.LastModified
 	var dstETag string = sanitizeEtag(copyResponse.ETag)
 
+	// Copy runs on the service, poll its status with backoff till it is no longer pending
 	copyStatus := copyResponse.CopyStatus
 	var prop blob.GetPropertiesResponse
 	pollCnt := 0
+	pollInterval := copyPollInterval
 	for copyStatus != nil && *copyStatus == blob.CopyStatusTypePending {
-		time.Sleep(time.Second * 1)
+		time.Sleep(pollInterval)
+		pollInterval = min(2*pollInterval, maxCopyPollInterval)
 		pollCnt++
 		prop, err = newBlobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
 			CPKInfo: bb.blobCPKOpt,
@@ -390,12 +808,97 @@ func (bb *BlockBlob) RenameFile(source string, target string, srcAttr *internal.
 
 	if copyStatus != nil && *copyStatus == blob.CopyStatusTypeSuccess {
 		modifyLMTandEtag(srcAttr, dstLMT, dstETag)
+	} else if copyStatus != nil && (*copyStatus == blob.CopyStatusTypeFailed || *copyStatus == blob.CopyStatusTypeAborted) {
+		// Source is kept as the data did not make it to the target
+		description := ""
+		if prop.CopyStatusDescription != nil {
+			description = *prop.CopyStatusDescription
+		}
+		log.Err("BlockBlob::RenameFile : Copy of %s to %s did not complete, status %s [%s]", source, target, *copyStatus, description)
+		return syscall.EIO
+	}
+
+	log.Trace("BlockBlob::RenameFile : %s -> %s done", source, target)
+	return nil
+}
+
+// copySourceErr : Map an error reading the source of a rename, nil if it needs no special handling
+func copySourceErr(source string, err error) error {
+	serr := storeBlobErrToErr(err)
+	if serr == ErrFileNotFound || isMissingCopySource(err) {
+		//Ideally this case doesn't hit as we are checking for the existence of src
+		//before making the call for RenameFile
+		log.Err("BlockBlob::RenameFile : Src Blob doesn't Exist %s [%s]", source, err.Error())
+		return syscall.ENOENT
+	} else if serr == CPKMismatch {
+		// Source was written with a customer provided key which differs from the one this mount is configured with
+		log.Err("BlockBlob::RenameFile : %s is encrypted with a customer provided key, cpk-encryption-key it was written with is required [%s]", source, err.Error())
+		return syscall.EACCES
+	}
+	return nil
+}
+
+// copyBlobWithCPK : Copy a blob encrypted with the configured customer provided key through this mount,
+// source is read and target is written with the key so both stay encrypted with it
+func (bb *BlockBlob) copyBlobWithCPK(blobClient *blockblob.Client, newBlobClient *blockblob.Client, source string, target string, srcAttr *internal.ObjAttr) error {
+	srcProp, err := blobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
+		CPKInfo: bb.blobCPKOpt,
+	})
+	if err != nil {
+		if serr := copySourceErr(source, err); serr != nil {
+			return serr
+		}
+		log.Err("BlockBlob::RenameFile : Failed to get properties of %s [%s]", source, err.Error())
+		return err
+	}
+
+	tier := bb.Config.defaultTier
+	if tier == nil && srcProp.AccessTier != nil && (srcProp.AccessTierInferred == nil || !*srcProp.AccessTierInferred) {
+		tier = to.Ptr(blob.AccessTier(*srcProp.AccessTier))
+	}
+
+	downloadResponse, err := blobClient.DownloadStream(context.Background(), &blob.DownloadStreamOptions{
+		CPKInfo: bb.blobCPKOpt,
+	})
+	if err != nil {
+		if serr := copySourceErr(source, err); serr != nil {
+			return serr
+		}
+		log.Err("BlockBlob::RenameFile : Failed to download %s [%s]", source, err.Error())
+		return err
+	}
+	defer downloadResponse.Body.Close()
+
+	// Metadata and content headers are the ones of the source, a symlink stays a link to the same target
+	uploadResponse, err := newBlobClient.UploadStream(context.Background(), downloadResponse.Body, &blockblob.UploadStreamOptions{
+		BlockSize:   bb.Config.blockSize,
+		Concurrency: int(bb.Config.maxConcurrency),
+		Metadata:    srcProp.Metadata,
+		AccessTier:  tier,
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobContentType:        srcProp.ContentType,
+			BlobContentEncoding:    srcProp.ContentEncoding,
+			BlobContentLanguage:    srcProp.ContentLanguage,
+			BlobContentDisposition: srcProp.ContentDisposition,
+			BlobCacheControl:       srcProp.CacheControl,
+			BlobContentMD5:         srcProp.ContentMD5,
+		},
+		CPKInfo: bb.blobCPKOpt,
+	})
+	if err != nil {
+		log.Err("BlockBlob::RenameFile : Failed to copy %s to %s [%s]", source, target, err.Error())
+		return err
 	}
 
+	modifyLMTandEtag(srcAttr, uploadResponse.LastModified, sanitizeEtag(uploadResponse.ETag))
+
 	log.Trace("BlockBlob::RenameFile : %s -> %s done", source, target)
+	return nil
+}
 
-	// Copy of the file is done so now delete the older file
-	err = bb.DeleteFile(source)
+// deleteRenamedSource : Delete the source of a rename once its copy is done
+func (bb *BlockBlob) deleteRenamedSource(source string, target string) error {
+	err := bb.DeleteFile(source)
 	for retry := 0; retry < 3 && err == syscall.ENOENT; retry++ {
 		// Sometimes backend is able to copy source file to destination but when we try to delete the
 		// source files it returns back with ENOENT. If file was just created on backend it might happen
@@ -418,14 +921,31 @@ func (bb *BlockBlob) RenameFile(source string, target string, srcAttr *internal.
 func (bb *BlockBlob) RenameDirectory(source string, target string) error {
 	log.Trace("BlockBlob::RenameDirectory : %s -> %s", source, target)
 
+	// Sources copied by an earlier attempt of the same rename are only deleted, not copied again
+	key := source + "\x00" + target
+	val, _ := bb.dirRenames.LoadOrStore(key, &dirRenameProgress{copied: make(map[string]bool)})
+	progress := val.(*dirRenameProgress)
+
+	concurrency := bb.Config.maxConcurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var renameErr error
+	failed := 0
+	sem := make(chan struct{}, concurrency)
+
 	srcDirPresent := false
-	pager := bb.Container.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
-		Prefix: to.Ptr(filepath.Join(bb.Config.prefixPath, source) + "/"),
+	pager := bb.containerClient().NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix: to.Ptr(joinPrefixPath(bb.Config.prefixPath, source) + "/"),
 	})
 	for pager.More() {
 		listBlobResp, err := pager.NextPage(context.Background())
 		if err != nil {
 			log.Err("BlockBlob::RenameDirectory : Failed to get list of blobs %s", err.Error())
+			wg.Wait()
 			return err
 		}
 
@@ -433,15 +953,37 @@ func (bb *BlockBlob) RenameDirectory(source string, target string) error {
 		for _, blobInfo := range listBlobResp.Segment.BlobItems {
 			srcDirPresent = true
 			srcPath := removePrefixPath(bb.Config.prefixPath, *blobInfo.Name)
-			err = bb.RenameFile(srcPath, strings.Replace(srcPath, source, target, 1), nil)
-			if err != nil {
-				log.Err("BlockBlob::RenameDirectory : Failed to rename file %s [%s]", srcPath, err.Error)
-			}
+
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(srcPath string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				err := bb.renameDirChild(progress, srcPath, strings.Replace(srcPath, source, target, 1))
+				if err != nil {
+					log.Err("BlockBlob::RenameDirectory : Failed to rename file %s [%s]", srcPath, err.Error())
+					lock.Lock()
+					failed++
+					if renameErr == nil {
+						renameErr = err
+					}
+					lock.Unlock()
+				}
+			}(srcPath)
 		}
 	}
+	wg.Wait()
+
+	if renameErr != nil {
+		// Marker is kept so the directory is still found at source and the rename can be retried
+		log.Err("BlockBlob::RenameDirectory : %d files of %s could not be renamed to %s", failed, source, target)
+		return renameErr
+	}
+	bb.dirRenames.Delete(key)
 
 	// To rename source marker blob check its properties before calling rename on it.
-	blobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, source))
+	blobClient := bb.containerClient().NewBlockBlobClient(joinPrefixPath(bb.Config.prefixPath, source))
 	_, err := blobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
 		CPKInfo: bb.blobCPKOpt,
 	})
@@ -462,46 +1004,116 @@ func (bb *BlockBlob) RenameDirectory(source string, target string) error {
 	return bb.RenameFile(source, target, nil)
 }
 
-func (bb *BlockBlob) getAttrUsingRest(name string) (attr *internal.ObjAttr, err error) {
-	log.Trace("BlockBlob::getAttrUsingRest : name %s", name)
-
-	blobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, name))
-	prop, err := blobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
-		CPKInfo: bb.blobCPKOpt,
-	})
+// renameDirChild : Rename one blob of a directory, the copy is skipped if an earlier attempt already did it
+func (bb *BlockBlob) renameDirChild(progress *dirRenameProgress, source string, target string) error {
+	progress.lock.Lock()
+	copied := progress.copied[source]
+	progress.lock.Unlock()
 
-	if err != nil {
-		serr := storeBlobErrToErr(err)
-		if serr == ErrFileNotFound {
-			return attr, syscall.ENOENT
-		} else if serr == InvalidPermission {
-			log.Err("BlockBlob::getAttrUsingRest : Insufficient permissions for %s [%s]", name, err.Error())
-			return attr, syscall.EACCES
-		} else {
-			log.Err("BlockBlob::getAttrUsingRest : Failed to get blob properties for %s [%s]", name, err.Error())
-			return attr, err
+	if !copied {
+		err := bb.copyBlob(source, target, nil)
+		if err != nil {
+			return err
 		}
+
+		progress.lock.Lock()
+		progress.copied[source] = true
+		progress.lock.Unlock()
 	}
 
-	// Since block blob does not support acls, we set mode to 0 and FlagModeDefault to true so the fuse layer can return the default permission.
+	err := bb.deleteRenamedSource(source, target)
+	if err != nil {
+		return err
+	}
+
+	progress.lock.Lock()
+	delete(progress.copied, source)
+	progress.lock.Unlock()
+	return nil
+}
+
+func (bb *BlockBlob) getAttrUsingRest(name string) (attr *internal.ObjAttr, err error) {
+	return bb.getAttrUsingRestIfModified(name, "")
+}
+
+// getAttrUsingRestIfModified : GetProperties call which is conditional on the ETag when one is provided
+func (bb *BlockBlob) getAttrUsingRestIfModified(name string, etag string) (attr *internal.ObjAttr, err error) {
+	log.Trace("BlockBlob::getAttrUsingRest : name %s", name)
+
+	opts := &blob.GetPropertiesOptions{
+		CPKInfo: bb.blobCPKOpt,
+	}
+	if etag != "" {
+		opts.AccessConditions = &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{
+				IfNoneMatch: to.Ptr(azcore.ETag(`"` + etag + `"`)),
+			},
+		}
+	}
+
+	ctx, cancel := operationContext(bb.Config.attrTimeout, 0)
+	defer cancel()
+
+	blobClient := bb.containerClient().NewBlockBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	prop, err := blobClient.GetProperties(ctx, opts)
+
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if etag != "" && errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotModified {
+			return attr, internal.ErrNotModified
+		}
+
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Err("BlockBlob::getAttrUsingRest : Timed out getting blob properties for %s [%s]", name, err.Error())
+			return attr, syscall.ETIMEDOUT
+		}
+
+		serr := storeBlobErrToErr(err)
+		if serr == ErrFileNotFound {
+			return attr, syscall.ENOENT
+		} else if serr == InvalidPermission {
+			log.Err("BlockBlob::getAttrUsingRest : Insufficient permissions for %s [%s]", name, err.Error())
+			return attr, syscall.EACCES
+		} else if serr == CPKMismatch {
+			log.Err("BlockBlob::getAttrUsingRest : %s is encrypted with a customer provided key, cpk-encryption-key it was written with is required [%s]", name, err.Error())
+			return attr, syscall.EACCES
+		} else if serr == EncryptionKeyUnavailable {
+			// Object exists and is still listed, only its encryption key can not be used
+			log.Err("BlockBlob::getAttrUsingRest : Encryption key of %s is not accessible [%s]", name, err.Error())
+			return attr, syscall.EACCES
+		} else {
+			log.Err("BlockBlob::getAttrUsingRest : Failed to get blob properties for %s [%s]", name, err.Error())
+			return attr, err
+		}
+	}
+
+	// Since block blob does not support acls, we set mode to 0 and FlagModeDefault to true so the fuse layer can return the default permission.
+	mtime := bb.lastModifiedTime(name, prop.LastModified, prop.CreationTime)
 	attr = &internal.ObjAttr{
 		Path:   name, // We don't need to strip the prefixPath here since we pass the input name
 		Name:   filepath.Base(name),
 		Size:   *prop.ContentLength,
 		Mode:   0,
-		Mtime:  *prop.LastModified,
-		Atime:  *prop.LastModified,
-		Ctime:  *prop.LastModified,
-		Crtime: *prop.CreationTime,
+		Mtime:  mtime,
+		Atime:  mtime,
+		Ctime:  mtime,
+		Crtime: bb.dereferenceTime(prop.CreationTime, mtime),
 		Flags:  internal.NewFileBitMap(),
 		MD5:    prop.ContentMD5,
 		ETag:   sanitizeEtag(prop.ETag),
 	}
 
 	parseMetadata(attr, prop.Metadata)
+	bb.applyDirContentType(attr, prop.ContentType)
+	bb.applySymlinkFormat(attr, prop.ContentType)
+	if bb.Config.reportCopyStatus {
+		applyCopyStatus(attr, &prop)
+	}
+	applyImmutabilityState(attr, &prop)
 
 	// We do not get permissions as part of this getAttr call hence setting the flag to true
 	attr.Flags.Set(internal.PropFlagModeDefault)
+	bb.applyUnixMode(attr)
 
 	return attr, nil
 }
@@ -509,49 +1121,54 @@ func (bb *BlockBlob) getAttrUsingRest(name string) (attr *internal.ObjAttr, err
 func (bb *BlockBlob) getAttrUsingList(name string) (attr *internal.ObjAttr, err error) {
 	log.Trace("BlockBlob::getAttrUsingList : name %s", name)
 
-	iteration := 0
-	var marker, new_marker *string
-	var blobs []*internal.ObjAttr
-	blobsRead := 0
-
-	for marker != nil || iteration == 0 {
-		blobs, new_marker, err = bb.List(name, marker, bb.Config.maxResultsForList)
-		if err != nil {
-			e := storeBlobErrToErr(err)
-			if e == ErrFileNotFound {
-				return attr, syscall.ENOENT
-			} else if e == InvalidPermission {
-				log.Err("BlockBlob::getAttrUsingList : Insufficient permissions for %s [%s]", name, err.Error())
-				return attr, syscall.EACCES
-			} else {
-				log.Warn("BlockBlob::getAttrUsingList : Failed to list blob properties for %s [%s]", name, err.Error())
-			}
-		}
+	// A blob with this exact name covers files and directories having a marker blob
+	attr, err = bb.getAttrUsingRest(name)
+	if err != syscall.ENOENT {
+		return attr, err
+	}
 
-		for i, blob := range blobs {
-			log.Trace("BlockBlob::getAttrUsingList : Item %d Blob %s", i+blobsRead, blob.Name)
-			if blob.Path == name {
-				return blob, nil
-			}
-		}
+	// Without a marker the directory exists only if something exists under it. A single item is enough to
+	// tell so don't enumerate the whole directory, or the siblings sharing its name as prefix.
+	listPath := joinPrefixPath(bb.Config.prefixPath, internal.TruncateDirName(name)) + "/"
+	pager := bb.containerClient().NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		MaxResults: to.Ptr(int32(1)),
+		Prefix:     &listPath,
+	})
 
-		marker = new_marker
-		iteration++
-		blobsRead += len(blobs)
+	ctx, cancel := operationContext(bb.Config.attrTimeout, 0)
+	defer cancel()
 
-		log.Trace("BlockBlob::getAttrUsingList : So far retrieved %d objects in %d iterations", blobsRead, iteration)
-		if new_marker == nil || *new_marker == "" {
-			break
+	listBlob, err := pager.NextPage(ctx)
+	if err != nil {
+		e := storeBlobErrToErr(err)
+		if e == ErrFileNotFound {
+			return nil, syscall.ENOENT
+		} else if e == InvalidPermission {
+			log.Err("BlockBlob::getAttrUsingList : Insufficient permissions for %s [%s]", name, err.Error())
+			return nil, syscall.EACCES
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			log.Err("BlockBlob::getAttrUsingList : Timed out listing %s [%s]", name, err.Error())
+			return nil, syscall.ETIMEDOUT
 		}
+		log.Err("BlockBlob::getAttrUsingList : Failed to list blob properties for %s [%s]", name, err.Error())
+		return nil, err
 	}
 
-	if err == nil {
+	if len(listBlob.Segment.BlobItems) == 0 {
 		log.Warn("BlockBlob::getAttrUsingList : blob %s does not exist", name)
 		return nil, syscall.ENOENT
 	}
 
-	log.Err("BlockBlob::getAttrUsingList : Failed to list blob properties for %s [%s]", name, err.Error())
-	return nil, err
+	return bb.createDirAttr(listPath), nil
+}
+
+// GetAttrIfModified : Retrieve attributes of the blob only if its ETag differs from the given one,
+// otherwise ErrNotModified is returned. Directories do not carry an ETag and fall back to GetAttr.
+func (bb *BlockBlob) GetAttrIfModified(name string, etag string) (attr *internal.ObjAttr, err error) {
+	if etag == "" {
+		return bb.GetAttr(name)
+	}
+	return bb.getAttrUsingRestIfModified(name, etag)
 }
 
 // GetAttr : Retrieve attributes of the blob
@@ -597,8 +1214,15 @@ func (bb *BlockBlob) List(prefix string, marker *string, count int32) ([]*intern
 
 	listPath := bb.getListPath(prefix)
 
+	// Marker issued under a different filter would resume an enumeration with different results
+	marker, err := decodeListMarker(marker, bb.Config.filterFingerprint)
+	if err != nil {
+		log.Err("BlockBlob::List : Invalid marker for prefix %s [%s]", prefix, err.Error())
+		return nil, nil, syscall.EINVAL
+	}
+
 	// Get a result segment starting with the blob indicated by the current Marker.
-	pager := bb.Container.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
+	pager := bb.containerClient().NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
 		Marker:     marker,
 		MaxResults: &count,
 		Prefix:     &listPath,
@@ -613,14 +1237,24 @@ func (bb *BlockBlob) List(prefix string, marker *string, count int32) ([]*intern
 	// APIs that may be affected include IsDirEmpty, ReadDir and StreamDir
 
 	if err != nil {
-		log.Err("BlockBlob::List : Failed to list the container with the prefix %s", err.Error)
+		log.Err("BlockBlob::List : Failed to list the container with the prefix %s [%s]", prefix, err.Error())
+		if storeBlobErrToErr(err) == ErrFileNotFound || bloberror.HasCode(err, bloberror.ContainerNotFound) {
+			return nil, nil, syscall.ENOENT
+		}
 		return nil, nil, err
 	}
 
 	// Process the blobs returned in this result segment (if the segment is empty, the loop body won't execute)
 	// Since block blob does not support acls, we set mode to 0 and FlagModeDefault to true so the fuse layer can return the default permission.
 
-	blobList, dirList, err := bb.processBlobItems(listBlob.Segment.BlobItems)
+	blobItems := listBlob.Segment.BlobItems
+	blobItems = bb.filterSnapshots(blobItems)
+	blobItems = bb.filterDeleted(blobItems)
+	if !bb.Config.listDirMarker {
+		blobItems = bb.filterDirMarker(listPath, blobItems)
+	}
+
+	blobList, dirList, err := bb.processBlobItems(blobItems)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -635,17 +1269,381 @@ func (bb *BlockBlob) List(prefix string, marker *string, count int32) ([]*intern
 		return nil, nil, err
 	}
 
-	return blobList, listBlob.NextMarker, nil
+	return blobList, encodeListMarker(listBlob.NextMarker, bb.Config.filterFingerprint), nil
+}
+
+// ListFlat : Enumerate all descendant blobs of the prefix without a delimiter, in a single paged listing.
+// Directories are reported only when their marker blob exists, virtual directories are implied by the paths of their children.
+func (bb *BlockBlob) ListFlat(prefix string, marker *string, count int32) ([]*internal.ObjAttr, *string, error) {
+	log.Trace("BlockBlob::ListFlat : prefix %s", prefix)
+
+	if count == 0 {
+		count = common.MaxDirListCount
+	}
+
+	listPath := bb.getListPath(prefix)
+
+	marker, err := decodeListMarker(marker, bb.Config.filterFingerprint)
+	if err != nil {
+		log.Err("BlockBlob::ListFlat : Invalid marker for prefix %s [%s]", prefix, err.Error())
+		return nil, nil, syscall.EINVAL
+	}
+
+	pager := bb.containerClient().NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Marker:     marker,
+		MaxResults: &count,
+		Prefix:     &listPath,
+		Include:    bb.listDetails,
+	})
+
+	listBlob, err := pager.NextPage(context.Background())
+	if err != nil {
+		log.Err("BlockBlob::ListFlat : Failed to list the container with the prefix %s [%s]", prefix, err.Error())
+		return nil, nil, err
+	}
+
+	blobItems := bb.filterDeleted(bb.filterSnapshots(listBlob.Segment.BlobItems))
+	if !bb.Config.listDirMarker {
+		blobItems = bb.filterDirMarker(listPath, blobItems)
+	}
+
+	blobList, _, err := bb.processBlobItems(blobItems)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return blobList, encodeListMarker(listBlob.NextMarker, bb.Config.filterFingerprint), nil
+}
+
+// GetDirUsage : Total size and number of files and directories under a directory, from a flat enumeration.
+// Virtual directories without a marker blob are counted from the paths of their children.
+func (bb *BlockBlob) GetDirUsage(ctx context.Context, name string) (size int64, fileCount int64, dirCount int64, err error) {
+	log.Trace("BlockBlob::GetDirUsage : name %s", name)
+
+	listPath := bb.getListPath(formatListDirName(name))
+	pager := bb.containerClient().NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  &listPath,
+		Include: container.ListBlobsInclude{Metadata: true},
+	})
+
+	dirs := make(map[string]bool)
+	for pager.More() {
+		listBlobResp, err := pager.NextPage(ctx)
+		if err != nil {
+			log.Err("BlockBlob::GetDirUsage : Failed to list blobs under %s [%s]", name, err.Error())
+			return 0, 0, 0, err
+		}
+
+		for _, blobInfo := range listBlobResp.Segment.BlobItems {
+			relPath := strings.TrimPrefix(*blobInfo.Name, listPath)
+			if relPath == "" {
+				// Marker of the directory itself named as "dir/"
+				continue
+			}
+
+			attr := &internal.ObjAttr{}
+			parseMetadata(attr, blobInfo.Metadata)
+			bb.applyDirContentType(attr, blobInfo.Properties.ContentType)
+			if attr.IsDir() {
+				dirs[relPath] = true
+			} else {
+				fileCount++
+				if blobInfo.Properties.ContentLength != nil {
+					size += *blobInfo.Properties.ContentLength
+				}
+			}
+
+			for dir := path.Dir(relPath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+				dirs[dir] = true
+			}
+		}
+	}
+
+	if fileCount == 0 && len(dirs) == 0 && name != "" {
+		// Listing can not tell an empty directory from a missing one
+		if _, err = bb.GetAttr(name); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	return size, fileCount, int64(len(dirs)), nil
 }
 
 func (bb *BlockBlob) getListPath(prefix string) string {
-	listPath := filepath.Join(bb.Config.prefixPath, prefix)
+	listPath := joinPrefixPath(bb.Config.prefixPath, prefix)
 	if (prefix != "" && prefix[len(prefix)-1] == '/') || (prefix == "" && bb.Config.prefixPath != "") {
 		listPath += "/"
 	}
 	return listPath
 }
 
+// filterDirMarker : Some tools create the directory marker as a blob named "dir/". Such a blob matches
+// the list prefix of the directory itself and shall not be reported as its own child.
+func (bb *BlockBlob) filterDirMarker(listPath string, blobItems []*container.BlobItem) []*container.BlobItem {
+	if listPath == "" {
+		return blobItems
+	}
+
+	filtered := make([]*container.BlobItem, 0, len(blobItems))
+	for _, blobInfo := range blobItems {
+		if blobInfo.Name != nil && *blobInfo.Name == listPath {
+			log.Debug("BlockBlob::List : Skipping directory marker %s from its own listing", *blobInfo.Name)
+			continue
+		}
+		filtered = append(filtered, blobInfo)
+	}
+
+	return filtered
+}
+
+// filterSnapshots : Listing including snapshots returns the same blob once per snapshot.
+// Normal listing only reports the current version, snapshots are available through ListSnapshots.
+func (bb *BlockBlob) filterSnapshots(blobItems []*container.BlobItem) []*container.BlobItem {
+	filtered := blobItems[:0]
+	for _, blobInfo := range blobItems {
+		if blobInfo.Snapshot != nil && *blobInfo.Snapshot != "" {
+			continue
+		}
+		filtered = append(filtered, blobInfo)
+	}
+	return filtered
+}
+
+// filterDeleted : A name which has been recreated after a soft delete is listed twice when deleted blobs are included.
+// Current blob is what the name refers to, so the deleted one is dropped.
+func (bb *BlockBlob) filterDeleted(blobItems []*container.BlobItem) []*container.BlobItem {
+	if !bb.Config.listDeleted {
+		return blobItems
+	}
+
+	live := make(map[string]bool)
+	for _, blobInfo := range blobItems {
+		if blobInfo.Deleted == nil || !*blobInfo.Deleted {
+			live[*blobInfo.Name] = true
+		}
+	}
+
+	filtered := blobItems[:0]
+	for _, blobInfo := range blobItems {
+		if blobInfo.Deleted != nil && *blobInfo.Deleted && live[*blobInfo.Name] {
+			continue
+		}
+		filtered = append(filtered, blobInfo)
+	}
+	return filtered
+}
+
+// SnapshotInfo : A read-only snapshot of a blob, ID is the timestamp the service identifies the snapshot with
+type SnapshotInfo struct {
+	ID           string
+	LastModified time.Time
+	Size         int64
+}
+
+// CreateSnapshot : Take a snapshot of the current state of a blob
+func (bb *BlockBlob) CreateSnapshot(name string) (string, error) {
+	log.Trace("BlockBlob::CreateSnapshot : name %s", name)
+
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	resp, err := blobClient.CreateSnapshot(context.Background(), &blob.CreateSnapshotOptions{
+		CPKInfo: bb.blobCPKOpt,
+	})
+	if err != nil {
+		serr := storeBlobErrToErr(err)
+		if serr == ErrFileNotFound {
+			log.Err("BlockBlob::CreateSnapshot : %s does not exist", name)
+			return "", syscall.ENOENT
+		} else if serr == InvalidPermission {
+			log.Err("BlockBlob::CreateSnapshot : Insufficient permissions for %s [%s]", name, err.Error())
+			return "", syscall.EACCES
+		}
+		log.Err("BlockBlob::CreateSnapshot : Failed to snapshot blob %s [%s]", name, err.Error())
+		return "", err
+	}
+
+	if resp.Snapshot == nil {
+		log.Err("BlockBlob::CreateSnapshot : No snapshot id returned for %s", name)
+		return "", syscall.EIO
+	}
+
+	return *resp.Snapshot, nil
+}
+
+// DeleteSnapshot : Delete a single snapshot of a blob, the blob and its other snapshots are left as is
+func (bb *BlockBlob) DeleteSnapshot(name string, snapshotID string) error {
+	log.Trace("BlockBlob::DeleteSnapshot : name %s, snapshot %s", name, snapshotID)
+
+	blobClient, err := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name)).WithSnapshot(snapshotID)
+	if err != nil {
+		log.Err("BlockBlob::DeleteSnapshot : Invalid snapshot %s of %s [%s]", snapshotID, name, err.Error())
+		return syscall.EINVAL
+	}
+
+	_, err = blobClient.Delete(context.Background(), nil)
+	if err != nil {
+		serr := storeBlobErrToErr(err)
+		if serr == ErrFileNotFound {
+			log.Err("BlockBlob::DeleteSnapshot : Snapshot %s of %s does not exist", snapshotID, name)
+			return syscall.ENOENT
+		} else if serr == InvalidPermission {
+			log.Err("BlockBlob::DeleteSnapshot : Insufficient permissions for %s [%s]", name, err.Error())
+			return syscall.EACCES
+		}
+		log.Err("BlockBlob::DeleteSnapshot : Failed to delete snapshot %s of %s [%s]", snapshotID, name, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// ListSnapshots : Get the snapshots of a blob, oldest first. Current version of the blob is not included.
+func (bb *BlockBlob) ListSnapshots(name string) ([]SnapshotInfo, error) {
+	log.Trace("BlockBlob::ListSnapshots : name %s", name)
+
+	blobName := joinPrefixPath(bb.Config.prefixPath, name)
+	pager := bb.containerClient().NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  &blobName,
+		Include: container.ListBlobsInclude{Snapshots: true},
+	})
+
+	snapshots := make([]SnapshotInfo, 0)
+	for pager.More() {
+		listBlobResp, err := pager.NextPage(context.Background())
+		if err != nil {
+			log.Err("BlockBlob::ListSnapshots : Failed to list snapshots of %s [%s]", name, err.Error())
+			return nil, err
+		}
+
+		for _, blobInfo := range listBlobResp.Segment.BlobItems {
+			// Prefix also matches other blobs starting with the same name
+			if blobInfo.Name == nil || *blobInfo.Name != blobName {
+				continue
+			}
+			if blobInfo.Snapshot == nil || *blobInfo.Snapshot == "" {
+				continue
+			}
+
+			snapshot := SnapshotInfo{ID: *blobInfo.Snapshot}
+			if blobInfo.Properties != nil {
+				snapshot.LastModified = bb.dereferenceTime(blobInfo.Properties.LastModified, time.Time{})
+				if blobInfo.Properties.ContentLength != nil {
+					snapshot.Size = *blobInfo.Properties.ContentLength
+				}
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	return snapshots, nil
+}
+
+// VersionInfo : A version of a blob kept by blob versioning, Created is when the content of this version was written
+type VersionInfo struct {
+	ID        string
+	Created   time.Time
+	Size      int64
+	IsCurrent bool
+}
+
+// ListVersions : Get the versions of a blob, oldest first, including the current one. Versions can be read with ReadVersionInBuffer.
+func (bb *BlockBlob) ListVersions(name string) ([]VersionInfo, error) {
+	log.Trace("BlockBlob::ListVersions : name %s", name)
+
+	blobName := joinPrefixPath(bb.Config.prefixPath, name)
+	pager := bb.containerClient().NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  &blobName,
+		Include: container.ListBlobsInclude{Versions: true},
+	})
+
+	versions := make([]VersionInfo, 0)
+	for pager.More() {
+		listBlobResp, err := pager.NextPage(context.Background())
+		if err != nil {
+			log.Err("BlockBlob::ListVersions : Failed to list versions of %s [%s]", name, err.Error())
+			return nil, err
+		}
+
+		for _, blobInfo := range listBlobResp.Segment.BlobItems {
+			// Prefix also matches other blobs starting with the same name
+			if blobInfo.Name == nil || *blobInfo.Name != blobName {
+				continue
+			}
+			if blobInfo.VersionID == nil || *blobInfo.VersionID == "" {
+				continue
+			}
+
+			version := VersionInfo{
+				ID:        *blobInfo.VersionID,
+				IsCurrent: blobInfo.IsCurrentVersion != nil && *blobInfo.IsCurrentVersion,
+			}
+			if blobInfo.Properties != nil {
+				version.Created = bb.dereferenceTime(blobInfo.Properties.LastModified, time.Time{})
+				if blobInfo.Properties.ContentLength != nil {
+					version.Size = *blobInfo.Properties.ContentLength
+				}
+			}
+			versions = append(versions, version)
+		}
+	}
+
+	return versions, nil
+}
+
+// DeletedVersion : A soft-deleted version of a blob, VersionID is empty when versioning is not enabled on the account
+type DeletedVersion struct {
+	VersionID   string
+	DeletedTime time.Time
+	Size        int64
+}
+
+// ListDeletedVersions : Get the soft-deleted versions of a blob in listing order.
+// GetAttr and listing report only the current version, even when the name has soft-deleted versions as well.
+func (bb *BlockBlob) ListDeletedVersions(name string) ([]DeletedVersion, error) {
+	log.Trace("BlockBlob::ListDeletedVersions : name %s", name)
+
+	blobName := joinPrefixPath(bb.Config.prefixPath, name)
+	pager := bb.containerClient().NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  &blobName,
+		Include: container.ListBlobsInclude{Deleted: true, Versions: true},
+	})
+
+	versions := make([]DeletedVersion, 0)
+	for pager.More() {
+		listBlobResp, err := pager.NextPage(context.Background())
+		if err != nil {
+			log.Err("BlockBlob::ListDeletedVersions : Failed to list deleted versions of %s [%s]", name, err.Error())
+			return nil, err
+		}
+
+		for _, blobInfo := range listBlobResp.Segment.BlobItems {
+			// Prefix also matches other blobs starting with the same name
+			if blobInfo.Name == nil || *blobInfo.Name != blobName {
+				continue
+			}
+			if blobInfo.Deleted == nil || !*blobInfo.Deleted {
+				continue
+			}
+			if blobInfo.IsCurrentVersion != nil && *blobInfo.IsCurrentVersion {
+				continue
+			}
+
+			version := DeletedVersion{}
+			if blobInfo.VersionID != nil {
+				version.VersionID = *blobInfo.VersionID
+			}
+			if blobInfo.Properties != nil {
+				version.DeletedTime = bb.dereferenceTime(blobInfo.Properties.DeletedTime, time.Time{})
+				if blobInfo.Properties.ContentLength != nil {
+					version.Size = *blobInfo.Properties.ContentLength
+				}
+			}
+			versions = append(versions, version)
+		}
+	}
+
+	return versions, nil
+}
+
 func (bb *BlockBlob) processBlobItems(blobItems []*container.BlobItem) ([]*internal.ObjAttr, map[string]bool, error) {
 	blobList := make([]*internal.ObjAttr, 0)
 	// For some directories 0 byte meta file may not exists so just create a map to figure out such directories
@@ -693,41 +1691,200 @@ func (bb *BlockBlob) getBlobAttr(blobInfo *container.BlobItem) (*internal.ObjAtt
 		log.Warn("BlockBlob::getBlobAttr : Failed to get file mode for %s [%s]", *blobInfo.Name, err.Error())
 	}
 
+	mtime := bb.lastModifiedTime(*blobInfo.Name, blobInfo.Properties.LastModified, blobInfo.Properties.CreationTime)
 	attr := &internal.ObjAttr{
 		Path:   removePrefixPath(bb.Config.prefixPath, *blobInfo.Name),
 		Name:   filepath.Base(*blobInfo.Name),
 		Size:   *blobInfo.Properties.ContentLength,
 		Mode:   mode,
-		Mtime:  *blobInfo.Properties.LastModified,
-		Atime:  bb.dereferenceTime(blobInfo.Properties.LastAccessedOn, *blobInfo.Properties.LastModified),
-		Ctime:  *blobInfo.Properties.LastModified,
-		Crtime: bb.dereferenceTime(blobInfo.Properties.CreationTime, *blobInfo.Properties.LastModified),
+		Mtime:  mtime,
+		Atime:  bb.dereferenceTime(blobInfo.Properties.LastAccessedOn, mtime),
+		Ctime:  mtime,
+		Crtime: bb.dereferenceTime(blobInfo.Properties.CreationTime, mtime),
 		Flags:  internal.NewFileBitMap(),
 		MD5:    blobInfo.Properties.ContentMD5,
 		ETag:   sanitizeEtag(blobInfo.Properties.ETag),
 	}
 
 	parseMetadata(attr, blobInfo.Metadata)
+	bb.applyDirContentType(attr, blobInfo.Properties.ContentType)
+	bb.applySymlinkFormat(attr, blobInfo.Properties.ContentType)
+	applyListedTier(attr, blobInfo.Properties.AccessTier)
+	if blobInfo.Deleted != nil && *blobInfo.Deleted {
+		attr.Flags.Set(internal.PropFlagDeleted)
+	}
 	if !bb.listDetails.Permissions {
 		// In case of HNS account do not set this flag
 		attr.Flags.Set(internal.PropFlagModeDefault)
 	}
+	bb.applyUnixMode(attr)
 
 	return attr, nil
 }
 
-func (bb *BlockBlob) getFileMode(permissions *string) (os.FileMode, error) {
-	if permissions == nil {
-		return 0, nil
+// applyListedTier : Listing already carries the access tier of each blob, surface it in metadata so that
+// callers do not need a GetProperties per blob to learn it. Left unset when the listing does not report it
+func applyListedTier(attr *internal.ObjAttr, tier *blob.AccessTier) {
+	if tier == nil || *tier == "" {
+		return
 	}
-	return getFileMode(*permissions)
-}
 
-func (bb *BlockBlob) dereferenceTime(input *time.Time, defaultTime time.Time) time.Time {
-	if input == nil {
-		return defaultTime
+	if attr.Metadata == nil {
+		attr.Metadata = make(map[string]*string)
 	}
-	return *input
+	attr.Metadata[accessTierKey] = to.Ptr(string(*tier))
+}
+
+// applyCopyStatus : Blob created by a copy (incremental or not) reports its status till the blob is modified,
+// it is surfaced in metadata so that tools can wait for an asynchronous copy to finish
+func applyCopyStatus(attr *internal.ObjAttr, prop *blob.GetPropertiesResponse) {
+	if prop.CopyStatus == nil {
+		return
+	}
+
+	if attr.Metadata == nil {
+		attr.Metadata = make(map[string]*string)
+	}
+	attr.Metadata[copyStatusKey] = to.Ptr(string(*prop.CopyStatus))
+	if prop.CopyProgress != nil {
+		attr.Metadata[copyProgressKey] = to.Ptr(*prop.CopyProgress)
+	}
+	if prop.CopySource != nil {
+		attr.Metadata[copySourceKey] = to.Ptr(*prop.CopySource)
+	}
+	if prop.IsIncrementalCopy != nil && *prop.IsIncrementalCopy {
+		attr.Metadata[incrementalCopyKey] = to.Ptr("true")
+	}
+}
+
+// applyImmutabilityState : Legal hold and immutability policy of the blob are surfaced in metadata, the service
+// reports them only for blobs in a container with version level immutability
+func applyImmutabilityState(attr *internal.ObjAttr, prop *blob.GetPropertiesResponse) {
+	if prop.LegalHold == nil && prop.ImmutabilityPolicyExpiresOn == nil {
+		return
+	}
+
+	if attr.Metadata == nil {
+		attr.Metadata = make(map[string]*string)
+	}
+	if prop.LegalHold != nil {
+		attr.Metadata[legalHoldKey] = to.Ptr(strconv.FormatBool(*prop.LegalHold))
+	}
+	if prop.ImmutabilityPolicyExpiresOn != nil {
+		attr.Metadata[immutableUntilKey] = to.Ptr(prop.ImmutabilityPolicyExpiresOn.UTC().Format(time.RFC3339))
+	}
+	if prop.ImmutabilityPolicyMode != nil {
+		attr.Metadata[immutabilityModeKey] = to.Ptr(strings.ToLower(string(*prop.ImmutabilityPolicyMode)))
+	}
+}
+
+// applyDirContentType : Blob with the configured directory-content-type is a directory marker even
+// without the folder metadata, as written by tools which rely only on the content type
+func (bb *BlockBlob) applyDirContentType(attr *internal.ObjAttr, contentType *string) {
+	if bb.Config.dirContentType == "" || contentType == nil || attr.IsDir() {
+		return
+	}
+
+	mediaType, _, _ := strings.Cut(*contentType, ";")
+	if strings.EqualFold(strings.TrimSpace(mediaType), bb.Config.dirContentType) {
+		attr.Flags = internal.NewDirBitMap()
+		attr.Mode = attr.Mode | os.ModeDir
+	}
+}
+
+// applySymlinkFormat : Links in the suffix and content-only formats are reported as symlinks whichever format
+// this mount writes, so links created by other tools show up as such. In suffix format the suffix is not shown.
+func (bb *BlockBlob) applySymlinkFormat(attr *internal.ObjAttr, contentType *string) {
+	if attr.IsDir() {
+		return
+	}
+
+	isLink := false
+	if contentType != nil {
+		mediaType, _, _ := strings.Cut(*contentType, ";")
+		isLink = strings.EqualFold(strings.TrimSpace(mediaType), symlinkContentType)
+	}
+
+	if len(attr.Name) > len(symlinkSuffix) && strings.HasSuffix(attr.Name, symlinkSuffix) {
+		isLink = true
+		if bb.Config.symlinkFormat == SymlinkFormatSuffix {
+			attr.Path = strings.TrimSuffix(attr.Path, symlinkSuffix)
+			attr.Name = strings.TrimSuffix(attr.Name, symlinkSuffix)
+		}
+	}
+
+	if isLink && !attr.IsSymlink() {
+		attr.Flags = internal.NewSymlinkBitMap()
+		attr.Mode = attr.Mode | os.ModeSymlink
+	}
+}
+
+// applyUnixMode : With store-unix-permissions the mode saved in metadata of the blob is used in place of the
+// default permissions. A malformed value falls back to default-unix-mode instead of failing the call.
+func (bb *BlockBlob) applyUnixMode(attr *internal.ObjAttr) {
+	if !bb.Config.storeUnixPermissions || !attr.IsModeDefault() {
+		return
+	}
+
+	for k, v := range attr.Metadata {
+		if strings.ToLower(k) != unixModeKey || v == nil {
+			continue
+		}
+
+		perm, err := strconv.ParseUint(strings.TrimSpace(*v), 8, 32)
+		if err != nil || perm > 0o7777 {
+			if bb.malformedModeLogged.CompareAndSwap(false, true) {
+				log.Warn("BlockBlob::applyUnixMode : Malformed mode %q in metadata of %s, using %o instead (further occurrences are not logged)", *v, attr.Path, bb.Config.defaultUnixMode)
+			}
+			perm = uint64(bb.Config.defaultUnixMode)
+		}
+
+		attr.Mode = (attr.Mode &^ os.ModePerm) | (os.FileMode(perm) & os.ModePerm)
+		attr.Flags.Clear(internal.PropFlagModeDefault)
+		return
+	}
+}
+
+func (bb *BlockBlob) getFileMode(permissions *string) (os.FileMode, error) {
+	if permissions == nil {
+		return 0, nil
+	}
+	return getFileMode(*permissions)
+}
+
+func (bb *BlockBlob) dereferenceTime(input *time.Time, defaultTime time.Time) time.Time {
+	if input == nil {
+		return defaultTime
+	}
+	return *input
+}
+
+// lastModifiedTime : Last modified time of the blob, or the configured fallback when it is missing
+func (bb *BlockBlob) lastModifiedTime(name string, lastModified *time.Time, creationTime *time.Time) time.Time {
+	if lastModified != nil && !lastModified.IsZero() {
+		return *lastModified
+	}
+
+	fallback := bb.Config.mtimeFallback
+	if fallback == nil {
+		fallback = DefaultMtimeFallback
+	}
+
+	for _, source := range fallback {
+		switch source {
+		case MtimeFallbackCreationTime:
+			if creationTime != nil && !creationTime.IsZero() {
+				log.Debug("BlockBlob::lastModifiedTime : %s has no last modified time, using creation time", name)
+				return *creationTime
+			}
+		case MtimeFallbackNow:
+			log.Debug("BlockBlob::lastModifiedTime : %s has no last modified time, using current time", name)
+			return time.Now()
+		}
+	}
+
+	log.Warn("BlockBlob::lastModifiedTime : %s has no last modified time", name)
+	return time.Time{}
 }
 
 func (bb *BlockBlob) processBlobPrefixes(blobPrefixes []*container.BlobPrefix, dirList map[string]bool, blobList *[]*internal.ObjAttr) error {
@@ -795,15 +1952,16 @@ func (bb *BlockBlob) createDirAttrWithPermissions(blobInfo *container.BlobPrefix
 	}
 
 	name := strings.TrimSuffix(*blobInfo.Name, "/")
+	mtime := bb.lastModifiedTime(name, blobInfo.Properties.LastModified, blobInfo.Properties.CreationTime)
 	attr := &internal.ObjAttr{
 		Path:   removePrefixPath(bb.Config.prefixPath, name),
 		Name:   filepath.Base(name),
 		Size:   *blobInfo.Properties.ContentLength,
 		Mode:   mode,
-		Mtime:  *blobInfo.Properties.LastModified,
-		Atime:  bb.dereferenceTime(blobInfo.Properties.LastAccessedOn, *blobInfo.Properties.LastModified),
-		Ctime:  *blobInfo.Properties.LastModified,
-		Crtime: bb.dereferenceTime(blobInfo.Properties.CreationTime, *blobInfo.Properties.LastModified),
+		Mtime:  mtime,
+		Atime:  bb.dereferenceTime(blobInfo.Properties.LastAccessedOn, mtime),
+		Ctime:  mtime,
+		Crtime: bb.dereferenceTime(blobInfo.Properties.CreationTime, mtime),
 		Flags:  internal.NewDirBitMap(),
 	}
 
@@ -821,12 +1979,44 @@ func trackDownload(name string, bytesTransferred int64, count int64, downloadPtr
 	}
 }
 
-// ReadToFile : Download a blob to a local file
-func (bb *BlockBlob) ReadToFile(name string, offset int64, count int64, fi *os.File) (err error) {
+// getConcurrency : Get the concurrency to be used for an operation.
+// A non-zero override supersedes the configured max-concurrency, clamped to the max connections allowed per host.
+func (bb *BlockBlob) getConcurrency(override uint16) uint16 {
+	if override == 0 {
+		return bb.Config.maxConcurrency
+	}
+
+	if override > uint16(MaxConnsPerHost) {
+		log.Warn("BlockBlob::getConcurrency : Concurrency override %d is too large, clamping to %d", override, MaxConnsPerHost)
+		return uint16(MaxConnsPerHost)
+	}
+
+	return override
+}
+
+// operationContext : Context bounding an operation with all its retries by timeout seconds, or by fallback when timeout is not set.
+// Cancelling it aborts the in flight request so its connection is closed instead of waiting on a slow response.
+func operationContext(timeout int32, fallback time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	}
+	if fallback > 0 {
+		return context.WithTimeout(context.Background(), fallback)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// ReadToFile : Download a blob to a local file. Ranges of block-size are fetched in parallel bounded by
+// max-concurrency and written at their offsets in the file, the last range is shorter when size is not a multiple.
+func (bb *BlockBlob) ReadToFile(options internal.CopyToFileOptions) (err error) {
+	name, offset, count, fi := options.Name, options.Offset, options.Count, options.File
 	log.Trace("BlockBlob::ReadToFile : name %s, offset : %d, count %d", name, offset, count)
 	//defer exectime.StatTimeCurrentBlock("BlockBlob::ReadToFile")()
 
-	blobClient := bb.Container.NewBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	blobClient, err := bb.versionBlobClient(name, options.VersionID)
+	if err != nil {
+		return err
+	}
 
 	downloadPtr := to.Ptr(int64(1))
 
@@ -843,13 +2033,25 @@ func (bb *BlockBlob) ReadToFile(name string, offset int64, count int64, fi *os.F
 		Offset: offset,
 		Count:  count,
 	}
+	dlOpts.Concurrency = bb.getConcurrency(options.Concurrency)
 
-	_, err = blobClient.DownloadFile(context.Background(), fi, &dlOpts)
+	if bb.Config.validateCRC64 {
+		err = bb.readToFileValidated(name, options.VersionID, offset, count, fi, dlOpts.Concurrency)
+	} else {
+		ctx, cancel := operationContext(bb.Config.readTimeout, 0)
+		defer cancel()
+		_, err = blobClient.DownloadFile(ctx, fi, &dlOpts)
+	}
 
 	if err != nil {
 		e := storeBlobErrToErr(err)
 		if e == ErrFileNotFound {
 			return syscall.ENOENT
+		} else if isDiskFull(err) {
+			return bb.discardPartialDownload(name, fi, err)
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			log.Err("BlockBlob::ReadToFile : Timed out downloading blob %s [%s]", name, err.Error())
+			return syscall.ETIMEDOUT
 		} else {
 			log.Err("BlockBlob::ReadToFile : Failed to download blob %s [%s]", name, err.Error())
 			return err
@@ -903,8 +2105,13 @@ func (bb *BlockBlob) ReadBuffer(name string, offset int64, len int64) ([]byte, e
 		len = attr.Size - offset
 	}
 
+	if bb.Config.maxBufferBytes > 0 && len > bb.Config.maxBufferBytes {
+		log.Err("BlockBlob::ReadBuffer : Requested length %v for %s exceeds max-buffer-bytes %v, use ReadToFile instead", len, name, bb.Config.maxBufferBytes)
+		return buff, syscall.EINVAL
+	}
+
 	buff = make([]byte, len)
-	blobClient := bb.Container.NewBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
 
 	dlOpts := (blob.DownloadBufferOptions)(*bb.downloadOptions)
 	dlOpts.Range = blob.HTTPRange{
@@ -912,7 +2119,14 @@ func (bb *BlockBlob) ReadBuffer(name string, offset int64, len int64) ([]byte, e
 		Count:  len,
 	}
 
-	_, err := blobClient.DownloadBuffer(context.Background(), buff, &dlOpts)
+	var err error
+	if bb.Config.validateCRC64 {
+		err = bb.readRangeValidated(name, "", offset, len, bytesWriterAt(buff), bb.Config.maxConcurrency)
+	} else {
+		ctx, cancel := operationContext(bb.Config.readTimeout, 0)
+		defer cancel()
+		_, err = blobClient.DownloadBuffer(ctx, buff, &dlOpts)
+	}
 
 	if err != nil {
 		e := storeBlobErrToErr(err)
@@ -920,6 +2134,9 @@ func (bb *BlockBlob) ReadBuffer(name string, offset int64, len int64) ([]byte, e
 			return buff, syscall.ENOENT
 		} else if e == InvalidRange {
 			return buff, syscall.ERANGE
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			log.Err("BlockBlob::ReadBuffer : Timed out downloading blob %s [%s]", name, err.Error())
+			return buff, syscall.ETIMEDOUT
 		}
 
 		log.Err("BlockBlob::ReadBuffer : Failed to download blob %s [%s]", name, err.Error())
@@ -929,16 +2146,209 @@ func (bb *BlockBlob) ReadBuffer(name string, offset int64, len int64) ([]byte, e
 	return buff, nil
 }
 
+// Largest range for which the service returns md5 or crc64 of the content
+const maxValidatedRange = 4 * common.MbToBytes
+
+// crc64 polynomial used by azure storage
+var azureCRC64Table = crc64.MakeTable(0x9A6C9329AC4BC9B5)
+
+type bytesWriterAt []byte
+
+func (b bytesWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	return copy(b[off:], p), nil
+}
+
+// readRangeValidated : Download the range in chunks for which the service returns crc64, every chunk is validated
+// by ReadInBuffer before it is written at its position relative to offset
+func (bb *BlockBlob) readRangeValidated(name string, versionID string, offset int64, count int64, writer io.WriterAt, concurrency uint16) error {
+	var wg sync.WaitGroup
+	var readErr error
+	var errLock sync.Mutex
+	workers := make(chan struct{}, max(concurrency, 1))
+
+	for start := int64(0); start < count; start += maxValidatedRange {
+		length := min(maxValidatedRange, count-start)
+
+		workers <- struct{}{}
+
+		// Do not start more chunks once one has failed, e.g. the local disk is full
+		errLock.Lock()
+		failed := readErr != nil
+		errLock.Unlock()
+		if failed {
+			<-workers
+			break
+		}
+
+		wg.Add(1)
+		go func(start int64, length int64) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+
+			chunk := make([]byte, length)
+			var err error
+			if versionID != "" {
+				err = bb.ReadVersionInBuffer(name, versionID, offset+start, length, chunk)
+			} else {
+				err = bb.ReadInBuffer(name, offset+start, length, chunk, nil)
+			}
+			if err == nil {
+				_, err = writer.WriteAt(chunk, start)
+			}
+			if err != nil {
+				errLock.Lock()
+				if readErr == nil {
+					readErr = err
+				}
+				errLock.Unlock()
+			}
+		}(start, length)
+	}
+	wg.Wait()
+
+	return readErr
+}
+
+// discardPartialDownload : Local disk filled up during the download, remove the partial file unless configured to keep it
+func (bb *BlockBlob) discardPartialDownload(name string, fi *os.File, err error) error {
+	if bb.Config.keepPartialOnDiskFull {
+		log.Err("BlockBlob::ReadToFile : Local disk full, partial download of %s kept in %s [%s]", name, fi.Name(), err.Error())
+		return syscall.ENOSPC
+	}
+
+	log.Err("BlockBlob::ReadToFile : Local disk full, removing partial download of %s from %s [%s]", name, fi.Name(), err.Error())
+
+	// Truncate first so the space is released even while the caller still holds the file open
+	if e := fi.Truncate(0); e != nil {
+		log.Warn("BlockBlob::ReadToFile : Failed to truncate %s [%s]", fi.Name(), e.Error())
+	}
+	if e := os.Remove(fi.Name()); e != nil && !os.IsNotExist(e) {
+		log.Warn("BlockBlob::ReadToFile : Failed to remove %s [%s]", fi.Name(), e.Error())
+	}
+	return syscall.ENOSPC
+}
+
+// readToFileValidated : Download the range to the file validating crc64 of each chunk, file is sized to the range like DownloadFile does
+func (bb *BlockBlob) readToFileValidated(name string, versionID string, offset int64, count int64, fi *os.File, concurrency uint16) error {
+	if count == 0 && versionID != "" {
+		blobClient, err := bb.versionBlobClient(name, versionID)
+		if err != nil {
+			return err
+		}
+		prop, err := blobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
+			CPKInfo: bb.blobCPKOpt,
+		})
+		if err != nil {
+			return err
+		}
+		count = *prop.ContentLength - offset
+	} else if count == 0 {
+		attr, err := bb.GetAttr(name)
+		if err != nil {
+			return err
+		}
+		count = attr.Size - offset
+	}
+
+	err := fi.Truncate(count)
+	if err != nil {
+		return err
+	}
+
+	return bb.readRangeValidated(name, versionID, offset, count, fi, concurrency)
+}
+
 // ReadInBuffer : Download specific range from a file to a user provided buffer
 func (bb *BlockBlob) ReadInBuffer(name string, offset int64, len int64, data []byte, etag *string) error {
 	// log.Trace("BlockBlob::ReadInBuffer : name %s", name)
+	err := bb.readInBuffer(name, "", offset, len, data, etag)
+	if !bloberror.HasCode(err, bloberror.ConditionNotMet) {
+		return err
+	}
+
+	// Blob changed while the read was in flight so the stream can not be resumed. If it was truncated
+	// read again limited to its current size instead of failing the read.
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	prop, propErr := blobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
+		CPKInfo: bb.blobCPKOpt,
+	})
+	if propErr != nil || prop.ContentLength == nil || offset+len <= *prop.ContentLength {
+		return err
+	}
+
+	size := *prop.ContentLength
+	log.Warn("BlockBlob::ReadInBuffer : %s shrank to %d bytes during read of offset %d length %d, reading up to the new size", name, size, offset, len)
+	if offset >= size {
+		// Range is now beyond the end of blob
+		return syscall.ERANGE
+	}
+
+	err = bb.readInBuffer(name, "", offset, size-offset, data, etag)
+	if end := min(len, int64(cap(data))); err == nil && size-offset < end {
+		clear(data[size-offset : end])
+	}
+	return err
+}
+
+// ReadVersionInBuffer : Download specific range from a version of the blob to a user provided buffer.
+// A version never changes so, unlike ReadInBuffer, there is no concurrent truncate to handle.
+func (bb *BlockBlob) ReadVersionInBuffer(name string, versionID string, offset int64, len int64, data []byte) error {
+	return bb.readInBuffer(name, versionID, offset, len, data, nil)
+}
+
+// archivedReadErr : Archived blob can not be read. When it is already being rehydrated the read is worth retrying,
+// so EAGAIN is returned along with the expected completion based on rehydrate priority.
+func (bb *BlockBlob) archivedReadErr(name string, blobClient *blob.Client, err error) error {
+	prop, propErr := blobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
+		CPKInfo: bb.blobCPKOpt,
+	})
+	if propErr == nil && prop.ArchiveStatus != nil && strings.HasPrefix(*prop.ArchiveStatus, "rehydrate-pending") {
+		rerr := newRehydratePendingError(name, prop.RehydratePriority)
+		log.Warn("BlockBlob::ReadInBuffer : %s", rerr.Error())
+		return rerr
+	}
+
+	log.Err("BlockBlob::ReadInBuffer : %s is in archive tier, rehydrate it to an online tier before reading [%s]", name, err.Error())
+	return syscall.EPERM
+}
+
+// versionBlobClient : Client of the given version of the blob, or of the current blob when versionID is empty
+func (bb *BlockBlob) versionBlobClient(name string, versionID string) (*blob.Client, error) {
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	if versionID == "" {
+		return blobClient, nil
+	}
+
+	versionClient, err := blobClient.WithVersionID(versionID)
+	if err != nil {
+		log.Err("BlockBlob::versionBlobClient : Invalid version %s of %s [%s]", versionID, name, err.Error())
+		return nil, syscall.EINVAL
+	}
+	return versionClient, nil
+}
+
+// zeroFill : Clear the buffer from the given index up to the end index, never past the length of buffer
+func zeroFill(data []byte, from int, to int64) {
+	to = min(to, int64(len(data)))
+	if int64(from) < to {
+		clear(data[from:to])
+	}
+}
+
+// readInBuffer : Download the range of blob, or of its version when versionID is set, into the buffer
+func (bb *BlockBlob) readInBuffer(name string, versionID string, offset int64, len int64, data []byte, etag *string) error {
 	if etag != nil {
 		*etag = ""
 	}
 
-	blobClient := bb.Container.NewBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	blobClient, err := bb.versionBlobClient(name, versionID)
+	if err != nil {
+		return err
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), max_context_timeout*time.Minute)
+	ctx, cancel := operationContext(bb.Config.readTimeout, max_context_timeout*time.Minute)
 	defer cancel()
 
 	opt := &blob.DownloadStreamOptions{
@@ -949,6 +2359,17 @@ func (bb *BlockBlob) ReadInBuffer(name string, offset int64, len int64, data []b
 		CPKInfo: bb.blobCPKOpt,
 	}
 
+	// Service computes md5 or crc64 of a range only up to 4MB, and only one of them per request
+	validateCRC := bb.Config.validateCRC64 && len > 0 && len <= maxValidatedRange
+	if validateCRC {
+		ctx = policy.WithHTTPHeader(ctx, http.Header{"x-ms-range-get-content-crc64": []string{"true"}})
+	}
+
+	validateRange := !validateCRC && bb.Config.validateMD5 && len > 0 && len <= maxValidatedRange
+	if validateRange {
+		opt.RangeGetContentMD5 = to.Ptr(true)
+	}
+
 	downloadResponse, err := blobClient.DownloadStream(ctx, opt)
 
 	if err != nil {
@@ -957,17 +2378,31 @@ func (bb *BlockBlob) ReadInBuffer(name string, offset int64, len int64, data []b
 			return syscall.ENOENT
 		} else if e == InvalidRange {
 			return syscall.ERANGE
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			log.Err("BlockBlob::ReadInBuffer : Timed out downloading blob %s [%s]", name, err.Error())
+			return syscall.ETIMEDOUT
+		} else if bloberror.HasCode(err, bloberror.BlobArchived) {
+			return bb.archivedReadErr(name, blobClient, err)
 		}
 
 		log.Err("BlockBlob::ReadInBufferWithETag : Failed to download blob %s [%s]", name, err.Error())
 		return err
 	}
 
-	var streamBody io.ReadCloser = downloadResponse.NewRetryReader(ctx, nil)
+	// Broken stream is resumed from the last received byte instead of failing the whole read
+	var streamBody io.ReadCloser = downloadResponse.NewRetryReader(ctx, &blob.RetryReaderOptions{
+		MaxRetries: bb.Config.readStreamRetries,
+		OnFailedRead: func(failureCount int32, lastError error, rnge blob.HTTPRange, willRetry bool) {
+			log.Warn("BlockBlob::ReadInBuffer : Stream of blob %s failed at offset %d, attempt %d, retry %t [%s]", name, rnge.Offset, failureCount, willRetry, lastError.Error())
+		},
+	})
 	dataRead, err := io.ReadFull(streamBody, data)
 
 	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		log.Err("BlockBlob::ReadInBuffer : Failed to copy data from body to buffer for blob %s [%s]", name, err.Error())
+		if errors.Is(err, context.DeadlineExceeded) {
+			return syscall.ETIMEDOUT
+		}
 		return err
 	}
 
@@ -976,6 +2411,37 @@ func (bb *BlockBlob) ReadInBuffer(name string, offset int64, len int64, data []b
 		return errors.New("failed to copy data from body to buffer")
 	}
 
+	// Service sent less than what it promised, the stream broke and retries are exhausted.
+	// This must not be mistaken for the sparse region beyond the end of blob.
+	if err != nil && downloadResponse.ContentLength != nil && int64(dataRead) < *downloadResponse.ContentLength {
+		_ = streamBody.Close()
+		log.Err("BlockBlob::ReadInBuffer : Short read of blob %s, got %d of %d bytes [%v]", name, dataRead, *downloadResponse.ContentLength, err)
+		return syscall.EIO
+	}
+
+	if validateCRC && downloadResponse.ContentCRC64 != nil {
+		if binary.LittleEndian.Uint64(downloadResponse.ContentCRC64) != crc64.Checksum(data[:dataRead], azureCRC64Table) {
+			_ = streamBody.Close()
+			log.Warn("BlockBlob::ReadInBuffer : CRC64 mismatch for blob %s at offset %d of length %d", name, offset, dataRead)
+			return syscall.EIO
+		}
+	}
+
+	if validateRange && downloadResponse.ContentMD5 != nil {
+		rangeMD5 := md5.Sum(data[:dataRead])
+		if !bytes.Equal(rangeMD5[:], downloadResponse.ContentMD5) {
+			_ = streamBody.Close()
+			log.Err("BlockBlob::ReadInBuffer : MD5 mismatch for blob %s at offset %d of length %d", name, offset, dataRead)
+			return syscall.EIO
+		}
+	}
+
+	// Blob may be shorter than the requested range if the file was extended without writing data.
+	// The region beyond the end of blob is sparse, so return zeros for it.
+	if downloadResponse.ContentLength != nil && *downloadResponse.ContentLength < len {
+		zeroFill(data, dataRead, len)
+	}
+
 	err = streamBody.Close()
 	if err != nil {
 		log.Err("BlockBlob::ReadInBuffer : Failed to close body for blob %s [%s]", name, err.Error())
@@ -1027,6 +2493,28 @@ func (bb *BlockBlob) calculateBlockSize(name string, fileSize int64) (blockSize
 	return blockSize, nil
 }
 
+// checkBlockLimit : With the configured block size an upload may need more than 50,000 blocks allowed by the service,
+// which fails only after most of the data is transferred. Detect it upfront and either bump up the block size
+// or, when strict-block-size is set, fail with EFBIG.
+func (bb *BlockBlob) checkBlockLimit(name string, size int64, blockSize int64) (int64, error) {
+	if blockSize == 0 || size <= blockSize*blockblob.MaxBlocks {
+		return blockSize, nil
+	}
+
+	if bb.Config.strictBlockSize {
+		log.Err("BlockBlob::checkBlockLimit : %s of size %v needs more than %v blocks of size %v", name, size, blockblob.MaxBlocks, blockSize)
+		return 0, syscall.EFBIG
+	}
+
+	newBlockSize, err := bb.calculateBlockSize(name, size)
+	if err != nil {
+		return 0, syscall.EFBIG
+	}
+
+	log.Warn("BlockBlob::checkBlockLimit : %s of size %v needs more than %v blocks of size %v, using block size %v", name, size, blockblob.MaxBlocks, blockSize, newBlockSize)
+	return newBlockSize, nil
+}
+
 // track the progress of upload of blobs where every 100MB of data uploaded is being tracked. It also tracks the completion of upload
 func trackUpload(name string, bytesTransferred int64, count int64, uploadPtr *int64) {
 	if bytesTransferred >= (*uploadPtr)*100*common.MbToBytes || bytesTransferred == count {
@@ -1039,27 +2527,41 @@ func trackUpload(name string, bytesTransferred int64, count int64, uploadPtr *in
 }
 
 // WriteFromFile : Upload local file to blob
-func (bb *BlockBlob) WriteFromFile(name string, metadata map[string]*string, fi *os.File) (err error) {
+func (bb *BlockBlob) WriteFromFile(options internal.CopyFromFileOptions) (err error) {
+	name, metadata, fi := options.Name, options.Metadata, options.File
 	log.Trace("BlockBlob::WriteFromFile : name %s", name)
 	//defer exectime.StatTimeCurrentBlock("WriteFromFile::WriteFromFile")()
 
-	blobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	err = bb.checkUploadTier(name)
+	if err != nil {
+		return err
+	}
+
+	blobClient := bb.containerClient().NewBlockBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
 	defer log.TimeTrack(time.Now(), "BlockBlob::WriteFromFile", name)
 
+	metadata = bb.withXAttrs(name, metadata)
+
 	uploadPtr := to.Ptr(int64(1))
 
 	blockSize := bb.Config.blockSize
-	// get the size of the file
+	// get the size of the file, only this many bytes are uploaded even if the file keeps growing during upload
 	stat, err := fi.Stat()
 	if err != nil {
 		log.Err("BlockBlob::WriteFromFile : Failed to get file size %s [%s]", name, err.Error())
 		return err
 	}
+	size := stat.Size()
 
 	// if the block size is not set then we configure it based on file size
 	if blockSize == 0 {
 		// based on file-size calculate block size
-		blockSize, err = bb.calculateBlockSize(name, stat.Size())
+		blockSize, err = bb.calculateBlockSize(name, size)
+		if err != nil {
+			return err
+		}
+	} else {
+		blockSize, err = bb.checkBlockLimit(name, size, blockSize)
 		if err != nil {
 			return err
 		}
@@ -1069,33 +2571,57 @@ func (bb *BlockBlob) WriteFromFile(name string, metadata map[string]*string, fi
 	// If file is uploaded in one shot (no blocks created) then server is populating md5 on upload automatically.
 	// hence we take cost of calculating md5 only for files which are bigger in size and which will be converted to blocks.
 	md5sum := []byte{}
-	if bb.Config.updateMD5 && stat.Size() >= blockblob.MaxUploadBlobBytes {
-		md5sum, err = common.GetMD5(fi)
+	if bb.Config.updateMD5 && size >= blockblob.MaxUploadBlobBytes {
+		hasher := md5.New()
+		_, err = io.Copy(hasher, io.NewSectionReader(fi, 0, size))
 		if err != nil {
 			// Md5 sum generation failed so set nil while uploading
 			log.Warn("BlockBlob::WriteFromFile : Failed to generate md5 of %s", name)
 			md5sum = []byte{0}
+		} else {
+			md5sum = hasher.Sum(nil)
+		}
+	}
+
+	// File may have been truncated while md5 was computed, bytes which no longer exist can not be uploaded.
+	// Data appended meanwhile is ignored so the blob is a consistent snapshot of the file at start of upload.
+	latest, err := fi.Stat()
+	if err == nil && latest.Size() < size {
+		log.Warn("BlockBlob::WriteFromFile : %s shrunk from %d to %d, skipping stale md5", name, size, latest.Size())
+		size = latest.Size()
+		md5sum = []byte{}
+		blockSize, err = bb.checkBlockLimit(name, size, blockSize)
+		if err != nil {
+			return err
 		}
 	}
 
 	uploadOptions := &blockblob.UploadFileOptions{
 		BlockSize:   blockSize,
-		Concurrency: bb.Config.maxConcurrency,
+		Concurrency: bb.getConcurrency(options.Concurrency),
 		Metadata:    metadata,
 		AccessTier:  bb.Config.defaultTier,
 		HTTPHeaders: &blob.HTTPHeaders{
-			BlobContentType: to.Ptr(getContentType(name)),
+			BlobContentType: to.Ptr(bb.uploadContentType(name, options.ContentType)),
 			BlobContentMD5:  md5sum,
 		},
 		CPKInfo: bb.blobCPKOpt,
+		Tags:    options.Tags,
 	}
-	if common.MonitorBfs() && stat.Size() > 0 {
+	if common.MonitorBfs() && size > 0 {
 		uploadOptions.Progress = func(bytesTransferred int64) {
-			trackUpload(name, bytesTransferred, stat.Size(), uploadPtr)
+			trackUpload(name, bytesTransferred, size, uploadPtr)
 		}
 	}
 
-	_, err = blobClient.UploadFile(context.Background(), fi, uploadOptions)
+	ctx, cancel := operationContext(bb.Config.writeTimeout, 0)
+	defer cancel()
+
+	if bb.Config.streamingWrite {
+		err = bb.streamReaderAtToBlockBlob(ctx, blobClient, fi, size, uploadOptions)
+	} else {
+		err = bb.uploadReaderAtToBlockBlob(ctx, blobClient, fi, size, uploadOptions)
+	}
 
 	if err != nil {
 		serr := storeBlobErrToErr(err)
@@ -1105,6 +2631,15 @@ func (bb *BlockBlob) WriteFromFile(name string, metadata map[string]*string, fi
 		} else if serr == InvalidPermission {
 			log.Err("BlockBlob::WriteFromFile : Insufficient permissions for %s [%s]", name, err.Error())
 			return syscall.EACCES
+		} else if serr == MD5Mismatch {
+			log.Err("BlockBlob::WriteFromFile : Data of blob %s got corrupted during upload [%s]", name, err.Error())
+			return syscall.EIO
+		} else if serr == BlobImmutable {
+			log.Err("BlockBlob::WriteFromFile : %s is under a legal hold or a locked immutability policy [%s]", name, err.Error())
+			return syscall.EPERM
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			log.Err("BlockBlob::WriteFromFile : Timed out uploading blob %s [%s]", name, err.Error())
+			return syscall.ETIMEDOUT
 		} else {
 			log.Err("BlockBlob::WriteFromFile : Failed to upload blob %s [%s]", name, err.Error())
 		}
@@ -1113,50 +2648,474 @@ func (bb *BlockBlob) WriteFromFile(name string, metadata map[string]*string, fi
 		log.Debug("BlockBlob::WriteFromFile : Upload complete of blob %v", name)
 
 		// store total bytes uploaded so far
-		if stat.Size() > 0 {
-			azStatsCollector.UpdateStats(stats_manager.Increment, bytesUploaded, stat.Size())
+		if size > 0 {
+			azStatsCollector.UpdateStats(stats_manager.Increment, bytesUploaded, size)
+		}
+	}
+
+	return nil
+}
+
+// Largest file uploaded with a single put, larger ones are staged in blocks
+var singleUploadMaxBytes int64 = blockblob.MaxUploadBlobBytes
+
+// transactionalMD5 : md5 of the data sent along with the request when validate-md5 is set,
+// service rejects the request if data got corrupted on the way
+func (bb *BlockBlob) transactionalMD5(data io.Reader) (blob.TransferValidationType, error) {
+	if !bb.Config.validateMD5 {
+		return nil, nil
+	}
+
+	hasher := md5.New()
+	_, err := io.Copy(hasher, data)
+	if err != nil {
+		return nil, err
+	}
+	return blob.TransferValidationTypeMD5(hasher.Sum(nil)), nil
+}
+
+// uploadReaderAtToBlockBlob : Upload first size bytes of the reader, same as UploadFile of the sdk except that the size is
+// fixed by the caller. UploadFile stats the file itself and would pick up data appended after the caller's stat.
+func (bb *BlockBlob) uploadReaderAtToBlockBlob(ctx context.Context, blobClient *blockblob.Client, reader io.ReaderAt, size int64, o *blockblob.UploadFileOptions) error {
+	if size <= singleUploadMaxBytes {
+		validation, err := bb.transactionalMD5(io.NewSectionReader(reader, 0, size))
+		if err != nil {
+			return err
+		}
+
+		var body io.ReadSeekCloser = streaming.NopCloser(io.NewSectionReader(reader, 0, size))
+		if o.Progress != nil {
+			body = streaming.NewRequestProgress(body, o.Progress)
+		}
+		expiry, mode := bb.immutabilityPolicy()
+		_, err = blobClient.Upload(ctx, body, &blockblob.UploadOptions{
+			HTTPHeaders:                  o.HTTPHeaders,
+			Metadata:                     o.Metadata,
+			Tier:                         o.AccessTier,
+			CPKInfo:                      o.CPKInfo,
+			Tags:                         o.Tags,
+			TransactionalValidation:      validation,
+			ImmutabilityPolicyExpiryTime: expiry,
+			ImmutabilityPolicyMode:       mode,
+		})
+		return err
+	}
+
+	blockIDs, err := bb.stageReaderAt(ctx, blobClient, reader, size, o)
+	if err != nil {
+		return err
+	}
+
+	expiry, mode := bb.immutabilityPolicy()
+	_, err = blobClient.CommitBlockList(ctx, blockIDs, &blockblob.CommitBlockListOptions{
+		HTTPHeaders:                  o.HTTPHeaders,
+		Metadata:                     o.Metadata,
+		Tier:                         o.AccessTier,
+		CPKInfo:                      o.CPKInfo,
+		Tags:                         o.Tags,
+		ImmutabilityPolicyExpiryTime: expiry,
+		ImmutabilityPolicyMode:       mode,
+	})
+	return err
+}
+
+// stageReaderAt : Stage first size bytes of the reader as blocks of o.BlockSize, returns the ids to commit in order
+func (bb *BlockBlob) stageReaderAt(ctx context.Context, blobClient *blockblob.Client, reader io.ReaderAt, size int64, o *blockblob.UploadFileOptions) ([]string, error) {
+	blockCount := (size + o.BlockSize - 1) / o.BlockSize
+	blockIDs := make([]string, blockCount)
+
+	var wg sync.WaitGroup
+	var stageErr error
+	var errLock sync.Mutex
+	var transferred atomic.Int64
+	workers := make(chan struct{}, max(o.Concurrency, 1))
+
+	for i := int64(0); i < blockCount; i++ {
+		blockIDs[i] = common.GetBlockID(common.BlockIDLength)
+		offset := i * o.BlockSize
+		length := min(o.BlockSize, size-offset)
+
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(id string, offset int64, length int64) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			errLock.Lock()
+			failed := stageErr != nil
+			errLock.Unlock()
+			if failed {
+				return
+			}
+
+			validation, err := bb.transactionalMD5(io.NewSectionReader(reader, offset, length))
+			if err == nil {
+				_, err = blobClient.StageBlock(ctx, id, streaming.NopCloser(io.NewSectionReader(reader, offset, length)), &blockblob.StageBlockOptions{
+					CPKInfo:                 o.CPKInfo,
+					TransactionalValidation: validation,
+				})
+			}
+			if err != nil {
+				errLock.Lock()
+				if stageErr == nil {
+					stageErr = err
+				}
+				errLock.Unlock()
+				return
+			}
+
+			if o.Progress != nil {
+				o.Progress(transferred.Add(length))
+			}
+		}(blockIDs[i], offset, length)
+	}
+	wg.Wait()
+
+	if stageErr != nil {
+		return nil, stageErr
+	}
+	return blockIDs, nil
+}
+
+// streamReaderAtToBlockBlob : Upload size bytes of reader as blocks, reading one block at a time into a buffer
+// which is reused once the block is staged. At most o.Concurrency buffers of o.BlockSize exist at any time.
+func (bb *BlockBlob) streamReaderAtToBlockBlob(ctx context.Context, blobClient *blockblob.Client, reader io.ReaderAt, size int64, o *blockblob.UploadFileOptions) error {
+	blockCount := (size + o.BlockSize - 1) / o.BlockSize
+	blockIDs := make([]string, blockCount)
+
+	// Buffers are allocated on first use, a block waits here till a staged one hands its buffer back
+	buffers := make(chan []byte, max(o.Concurrency, 1))
+	for i := 0; i < cap(buffers); i++ {
+		buffers <- nil
+	}
+
+	var wg sync.WaitGroup
+	var stageErr error
+	var errLock sync.Mutex
+	var transferred atomic.Int64
+	failed := func() bool {
+		errLock.Lock()
+		defer errLock.Unlock()
+		return stageErr != nil
+	}
+	setErr := func(err error) {
+		errLock.Lock()
+		defer errLock.Unlock()
+		if stageErr == nil {
+			stageErr = err
+		}
+	}
+
+	for i := int64(0); i < blockCount && !failed(); i++ {
+		blockIDs[i] = common.GetBlockID(common.BlockIDLength)
+		offset := i * o.BlockSize
+		length := min(o.BlockSize, size-offset)
+
+		buf := <-buffers
+		if buf == nil {
+			buf = bb.buffers.get(o.BlockSize)
+		}
+
+		n, err := reader.ReadAt(buf[:length], offset)
+		if int64(n) < length {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			buffers <- buf
+			setErr(err)
+			break
+		}
+
+		wg.Add(1)
+		go func(id string, data []byte) {
+			defer wg.Done()
+			defer func() { buffers <- buf }()
+
+			validation, err := bb.transactionalMD5(bytes.NewReader(data))
+			if err == nil {
+				_, err = blobClient.StageBlock(ctx, id, streaming.NopCloser(bytes.NewReader(data)), &blockblob.StageBlockOptions{
+					CPKInfo:                 o.CPKInfo,
+					TransactionalValidation: validation,
+				})
+			}
+			if err != nil {
+				setErr(err)
+				return
+			}
+
+			if o.Progress != nil {
+				o.Progress(transferred.Add(int64(len(data))))
+			}
+		}(blockIDs[i], buf[:length])
+	}
+	wg.Wait()
+
+	// Every stage has returned, no request refers to the buffers any more
+	for i := 0; i < cap(buffers); i++ {
+		if buf := <-buffers; buf != nil {
+			bb.buffers.put(buf)
+		}
+	}
+
+	if stageErr != nil {
+		return stageErr
+	}
+
+	expiry, mode := bb.immutabilityPolicy()
+	_, err := blobClient.CommitBlockList(ctx, blockIDs, &blockblob.CommitBlockListOptions{
+		HTTPHeaders:                  o.HTTPHeaders,
+		Metadata:                     o.Metadata,
+		Tier:                         o.AccessTier,
+		CPKInfo:                      o.CPKInfo,
+		Tags:                         o.Tags,
+		ImmutabilityPolicyExpiryTime: expiry,
+		ImmutabilityPolicyMode:       mode,
+	})
+	return err
+}
+
+// WriteFromBuffer : Upload from a buffer to a blob
+func (bb *BlockBlob) WriteFromBuffer(name string, metadata map[string]*string, data []byte) error {
+	return bb.uploadBuffer(name, metadata, data, "")
+}
+
+// uploadContentType : Content type to upload name with, one provided by the caller is kept as is
+func (bb *BlockBlob) uploadContentType(name string, contentType string) string {
+	if contentType != "" {
+		return contentType
+	}
+
+	if bb.Config.contentTypeDetection {
+		ext := strings.ToLower(filepath.Ext(name))
+		if value, found := bb.Config.contentTypeMap[ext]; found {
+			return value
+		}
+		if value := mime.TypeByExtension(ext); value != "" {
+			return value
+		}
+	}
+
+	return getContentType(name)
+}
+
+// uploadBuffer : Upload data as the whole blob, content type is detected from name when not provided
+func (bb *BlockBlob) uploadBuffer(name string, metadata map[string]*string, data []byte, contentType string) error {
+	log.Trace("BlockBlob::WriteFromBuffer : name %s", name)
+
+	err := bb.checkUploadTier(name)
+	if err != nil {
+		return err
+	}
+
+	blobClient := bb.containerClient().NewBlockBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+
+	defer log.TimeTrack(time.Now(), "BlockBlob::WriteFromBuffer", name)
+
+	blockSize, err := bb.checkBlockLimit(name, int64(len(data)), bb.Config.blockSize)
+	if err != nil {
+		return err
+	}
+
+	uploadOptions := &blockblob.UploadBufferOptions{
+		BlockSize:   blockSize,
+		Concurrency: bb.Config.maxConcurrency,
+		Metadata:    metadata,
+		AccessTier:  bb.Config.defaultTier,
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobContentType: to.Ptr(bb.uploadContentType(name, contentType)),
+		},
+		CPKInfo: bb.blobCPKOpt,
+	}
+
+	ctx, cancel := operationContext(bb.Config.writeTimeout, 0)
+	defer cancel()
+
+	if bb.Config.validateMD5 || bb.immutableContainer {
+		// sdk can not send md5 per block nor an immutability policy, so blocks are staged here
+		if uploadOptions.BlockSize == 0 {
+			uploadOptions.BlockSize = blockblob.MaxStageBlockBytes
 		}
+		err = bb.uploadReaderAtToBlockBlob(ctx, blobClient, bytes.NewReader(data), int64(len(data)), uploadOptions)
+	} else {
+		_, err = blobClient.UploadBuffer(ctx, data, uploadOptions)
+	}
+
+	if err != nil {
+		serr := storeBlobErrToErr(err)
+		if serr == MD5Mismatch {
+			log.Err("BlockBlob::WriteFromBuffer : Data of blob %s got corrupted during upload [%s]", name, err.Error())
+			return syscall.EIO
+		} else if serr == BlobImmutable {
+			log.Err("BlockBlob::WriteFromBuffer : %s is under a legal hold or a locked immutability policy [%s]", name, err.Error())
+			return syscall.EPERM
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			log.Err("BlockBlob::WriteFromBuffer : Timed out uploading blob %s [%s]", name, err.Error())
+			return syscall.ETIMEDOUT
+		}
+		log.Err("BlockBlob::WriteFromBuffer : Failed to upload blob %s [%s]", name, err.Error())
+		return err
 	}
 
 	return nil
 }
 
-// WriteFromBuffer : Upload from a buffer to a blob
-func (bb *BlockBlob) WriteFromBuffer(name string, metadata map[string]*string, data []byte) error {
-	log.Trace("BlockBlob::WriteFromBuffer : name %s", name)
-	blobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, name))
+// CreateImmutable : Create a blob only if it does not exist (write-once) and optionally put it under
+// legal hold and/or a time based immutability policy
+func (bb *BlockBlob) CreateImmutable(name string, data []byte, legalHold bool, expiry *time.Time) error {
+	log.Trace("BlockBlob::CreateImmutable : name %s, legal-hold %t", name, legalHold)
+	blobClient := bb.containerClient().NewBlockBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+
+	// Protection goes with the upload itself, a blob created without it could neither be protected later
+	// nor be created again as it already exists
+	expiryTime, mode := bb.immutabilityPolicy()
+	if expiry != nil {
+		expiryTime, mode = expiry, to.Ptr(blob.ImmutabilityPolicySettingUnlocked)
+	}
+	var hold *bool
+	if legalHold {
+		hold = to.Ptr(true)
+	}
+	headers := &blob.HTTPHeaders{
+		BlobContentType: to.Ptr(bb.uploadContentType(name, "")),
+	}
+	conditions := &blob.AccessConditions{
+		ModifiedAccessConditions: &blob.ModifiedAccessConditions{
+			IfNoneMatch: to.Ptr(azcore.ETagAny),
+		},
+	}
+
+	ctx, cancel := operationContext(bb.Config.writeTimeout, 0)
+	defer cancel()
+
+	var err error
+	size := int64(len(data))
+	if size <= singleUploadMaxBytes {
+		_, err = blobClient.Upload(ctx, streaming.NopCloser(bytes.NewReader(data)), &blockblob.UploadOptions{
+			HTTPHeaders:                  headers,
+			Tier:                         bb.Config.defaultTier,
+			CPKInfo:                      bb.blobCPKOpt,
+			AccessConditions:             conditions,
+			LegalHold:                    hold,
+			ImmutabilityPolicyExpiryTime: expiryTime,
+			ImmutabilityPolicyMode:       mode,
+		})
+	} else {
+		blockSize := bb.Config.blockSize
+		if blockSize == 0 {
+			blockSize = blockblob.MaxStageBlockBytes
+		}
+		var blockIDs []string
+		blockIDs, err = bb.stageReaderAt(ctx, blobClient, bytes.NewReader(data), size, &blockblob.UploadFileOptions{
+			BlockSize:   blockSize,
+			Concurrency: bb.getConcurrency(0),
+			CPKInfo:     bb.blobCPKOpt,
+		})
+		if err == nil {
+			_, err = blobClient.CommitBlockList(ctx, blockIDs, &blockblob.CommitBlockListOptions{
+				HTTPHeaders:                  headers,
+				Tier:                         bb.Config.defaultTier,
+				CPKInfo:                      bb.blobCPKOpt,
+				AccessConditions:             conditions,
+				LegalHold:                    hold,
+				ImmutabilityPolicyExpiryTime: expiryTime,
+				ImmutabilityPolicyMode:       mode,
+			})
+		}
+	}
+
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobAlreadyExists, bloberror.ConditionNotMet) {
+			log.Err("BlockBlob::CreateImmutable : %s already exists", name)
+			return syscall.EEXIST
+		}
+		log.Err("BlockBlob::CreateImmutable : Failed to upload blob %s [%s]", name, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// SetImmutabilityPolicy : Keep the blob immutable till the given time, mode is unlocked or locked.
+// A locked policy can only be extended, it can neither be shortened nor cleared.
+func (bb *BlockBlob) SetImmutabilityPolicy(name string, until time.Time, mode string) error {
+	log.Trace("BlockBlob::SetImmutabilityPolicy : name %s, until %v, mode %s", name, until, mode)
+
+	var setting blob.ImmutabilityPolicySetting
+	switch strings.ToLower(mode) {
+	case "", "unlocked":
+		setting = blob.ImmutabilityPolicySettingUnlocked
+	case "locked":
+		setting = blob.ImmutabilityPolicySettingLocked
+	default:
+		log.Err("BlockBlob::SetImmutabilityPolicy : Invalid mode %s for %s, supported values are unlocked and locked", mode, name)
+		return syscall.EINVAL
+	}
+
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	_, err := blobClient.SetImmutabilityPolicy(context.Background(), until, &blob.SetImmutabilityPolicyOptions{
+		Mode: &setting,
+	})
+	return bb.immutabilityErr("SetImmutabilityPolicy", name, err)
+}
+
+// ClearImmutabilityPolicy : Remove the unlocked immutability policy of the blob
+func (bb *BlockBlob) ClearImmutabilityPolicy(name string) error {
+	log.Trace("BlockBlob::ClearImmutabilityPolicy : name %s", name)
 
-	defer log.TimeTrack(time.Now(), "BlockBlob::WriteFromBuffer", name)
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	_, err := blobClient.DeleteImmutabilityPolicy(context.Background(), nil)
+	return bb.immutabilityErr("ClearImmutabilityPolicy", name, err)
+}
 
-	_, err := blobClient.UploadBuffer(context.Background(), data, &blockblob.UploadBufferOptions{
-		BlockSize:   bb.Config.blockSize,
-		Concurrency: bb.Config.maxConcurrency,
-		Metadata:    metadata,
-		AccessTier:  bb.Config.defaultTier,
-		HTTPHeaders: &blob.HTTPHeaders{
-			BlobContentType: to.Ptr(getContentType(name)),
-		},
-		CPKInfo: bb.blobCPKOpt,
-	})
+// SetLegalHold : Put the blob under legal hold or release it
+func (bb *BlockBlob) SetLegalHold(name string, on bool) error {
+	log.Trace("BlockBlob::SetLegalHold : name %s, legal-hold %t", name, on)
 
-	if err != nil {
-		log.Err("BlockBlob::WriteFromBuffer : Failed to upload blob %s [%s]", name, err.Error())
-		return err
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	_, err := blobClient.SetLegalHold(context.Background(), on, nil)
+	return bb.immutabilityErr("SetLegalHold", name, err)
+}
+
+// immutabilityErr : Convert failure to change the legal hold or immutability policy of a blob
+func (bb *BlockBlob) immutabilityErr(method string, name string, err error) error {
+	if err == nil {
+		return nil
 	}
 
-	return nil
+	serr := storeBlobErrToErr(err)
+	if serr == ErrFileNotFound {
+		log.Err("BlockBlob::%s : %s does not exist", method, name)
+		return syscall.ENOENT
+	} else if serr == InvalidPermission {
+		log.Err("BlockBlob::%s : Insufficient permissions for %s [%s]", method, name, err.Error())
+		return syscall.EACCES
+	} else if bloberror.HasCode(err, bloberror.ImmutabilityPolicyDeleteOnLockedPolicy) || serr == BlobImmutable {
+		log.Err("BlockBlob::%s : Immutability policy of %s is locked [%s]", method, name, err.Error())
+		return syscall.EPERM
+	}
+	log.Err("BlockBlob::%s : Failed to update immutability of %s [%s]", method, name, err.Error())
+	return err
 }
 
 // GetFileBlockOffsets: store blocks ids and corresponding offsets
 func (bb *BlockBlob) GetFileBlockOffsets(name string) (*common.BlockOffsetList, error) {
 	var blockOffset int64 = 0
 	blockList := common.BlockOffsetList{}
-	blobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	blobClient := bb.containerClient().NewBlockBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
 
 	storageBlockList, err := blobClient.GetBlockList(context.Background(), blockblob.BlockListTypeCommitted, nil)
 
 	if err != nil {
-		log.Err("BlockBlob::GetFileBlockOffsets : Failed to get block list %s ", name, err.Error())
+		if bloberror.HasCode(err, bloberror.InvalidBlobType) {
+			// Append and page blobs do not have a block list, their blocks can not be staged or re-committed
+			log.Err("BlockBlob::GetFileBlockOffsets : %s is not a block blob, block offsets are not supported", name)
+			return &common.BlockOffsetList{}, syscall.ENOTSUP
+		} else if storeBlobErrToErr(err) == ErrFileNotFound {
+			// Missing file is an error, unlike an existing empty one which has an empty block list
+			log.Err("BlockBlob::GetFileBlockOffsets : %s does not exist", name)
+			return &common.BlockOffsetList{}, syscall.ENOENT
+		}
+		log.Err("BlockBlob::GetFileBlockOffsets : Failed to get block list %s [%s]", name, err.Error())
 		return &common.BlockOffsetList{}, err
 	}
 
@@ -1167,6 +3126,10 @@ func (bb *BlockBlob) GetFileBlockOffsets(name string) (*common.BlockOffsetList,
 	}
 
 	for _, block := range storageBlockList.CommittedBlocks {
+		if len(*block.Name) != len(*storageBlockList.CommittedBlocks[0].Name) {
+			log.Err("BlockBlob::GetFileBlockOffsets : Block ids of %s differ in length [%s, %s]", name, *storageBlockList.CommittedBlocks[0].Name, *block.Name)
+			return &common.BlockOffsetList{}, syscall.EINVAL
+		}
 		blk := &common.Block{
 			Id:         *block.Name,
 			StartIndex: int64(blockOffset),
@@ -1261,8 +3224,8 @@ func (bb *BlockBlob) TruncateFile(name string, size int64) error {
 		// If we are resizing to a value > 1GB then we need to upload multiple blocks to resize
 		if size > 1*common.GbToBytes {
 			blkSize := int64(16 * common.MbToBytes)
-			blobName := filepath.Join(bb.Config.prefixPath, name)
-			blobClient := bb.Container.NewBlockBlobClient(blobName)
+			blobName := joinPrefixPath(bb.Config.prefixPath, name)
+			blobClient := bb.containerClient().NewBlockBlobClient(blobName)
 
 			blkList := make([]string, 0)
 			id := common.GetBlockID(common.BlockIDLength)
@@ -1291,7 +3254,7 @@ func (bb *BlockBlob) TruncateFile(name string, size int64) error {
 				size -= blkSize
 			}
 
-			err = bb.CommitBlocks(blobName, blkList, nil)
+			err = bb.CommitBlocks(blobName, blkList, nil, nil, "", "")
 			if err != nil {
 				log.Err("BlockBlob::TruncateFile : Failed to commit blocks for %s [%s]", name, err.Error())
 				return err
@@ -1344,7 +3307,7 @@ func (bb *BlockBlob) TruncateFile(name string, size int64) error {
 					return err
 				}
 			}
-			err = bb.StageAndCommit(name, bol)
+			err = bb.StageAndCommit(name, bol, 0)
 			if err != nil {
 				log.Err("BlockBlob::TruncateFile : Failed to stage and commit file %s", name, err.Error())
 				return err
@@ -1372,6 +3335,91 @@ func (bb *BlockBlob) HandleSmallFile(name string, size int64, originalSize int64
 	return data, err
 }
 
+// checkWritableTier : Blobs in archive tier can not be modified till they are rehydrated,
+// so with check-archive-tier fail before any data is read or staged for such a blob
+func (bb *BlockBlob) checkWritableTier(name string) error {
+	if !bb.Config.checkArchiveTier {
+		return nil
+	}
+
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	prop, err := blobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
+		CPKInfo: bb.blobCPKOpt,
+	})
+	if err != nil {
+		if storeBlobErrToErr(err) == ErrFileNotFound {
+			// Blob does not exist yet, so there is nothing archived to write to
+			return nil
+		}
+		log.Err("BlockBlob::checkWritableTier : Failed to get tier of %s [%s]", name, err.Error())
+		return err
+	}
+
+	if prop.AccessTier != nil && blob.AccessTier(*prop.AccessTier) == blob.AccessTierArchive {
+		log.Err("BlockBlob::checkWritableTier : %s is in archive tier, rehydrate it to an online tier before writing", name)
+		return syscall.EPERM
+	}
+
+	return nil
+}
+
+// archivedWriteErr : Service rejected the write as the blob is in archive tier
+func archivedWriteErr(name string, err error) error {
+	if err != nil && bloberror.HasCode(err, bloberror.BlobArchived) {
+		log.Err("BlockBlob::archivedWriteErr : %s is in archive tier, rehydrate it to an online tier before writing [%s]", name, err.Error())
+		return syscall.EPERM
+	}
+	return err
+}
+
+// checkUploadTier : Data uploaded with archive as default tier is offline right away and can not be read back
+// till it is rehydrated, so such uploads are rejected when reject-archive-tier is set
+func (bb *BlockBlob) checkUploadTier(name string) error {
+	if bb.Config.defaultTier == nil || *bb.Config.defaultTier != blob.AccessTierArchive {
+		return nil
+	}
+
+	if bb.Config.rejectArchiveTier {
+		log.Err("BlockBlob::checkUploadTier : Rejecting upload of %s as default tier is archive", name)
+		return syscall.EPERM
+	}
+
+	log.Warn("BlockBlob::checkUploadTier : %s is uploaded in archive tier and will not be readable till it is rehydrated", name)
+	return nil
+}
+
+// SetTier : Change the access tier of a blob
+func (bb *BlockBlob) SetTier(name string, tier blob.AccessTier) error {
+	log.Trace("BlockBlob::SetTier : name %s tier %s", name, tier)
+
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	_, err := blobClient.SetTier(context.Background(), tier, nil)
+	if err != nil {
+		log.Err("BlockBlob::SetTier : Failed to set tier of %s to %s [%s]", name, tier, err.Error())
+		var respErr *azcore.ResponseError
+		errors.As(err, &respErr)
+		if respErr != nil {
+			switch (bloberror.Code)(respErr.ErrorCode) {
+			case bloberror.InvalidBlobTier, bloberror.CannotChangeToLowerTier, bloberror.BlobTierInadequateForContentLength:
+				return syscall.EINVAL
+			}
+		}
+
+		switch storeBlobErrToErr(err) {
+		case ErrFileNotFound:
+			return syscall.ENOENT
+		case InvalidPermission:
+			return syscall.EACCES
+		case BlobIsUnderLease:
+			return syscall.EIO
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Write : write data at given offset to a blob
 func (bb *BlockBlob) Write(options internal.WriteFileOptions) error {
 	name := options.Handle.Path
@@ -1380,6 +3428,17 @@ func (bb *BlockBlob) Write(options internal.WriteFileOptions) error {
 	log.Trace("BlockBlob::Write : name %s offset %v", name, offset)
 	// tracks the case where our offset is great than our current file size (appending only - not modifying pre-existing data)
 	var dataBuffer *[]byte
+
+	err := bb.checkUploadTier(name)
+	if err != nil {
+		return err
+	}
+
+	err = bb.checkWritableTier(name)
+	if err != nil {
+		return err
+	}
+
 	// when the file offset mapping is cached we don't need to make a get block list call
 	fileOffsets, err := bb.GetFileBlockOffsets(name)
 	if err != nil {
@@ -1416,10 +3475,10 @@ func (bb *BlockBlob) Write(options internal.WriteFileOptions) error {
 			}
 		}
 		// WriteFromBuffer should be able to handle the case where now the block is too big and gets split into multiple blocks
-		err := bb.WriteFromBuffer(name, options.Metadata, *dataBuffer)
+		err := bb.WriteFromBuffer(name, bb.withXAttrs(name, options.Metadata), *dataBuffer)
 		if err != nil {
 			log.Err("BlockBlob::Write : Failed to upload to blob %s ", name, err.Error())
-			return err
+			return archivedWriteErr(name, err)
 		}
 		// case 2: given offset is within the size of the blob - and the blob consists of multiple blocks
 		// case 3: new blocks need to be added
@@ -1448,41 +3507,74 @@ func (bb *BlockBlob) Write(options internal.WriteFileOptions) error {
 		blockOffset := offset - fileOffsets.BlockList[index].StartIndex
 		copy(oldDataBuffer[blockOffset:], data)
 		err := bb.stageAndCommitModifiedBlocks(name, oldDataBuffer, fileOffsets)
-		return err
+		return archivedWriteErr(name, err)
 	}
 	return nil
 }
 
 // TODO: make a similar method facing stream that would enable us to write to cached blocks then stage and commit
 func (bb *BlockBlob) stageAndCommitModifiedBlocks(name string, data []byte, offsetList *common.BlockOffsetList) error {
-	blobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	blobClient := bb.containerClient().NewBlockBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
 	blockOffset := int64(0)
 	var blockIDList []string
+
+	var wg sync.WaitGroup
+	var stageErr error
+	var errLock sync.Mutex
+	workers := make(chan struct{}, max(bb.getConcurrency(0), 1))
+
 	for _, blk := range offsetList.BlockList {
 		blockIDList = append(blockIDList, blk.Id)
 		if blk.Dirty() {
-			_, err := blobClient.StageBlock(context.Background(),
-				blk.Id,
-				streaming.NopCloser(bytes.NewReader(data[blockOffset:(blk.EndIndex-blk.StartIndex)+blockOffset])),
-				&blockblob.StageBlockOptions{
-					CPKInfo: bb.blobCPKOpt,
-				})
+			// data holds the dirty blocks back to back, so offsets are worked out in block order before staging
+			blkData := data[blockOffset : (blk.EndIndex-blk.StartIndex)+blockOffset]
+			workers <- struct{}{}
+			wg.Add(1)
+			go func(id string, offset int64, blkData []byte) {
+				defer func() {
+					<-workers
+					wg.Done()
+				}()
+
+				validation, _ := bb.transactionalMD5(bytes.NewReader(blkData))
+				_, err := blobClient.StageBlock(context.Background(),
+					id,
+					streaming.NopCloser(bytes.NewReader(blkData)),
+					&blockblob.StageBlockOptions{
+						CPKInfo:                 bb.blobCPKOpt,
+						TransactionalValidation: validation,
+					})
 
-			if err != nil {
-				log.Err("BlockBlob::stageAndCommitModifiedBlocks : Failed to stage to blob %s at block %v [%s]", name, blockOffset, err.Error())
-				return err
-			}
+				if err != nil {
+					log.Err("BlockBlob::stageAndCommitModifiedBlocks : Failed to stage to blob %s at block %v [%s]", name, offset, err.Error())
+					errLock.Lock()
+					if stageErr == nil {
+						stageErr = err
+					}
+					errLock.Unlock()
+				}
+			}(blk.Id, blockOffset, blkData)
 			blockOffset = (blk.EndIndex - blk.StartIndex) + blockOffset
 		}
 	}
+	wg.Wait()
+
+	if stageErr != nil {
+		return stageErr
+	}
+
+	expiry, mode := bb.immutabilityPolicy()
 	_, err := blobClient.CommitBlockList(context.Background(),
 		blockIDList,
 		&blockblob.CommitBlockListOptions{
 			HTTPHeaders: &blob.HTTPHeaders{
-				BlobContentType: to.Ptr(getContentType(name)),
+				BlobContentType: to.Ptr(bb.uploadContentType(name, "")),
 			},
-			Tier:    bb.Config.defaultTier,
-			CPKInfo: bb.blobCPKOpt,
+			Metadata:                     bb.withXAttrs(name, nil),
+			Tier:                         bb.Config.defaultTier,
+			CPKInfo:                      bb.blobCPKOpt,
+			ImmutabilityPolicyExpiryTime: expiry,
+			ImmutabilityPolicyMode:       mode,
 		})
 
 	if err != nil {
@@ -1492,54 +3584,127 @@ func (bb *BlockBlob) stageAndCommitModifiedBlocks(name string, data []byte, offs
 	return nil
 }
 
-func (bb *BlockBlob) StageAndCommit(name string, bol *common.BlockOffsetList) error {
+// StageAndCommit : Stage the dirty blocks in parallel and commit the block list
+func (bb *BlockBlob) StageAndCommit(name string, bol *common.BlockOffsetList, concurrency uint16) error {
 	// lock on the blob name so that no stage and commit race condition occur causing failure
 	blobMtx := bb.blockLocks.GetLock(name)
 	blobMtx.Lock()
 	defer blobMtx.Unlock()
-	blobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, name))
+
+	err := bb.checkUploadTier(name)
+	if err != nil {
+		return err
+	}
+
+	err = bb.checkWritableTier(name)
+	if err != nil {
+		return err
+	}
+
+	blobClient := bb.containerClient().NewBlockBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+
+	// Nothing changed since the last flush
+	if !slices.ContainsFunc(bol.BlockList, func(blk *common.Block) bool { return blk.Dirty() || blk.Removed() }) {
+		return nil
+	}
+
+	metadata := bb.withXAttrs(name, nil)
+
+	// Small file has no committed blocks, so a single new block is uploaded with one Put Blob
+	// instead of staging it and committing the block list
+	if bol.SmallFile() && len(bol.BlockList) == 1 && bol.BlockList[0].Dirty() {
+		return bb.flushErr(name, bb.putSmallFile(blobClient, name, bol.BlockList[0], metadata))
+	}
+
 	var blockIDList []string
-	var data []byte
 	staged := false
+
+	var wg sync.WaitGroup
+	var stageErr error
+	var errLock sync.Mutex
+	workers := make(chan struct{}, max(bb.getConcurrency(concurrency), 1))
+
 	for _, blk := range bol.BlockList {
 		blockIDList = append(blockIDList, blk.Id)
-		if blk.Truncated() {
-			data = make([]byte, blk.EndIndex-blk.StartIndex)
-			blk.Flags.Clear(common.TruncatedBlock)
-		} else {
-			data = blk.Data
-		}
-		if blk.Dirty() {
-			_, err := blobClient.StageBlock(context.Background(),
-				blk.Id,
-				streaming.NopCloser(bytes.NewReader(data)),
-				&blockblob.StageBlockOptions{
-					CPKInfo: bb.blobCPKOpt,
-				})
-			if err != nil {
-				log.Err("BlockBlob::StageAndCommit : Failed to stage to blob %s with ID %s at block %v [%s]", name, blk.Id, blk.StartIndex, err.Error())
-				return err
+		// Small file has no committed blocks, a clean block of it was uploaded with Put Blob and has to be staged too
+		if blk.Dirty() || bol.SmallFile() {
+			// Truncated block reads as zeros, its buffer comes from the pool and goes back once staged
+			data := blk.Data
+			pooled := blk.Truncated()
+			if pooled {
+				data = bb.buffers.get(blk.EndIndex - blk.StartIndex)
+				clear(data)
 			}
+
 			staged = true
-			blk.Flags.Clear(common.DirtyBlock)
+			workers <- struct{}{}
+			wg.Add(1)
+			go func(blk *common.Block, data []byte, pooled bool) {
+				defer func() {
+					if pooled {
+						bb.buffers.put(data)
+					}
+					<-workers
+					wg.Done()
+				}()
+
+				validation, _ := bb.transactionalMD5(bytes.NewReader(data))
+				_, err := blobClient.StageBlock(context.Background(),
+					blk.Id,
+					streaming.NopCloser(bytes.NewReader(data)),
+					&blockblob.StageBlockOptions{
+						CPKInfo:                 bb.blobCPKOpt,
+						TransactionalValidation: validation,
+					})
+				if err != nil {
+					log.Err("BlockBlob::StageAndCommit : Failed to stage to blob %s with ID %s at block %v [%s]", name, blk.Id, blk.StartIndex, err.Error())
+					errLock.Lock()
+					if stageErr == nil {
+						stageErr = err
+					}
+					errLock.Unlock()
+					return
+				}
+				blk.Flags.Clear(common.TruncatedBlock)
+				blk.Flags.Clear(common.DirtyBlock)
+			}(blk, data, pooled)
 		} else if blk.Removed() {
 			staged = true
 		}
 	}
+	wg.Wait()
+
+	if stageErr != nil {
+		return archivedWriteErr(name, stageErr)
+	}
+
 	if staged {
+		expiry, mode := bb.immutabilityPolicy()
 		_, err := blobClient.CommitBlockList(context.Background(),
 			blockIDList,
 			&blockblob.CommitBlockListOptions{
 				HTTPHeaders: &blob.HTTPHeaders{
-					BlobContentType: to.Ptr(getContentType(name)),
+					BlobContentType: to.Ptr(bb.uploadContentType(name, "")),
 				},
-				Tier:    bb.Config.defaultTier,
-				CPKInfo: bb.blobCPKOpt,
+				Metadata:                     metadata,
+				Tier:                         bb.Config.defaultTier,
+				CPKInfo:                      bb.blobCPKOpt,
+				AccessConditions:             bb.flushAccessConditions(),
+				ImmutabilityPolicyExpiryTime: expiry,
+				ImmutabilityPolicyMode:       mode,
 				// AccessConditions: &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: bol.Etag}},
 			})
 		if err != nil {
 			log.Err("BlockBlob::StageAndCommit : Failed to commit block list to blob %s [%s]", name, err.Error())
-			return err
+			return bb.flushErr(name, err)
+		}
+
+		// List now matches the committed blocks, so later flushes are based on it and not on what was pending
+		for _, blk := range bol.BlockList {
+			blk.Flags.Clear(common.RemovedBlocks)
+		}
+		if len(blockIDList) > 0 {
+			bol.Flags.Clear(common.SmallFile)
 		}
 		// update the etag
 		// bol.Etag = resp.ETag()
@@ -1547,10 +3712,76 @@ func (bb *BlockBlob) StageAndCommit(name string, bol *common.BlockOffsetList) er
 	return nil
 }
 
+// putSmallFile : Upload the only block of a small file as the whole blob.
+// Headers, metadata, tier and CPK match what the block list commit would have set.
+func (bb *BlockBlob) putSmallFile(blobClient *blockblob.Client, name string, blk *common.Block, metadata map[string]*string) error {
+	data := blk.Data
+	if blk.Truncated() {
+		data = bb.buffers.get(blk.EndIndex - blk.StartIndex)
+		clear(data)
+		defer bb.buffers.put(data)
+	}
+
+	validation, _ := bb.transactionalMD5(bytes.NewReader(data))
+	expiry, mode := bb.immutabilityPolicy()
+	_, err := blobClient.Upload(context.Background(),
+		streaming.NopCloser(bytes.NewReader(data)),
+		&blockblob.UploadOptions{
+			HTTPHeaders: &blob.HTTPHeaders{
+				BlobContentType: to.Ptr(bb.uploadContentType(name, "")),
+			},
+			Metadata:                     metadata,
+			Tier:                         bb.Config.defaultTier,
+			CPKInfo:                      bb.blobCPKOpt,
+			TransactionalValidation:      validation,
+			AccessConditions:             bb.flushAccessConditions(),
+			ImmutabilityPolicyExpiryTime: expiry,
+			ImmutabilityPolicyMode:       mode,
+		})
+	if err != nil {
+		if storeBlobErrToErr(err) == MD5Mismatch {
+			log.Err("BlockBlob::StageAndCommit : Data of small file %s got corrupted during upload [%s]", name, err.Error())
+			return syscall.EIO
+		}
+		log.Err("BlockBlob::StageAndCommit : Failed to upload small file %s [%s]", name, err.Error())
+		return err
+	}
+
+	blk.Flags.Clear(common.TruncatedBlock)
+	blk.Flags.Clear(common.DirtyBlock)
+	return nil
+}
+
+// flushAccessConditions : In strict flush mode the upload only succeeds if the blob still exists,
+// otherwise a blob deleted by another client is recreated with only the data of this handle
+func (bb *BlockBlob) flushAccessConditions() *blob.AccessConditions {
+	if !bb.Config.strictFlush {
+		return nil
+	}
+	return &blob.AccessConditions{
+		ModifiedAccessConditions: &blob.ModifiedAccessConditions{
+			IfMatch: to.Ptr(azcore.ETagAny),
+		},
+	}
+}
+
+// flushErr : Failed existence condition of a strict flush means the blob was deleted, archived blob can not be flushed to
+func (bb *BlockBlob) flushErr(name string, err error) error {
+	if err != nil && bb.Config.strictFlush && (bloberror.HasCode(err, bloberror.ConditionNotMet) || storeBlobErrToErr(err) == ErrFileNotFound) {
+		log.Err("BlockBlob::StageAndCommit : %s was deleted before it was flushed", name)
+		return syscall.ENOENT
+	}
+	return archivedWriteErr(name, err)
+}
+
 // ChangeMod : Change mode of a blob
-func (bb *BlockBlob) ChangeMod(name string, _ os.FileMode) error {
+func (bb *BlockBlob) ChangeMod(name string, mode os.FileMode) error {
 	log.Trace("BlockBlob::ChangeMod : name %s", name)
 
+	if bb.Config.storeUnixPermissions {
+		return bb.storeUnixMode(name, mode)
+	}
+
 	if bb.Config.ignoreAccessModifiers {
 		// for operations like git clone where transaction fails if chmod is not successful
 		// return success instead of ENOSYS
@@ -1561,6 +3792,151 @@ func (bb *BlockBlob) ChangeMod(name string, _ os.FileMode) error {
 	return syscall.ENOTSUP
 }
 
+// storeUnixMode : Save the mode in metadata of the blob, keeping rest of its metadata as is
+func (bb *BlockBlob) storeUnixMode(name string, mode os.FileMode) error {
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	prop, err := blobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
+		CPKInfo: bb.blobCPKOpt,
+	})
+	if err != nil {
+		log.Err("BlockBlob::storeUnixMode : Failed to get properties of %s [%s]", name, err.Error())
+		if storeBlobErrToErr(err) == ErrFileNotFound {
+			return syscall.ENOENT
+		}
+		return err
+	}
+
+	metadata := make(map[string]*string, len(prop.Metadata)+1)
+	for k, v := range prop.Metadata {
+		if strings.ToLower(k) != unixModeKey {
+			metadata[k] = v
+		}
+	}
+	metadata[unixModeKey] = to.Ptr(fmt.Sprintf("%04o", mode.Perm()))
+
+	_, err = blobClient.SetMetadata(context.Background(), metadata, &blob.SetMetadataOptions{
+		CPKInfo: bb.blobCPKOpt,
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: prop.ETag},
+		},
+	})
+	if err != nil {
+		log.Err("BlockBlob::storeUnixMode : Failed to set mode of %s to %s [%s]", name, mode, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// SetMetadataKey : Set a single metadata key of a blob keeping the rest of its metadata, nil value removes the key
+func (bb *BlockBlob) SetMetadataKey(name string, key string, value *string) error {
+	log.Trace("BlockBlob::SetMetadataKey : name %s, key %s", name, key)
+
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	prop, err := blobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
+		CPKInfo: bb.blobCPKOpt,
+	})
+	if err != nil {
+		log.Err("BlockBlob::SetMetadataKey : Failed to get properties of %s [%s]", name, err.Error())
+		if storeBlobErrToErr(err) == ErrFileNotFound {
+			return syscall.ENOENT
+		}
+		return err
+	}
+
+	metadata := make(map[string]*string, len(prop.Metadata)+1)
+	for k, v := range prop.Metadata {
+		if !strings.EqualFold(k, key) {
+			metadata[k] = v
+		}
+	}
+	if value != nil {
+		metadata[key] = value
+	}
+
+	// Conditional on the etag so a concurrent metadata update is not lost
+	_, err = blobClient.SetMetadata(context.Background(), metadata, &blob.SetMetadataOptions{
+		CPKInfo: bb.blobCPKOpt,
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: prop.ETag},
+		},
+	})
+	if err != nil {
+		log.Err("BlockBlob::SetMetadataKey : Failed to set %s of %s [%s]", key, name, err.Error())
+		if bloberror.HasCode(err, bloberror.ConditionNotMet) {
+			return syscall.EAGAIN
+		}
+		return err
+	}
+
+	return nil
+}
+
+// withXAttrs : Metadata for an upload replacing name, with the extended attributes the blob already has carried forward.
+// An upload replaces all metadata of a blob, while extended attributes are only ever set through SetMetadataKey.
+// Keys in the given metadata win over the existing ones.
+func (bb *BlockBlob) withXAttrs(name string, metadata map[string]*string) map[string]*string {
+	if len(bb.Config.xattrKeys) == 0 {
+		return metadata
+	}
+
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	prop, err := blobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
+		CPKInfo: bb.blobCPKOpt,
+	})
+	if err != nil {
+		if storeBlobErrToErr(err) != ErrFileNotFound {
+			log.Err("BlockBlob::withXAttrs : Failed to get extended attributes of %s, they are not kept [%s]", name, err.Error())
+		}
+		return metadata
+	}
+
+	merged := make(map[string]*string, len(metadata)+len(prop.Metadata))
+	given := make(map[string]bool, len(metadata))
+	for k, v := range metadata {
+		merged[k] = v
+		given[strings.ToLower(k)] = true
+	}
+	for k, v := range prop.Metadata {
+		key := strings.ToLower(k)
+		if strings.HasPrefix(key, xattrKeyPrefix) && !given[key] {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// GetMetadataKey : Value of a single metadata key of a blob, nil when the blob does not have the key
+func (bb *BlockBlob) GetMetadataKey(name string, key string) (*string, error) {
+	log.Trace("BlockBlob::GetMetadataKey : name %s, key %s", name, key)
+
+	blobClient := bb.containerClient().NewBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	prop, err := blobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
+		CPKInfo: bb.blobCPKOpt,
+	})
+	if err != nil {
+		log.Err("BlockBlob::GetMetadataKey : Failed to get properties of %s [%s]", name, err.Error())
+		if storeBlobErrToErr(err) == ErrFileNotFound {
+			return nil, syscall.ENOENT
+		}
+		return nil, err
+	}
+
+	// Keys come back in whatever case the http layer gives them
+	for k, v := range prop.Metadata {
+		if strings.EqualFold(k, key) {
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+// ChmodRecursive : Flat namespace has no permissions to change across a directory tree
+func (bb *BlockBlob) ChmodRecursive(name string, _ os.FileMode) (RecursiveACLResult, error) {
+	log.Trace("BlockBlob::ChmodRecursive : name %s", name)
+	return RecursiveACLResult{}, syscall.ENOTSUP
+}
+
 // ChangeOwner : Change owner of a blob
 func (bb *BlockBlob) ChangeOwner(name string, _ int, _ int) error {
 	log.Trace("BlockBlob::ChangeOwner : name %s", name)
@@ -1577,7 +3953,7 @@ func (bb *BlockBlob) ChangeOwner(name string, _ int, _ int) error {
 
 // GetCommittedBlockList : Get the list of committed blocks
 func (bb *BlockBlob) GetCommittedBlockList(name string) (*internal.CommittedBlockList, error) {
-	blobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	blobClient := bb.containerClient().NewBlockBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
 
 	storageBlockList, err := blobClient.GetBlockList(context.Background(), blockblob.BlockListTypeCommitted, nil)
 
@@ -1610,18 +3986,28 @@ func (bb *BlockBlob) GetCommittedBlockList(name string) (*internal.CommittedBloc
 func (bb *BlockBlob) StageBlock(name string, data []byte, id string) error {
 	log.Trace("BlockBlob::StageBlock : name %s, ID %v, length %v", name, id, len(data))
 
-	ctx, cancel := context.WithTimeout(context.Background(), max_context_timeout*time.Minute)
+	ctx, cancel := operationContext(bb.Config.writeTimeout, max_context_timeout*time.Minute)
 	defer cancel()
 
-	blobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	validation, _ := bb.transactionalMD5(bytes.NewReader(data))
+
+	blobClient := bb.containerClient().NewBlockBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
 	_, err := blobClient.StageBlock(ctx,
 		id,
 		streaming.NopCloser(bytes.NewReader(data)),
 		&blockblob.StageBlockOptions{
-			CPKInfo: bb.blobCPKOpt,
+			CPKInfo:                 bb.blobCPKOpt,
+			TransactionalValidation: validation,
 		})
 
 	if err != nil {
+		if storeBlobErrToErr(err) == MD5Mismatch {
+			log.Err("BlockBlob::StageBlock : Data of blob %s with ID %s got corrupted during upload [%s]", name, id, err.Error())
+			return syscall.EIO
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			log.Err("BlockBlob::StageBlock : Timed out staging to blob %s with ID %s [%s]", name, id, err.Error())
+			return syscall.ETIMEDOUT
+		}
 		log.Err("BlockBlob::StageBlock : Failed to stage to blob %s with ID %s [%s]", name, id, err.Error())
 		return err
 	}
@@ -1630,24 +4016,52 @@ func (bb *BlockBlob) StageBlock(name string, data []byte, id string) error {
 }
 
 // CommitBlocks : persists the block list
-func (bb *BlockBlob) CommitBlocks(name string, blockList []string, newEtag *string) error {
+// CommitBlocks : Commit the block list, with ifMatch set the commit fails with EBUSY if the blob has changed since
+func (bb *BlockBlob) CommitBlocks(name string, blockList []string, newEtag *string, tags map[string]string, ifMatch string, contentType string) error {
 	log.Trace("BlockBlob::CommitBlocks : name %s", name)
 
-	ctx, cancel := context.WithTimeout(context.Background(), max_context_timeout*time.Minute)
+	err := bb.checkUploadTier(name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := operationContext(bb.Config.writeTimeout, max_context_timeout*time.Minute)
 	defer cancel()
 
-	blobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, name))
-	resp, err := blobClient.CommitBlockList(ctx,
-		blockList,
-		&blockblob.CommitBlockListOptions{
-			HTTPHeaders: &blob.HTTPHeaders{
-				BlobContentType: to.Ptr(getContentType(name)),
+	expiry, mode := bb.immutabilityPolicy()
+	opts := &blockblob.CommitBlockListOptions{
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobContentType: to.Ptr(bb.uploadContentType(name, contentType)),
+		},
+		Metadata:                     bb.withXAttrs(name, nil),
+		Tier:                         bb.Config.defaultTier,
+		CPKInfo:                      bb.blobCPKOpt,
+		Tags:                         tags,
+		ImmutabilityPolicyExpiryTime: expiry,
+		ImmutabilityPolicyMode:       mode,
+	}
+	if ifMatch != "" {
+		opts.AccessConditions = &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{
+				IfMatch: to.Ptr(azcore.ETag(`"` + ifMatch + `"`)),
 			},
-			Tier:    bb.Config.defaultTier,
-			CPKInfo: bb.blobCPKOpt,
-		})
+		}
+	}
+
+	blobClient := bb.containerClient().NewBlockBlobClient(joinPrefixPath(bb.Config.prefixPath, name))
+	resp, err := blobClient.CommitBlockList(ctx, blockList, opts)
 
 	if err != nil {
+		if bloberror.HasCode(err, bloberror.ConditionNotMet) {
+			log.Err("BlockBlob::CommitBlocks : %s has been modified since etag %s, not overwriting it", name, ifMatch)
+			return syscall.EBUSY
+		} else if storeBlobErrToErr(err) == BlobImmutable {
+			log.Err("BlockBlob::CommitBlocks : %s is under a legal hold or a locked immutability policy [%s]", name, err.Error())
+			return syscall.EPERM
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			log.Err("BlockBlob::CommitBlocks : Timed out committing block list to blob %s [%s]", name, err.Error())
+			return syscall.ETIMEDOUT
+		}
 		log.Err("BlockBlob::CommitBlocks : Failed to commit block list to blob %s [%s]", name, err.Error())
 		return err
 	}
@@ -1660,6 +4074,7 @@ func (bb *BlockBlob) CommitBlocks(name string, blockList []string, newEtag *stri
 }
 
 func (bb *BlockBlob) SetFilter(filter string) error {
+	bb.Config.filterFingerprint = getFilterFingerprint(filter)
 	if filter == "" {
 		bb.Config.filter = nil
 		return nil
@@ -37,24 +37,41 @@
 package azstorage
 
 import (
+	"bufio"
 	"bytes"
 	"container/list"
 	"context"
+	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"hash/crc64"
 	"io"
 	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
@@ -371,7 +388,7 @@ func (s *blockBlobTestSuite) TestListContainers() {
 		defer c.Delete(ctx, nil)
 	}
 
-	containers, err := s.az.ListContainers()
+	containers, err := s.az.ListContainers("")
 
 	s.assert.Nil(err)
 	s.assert.NotNil(containers)
@@ -385,6 +402,25 @@ func (s *blockBlobTestSuite) TestListContainers() {
 	s.assert.EqualValues(num, count)
 }
 
+func (s *blockBlobTestSuite) TestListContainersPrefix() {
+	defer s.cleanupTest()
+	// Setup
+	num := 5
+	prefix := generateContainerName()
+	for i := 0; i < num; i++ {
+		c := s.serviceClient.NewContainerClient(prefix + fmt.Sprint(i))
+		c.Create(ctx, nil)
+		defer c.Delete(ctx, nil)
+	}
+
+	containers, err := s.az.ListContainers(prefix)
+	s.assert.Nil(err)
+	s.assert.Len(containers, num)
+	for _, c := range containers {
+		s.assert.True(strings.HasPrefix(c, prefix))
+	}
+}
+
 // TODO : ListContainersHuge: Maybe this is overkill?
 
 func checkMetadata(metadata map[string]*string, key string, val string) bool {
@@ -417,6 +453,41 @@ func (s *blockBlobTestSuite) TestCreateDir() {
 	}
 }
 
+func (s *blockBlobTestSuite) TestCreateDirConcurrent() {
+	defer s.cleanupTest()
+	name := generateDirectoryName()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.az.CreateDir(internal.CreateDirOptions{Name: name})
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		s.assert.Nil(err)
+	}
+	dir := s.containerClient.NewBlobClient(name)
+	props, err := dir.GetProperties(ctx, nil)
+	s.assert.Nil(err)
+	s.assert.True(checkMetadata(props.Metadata, folderKey, "true"))
+}
+
+func (s *blockBlobTestSuite) TestCreateDirOverFile() {
+	defer s.cleanupTest()
+	name := generateFileName()
+	_, err := s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	s.assert.Nil(err)
+
+	err = s.az.CreateDir(internal.CreateDirOptions{Name: name})
+	s.assert.NotNil(err)
+	s.assert.EqualValues(syscall.EEXIST, err)
+}
+
 func (s *blockBlobTestSuite) TestDeleteDir() {
 	defer s.cleanupTest()
 	// Testing dir and dir/
@@ -617,6 +688,61 @@ func (s *blockBlobTestSuite) TestReadDirNoVirtualDirectory() {
 	}
 }
 
+func (s *blockBlobTestSuite) TestReadDirWithSnapshots() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateDirectoryName()
+	s.az.CreateDir(internal.CreateDirOptions{Name: name})
+	childName := name + "/" + generateFileName()
+	h, _ := s.az.CreateFile(internal.CreateFileOptions{Name: childName})
+	s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: []byte("test data")})
+	for i := 0; i < 2; i++ {
+		_, err := s.containerClient.NewBlobClient(childName).CreateSnapshot(ctx, nil)
+		s.assert.Nil(err)
+	}
+
+	// Even when the service returns the snapshots, the blob is reported only once
+	bb := s.az.storage.(*BlockBlob)
+	bb.listDetails.Snapshots = true
+	defer func() { bb.listDetails.Snapshots = false }()
+
+	entries, err := s.az.ReadDir(internal.ReadDirOptions{Name: name})
+	s.assert.Nil(err)
+	s.assert.EqualValues(1, len(entries))
+	s.assert.EqualValues(childName, entries[0].Path)
+
+	snapshots, err := bb.ListSnapshots(childName)
+	s.assert.Nil(err)
+	s.assert.Len(snapshots, 2)
+}
+
+func (s *blockBlobTestSuite) TestCreateListDeleteSnapshot() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	h, _ := s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: []byte("test data")})
+	bb := s.az.storage.(*BlockBlob)
+
+	first, err := bb.CreateSnapshot(name)
+	s.assert.Nil(err)
+	second, err := bb.CreateSnapshot(name)
+	s.assert.Nil(err)
+	s.assert.NotEqual(first, second)
+
+	snapshots, err := bb.ListSnapshots(name)
+	s.assert.Nil(err)
+	s.assert.Len(snapshots, 2)
+	s.assert.ElementsMatch([]string{first, second}, []string{snapshots[0].ID, snapshots[1].ID})
+
+	err = bb.DeleteSnapshot(name, first)
+	s.assert.Nil(err)
+	snapshots, err = bb.ListSnapshots(name)
+	s.assert.Nil(err)
+	s.assert.Len(snapshots, 1)
+	s.assert.Equal(second, snapshots[0].ID)
+}
+
 func (s *blockBlobTestSuite) TestReadDirHierarchy() {
 	defer s.cleanupTest()
 	// Setup
@@ -744,6 +870,27 @@ func (s *blockBlobTestSuite) TestReadDirListBlocked() {
 	s.assert.EqualValues(0, len(entries)) // Since we block the list, it will return an empty list.
 }
 
+func (s *blockBlobTestSuite) TestReadDirSkipsOwnMarker() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateDirectoryName()
+	// Marker blob with trailing slash as created by some tools
+	_, err := s.containerClient.NewBlockBlobClient(name+"/").UploadBuffer(ctx, []byte{}, nil)
+	s.assert.Nil(err)
+	s.az.CreateFile(internal.CreateFileOptions{Name: name + "/file"})
+
+	entries, err := s.az.ReadDir(internal.ReadDirOptions{Name: name})
+	s.assert.Nil(err)
+	s.assert.EqualValues(1, len(entries))
+	s.assert.EqualValues("file", entries[0].Name)
+
+	// Raw view returns the marker as well
+	s.az.storage.(*BlockBlob).Config.listDirMarker = true
+	entries, err = s.az.ReadDir(internal.ReadDirOptions{Name: name})
+	s.assert.Nil(err)
+	s.assert.EqualValues(2, len(entries))
+}
+
 func (s *blockBlobTestSuite) TestStreamDirSmallCountNoDuplicates() {
 	defer s.cleanupTest()
 	// Setup
@@ -961,6 +1108,25 @@ func (s *blockBlobTestSuite) TestCreateFile() {
 	s.assert.Empty(props.Metadata)
 }
 
+func (s *blockBlobTestSuite) TestCreateImmutable() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	data := []byte("write once")
+
+	err := s.az.CreateImmutable(name, data, false, nil)
+	s.assert.Nil(err)
+
+	// Second attempt should not overwrite the blob
+	err = s.az.CreateImmutable(name, []byte("overwrite"), false, nil)
+	s.assert.NotNil(err)
+	s.assert.EqualValues(syscall.EEXIST, err)
+
+	output, err := s.az.ReadFile(internal.ReadFileOptions{Handle: handlemap.NewHandle(name)})
+	s.assert.Nil(err)
+	s.assert.EqualValues(data, output)
+}
+
 func (s *blockBlobTestSuite) TestOpenFile() {
 	defer s.cleanupTest()
 	// Setup
@@ -1052,6 +1218,38 @@ func (s *blockBlobTestSuite) TestDeleteFileError() {
 	s.assert.NotNil(err)
 }
 
+func (s *blockBlobTestSuite) TestPercentSignNameRoundTrip() {
+	defer s.cleanupTest()
+	// Names are passed to the service as is, a literal percent sign or something that looks
+	// like an escape sequence shall come back unchanged
+	dir := generateDirectoryName()
+	s.az.CreateDir(internal.CreateDirOptions{Name: dir})
+	src := dir + "/50%off" + randomString(4) + ".txt"
+	dst := dir + "/100%25" + randomString(4) + ".txt"
+
+	h, err := s.az.CreateFile(internal.CreateFileOptions{Name: src})
+	s.assert.Nil(err)
+	s.assert.NotNil(h)
+
+	attr, err := s.az.GetAttr(internal.GetAttrOptions{Name: src})
+	s.assert.Nil(err)
+	s.assert.Equal(src, attr.Path)
+
+	entries, err := s.az.ReadDir(internal.ReadDirOptions{Name: dir})
+	s.assert.Nil(err)
+	s.assert.Len(entries, 1)
+	s.assert.Equal(src, entries[0].Path)
+
+	err = s.az.RenameFile(internal.RenameFileOptions{Src: src, Dst: dst})
+	s.assert.Nil(err)
+
+	_, err = s.az.GetAttr(internal.GetAttrOptions{Name: src})
+	s.assert.Equal(syscall.ENOENT, err)
+	attr, err = s.az.GetAttr(internal.GetAttrOptions{Name: dst})
+	s.assert.Nil(err)
+	s.assert.Equal(dst, attr.Path)
+}
+
 func (s *blockBlobTestSuite) TestRenameFile() {
 	defer s.cleanupTest()
 	// Setup
@@ -1239,7 +1437,7 @@ func (bbTestSuite *blockBlobTestSuite) TestReadInBufferWithETAGMismatch() {
 	bbTestSuite.assert.Equal(etag, attr.ETag)
 
 	// Update the file in parallel using another handle
-	handle1, err := bbTestSuite.az.OpenFile(internal.OpenFileOptions{Name: name})
+	handle1, err := bbTestSuite.az.OpenFile(internal.OpenFileOptions{Name: name, Flags: os.O_RDWR})
 	bbTestSuite.assert.Nil(err)
 	testData = "test data 12345678910 123123123123123123123"
 	data = []byte(testData)
@@ -1484,6 +1682,69 @@ func (s *blockBlobTestSuite) TestTruncateSmallFileBigger() {
 	s.assert.EqualValues(testData, output[:len(data)])
 }
 
+func (s *blockBlobTestSuite) TestReadInBufferAfterSparseGrow() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	h, _ := s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	testData := "test data"
+	data := []byte(testData)
+	s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: data})
+
+	// Grow the file only in size, blob still holds just the original data
+	h, _ = s.az.OpenFile(internal.OpenFileOptions{Name: name})
+	h.Size = 30
+
+	output := make([]byte, 20)
+	for i := range output {
+		output[i] = 0xff
+	}
+	length, err := s.az.ReadInBuffer(internal.ReadInBufferOptions{Handle: h, Offset: 0, Data: output})
+	s.assert.Nil(err)
+	s.assert.EqualValues(20, length)
+	s.assert.EqualValues(testData, output[:len(data)])
+	s.assert.EqualValues(make([]byte, 20-len(data)), output[len(data):])
+
+	// Read completely beyond the end of blob
+	for i := range output {
+		output[i] = 0xff
+	}
+	length, err = s.az.ReadInBuffer(internal.ReadInBufferOptions{Handle: h, Offset: 15, Data: output[:10]})
+	s.assert.Nil(err)
+	s.assert.EqualValues(10, length)
+	s.assert.EqualValues(make([]byte, 10), output[:10])
+}
+
+func (s *blockBlobTestSuite) TestWriteFileArchiveTier() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	h, _ := s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: []byte("test data")})
+
+	blobClient := s.containerClient.NewBlockBlobClient(name)
+	_, err := blobClient.SetTier(ctx, blob.AccessTierArchive, nil)
+	s.assert.Nil(err)
+	s.az.storage.(*BlockBlob).Config.checkArchiveTier = true
+
+	_, err = s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: []byte("new data")})
+	s.assert.NotNil(err)
+	s.assert.EqualValues(syscall.EPERM, err)
+
+	bol := &common.BlockOffsetList{
+		BlockList: []*common.Block{{StartIndex: 0, EndIndex: 8, Data: []byte("new data")}},
+	}
+	bol.BlockList[0].Flags.Set(common.DirtyBlock)
+	err = s.az.storage.StageAndCommit(name, bol, 0)
+	s.assert.NotNil(err)
+	s.assert.EqualValues(syscall.EPERM, err)
+
+	// Nothing should have been staged for the blob
+	blockList, err := blobClient.GetBlockList(ctx, blockblob.BlockListTypeUncommitted, nil)
+	s.assert.Nil(err)
+	s.assert.Empty(blockList.UncommittedBlocks)
+}
+
 func (s *blockBlobTestSuite) TestTruncateChunkedFileBigger() {
 	defer s.cleanupTest()
 	// Setup
@@ -1740,6 +2001,50 @@ func (s *blockBlobTestSuite) TestOverwriteBlocks() {
 	f.Close()
 }
 
+func (s *blockBlobTestSuite) TestOverwriteStraddlingBlocks() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	h, _ := s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	testData := "testdatates1dat1tes2dat2tes3dat3tes4dat4"
+	data := []byte(testData)
+
+	// use our method to make the max upload size (size before a blob is broken down to blocks) to 4 Bytes
+	err := uploadReaderAtToBlockBlob(ctx, bytes.NewReader(data), int64(len(data)), 4, s.containerClient.NewBlockBlobClient(name), &blockblob.UploadBufferOptions{
+		BlockSize: 4,
+	})
+	s.assert.Nil(err)
+
+	// write of 4 bytes at offset 14 straddles the blocks [12,16) and [16,20) only
+	offsets, err := s.az.storage.GetFileBlockOffsets(name)
+	s.assert.Nil(err)
+	index, size, exceeds, appendOnly := offsets.FindBlocksToModify(14, 4)
+	s.assert.Equal(3, index)
+	s.assert.EqualValues(8, size)
+	s.assert.False(exceeds)
+	s.assert.False(appendOnly)
+
+	f, _ := os.CreateTemp("", name+".tmp")
+	defer os.Remove(f.Name())
+	newTestData := []byte("cake")
+	_, err = s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 14, Data: newTestData})
+	s.assert.Nil(err)
+
+	currentData := []byte("testdatates1dacakes2dat2tes3dat3tes4dat4")
+	dataLen := len(currentData)
+	output := make([]byte, dataLen)
+
+	err = s.az.CopyToFile(internal.CopyToFileOptions{Name: name, File: f})
+	s.assert.Nil(err)
+
+	f, _ = os.Open(f.Name())
+	len, err := f.Read(output)
+	s.assert.Nil(err)
+	s.assert.EqualValues(dataLen, len)
+	s.assert.EqualValues(currentData, output)
+	f.Close()
+}
+
 func (s *blockBlobTestSuite) TestOverwriteAndAppendBlocks() {
 	defer s.cleanupTest()
 	// Setup
@@ -1876,6 +2181,38 @@ func (s *blockBlobTestSuite) TestCopyFromFile() {
 	s.assert.EqualValues(testData, output)
 }
 
+func (s *blockBlobTestSuite) TestCopyFromFileTruncatedSinceOpen() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	homeDir, _ := os.UserHomeDir()
+	f, _ := os.CreateTemp(homeDir, name+".tmp")
+	defer os.Remove(f.Name())
+	f.Write([]byte("test data which will be truncated"))
+
+	for _, size := range []int64{9, 0} {
+		s.Run(strconv.FormatInt(size, 10), func() {
+			// Local file shrinks after it was opened and written to
+			err := f.Truncate(size)
+			s.assert.Nil(err)
+
+			err = s.az.CopyFromFile(internal.CopyFromFileOptions{Name: name, File: f})
+			s.assert.Nil(err)
+
+			// Blob should match the current local content
+			props, err := s.containerClient.NewBlobClient(name).GetProperties(ctx, nil)
+			s.assert.Nil(err)
+			s.assert.EqualValues(size, *props.ContentLength)
+
+			resp, err := s.containerClient.NewBlobClient(name).DownloadStream(ctx, nil)
+			s.assert.Nil(err)
+			output, _ := io.ReadAll(resp.Body)
+			s.assert.EqualValues("test data"[:size], string(output))
+		})
+	}
+}
+
 func (s *blockBlobTestSuite) TestCreateLink() {
 	defer s.cleanupTest()
 	// Setup
@@ -2033,6 +2370,35 @@ func (s *blockBlobTestSuite) TestGetAttrDirWithCPKEnabled() {
 	s.assert.True(checkMetadata(props.Metadata, folderKey, "true"))
 }
 
+func (s *blockBlobTestSuite) TestGetAttrWithoutCPK() {
+	defer s.cleanupTest()
+	CPKEncryptionKey, CPKEncryptionKeySHA256 := generateCPKInfo()
+	cpkConfig := fmt.Sprintf("azstorage:\n  account-name: %s\n  endpoint: https://%s.blob.core.windows.net/\n  type: block\n  cpk-enabled: true\n  cpk-encryption-key: %s\n  cpk-encryption-key-sha256: %s\n  account-key: %s\n  mode: key\n  container: %s\n",
+		storageTestConfigurationParameters.BlockAccount, storageTestConfigurationParameters.BlockAccount, CPKEncryptionKey, CPKEncryptionKeySHA256, storageTestConfigurationParameters.BlockKey, s.container)
+
+	s.tearDownTestHelper(false)
+	s.setupTestHelper(cpkConfig, s.container, false)
+
+	dirName := generateDirectoryName()
+	s.az.CreateDir(internal.CreateDirOptions{Name: dirName})
+	fileName := generateFileName()
+	s.az.CreateFile(internal.CreateFileOptions{Name: fileName})
+
+	// Mount with the key stats its own objects
+	for _, name := range []string{dirName, fileName} {
+		_, err := s.az.GetAttr(internal.GetAttrOptions{Name: name})
+		s.assert.Nil(err)
+	}
+
+	// Mount without the key gets a clear error instead of a generic failure
+	s.tearDownTestHelper(false)
+	s.setupTestHelper("", s.container, false)
+	for _, name := range []string{dirName, fileName} {
+		_, err := s.az.GetAttr(internal.GetAttrOptions{Name: name})
+		s.assert.Equal(syscall.EACCES, err)
+	}
+}
+
 func (s *blockBlobTestSuite) TestGetAttrFile() {
 	defer s.cleanupTest()
 	vdConfig := fmt.Sprintf("azstorage:\n  account-name: %s\n  endpoint: https://%s.blob.core.windows.net/\n  type: block\n  account-key: %s\n  mode: key\n  container: %s\n  fail-unsupported-op: true\n  virtual-directory: true",
@@ -2090,7 +2456,52 @@ func (s *blockBlobTestSuite) TestGetAttrLink() {
 	}
 }
 
-func (s *blockBlobTestSuite) TestGetAttrFileSize() {
+func (s *blockBlobTestSuite) TestGetAttrFileWithTrailingSlash() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	dirName := generateDirectoryName()
+	s.az.CreateDir(internal.CreateDirOptions{Name: dirName})
+
+	_, err := s.az.GetAttr(internal.GetAttrOptions{Name: name})
+	s.assert.Nil(err)
+
+	_, err = s.az.GetAttr(internal.GetAttrOptions{Name: name + "/"})
+	s.assert.NotNil(err)
+	s.assert.EqualValues(syscall.ENOTDIR, err)
+
+	attr, err := s.az.GetAttr(internal.GetAttrOptions{Name: dirName + "/"})
+	s.assert.Nil(err)
+	s.assert.True(attr.IsDir())
+}
+
+func (s *blockBlobTestSuite) TestGetAttrIfNoneMatch() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	h, _ := s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: []byte("test data")})
+
+	attr, err := s.az.GetAttr(internal.GetAttrOptions{Name: name})
+	s.assert.Nil(err)
+	s.assert.NotEmpty(attr.ETag)
+
+	// Unchanged blob
+	_, err = s.az.GetAttr(internal.GetAttrOptions{Name: name, IfNoneMatch: attr.ETag})
+	s.assert.Equal(internal.ErrNotModified, err)
+
+	// Blob modified by another client
+	_, err = s.containerClient.NewBlockBlobClient(name).UploadBuffer(ctx, []byte("new test data"), nil)
+	s.assert.Nil(err)
+
+	changed, err := s.az.GetAttr(internal.GetAttrOptions{Name: name, IfNoneMatch: attr.ETag})
+	s.assert.Nil(err)
+	s.assert.NotEqual(attr.ETag, changed.ETag)
+	s.assert.EqualValues(len("new test data"), changed.Size)
+}
+
+func (s *blockBlobTestSuite) TestGetAttrLinkSize() {
 	defer s.cleanupTest()
 	vdConfig := fmt.Sprintf("azstorage:\n  account-name: %s\n  endpoint: https://%s.blob.core.windows.net/\n  type: block\n  account-key: %s\n  mode: key\n  container: %s\n  fail-unsupported-op: true\n  virtual-directory: true",
 		storageTestConfigurationParameters.BlockAccount, storageTestConfigurationParameters.BlockAccount, storageTestConfigurationParameters.BlockKey, s.container)
@@ -2105,23 +2516,36 @@ func (s *blockBlobTestSuite) TestGetAttrFileSize() {
 		}
 		s.Run(testName, func() {
 			// Setup
-			name := generateFileName()
-			h, _ := s.az.CreateFile(internal.CreateFileOptions{Name: name})
-			testData := "test data"
-			data := []byte(testData)
+			target := generateFileName()
+			h, _ := s.az.CreateFile(internal.CreateFileOptions{Name: target})
+			data := []byte("symlink target has more data than its name")
 			s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: data})
+			name := generateFileName()
+			s.az.CreateLink(internal.CreateLinkOptions{Name: name, Target: target})
 
 			props, err := s.az.GetAttr(internal.GetAttrOptions{Name: name})
 			s.assert.Nil(err)
 			s.assert.NotNil(props)
-			s.assert.False(props.IsDir())
-			s.assert.False(props.IsSymlink())
-			s.assert.EqualValues(len(testData), props.Size)
+			s.assert.True(props.IsSymlink())
+			s.assert.EqualValues(len(target), props.Size)
+
+			// Size reported through listing should match as well
+			entries, err := s.az.ReadDir(internal.ReadDirOptions{Name: ""})
+			s.assert.Nil(err)
+			found := false
+			for _, e := range entries {
+				if e.Path == name {
+					found = true
+					s.assert.True(e.IsSymlink())
+					s.assert.EqualValues(len(target), e.Size)
+				}
+			}
+			s.assert.True(found)
 		})
 	}
 }
 
-func (s *blockBlobTestSuite) TestGetAttrFileTime() {
+func (s *blockBlobTestSuite) TestGetAttrFileSize() {
 	defer s.cleanupTest()
 	vdConfig := fmt.Sprintf("azstorage:\n  account-name: %s\n  endpoint: https://%s.blob.core.windows.net/\n  type: block\n  account-key: %s\n  mode: key\n  container: %s\n  fail-unsupported-op: true\n  virtual-directory: true",
 		storageTestConfigurationParameters.BlockAccount, storageTestConfigurationParameters.BlockAccount, storageTestConfigurationParameters.BlockKey, s.container)
@@ -2142,11 +2566,42 @@ func (s *blockBlobTestSuite) TestGetAttrFileTime() {
 			data := []byte(testData)
 			s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: data})
 
-			before, err := s.az.GetAttr(internal.GetAttrOptions{Name: name})
+			props, err := s.az.GetAttr(internal.GetAttrOptions{Name: name})
 			s.assert.Nil(err)
-			s.assert.NotNil(before.Mtime)
+			s.assert.NotNil(props)
+			s.assert.False(props.IsDir())
+			s.assert.False(props.IsSymlink())
+			s.assert.EqualValues(len(testData), props.Size)
+		})
+	}
+}
 
-			time.Sleep(time.Second * 3) // Wait 3 seconds and then modify the file again
+func (s *blockBlobTestSuite) TestGetAttrFileTime() {
+	defer s.cleanupTest()
+	vdConfig := fmt.Sprintf("azstorage:\n  account-name: %s\n  endpoint: https://%s.blob.core.windows.net/\n  type: block\n  account-key: %s\n  mode: key\n  container: %s\n  fail-unsupported-op: true\n  virtual-directory: true",
+		storageTestConfigurationParameters.BlockAccount, storageTestConfigurationParameters.BlockAccount, storageTestConfigurationParameters.BlockKey, s.container)
+	configs := []string{"", vdConfig}
+	for _, c := range configs {
+		// This is a little janky but required since testify suite does not support running setup or clean up for subtests.
+		s.tearDownTestHelper(false)
+		s.setupTestHelper(c, s.container, true)
+		testName := ""
+		if c != "" {
+			testName = "virtual-directory"
+		}
+		s.Run(testName, func() {
+			// Setup
+			name := generateFileName()
+			h, _ := s.az.CreateFile(internal.CreateFileOptions{Name: name})
+			testData := "test data"
+			data := []byte(testData)
+			s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: data})
+
+			before, err := s.az.GetAttr(internal.GetAttrOptions{Name: name})
+			s.assert.Nil(err)
+			s.assert.NotNil(before.Mtime)
+
+			time.Sleep(time.Second * 3) // Wait 3 seconds and then modify the file again
 
 			s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: data})
 
@@ -2195,6 +2650,38 @@ func (s *blockBlobTestSuite) TestChmod() {
 	s.assert.EqualValues(syscall.ENOTSUP, err)
 }
 
+func (s *blockBlobTestSuite) TestChmodRecursive() {
+	defer s.cleanupTest()
+	// Setup
+	base := generateDirectoryName()
+	s.setupHierarchy(base)
+
+	_, err := s.az.ChmodRecursive(base, 0750)
+	s.assert.NotNil(err)
+	s.assert.EqualValues(syscall.ENOTSUP, err)
+}
+
+func (s *blockBlobTestSuite) TestSetTier() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	h, _ := s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	testData := "test data"
+	data := []byte(testData)
+	s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: data})
+	s.az.FlushFile(internal.FlushFileOptions{Handle: h})
+
+	err := s.az.SetTier(name, blob.AccessTierCool)
+	s.assert.Nil(err)
+
+	props, err := s.containerClient.NewBlobClient(name).GetProperties(ctx, nil)
+	s.assert.Nil(err)
+	s.assert.EqualValues(blob.AccessTierCool, *props.AccessTier)
+
+	err = s.az.SetTier(generateFileName(), blob.AccessTierCool)
+	s.assert.EqualValues(syscall.ENOENT, err)
+}
+
 func (s *blockBlobTestSuite) TestChmodIgnore() {
 	defer s.cleanupTest()
 	// Setup
@@ -2364,6 +2851,73 @@ func (s *blockBlobTestSuite) TestGetFileBlockOffsetsChunkedFile() {
 	s.assert.EqualValues(16, offsetList.BlockIdLength)
 }
 
+func (s *blockBlobTestSuite) TestCommitDataWithTags() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	data := []byte("123123")
+
+	id := base64.StdEncoding.EncodeToString(common.NewUUIDWithLength(16))
+	err := s.az.StageData(internal.StageDataOptions{
+		Name:   name,
+		Id:     id,
+		Data:   data,
+		Offset: 0,
+	})
+	s.assert.Nil(err)
+
+	err = s.az.CommitData(internal.CommitDataOptions{
+		Name:      name,
+		List:      []string{id},
+		BlockSize: 1,
+		Tags:      map[string]string{"project": "blobfuse"},
+	})
+	s.assert.Nil(err)
+
+	resp, err := s.containerClient.NewBlobClient(name).GetTags(ctx, nil)
+	s.assert.Nil(err)
+	s.assert.Len(resp.BlobTagSet, 1)
+	s.assert.EqualValues("project", *resp.BlobTagSet[0].Key)
+	s.assert.EqualValues("blobfuse", *resp.BlobTagSet[0].Value)
+}
+
+func (s *blockBlobTestSuite) TestCopyFromFileWithTags() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	f, err := os.CreateTemp("", name+".tmp")
+	s.assert.Nil(err)
+	defer os.Remove(f.Name())
+	_, err = f.Write([]byte("test data"))
+	s.assert.Nil(err)
+
+	err = s.az.CopyFromFile(internal.CopyFromFileOptions{Name: name, File: f, Tags: map[string]string{"stage": "raw"}})
+	s.assert.Nil(err)
+
+	resp, err := s.containerClient.NewBlobClient(name).GetTags(ctx, nil)
+	s.assert.Nil(err)
+	s.assert.Len(resp.BlobTagSet, 1)
+	s.assert.EqualValues("stage", *resp.BlobTagSet[0].Key)
+	s.assert.EqualValues("raw", *resp.BlobTagSet[0].Value)
+}
+
+func (s *blockBlobTestSuite) TestGetFileBlockOffsetsAppendBlob() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	appendClient := s.containerClient.NewAppendBlobClient(name)
+	_, err := appendClient.Create(ctx, nil)
+	s.assert.Nil(err)
+	_, err = appendClient.AppendBlock(ctx, streaming.NopCloser(bytes.NewReader([]byte("testdata"))), nil)
+	s.assert.Nil(err)
+
+	// GetFileBlockOffsets
+	offsetList, err := s.az.GetFileBlockOffsets(internal.GetFileBlockOffsetsOptions{Name: name})
+	s.assert.NotNil(err)
+	s.assert.EqualValues(syscall.ENOTSUP, err)
+	s.assert.Len(offsetList.BlockList, 0)
+}
+
 func (s *blockBlobTestSuite) TestGetFileBlockOffsetsError() {
 	defer s.cleanupTest()
 	// Setup
@@ -2372,6 +2926,20 @@ func (s *blockBlobTestSuite) TestGetFileBlockOffsetsError() {
 	// GetFileBlockOffsets
 	_, err := s.az.GetFileBlockOffsets(internal.GetFileBlockOffsetsOptions{Name: name})
 	s.assert.NotNil(err)
+	s.assert.EqualValues(syscall.ENOENT, err)
+}
+
+func (s *blockBlobTestSuite) TestGetFileBlockOffsetsEmptyFile() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	s.az.CreateFile(internal.CreateFileOptions{Name: name})
+
+	// GetFileBlockOffsets
+	offsetList, err := s.az.GetFileBlockOffsets(internal.GetFileBlockOffsetsOptions{Name: name})
+	s.assert.Nil(err)
+	s.assert.Len(offsetList.BlockList, 0)
+	s.assert.True(offsetList.SmallFile())
 }
 
 func (s *blockBlobTestSuite) TestFlushFileEmptyFile() {
@@ -2419,6 +2987,45 @@ func (s *blockBlobTestSuite) TestFlushFileChunkedFile() {
 	s.assert.EqualValues(data, output)
 }
 
+func (s *blockBlobTestSuite) TestFlushFileConcurrencyOverride() {
+	defer s.cleanupTest()
+
+	// Setup
+	name := generateFileName()
+	blockSize := 4 * MB
+	h, _ := s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	data := make([]byte, 16*MB)
+	rand.Read(data)
+
+	// use our method to make the max upload size (size before a blob is broken down to blocks) to 4 Bytes
+	err := uploadReaderAtToBlockBlob(ctx, bytes.NewReader(data), int64(len(data)), 4, s.containerClient.NewBlockBlobClient(name), &blockblob.UploadBufferOptions{
+		BlockSize: int64(blockSize),
+	})
+	s.assert.Nil(err)
+	bol, _ := s.az.GetFileBlockOffsets(internal.GetFileBlockOffsetsOptions{Name: name})
+	handlemap.CreateCacheObject(int64(16*MB), h)
+	h.CacheObj.BlockOffsetList = bol
+
+	// Mark every block dirty so all of them are staged with the overridden concurrency
+	for i, blk := range h.CacheObj.BlockOffsetList.BlockList {
+		blk.Data = make([]byte, blockSize)
+		rand.Read(blk.Data)
+		copy(data[i*blockSize:], blk.Data)
+		blk.Flags.Set(common.DirtyBlock)
+	}
+
+	s.assert.EqualValues(2, s.az.storage.(*BlockBlob).getConcurrency(2))
+	err = s.az.FlushFile(internal.FlushFileOptions{Handle: h, Concurrency: 2})
+	s.assert.Nil(err)
+	for _, blk := range h.CacheObj.BlockOffsetList.BlockList {
+		s.assert.False(blk.Dirty())
+	}
+
+	output, err := s.az.ReadFile(internal.ReadFileOptions{Handle: h})
+	s.assert.Nil(err)
+	s.assert.EqualValues(data, output)
+}
+
 func (s *blockBlobTestSuite) TestFlushFileUpdateChunkedFile() {
 	defer s.cleanupTest()
 
@@ -2881,7 +3488,7 @@ func (s *blockBlobTestSuite) TestMD5SetOnUpload() {
 			s.assert.EqualValues(n, blockblob.MaxUploadBlobBytes+1)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(internal.CopyFromFileOptions{Name: name, File: f})
 			s.assert.Nil(err)
 
 			prop, err := s.az.storage.GetAttr(name)
@@ -2934,7 +3541,7 @@ func (s *blockBlobTestSuite) TestMD5NotSetOnUpload() {
 			s.assert.EqualValues(n, blockblob.MaxUploadBlobBytes+1)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(internal.CopyFromFileOptions{Name: name, File: f})
 			s.assert.Nil(err)
 
 			prop, err := s.az.storage.GetAttr(name)
@@ -2982,7 +3589,7 @@ func (s *blockBlobTestSuite) TestMD5AutoSetOnUpload() {
 			s.assert.EqualValues(n, 100)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(internal.CopyFromFileOptions{Name: name, File: f})
 			s.assert.Nil(err)
 
 			prop, err := s.az.storage.GetAttr(name)
@@ -3035,7 +3642,7 @@ func (s *blockBlobTestSuite) TestInvalidateMD5PostUpload() {
 			s.assert.EqualValues(n, 100)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(internal.CopyFromFileOptions{Name: name, File: f})
 			s.assert.Nil(err)
 
 			blobClient := s.containerClient.NewBlobClient(name)
@@ -3091,7 +3698,7 @@ func (s *blockBlobTestSuite) TestValidateAutoMD5OnRead() {
 			s.assert.EqualValues(n, 100)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(internal.CopyFromFileOptions{Name: name, File: f})
 			s.assert.Nil(err)
 			_ = f.Close()
 			_ = os.Remove(name)
@@ -3104,7 +3711,7 @@ func (s *blockBlobTestSuite) TestValidateAutoMD5OnRead() {
 			s.assert.Nil(err)
 			s.assert.NotNil(f)
 
-			err = s.az.storage.ReadToFile(name, 0, 100, f)
+			err = s.az.storage.ReadToFile(internal.CopyToFileOptions{Name: name, Offset: 0, Count: 100, File: f})
 			s.assert.Nil(err)
 
 			_ = s.az.storage.DeleteFile(name)
@@ -3147,7 +3754,7 @@ func (s *blockBlobTestSuite) TestValidateManualMD5OnRead() {
 			s.assert.EqualValues(n, blockblob.MaxUploadBlobBytes+1)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(internal.CopyFromFileOptions{Name: name, File: f})
 			s.assert.Nil(err)
 			_ = f.Close()
 			_ = os.Remove(name)
@@ -3160,7 +3767,7 @@ func (s *blockBlobTestSuite) TestValidateManualMD5OnRead() {
 			s.assert.Nil(err)
 			s.assert.NotNil(f)
 
-			err = s.az.storage.ReadToFile(name, 0, blockblob.MaxUploadBlobBytes+1, f)
+			err = s.az.storage.ReadToFile(internal.CopyToFileOptions{Name: name, Offset: 0, Count: blockblob.MaxUploadBlobBytes + 1, File: f})
 			s.assert.Nil(err)
 
 			_ = s.az.storage.DeleteFile(name)
@@ -3203,7 +3810,7 @@ func (s *blockBlobTestSuite) TestInvalidMD5OnRead() {
 			s.assert.EqualValues(n, 100)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(internal.CopyFromFileOptions{Name: name, File: f})
 			s.assert.Nil(err)
 			_ = f.Close()
 			_ = os.Remove(name)
@@ -3219,7 +3826,7 @@ func (s *blockBlobTestSuite) TestInvalidMD5OnRead() {
 			s.assert.Nil(err)
 			s.assert.NotNil(f)
 
-			err = s.az.storage.ReadToFile(name, 0, 100, f)
+			err = s.az.storage.ReadToFile(internal.CopyToFileOptions{Name: name, Offset: 0, Count: 100, File: f})
 			s.assert.NotNil(err)
 			s.assert.Contains(err.Error(), "md5 sum mismatch on download")
 
@@ -3263,7 +3870,7 @@ func (s *blockBlobTestSuite) TestInvalidMD5OnReadNoVaildate() {
 			s.assert.EqualValues(n, 100)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(internal.CopyFromFileOptions{Name: name, File: f})
 			s.assert.Nil(err)
 			_ = f.Close()
 			_ = os.Remove(name)
@@ -3279,7 +3886,7 @@ func (s *blockBlobTestSuite) TestInvalidMD5OnReadNoVaildate() {
 			s.assert.Nil(err)
 			s.assert.NotNil(f)
 
-			err = s.az.storage.ReadToFile(name, 0, 100, f)
+			err = s.az.storage.ReadToFile(internal.CopyToFileOptions{Name: name, Offset: 0, Count: 100, File: f})
 			s.assert.Nil(err)
 
 			_ = s.az.storage.DeleteFile(name)
@@ -3316,7 +3923,7 @@ func (s *blockBlobTestSuite) TestDownloadBlobWithCPKEnabled() {
 	s.assert.Nil(err)
 	s.assert.NotNil(f)
 
-	err = s.az.storage.ReadToFile(name, 0, int64(len(data)), f)
+	err = s.az.storage.ReadToFile(internal.CopyToFileOptions{Name: name, Offset: 0, Count: int64(len(data)), File: f})
 	s.assert.Nil(err)
 	fileData, err := os.ReadFile(name)
 	s.assert.Nil(err)
@@ -3360,7 +3967,7 @@ func (s *blockBlobTestSuite) TestUploadBlobWithCPKEnabled() {
 	s.assert.Nil(err)
 	_, _ = f.Seek(0, 0)
 
-	err = s.az.storage.WriteFromFile(name1, nil, f)
+	err = s.az.storage.WriteFromFile(internal.CopyFromFileOptions{Name: name1, File: f})
 	s.assert.Nil(err)
 
 	file := s.containerClient.NewBlobClient(name1)
@@ -3451,6 +4058,38 @@ func (suite *blockBlobTestSuite) TestTruncateNoBlockFileToLarger() {
 	suite.UtilityFunctionTruncateFileToLarger(200*MB, 300*MB)
 }
 
+func (s *blockBlobTestSuite) TestBlobFilterChangedMidPagination() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateDirectoryName()
+	s.az.CreateDir(internal.CreateDirOptions{Name: name})
+	for i := 1; i <= 4; i++ {
+		s.az.CreateFile(internal.CreateFileOptions{Name: fmt.Sprintf("%s/abcd%d.txt", name, i)})
+	}
+
+	err := s.az.storage.(*BlockBlob).SetFilter("name=^abcd.*")
+	s.assert.Nil(err)
+
+	_, marker, err := s.az.StreamDir(internal.StreamDirOptions{Name: name + "/", Count: 2})
+	s.assert.Nil(err)
+	s.assert.NotEmpty(marker)
+
+	// Continuing with the same filter works
+	_, _, err = s.az.StreamDir(internal.StreamDirOptions{Name: name + "/", Token: marker, Count: 2})
+	s.assert.Nil(err)
+
+	// Changing the filter invalidates the outstanding marker
+	err = s.az.storage.(*BlockBlob).SetFilter("name=^bla.*")
+	s.assert.Nil(err)
+	_, _, err = s.az.StreamDir(internal.StreamDirOptions{Name: name + "/", Token: marker, Count: 2})
+	s.assert.NotNil(err)
+	s.assert.EqualValues(syscall.EINVAL, err)
+
+	// Restarting the listing works with the new filter
+	_, _, err = s.az.StreamDir(internal.StreamDirOptions{Name: name + "/", Count: 2})
+	s.assert.Nil(err)
+}
+
 func (s *blockBlobTestSuite) TestBlobFilters() {
 	defer s.cleanupTest()
 	// Setup
@@ -3638,6 +4277,2476 @@ func (s *blockBlobTestSuite) TestList() {
 	s.assert.EqualValues(base, blobList[0].Path)
 }
 
+func (s *azStorageTestSuite) TestPing() {
+	var requests atomic.Int32
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if status != http.StatusOK {
+			w.Header().Set("x-ms-error-code", "AuthenticationFailed")
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	s.assert.Nil(bb.Ping(context.Background()))
+	s.assert.EqualValues(1, requests.Load())
+
+	status = http.StatusForbidden
+	err = bb.Ping(context.Background())
+	s.assert.NotNil(err)
+	s.assert.Contains(err.Error(), "AuthenticationFailed")
+	s.assert.EqualValues(2, requests.Load())
+
+	// Unreachable account fails right away even though the client itself would retry
+	containerClient, err := container.NewClientWithNoCredential("http://127.0.0.1:1/cont", nil)
+	s.assert.Nil(err)
+	bb = &BlockBlob{Container: containerClient}
+	start := time.Now()
+	s.assert.NotNil(bb.Ping(context.Background()))
+	s.assert.Less(time.Since(start), 2*time.Second)
+}
+
+func (s *azStorageTestSuite) TestUploadWithArchiveDefaultTier() {
+	bb := &BlockBlob{}
+	bb.Config.defaultTier = to.Ptr(blob.AccessTierArchive)
+
+	// Only warned about by default
+	s.assert.Nil(bb.checkUploadTier("file"))
+
+	bb.Config.rejectArchiveTier = true
+	err := bb.WriteFromBuffer("file", nil, []byte("data"))
+	s.assert.NotNil(err)
+	s.assert.Equal(syscall.EPERM, err)
+
+	err = bb.CommitBlocks("file", []string{"blk"}, nil, nil, "", "")
+	s.assert.Equal(syscall.EPERM, err)
+
+	bb.Config.defaultTier = to.Ptr(blob.AccessTierCool)
+	s.assert.Nil(bb.checkUploadTier("file"))
+}
+
+func (s *azStorageTestSuite) TestGetAttrVirtualDirBoundedList() {
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if r.Method == http.MethodHead {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// Directory has many more children than the one returned, listing has to stop at the first
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Prefix>dir/</Prefix><MaxResults>1</MaxResults>` +
+			`<Blobs><Blob><Name>dir/child0</Name><Properties><Content-Length>0</Content-Length><Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified></Properties></Blob></Blobs>` +
+			`<NextMarker>child1</NextMarker></EnumerationResults>`))
+	}))
+	defer srv.Close()
+
+	containerClient, err := container.NewClientWithNoCredential(srv.URL+"/cont", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	s.assert.Nil(err)
+
+	bb := &BlockBlob{Container: containerClient}
+	bb.Config.virtualDirectory = true
+
+	attr, err := bb.GetAttr("dir")
+	s.assert.Nil(err)
+	s.assert.NotNil(attr)
+	s.assert.True(attr.IsDir())
+	s.assert.Equal("dir", attr.Path)
+
+	// One property lookup for the marker and one single item listing
+	s.assert.Len(requests, 2)
+	s.assert.Equal(http.MethodGet, requests[1].Method)
+	s.assert.Equal("1", requests[1].URL.Query().Get("maxresults"))
+	s.assert.Equal("dir/", requests[1].URL.Query().Get("prefix"))
+	s.assert.Empty(requests[1].URL.Query().Get("delimiter"))
+}
+
+func (s *azStorageTestSuite) TestDeleteDirBestEffort() {
+	var lock sync.Mutex
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Prefix>dir/</Prefix><Blobs>` +
+				`<Blob><Name>dir/a</Name><Properties><Content-Length>0</Content-Length></Properties></Blob>` +
+				`<Blob><Name>dir/b</Name><Properties><Content-Length>0</Content-Length></Properties></Blob>` +
+				`<Blob><Name>dir/c</Name><Properties><Content-Length>0</Content-Length></Properties></Blob>` +
+				`</Blobs><NextMarker/></EnumerationResults>`))
+			return
+		}
+
+		// Batch endpoint is not available, deletes fall back to one request per blob
+		if r.Method == http.MethodPost {
+			w.Header().Set("x-ms-error-code", "FeatureNotSupported")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Delete of one child fails, rest of them should still go through
+		if r.URL.Path == "/cont/dir/b" {
+			w.Header().Set("x-ms-error-code", "LeaseIdMissing")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		lock.Lock()
+		deleted = append(deleted, r.URL.Path)
+		lock.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	containerClient, err := container.NewClientWithNoCredential(srv.URL+"/cont", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	s.assert.Nil(err)
+
+	bb := &BlockBlob{Container: containerClient}
+	bb.Config.deleteDirBestEffort = true
+
+	err = bb.DeleteDirectory("dir")
+	s.assert.NotNil(err)
+
+	var dirErr *DeleteDirError
+	s.assert.True(errors.As(err, &dirErr))
+	s.assert.Equal("dir", dirErr.Dir)
+	s.assert.Len(dirErr.Failed, 1)
+	s.assert.Contains(dirErr.Failed, "dir/b")
+	s.assert.Contains(err.Error(), "dir/b")
+
+	// Other children are gone, marker is kept as the directory is not fully deleted
+	s.assert.ElementsMatch([]string{"/cont/dir/a", "/cont/dir/c"}, deleted)
+}
+
+func (s *azStorageTestSuite) TestDeleteDirBatch() {
+	names := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		names = append(names, fmt.Sprintf("dir/f%04d", i))
+	}
+	tree := newTreeServer(names)
+	defer tree.Close()
+
+	var lock sync.Mutex
+	var requests, batches int
+	deleted := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests++
+
+		switch r.Method {
+		case http.MethodGet:
+			tree.Config.Handler.ServeHTTP(w, r)
+		case http.MethodDelete:
+			// Blob under lease can not be deleted individually either
+			if r.URL.Path == "/cont/dir/f0999" {
+				w.Header().Set("x-ms-error-code", "LeaseIdMissing")
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			deleted[r.URL.Path] = true
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPost:
+			s.assert.Equal("batch", r.URL.Query().Get("comp"))
+			batches++
+			_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			s.assert.Nil(err)
+
+			var body strings.Builder
+			count := 0
+			reader := multipart.NewReader(r.Body, params["boundary"])
+			for part, err := reader.NextPart(); err == nil; part, err = reader.NextPart() {
+				count++
+				sub, err := http.ReadRequest(bufio.NewReader(part))
+				s.assert.Nil(err)
+				s.assert.Equal(http.MethodDelete, sub.Method)
+
+				status := "202 Accepted"
+				switch sub.URL.Path {
+				case "/cont/dir/f0500":
+					// Transient failure inside the batch, individual retry goes through
+					status = "500 Internal Server Error\r\nx-ms-error-code: InternalError"
+				case "/cont/dir/f0999":
+					status = "412 Precondition Failed\r\nx-ms-error-code: LeaseIdMissing"
+				default:
+					deleted[sub.URL.Path] = true
+				}
+				body.WriteString("--batchresponse\r\nContent-Type: application/http\r\nContent-ID: " + part.Header.Get("Content-ID") +
+					"\r\n\r\nHTTP/1.1 " + status + "\r\nContent-Length: 0\r\n\r\n")
+			}
+			s.assert.LessOrEqual(count, maxBatchDeleteSize)
+			body.WriteString("--batchresponse--\r\n")
+
+			w.Header().Set("Content-Type", "multipart/mixed; boundary=batchresponse")
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(body.String()))
+		}
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.Config.deleteDirBestEffort = true
+
+	err = bb.DeleteDirectory("dir")
+	var dirErr *DeleteDirError
+	s.assert.True(errors.As(err, &dirErr))
+	s.assert.Len(dirErr.Failed, 1)
+	s.assert.Equal(syscall.EIO, dirErr.Failed["dir/f0999"])
+	s.assert.Len(deleted, 999)
+	s.assert.True(deleted["/cont/dir/f0500"])
+
+	// One list, four batches and individual retries of the two blobs which failed in their batch
+	s.assert.Equal(4, batches)
+	s.assert.Equal(7, requests)
+}
+
+func (s *azStorageTestSuite) TestRenameFileServerSideCopy() {
+	defer func(interval time.Duration) { copyPollInterval = interval }(copyPollInterval)
+	copyPollInterval = time.Millisecond
+
+	var copyTier, finalStatus string
+	var polls int
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodHead && r.URL.Path == "/cont/src":
+			w.Header().Set("x-ms-access-tier", "Cool")
+			w.Header().Set("Content-Length", "0")
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPut:
+			// Data is copied by the service, only the source url is sent
+			s.assert.True(strings.HasSuffix(r.Header.Get("x-ms-copy-source"), "/cont/src"))
+			s.assert.Zero(r.ContentLength)
+			copyTier = r.Header.Get("x-ms-access-tier")
+			polls = 0
+			w.Header().Set("x-ms-copy-status", "pending")
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodHead:
+			polls++
+			status := "pending"
+			if polls == 3 {
+				status = finalStatus
+			}
+			w.Header().Set("x-ms-copy-status", status)
+			w.Header().Set("Content-Length", "0")
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	// Tier of the source is kept and source is deleted once copy succeeds
+	finalStatus = "success"
+	err = bb.RenameFile("src", "dst", nil)
+	s.assert.Nil(err)
+	s.assert.Equal("Cool", copyTier)
+	s.assert.Equal(3, polls)
+	s.assert.Equal([]string{"/cont/src"}, deleted)
+
+	// Configured default tier takes precedence
+	bb.Config.defaultTier = to.Ptr(blob.AccessTierHot)
+	err = bb.RenameFile("src", "dst", nil)
+	s.assert.Nil(err)
+	s.assert.Equal("Hot", copyTier)
+
+	// Source is kept when the copy fails
+	deleted = nil
+	finalStatus = "failed"
+	err = bb.RenameFile("src", "dst", nil)
+	s.assert.Equal(syscall.EIO, err)
+	s.assert.Empty(deleted)
+}
+
+func (s *azStorageTestSuite) TestListCollapsesSnapshots() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		blob := func(name, snapshot string) string {
+			return `<Blob><Name>` + name + `</Name><Snapshot>` + snapshot + `</Snapshot><Properties><Content-Length>4</Content-Length>` +
+				`<Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified></Properties></Blob>`
+		}
+		w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Prefix>dir/</Prefix><Blobs>` +
+			blob("dir/a", "2024-01-01T00:00:00.0000000Z") + blob("dir/a", "2024-01-02T00:00:00.0000000Z") + blob("dir/a", "") +
+			blob("dir/ab", "2024-01-01T00:00:00.0000000Z") + blob("dir/ab", "") +
+			`</Blobs><NextMarker/></EnumerationResults>`))
+	}))
+	defer srv.Close()
+
+	containerClient, err := container.NewClientWithNoCredential(srv.URL+"/cont", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	s.assert.Nil(err)
+
+	bb := &BlockBlob{Container: containerClient}
+	bb.Config.listDirMarker = true
+
+	list, _, err := bb.List("dir/", nil, 0)
+	s.assert.Nil(err)
+	s.assert.Len(list, 2)
+	s.assert.Equal("dir/a", list[0].Path)
+	s.assert.Equal("dir/ab", list[1].Path)
+
+	// Snapshots are reported only through the snapshot aware api, and only for the exact blob
+	snapshots, err := bb.ListSnapshots("dir/a")
+	s.assert.Nil(err)
+	s.assert.Len(snapshots, 2)
+	s.assert.Equal("2024-01-01T00:00:00.0000000Z", snapshots[0].ID)
+	s.assert.Equal("2024-01-02T00:00:00.0000000Z", snapshots[1].ID)
+}
+
+func (s *azStorageTestSuite) TestListDeletedVersions() {
+	var listQuery url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Properties of the name are those of its current version
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "10")
+			w.Header().Set("Last-Modified", "Wed, 03 Jan 2024 00:00:00 GMT")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		listQuery = r.URL.Query()
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		blob := func(name, version string, current, deleted bool, size int) string {
+			item := `<Blob><Name>` + name + `</Name><VersionId>` + version + `</VersionId>`
+			if current {
+				item += `<IsCurrentVersion>true</IsCurrentVersion>`
+			}
+			item += `<Deleted>` + strconv.FormatBool(deleted) + `</Deleted><Properties><Content-Length>` + strconv.Itoa(size) + `</Content-Length>` +
+				`<Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified>`
+			if deleted {
+				item += `<DeletedTime>Tue, 02 Jan 2024 00:00:00 GMT</DeletedTime>`
+			}
+			return item + `</Properties></Blob>`
+		}
+		w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Blobs>` +
+			blob("a", "2024-01-01T00:00:00.0000000Z", false, true, 4) + blob("a", "2024-01-03T00:00:00.0000000Z", true, false, 10) +
+			blob("ab", "2024-01-01T00:00:00.0000000Z", false, true, 6) +
+			`</Blobs><NextMarker/></EnumerationResults>`))
+	}))
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	attr, err := bb.GetAttr("a")
+	s.assert.Nil(err)
+	s.assert.EqualValues(10, attr.Size)
+
+	versions, err := bb.ListDeletedVersions("a")
+	s.assert.Nil(err)
+	s.assert.Contains(listQuery.Get("include"), "deleted")
+	s.assert.Contains(listQuery.Get("include"), "versions")
+	s.assert.Len(versions, 1)
+	s.assert.Equal("2024-01-01T00:00:00.0000000Z", versions[0].VersionID)
+	s.assert.EqualValues(4, versions[0].Size)
+	s.assert.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), versions[0].DeletedTime.UTC())
+}
+
+func (s *azStorageTestSuite) TestSnapshots() {
+	var lock sync.Mutex
+	var snapshots []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		q := r.URL.Query()
+
+		switch {
+		case r.Method == http.MethodPut && q.Get("comp") == "snapshot":
+			id := time.Date(2024, 1, 1, 0, 0, len(snapshots), 0, time.UTC).Format("2006-01-02T15:04:05.0000000Z")
+			snapshots = append(snapshots, id)
+			w.Header().Set("x-ms-snapshot", id)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodDelete && q.Get("snapshot") != "":
+			for i, id := range snapshots {
+				if id == q.Get("snapshot") {
+					snapshots = append(snapshots[:i], snapshots[i+1:]...)
+					w.WriteHeader(http.StatusAccepted)
+					return
+				}
+			}
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		case q.Get("comp") == "list":
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusOK)
+			var body strings.Builder
+			for _, id := range append(snapshots, "") {
+				body.WriteString(`<Blob><Name>a</Name><Snapshot>` + id + `</Snapshot><Properties><Content-Length>4</Content-Length>` +
+					`<Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified></Properties></Blob>`)
+			}
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Blobs>` + body.String() + `</Blobs><NextMarker/></EnumerationResults>`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	first, err := bb.CreateSnapshot("a")
+	s.assert.Nil(err)
+	second, err := bb.CreateSnapshot("a")
+	s.assert.Nil(err)
+	s.assert.NotEqual(first, second)
+
+	list, err := bb.ListSnapshots("a")
+	s.assert.Nil(err)
+	s.assert.Len(list, 2)
+	s.assert.Equal(first, list[0].ID)
+	s.assert.Equal(second, list[1].ID)
+	s.assert.EqualValues(4, list[0].Size)
+
+	err = bb.DeleteSnapshot("a", first)
+	s.assert.Nil(err)
+	list, err = bb.ListSnapshots("a")
+	s.assert.Nil(err)
+	s.assert.Len(list, 1)
+	s.assert.Equal(second, list[0].ID)
+
+	err = bb.DeleteSnapshot("a", first)
+	s.assert.Equal(syscall.ENOENT, err)
+
+	dl := &Datalake{}
+	_, err = dl.CreateSnapshot("a")
+	s.assert.Equal(syscall.ENOTSUP, err)
+}
+
+// newBrokenStreamServer : Serves the content as a blob, every response sends at most breakAfter bytes before the connection drops
+func newBrokenStreamServer(content []byte, breakAfter int, ranges *[]string) *httptest.Server {
+	var lock sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rng := r.Header.Get("x-ms-range")
+		lock.Lock()
+		*ranges = append(*ranges, rng)
+		lock.Unlock()
+
+		var start, end int
+		_, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		end = min(end, len(content)-1)
+
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.Header().Set("ETag", "\"etag\"")
+		w.WriteHeader(http.StatusPartialContent)
+		if end-start+1 <= breakAfter {
+			w.Write(content[start : end+1])
+			return
+		}
+
+		// Drop the connection midway through the body
+		w.Write(content[start : start+breakAfter])
+		w.(http.Flusher).Flush()
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+}
+
+func (s *azStorageTestSuite) TestReadInBufferResumesBrokenStream() {
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	var ranges []string
+	srv := newBrokenStreamServer(content, 10, &ranges)
+	defer srv.Close()
+
+	containerClient, err := container.NewClientWithNoCredential(srv.URL+"/cont", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	s.assert.Nil(err)
+
+	bb := &BlockBlob{Container: containerClient}
+	bb.Config.readStreamRetries = 3
+
+	// Range is resumed from the last received byte till the read completes
+	data := make([]byte, 30)
+	err = bb.ReadInBuffer("file", 4, 30, data, nil)
+	s.assert.Nil(err)
+	s.assert.Equal(content[4:34], data)
+	s.assert.Equal([]string{"bytes=4-33", "bytes=14-33", "bytes=24-33"}, ranges)
+
+	// Broken stream is reported once retries are exhausted, not filled with zeros
+	ranges = nil
+	deadSrv := newBrokenStreamServer(content, 0, &ranges)
+	defer deadSrv.Close()
+	bb.Container, err = container.NewClientWithNoCredential(deadSrv.URL+"/cont", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	s.assert.Nil(err)
+	bb.Config.readStreamRetries = 1
+	err = bb.ReadInBuffer("file", 0, 30, data, nil)
+	s.assert.Equal(syscall.EIO, err)
+	s.assert.Len(ranges, 2)
+}
+
+func (s *azStorageTestSuite) TestGetAttrWithCPK() {
+	key, keySha := "a2V5", "a2V5c2hh"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Object is written with a customer provided key, service refuses to describe it without that key
+		if r.Header.Get("x-ms-encryption-key") != key {
+			w.Header().Set("x-ms-error-code", "BlobUsesCustomerSpecifiedEncryption")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Length", "0")
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("x-ms-creation-time", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("x-ms-meta-hdi_isfolder", "true")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	containerClient, err := container.NewClientWithNoCredential(srv.URL+"/cont", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	s.assert.Nil(err)
+
+	// Mount without the key gets a clear permission error
+	bb := &BlockBlob{Container: containerClient}
+	_, err = bb.GetAttr("dir")
+	s.assert.Equal(syscall.EACCES, err)
+
+	// Mount with the key can stat its own objects
+	bb.Config.cpkEnabled = true
+	bb.Config.cpkEncryptionKey = key
+	bb.Config.cpkEncryptionKeySha256 = keySha
+	s.assert.Nil(bb.Configure(bb.Config))
+	bb.Container = containerClient
+	attr, err := bb.GetAttr("dir")
+	s.assert.Nil(err)
+	s.assert.True(attr.IsDir())
+}
+
+func (s *azStorageTestSuite) TestStreamingWriteMemory() {
+	const fileSize = 4 * common.GbToBytes
+	const blockSize = 8 * common.MbToBytes
+	const concurrency = 4
+
+	f, err := os.CreateTemp("", "sparse")
+	s.assert.Nil(err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	s.assert.Nil(f.Truncate(fileSize))
+	_, err = f.WriteAt([]byte("tail"), fileSize-4)
+	s.assert.Nil(err)
+
+	var staged, committed atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		if r.URL.Query().Get("comp") == "blocklist" {
+			committed.Add(1)
+		} else {
+			staged.Add(n)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.Config.blockSize = blockSize
+	bb.Config.maxConcurrency = concurrency
+	bb.Config.streamingWrite = true
+
+	// Sample the heap while the upload runs
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	var peak atomic.Uint64
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		var m runtime.MemStats
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+				runtime.ReadMemStats(&m)
+				if m.HeapInuse > peak.Load() {
+					peak.Store(m.HeapInuse)
+				}
+			}
+		}
+	}()
+
+	err = bb.WriteFromFile(internal.CopyFromFileOptions{Name: "file", File: f})
+	close(done)
+	<-sampled
+	s.assert.Nil(err)
+	s.assert.EqualValues(fileSize, staged.Load())
+	s.assert.EqualValues(1, committed.Load())
+
+	// Only the block buffers in flight are held, never a large part of the file
+	growth := int64(peak.Load()) - int64(before.HeapInuse)
+	s.assert.Less(growth, int64(2*blockSize*concurrency), "heap grew by %d bytes", growth)
+}
+
+func (s *azStorageTestSuite) TestWriteFromFileGrowingFile() {
+	for _, staged := range []bool{false, true} {
+		s.Run(strconv.FormatBool(staged), func() {
+			f, err := os.CreateTemp("", "growing")
+			s.assert.Nil(err)
+			defer os.Remove(f.Name())
+			_, err = f.Write(bytes.Repeat([]byte("a"), 1000))
+			s.assert.Nil(err)
+
+			var lock sync.Mutex
+			uploaded := 0
+			grown := false
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				// Writer keeps appending to the file while upload is in progress
+				if !grown {
+					grown = true
+					_, _ = f.WriteAt(bytes.Repeat([]byte("b"), 500), 1000)
+				}
+
+				body, _ := io.ReadAll(r.Body)
+				if r.URL.Query().Get("comp") != "blocklist" {
+					uploaded += len(body)
+				}
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer srv.Close()
+
+			bb, err := newTreeBlockBlob(srv)
+			s.assert.Nil(err)
+			bb.Config.blockSize = 300
+			bb.Config.maxConcurrency = 4
+			if staged {
+				defer func(old int64) { singleUploadMaxBytes = old }(singleUploadMaxBytes)
+				singleUploadMaxBytes = 100
+			}
+
+			err = bb.WriteFromFile(internal.CopyFromFileOptions{Name: "file", File: f})
+			s.assert.Nil(err)
+			s.assert.True(grown)
+
+			// Only the size seen at start of upload is sent
+			s.assert.Equal(1000, uploaded)
+		})
+	}
+}
+
+func (s *azStorageTestSuite) TestGetAttrMtimeFallback() {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	withCreation := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Blob properties without any last modified time
+		w.Header().Set("Content-Length", "5")
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		if withCreation {
+			w.Header().Set("x-ms-creation-time", created.Format(http.TimeFormat))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	// Default order picks creation time first
+	attr, err := bb.GetAttr("file")
+	s.assert.Nil(err)
+	s.assert.True(created.Equal(attr.Mtime))
+	s.assert.True(created.Equal(attr.Ctime))
+
+	// Without creation time current time is used
+	withCreation = false
+	before := time.Now()
+	attr, err = bb.GetAttr("file")
+	s.assert.Nil(err)
+	s.assert.False(attr.Mtime.Before(before.Truncate(time.Second)))
+	s.assert.True(attr.Crtime.Equal(attr.Mtime))
+
+	// Fallback order is configurable, no fallback leaves mtime unset
+	withCreation = true
+	bb.Config.mtimeFallback = []string{MtimeFallbackNow}
+	attr, err = bb.GetAttr("file")
+	s.assert.Nil(err)
+	s.assert.False(attr.Mtime.Before(before.Truncate(time.Second)))
+	s.assert.True(created.Equal(attr.Crtime))
+
+	bb.Config.mtimeFallback = []string{}
+	attr, err = bb.GetAttr("file")
+	s.assert.Nil(err)
+	s.assert.True(attr.Mtime.IsZero())
+}
+
+func (s *azStorageTestSuite) TestValidateMD5OnUpload() {
+	content := []byte("data which is checked end to end")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method == http.MethodGet {
+			// Range md5 does not match the data returned
+			w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(make([]byte, md5.Size)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(content)
+			return
+		}
+
+		// Service verifies the md5 sent with the request
+		if sent := r.Header.Get("Content-MD5"); sent != "" {
+			sum := md5.Sum(body)
+			if sent != base64.StdEncoding.EncodeToString(sum[:]) {
+				w.Header().Set("x-ms-error-code", "Md5Mismatch")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	transport := &corruptingTransport{}
+	containerClient, err := container.NewClientWithNoCredential(srv.URL+"/cont", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+			Transport: transport,
+		},
+	})
+	s.assert.Nil(err)
+	bb := &BlockBlob{Container: containerClient}
+	bb.Config.validateMD5 = true
+	bb.Config.maxConcurrency = 4
+
+	err = bb.WriteFromBuffer("file", nil, content)
+	s.assert.Nil(err)
+	err = bb.StageBlock("file", content, common.GetBlockID(common.BlockIDLength))
+	s.assert.Nil(err)
+
+	// Data corrupted after md5 was computed is rejected
+	transport.corrupt = true
+	err = bb.WriteFromBuffer("file", nil, content)
+	s.assert.Equal(syscall.EIO, err)
+	err = bb.StageBlock("file", content, common.GetBlockID(common.BlockIDLength))
+	s.assert.Equal(syscall.EIO, err)
+
+	// Blocks are staged individually with their md5 when data is too large for a single upload
+	defer func(old int64) { singleUploadMaxBytes = old }(singleUploadMaxBytes)
+	singleUploadMaxBytes = 8
+	bb.Config.blockSize = 8
+	err = bb.WriteFromBuffer("file", nil, content)
+	s.assert.Equal(syscall.EIO, err)
+
+	transport.corrupt = false
+	err = bb.WriteFromBuffer("file", nil, content)
+	s.assert.Nil(err)
+
+	// Downloaded range not matching its md5 fails the read
+	data := make([]byte, len(content))
+	err = bb.ReadInBuffer("file", 0, int64(len(content)), data, nil)
+	s.assert.Equal(syscall.EIO, err)
+
+	bb.Config.validateMD5 = false
+	err = bb.ReadInBuffer("file", 0, int64(len(content)), data, nil)
+	s.assert.Nil(err)
+	s.assert.Equal(content, data)
+}
+
+func (s *azStorageTestSuite) TestValidateCRC64OnRead() {
+	content := make([]byte, 10*common.MbToBytes)
+	_, _ = rand.Read(content)
+	corrupt := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		var start, end int
+		_, _ = fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &start, &end)
+		body := append([]byte{}, content[start:end+1]...)
+		if r.Header.Get("x-ms-range-get-content-crc64") == "true" {
+			crc := make([]byte, 8)
+			binary.LittleEndian.PutUint64(crc, crc64.Checksum(body, azureCRC64Table))
+			w.Header().Set("x-ms-content-crc64", base64.StdEncoding.EncodeToString(crc))
+		}
+		if corrupt {
+			body[len(body)-1] ^= 0xff
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.Header().Set("ETag", `"etag1"`)
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.Config.validateCRC64 = true
+	bb.Config.maxConcurrency = 4
+	bb.downloadOptions = &blob.DownloadFileOptions{}
+
+	// Valid data passes, etag is still reported
+	data := make([]byte, 100)
+	var etag string
+	err = bb.ReadInBuffer("file", 10, 100, data, &etag)
+	s.assert.Nil(err)
+	s.assert.Equal(content[10:110], data)
+	s.assert.Equal("etag1", etag)
+
+	buff, err := bb.ReadBuffer("file", 0, int64(len(content)))
+	s.assert.Nil(err)
+	s.assert.Equal(content, buff)
+
+	f, err := os.CreateTemp("", "crc64")
+	s.assert.Nil(err)
+	defer os.Remove(f.Name())
+	err = bb.ReadToFile(internal.CopyToFileOptions{Name: "file", File: f})
+	s.assert.Nil(err)
+	local, _ := os.ReadFile(f.Name())
+	s.assert.Equal(content, local)
+
+	// Data not matching its crc64 fails every read path
+	corrupt = true
+	err = bb.ReadInBuffer("file", 10, 100, data, nil)
+	s.assert.Equal(syscall.EIO, err)
+	_, err = bb.ReadBuffer("file", 0, int64(len(content)))
+	s.assert.Equal(syscall.EIO, err)
+	err = bb.ReadToFile(internal.CopyToFileOptions{Name: "file", File: f})
+	s.assert.Equal(syscall.EIO, err)
+
+	// Nothing is validated when not configured
+	bb.Config.validateCRC64 = false
+	err = bb.ReadInBuffer("file", 10, 100, data, nil)
+	s.assert.Nil(err)
+}
+
+func (s *azStorageTestSuite) TestGetAttrUnixMode() {
+	modes := map[string]string{"/cont/valid": "0750", "/cont/malformed": "rwxr-x---", "/cont/toolarge": "77777", "/cont/none": ""}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mode, found := modes[r.URL.Path]
+		if !found {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodPut {
+			s.assert.Equal("metadata", r.URL.Query().Get("comp"))
+			modes[r.URL.Path] = r.Header.Get("x-ms-meta-mode")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if mode != "" {
+			w.Header().Set("x-ms-meta-mode", mode)
+		}
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.Config.storeUnixPermissions = true
+	bb.Config.defaultUnixMode = 0600
+
+	attr, err := bb.GetAttr("valid")
+	s.assert.Nil(err)
+	s.assert.False(attr.IsModeDefault())
+	s.assert.EqualValues(0750, attr.Mode)
+
+	// Malformed mode falls back to the default instead of failing or reporting garbage
+	for _, name := range []string{"malformed", "toolarge"} {
+		attr, err = bb.GetAttr(name)
+		s.assert.Nil(err)
+		s.assert.False(attr.IsModeDefault())
+		s.assert.EqualValues(0600, attr.Mode)
+	}
+	s.assert.True(bb.malformedModeLogged.Load())
+
+	// Without a mode the fuse layer decides the permissions
+	attr, err = bb.GetAttr("none")
+	s.assert.Nil(err)
+	s.assert.True(attr.IsModeDefault())
+
+	// Chmod saves the mode which is reported back
+	err = bb.ChangeMod("none", 0640)
+	s.assert.Nil(err)
+	s.assert.Equal("0640", modes["/cont/none"])
+	attr, err = bb.GetAttr("none")
+	s.assert.Nil(err)
+	s.assert.EqualValues(0640, attr.Mode)
+
+	bb.Config.storeUnixPermissions = false
+	attr, err = bb.GetAttr("valid")
+	s.assert.Nil(err)
+	s.assert.True(attr.IsModeDefault())
+}
+
+func (s *azStorageTestSuite) TestDirContentType() {
+	var markerType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			markerType = r.Header.Get("x-ms-blob-content-type")
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodHead:
+			w.Header().Set("Content-Type", "application/directory; charset=utf-8")
+			w.Header().Set("Content-Length", "0")
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Blobs>` +
+				`<Blob><Name>a</Name><Properties><Content-Length>0</Content-Length><Content-Type>application/directory</Content-Type></Properties></Blob>` +
+				`<Blob><Name>b</Name><Properties><Content-Length>4</Content-Length><Content-Type>text/plain</Content-Type></Properties></Blob>` +
+				`</Blobs><NextMarker/></EnumerationResults>`))
+		}
+	}))
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	// Without the config content type is neither set on the marker nor used for detection
+	err = bb.CreateDirectory("dir.txt")
+	s.assert.Nil(err)
+	s.assert.Equal("text/plain", markerType)
+	attr, err := bb.GetAttr("dir")
+	s.assert.Nil(err)
+	s.assert.False(attr.IsDir())
+
+	bb.Config.dirContentType = "application/directory"
+	err = bb.CreateDirectory("dir.txt")
+	s.assert.Nil(err)
+	s.assert.Equal("application/directory", markerType)
+
+	attr, err = bb.GetAttr("dir")
+	s.assert.Nil(err)
+	s.assert.True(attr.IsDir())
+
+	list, _, err := bb.List("", nil, 0)
+	s.assert.Nil(err)
+	s.assert.Len(list, 2)
+	s.assert.True(list[0].IsDir())
+	s.assert.False(list[1].IsDir())
+}
+
+func (s *azStorageTestSuite) TestOperationTimeout() {
+	// Server never answers, a request only ends when the client gives up on it
+	var cancelled atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Closed connection is noticed only once the request body has been consumed
+		_, _ = io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+			cancelled.Add(1)
+		case <-time.After(30 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.Config.attrTimeout = 1
+	bb.Config.readTimeout = 1
+	bb.Config.writeTimeout = 1
+
+	start := time.Now()
+	_, err = bb.GetAttr("a")
+	s.assert.Equal(syscall.ETIMEDOUT, err)
+
+	err = bb.ReadInBuffer("a", 0, 10, make([]byte, 10), nil)
+	s.assert.Equal(syscall.ETIMEDOUT, err)
+
+	err = bb.StageBlock("a", []byte("data"), base64.StdEncoding.EncodeToString([]byte("id")))
+	s.assert.Equal(syscall.ETIMEDOUT, err)
+	s.assert.Less(time.Since(start), 10*time.Second)
+
+	// Deadline aborted the requests, server side saw the connections go away
+	s.assert.Eventually(func() bool { return cancelled.Load() == 3 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func (s *azStorageTestSuite) TestTinyBlockSizeClamped() {
+	logFile := filepath.Join(s.T().TempDir(), "clamp.txt")
+	err := log.SetDefaultLogger("base", common.LogConfig{FilePath: logFile, Level: common.ELogLevel.LOG_WARNING()})
+	s.assert.Nil(err)
+	defer log.SetDefaultLogger("silent", common.LogConfig{Level: common.ELogLevel.LOG_DEBUG()})
+
+	bb := &BlockBlob{}
+	err = bb.UpdateConfig(AzStorageConfig{blockSize: 1})
+	s.assert.Nil(err)
+	s.assert.EqualValues(MinBlockSize, bb.Config.blockSize)
+
+	// 0 lets block size be computed from the file size
+	err = bb.UpdateConfig(AzStorageConfig{blockSize: 0})
+	s.assert.Nil(err)
+	s.assert.EqualValues(0, bb.Config.blockSize)
+
+	err = bb.UpdateConfig(AzStorageConfig{blockSize: 8, allowTinyBlocks: true})
+	s.assert.Nil(err)
+	s.assert.EqualValues(8, bb.Config.blockSize)
+
+	err = log.Destroy()
+	s.assert.Nil(err)
+	data, err := os.ReadFile(logFile)
+	s.assert.Nil(err)
+	s.assert.Contains(string(data), "Block size 1 is below the minimum")
+	s.assert.NotContains(string(data), "Block size 8")
+}
+
+// newSoftDeleteServer : Container keeping deleted blobs until they are undeleted
+func newSoftDeleteServer(names []string, softDelete bool) *httptest.Server {
+	var lock sync.Mutex
+	deleted := map[string]bool{}
+	live := map[string]bool{}
+	for _, name := range names {
+		live["/cont/"+name] = true
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		q := r.URL.Query()
+
+		switch {
+		case q.Get("restype") == "service" && q.Get("comp") == "properties":
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><StorageServiceProperties><DeleteRetentionPolicy><Enabled>` +
+				strconv.FormatBool(softDelete) + `</Enabled></DeleteRetentionPolicy></StorageServiceProperties>`))
+		case q.Get("comp") == "list":
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusOK)
+			var body strings.Builder
+			for _, name := range names {
+				item := func(isDeleted bool) {
+					body.WriteString(`<Blob><Name>` + name + `</Name><Deleted>` + strconv.FormatBool(isDeleted) + `</Deleted><Properties><Content-Length>4</Content-Length>` +
+						`<Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified></Properties></Blob>`)
+				}
+				if deleted["/cont/"+name] && strings.Contains(q.Get("include"), "deleted") {
+					item(true)
+				}
+				if live["/cont/"+name] {
+					item(false)
+				}
+			}
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Blobs>` + body.String() + `</Blobs><NextMarker/></EnumerationResults>`))
+		case r.Method == http.MethodDelete && live[r.URL.Path]:
+			delete(live, r.URL.Path)
+			deleted[r.URL.Path] = true
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPut && q.Get("comp") == "":
+			// Upload recreates the name, its deleted blob stays until undeleted
+			live[r.URL.Path] = true
+			w.WriteHeader(http.StatusCreated)
+		case q.Get("comp") == "undelete" && (deleted[r.URL.Path] || live[r.URL.Path]):
+			delete(deleted, r.URL.Path)
+			live[r.URL.Path] = true
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodHead && live[r.URL.Path]:
+			w.Header().Set("Content-Length", "4")
+			w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func (s *azStorageTestSuite) TestUndeleteFile() {
+	srv := newSoftDeleteServer([]string{"a"}, true)
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	err = bb.DeleteFile("a")
+	s.assert.Nil(err)
+	_, err = bb.GetAttr("a")
+	s.assert.Equal(syscall.ENOENT, err)
+
+	err = bb.UndeleteFile("a")
+	s.assert.Nil(err)
+	attr, err := bb.GetAttr("a")
+	s.assert.Nil(err)
+	s.assert.False(attr.IsDeleted())
+
+	err = bb.UndeleteFile("b")
+	s.assert.Equal(syscall.ENOENT, err)
+}
+
+func (s *azStorageTestSuite) TestListDeleted() {
+	srv := newSoftDeleteServer([]string{"a", "b", "c"}, false)
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.Service, err = service.NewClientWithNoCredential(srv.URL+"/", &service.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}},
+	})
+	s.assert.Nil(err)
+
+	// b is recreated after delete, the current blob is what gets listed for it
+	s.assert.Nil(bb.DeleteFile("a"))
+	s.assert.Nil(bb.DeleteFile("b"))
+	s.assert.Nil(bb.WriteFromBuffer("b", nil, []byte("data")))
+
+	// Deleted blobs are not listed by default
+	list, _, err := bb.List("", nil, 0)
+	s.assert.Nil(err)
+	s.assert.Len(list, 2)
+
+	bb.Config.listDeleted = true
+	bb.listDetails.Deleted = true
+	list, _, err = bb.List("", nil, 0)
+	s.assert.Nil(err)
+	s.assert.Len(list, 3)
+	deleted := map[string]bool{}
+	for _, attr := range list {
+		deleted[attr.Path] = attr.IsDeleted()
+	}
+	s.assert.Equal(map[string]bool{"a": true, "b": false, "c": false}, deleted)
+
+	// Account in the fake server has soft delete disabled
+	logFile := filepath.Join(s.T().TempDir(), "softdelete.txt")
+	err = log.SetDefaultLogger("base", common.LogConfig{FilePath: logFile, Level: common.ELogLevel.LOG_WARNING()})
+	s.assert.Nil(err)
+	defer log.SetDefaultLogger("silent", common.LogConfig{Level: common.ELogLevel.LOG_DEBUG()})
+
+	err = bb.TestPipeline()
+	s.assert.Nil(err)
+	s.assert.Nil(log.Destroy())
+	data, err := os.ReadFile(logFile)
+	s.assert.Nil(err)
+	s.assert.Contains(string(data), "soft delete is not enabled on the account")
+}
+
+func (s *azStorageTestSuite) TestGetAttrCopyStatus() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "0")
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("x-ms-meta-owner", "backup")
+		w.Header().Set("x-ms-copy-status", "pending")
+		w.Header().Set("x-ms-copy-progress", "1024/4096")
+		w.Header().Set("x-ms-copy-source", "https://account.blob.core.windows.net/cont/src?snapshot=2024-01-01T00:00:00.0000000Z")
+		w.Header().Set("x-ms-incremental-copy", "true")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	// Not reported unless asked for
+	attr, err := bb.GetAttr("dst")
+	s.assert.Nil(err)
+	s.assert.NotContains(attr.Metadata, copyStatusKey)
+
+	bb.Config.reportCopyStatus = true
+	attr, err = bb.GetAttr("dst")
+	s.assert.Nil(err)
+	s.assert.Equal("pending", *attr.Metadata[copyStatusKey])
+	s.assert.Equal("1024/4096", *attr.Metadata[copyProgressKey])
+	s.assert.Equal("https://account.blob.core.windows.net/cont/src?snapshot=2024-01-01T00:00:00.0000000Z", *attr.Metadata[copySourceKey])
+	s.assert.Equal("true", *attr.Metadata[incrementalCopyKey])
+	// User metadata is kept alongside
+	s.assert.Len(attr.Metadata, 5)
+}
+
+func (s *azStorageTestSuite) TestReadInBufferRehydrating() {
+	archiveStatus := "rehydrate-pending-to-hot"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "10")
+			w.Header().Set("x-ms-access-tier", "Archive")
+			if archiveStatus != "" {
+				w.Header().Set("x-ms-archive-status", archiveStatus)
+				w.Header().Set("x-ms-rehydrate-priority", "High")
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("x-ms-error-code", "BlobArchived")
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	err = bb.ReadInBuffer("a", 0, 10, make([]byte, 10), nil)
+	s.assert.ErrorIs(err, syscall.EAGAIN)
+	var rerr *RehydratePendingError
+	s.assert.ErrorAs(err, &rerr)
+	s.assert.Equal("High", rerr.Priority)
+	s.assert.Equal(time.Hour, rerr.Estimate)
+	s.assert.Contains(err.Error(), "within 1h0m0s")
+
+	// Archived blob which is not being rehydrated will not become readable by retrying
+	archiveStatus = ""
+	err = bb.ReadInBuffer("a", 0, 10, make([]byte, 10), nil)
+	s.assert.Equal(syscall.EPERM, err)
+}
+
+func (s *azStorageTestSuite) TestGetFileBlockOffsetsEmptyFile() {
+	var lock sync.Mutex
+	created := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.Method == http.MethodPut {
+			created[r.URL.Path] = true
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+
+		if !created[r.URL.Path] {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><BlockList><CommittedBlocks/></BlockList>`))
+	}))
+	defer srv.Close()
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	err = bb.CreateFile("empty", 0644, "")
+	s.assert.Nil(err)
+	offsetList, err := bb.GetFileBlockOffsets("empty")
+	s.assert.Nil(err)
+	s.assert.NotNil(offsetList)
+	s.assert.Empty(offsetList.BlockList)
+	s.assert.True(offsetList.SmallFile())
+
+	_, err = bb.GetFileBlockOffsets("missing")
+	s.assert.Equal(syscall.ENOENT, err)
+}
+
+func (s *azStorageTestSuite) TestRenameDirResume() {
+	var lock sync.Mutex
+	live := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		live[fmt.Sprintf("/cont/src/f%02d", i)] = true
+	}
+	// First attempt fails the copy of one blob and the delete of another after its copy
+	failCopy := map[string]int{"/cont/src/f03": 1}
+	failDelete := map[string]int{"/cont/src/f11": 1}
+	copies := map[string]int{}
+	var inFlight, maxInFlight int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if copySource := r.Header.Get("x-ms-copy-source"); copySource != "" {
+			cur := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for old := atomic.LoadInt32(&maxInFlight); cur > old && !atomic.CompareAndSwapInt32(&maxInFlight, old, cur); old = atomic.LoadInt32(&maxInFlight) {
+			}
+			time.Sleep(20 * time.Millisecond)
+
+			src, _ := url.Parse(copySource)
+			lock.Lock()
+			defer lock.Unlock()
+			copies[src.Path]++
+			if failCopy[src.Path] > 0 {
+				failCopy[src.Path]--
+				w.Header().Set("x-ms-error-code", "InternalError")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			live[r.URL.Path] = true
+			w.Header().Set("x-ms-copy-status", "success")
+			w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		switch {
+		case r.URL.Query().Get("comp") == "list":
+			prefix := "/cont/" + r.URL.Query().Get("prefix")
+			names := make([]string, 0)
+			for name := range live {
+				if strings.HasPrefix(name, prefix) {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			var body strings.Builder
+			for _, name := range names {
+				body.WriteString(`<Blob><Name>` + strings.TrimPrefix(name, "/cont/") + `</Name><Properties><Content-Length>4</Content-Length>` +
+					`<Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified></Properties></Blob>`)
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Blobs>` + body.String() + `</Blobs><NextMarker/></EnumerationResults>`))
+		case r.Method == http.MethodDelete && failDelete[r.URL.Path] > 0:
+			failDelete[r.URL.Path]--
+			w.Header().Set("x-ms-error-code", "InternalError")
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.Method == http.MethodDelete && live[r.URL.Path]:
+			delete(live, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodHead && live[r.URL.Path]:
+			w.Header().Set("Content-Length", "4")
+			w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.Config.maxConcurrency = 4
+
+	// Partial failure is reported and the failed blobs stay at source
+	err = bb.RenameDirectory("src", "dst")
+	s.assert.NotNil(err)
+	s.assert.True(live["/cont/src/f03"])
+	s.assert.True(live["/cont/src/f11"])
+	s.assert.True(live["/cont/dst/f11"])
+	s.assert.False(live["/cont/dst/f03"])
+	s.assert.False(live["/cont/src/f00"])
+	s.assert.True(live["/cont/dst/f00"])
+	s.assert.Greater(maxInFlight, int32(1))
+	s.assert.LessOrEqual(maxInFlight, int32(4))
+
+	// Retry copies only what did not make it, the blob whose delete failed is not copied again
+	err = bb.RenameDirectory("src", "dst")
+	s.assert.Nil(err)
+	for i := 0; i < 20; i++ {
+		s.assert.False(live[fmt.Sprintf("/cont/src/f%02d", i)])
+		s.assert.True(live[fmt.Sprintf("/cont/dst/f%02d", i)])
+	}
+	s.assert.Equal(2, copies["/cont/src/f03"])
+	s.assert.Equal(1, copies["/cont/src/f11"])
+	s.assert.Equal(1, copies["/cont/src/f00"])
+	s.assert.Len(copies, 20)
+}
+
+func (s *azStorageTestSuite) TestFlushSmallFileSinglePut() {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	var lock sync.Mutex
+	requests := make([]string, 0)
+	var body []byte
+	var contentType, encryptionKey string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Query().Get("comp"))
+		if r.Method == http.MethodPut {
+			body = data
+			contentType = r.Header.Get("x-ms-blob-content-type")
+			encryptionKey = r.Header.Get("x-ms-encryption-key")
+		}
+		if r.Method == http.MethodHead {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.blobCPKOpt = &blob.CPKInfo{
+		EncryptionKey:       to.Ptr(key),
+		EncryptionKeySHA256: to.Ptr("sha"),
+		EncryptionAlgorithm: to.Ptr(blob.EncryptionAlgorithmTypeAES256),
+	}
+
+	// Single dirty block of a small file is uploaded with one Put Blob
+	bol := &common.BlockOffsetList{}
+	bol.Flags.Set(common.SmallFile)
+	blk := &common.Block{Id: common.GetBlockID(common.BlockIDLength), StartIndex: 0, EndIndex: 5, Data: []byte("hello")}
+	blk.Flags.Set(common.DirtyBlock)
+	bol.BlockList = append(bol.BlockList, blk)
+
+	err = bb.StageAndCommit("file.txt", bol, 0)
+	s.assert.Nil(err)
+	s.assert.Equal([]string{"PUT "}, requests)
+	s.assert.Equal([]byte("hello"), body)
+	s.assert.Equal("text/plain", contentType)
+	s.assert.Equal(key, encryptionKey)
+	s.assert.False(blk.Dirty())
+
+	// More than one block still goes through stage and commit
+	requests = requests[:0]
+	blk.Flags.Set(common.DirtyBlock)
+	blk2 := &common.Block{Id: common.GetBlockID(common.BlockIDLength), StartIndex: 5, EndIndex: 10, Data: []byte("world")}
+	blk2.Flags.Set(common.DirtyBlock)
+	bol.BlockList = append(bol.BlockList, blk2)
+
+	err = bb.StageAndCommit("file.txt", bol, 0)
+	s.assert.Nil(err)
+	s.assert.ElementsMatch([]string{"PUT block", "PUT block", "PUT blocklist"}, requests)
+}
+
+func (s *azStorageTestSuite) TestFlushDeletedFile() {
+	var lock sync.Mutex
+	exists := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		switch {
+		case r.Method == http.MethodHead && !exists:
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodHead:
+			w.Header().Set("Content-Length", "5")
+			w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "block":
+			w.WriteHeader(http.StatusCreated)
+		case r.Header.Get("If-Match") == "*" && !exists:
+			w.Header().Set("x-ms-error-code", "ConditionNotMet")
+			w.WriteHeader(http.StatusPreconditionFailed)
+		default:
+			exists = true
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	newBlock := func(start int64, data string) *common.Block {
+		blk := &common.Block{Id: common.GetBlockID(common.BlockIDLength), StartIndex: start, EndIndex: start + int64(len(data)), Data: []byte(data)}
+		blk.Flags.Set(common.DirtyBlock)
+		return blk
+	}
+	smallFile := func() *common.BlockOffsetList {
+		bol := &common.BlockOffsetList{BlockList: []*common.Block{newBlock(0, "hello")}}
+		bol.Flags.Set(common.SmallFile)
+		return bol
+	}
+	chunkedFile := func() *common.BlockOffsetList {
+		return &common.BlockOffsetList{BlockList: []*common.Block{newBlock(0, "hello"), newBlock(5, "world")}}
+	}
+
+	for _, bol := range []func() *common.BlockOffsetList{smallFile, chunkedFile} {
+		// Default mode recreates the deleted blob
+		bb.Config.strictFlush = false
+		exists = false
+		err = bb.StageAndCommit("file", bol(), 0)
+		s.assert.Nil(err)
+		s.assert.True(exists)
+
+		// Strict mode fails and the blob is not recreated
+		bb.Config.strictFlush = true
+		exists = false
+		err = bb.StageAndCommit("file", bol(), 0)
+		s.assert.Equal(syscall.ENOENT, err)
+		s.assert.False(exists)
+
+		// Strict mode still flushes a blob which exists
+		exists = true
+		err = bb.StageAndCommit("file", bol(), 0)
+		s.assert.Nil(err)
+	}
+}
+
+func (s *azStorageTestSuite) TestGetAttrUnknownEncryptionKey() {
+	codes := map[string]int{
+		"cpk":      http.StatusConflict,
+		"nocpk":    http.StatusConflict,
+		"keyvault": http.StatusForbidden,
+		"scope":    http.StatusForbidden,
+	}
+	errorCodes := map[string]string{
+		"cpk":      "BlobUsesCustomerSpecifiedEncryption",
+		"nocpk":    "BlobDoesNotUseCustomerSpecifiedEncryption",
+		"keyvault": "KeyVaultEncryptionKeyNotFound",
+		"scope":    "EncryptionScopeDisabled",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("comp") == "list" {
+			var body strings.Builder
+			for _, name := range []string{"cpk", "keyvault", "nocpk", "scope"} {
+				if !strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+					continue
+				}
+				body.WriteString(`<Blob><Name>` + name + `</Name><Properties><Content-Length>4</Content-Length>` +
+					`<Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified></Properties></Blob>`)
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Blobs>` + body.String() + `</Blobs><NextMarker/></EnumerationResults>`))
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/cont/")
+		if status, ok := codes[name]; ok {
+			w.Header().Set("x-ms-error-code", errorCodes[name])
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	// Objects encrypted with a key the mount does not have are present but not accessible
+	for name := range codes {
+		_, err = bb.GetAttr(name)
+		s.assert.Equal(syscall.EACCES, err, name)
+	}
+	_, err = bb.GetAttr("missing")
+	s.assert.Equal(syscall.ENOENT, err)
+
+	// and they are still listed
+	list, _, err := bb.List("", nil, 0)
+	s.assert.Nil(err)
+	s.assert.Len(list, len(codes))
+}
+
+func (s *azStorageTestSuite) TestContentTypeDetection() {
+	var lock sync.Mutex
+	contentTypes := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if r.Method == http.MethodHead {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		lock.Lock()
+		contentTypes[strings.TrimPrefix(r.URL.Path, "/cont/")] = r.Header.Get("x-ms-blob-content-type")
+		lock.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	// Without detection only the built in types are known
+	s.assert.Equal("application/octet-stream", bb.uploadContentType("site/app.webmanifest", ""))
+
+	bb.Config.contentTypeDetection = true
+	bb.Config.contentTypeMap = map[string]string{".webmanifest": "application/manifest+json"}
+
+	s.assert.Nil(bb.CreateFile("site/data.json", 0644, ""))
+	s.assert.Nil(bb.CreateFile("site/index.html", 0644, ""))
+	s.assert.Nil(bb.CreateFile("site/blob.unknownext", 0644, ""))
+	s.assert.Nil(bb.CreateFile("site/app.webmanifest", 0644, ""))
+	s.assert.Nil(bb.CreateFile("site/explicit.json", 0644, "text/x-custom"))
+	s.assert.Nil(bb.CommitBlocks("site/committed.html", []string{}, nil, nil, "", ""))
+
+	f, err := os.CreateTemp("", "contenttype")
+	s.assert.Nil(err)
+	defer os.Remove(f.Name())
+	s.assert.Nil(bb.WriteFromFile(internal.CopyFromFileOptions{Name: "site/uploaded.json", File: f}))
+	s.assert.Nil(bb.WriteFromFile(internal.CopyFromFileOptions{Name: "site/typed.html", File: f, ContentType: "text/plain"}))
+
+	s.assert.Equal("application/json", contentTypes["site/data.json"])
+	s.assert.True(strings.HasPrefix(contentTypes["site/index.html"], "text/html"))
+	s.assert.Equal("application/octet-stream", contentTypes["site/blob.unknownext"])
+	s.assert.Equal("application/manifest+json", contentTypes["site/app.webmanifest"])
+	s.assert.Equal("text/x-custom", contentTypes["site/explicit.json"])
+	s.assert.True(strings.HasPrefix(contentTypes["site/committed.html"], "text/html"))
+	s.assert.Equal("application/json", contentTypes["site/uploaded.json"])
+	s.assert.Equal("text/plain", contentTypes["site/typed.html"])
+}
+
+func (s *azStorageTestSuite) TestFlushOverwriteAfterFlush() {
+	var lock sync.Mutex
+	var content []byte
+	staged := map[string][]byte{}
+	committed := map[string][]byte{}
+	stageCalls := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		q := r.URL.Query()
+		switch {
+		case r.Method == http.MethodHead:
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		case q.Get("comp") == "block":
+			staged[q.Get("blockid")] = body
+			stageCalls[q.Get("blockid")]++
+			w.WriteHeader(http.StatusCreated)
+		case q.Get("comp") == "blocklist":
+			var list struct {
+				Latest []string `xml:"Latest"`
+			}
+			_ = xml.Unmarshal(body, &list)
+			blocks := map[string][]byte{}
+			data := make([]byte, 0)
+			for _, id := range list.Latest {
+				blk, ok := staged[id]
+				if !ok {
+					blk, ok = committed[id]
+				}
+				if !ok {
+					w.Header().Set("x-ms-error-code", "InvalidBlockList")
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				blocks[id] = blk
+				data = append(data, blk...)
+			}
+			committed, staged, content = blocks, map[string][]byte{}, data
+			w.WriteHeader(http.StatusCreated)
+		default:
+			// Put Blob leaves the blob without committed blocks
+			committed, staged, content = map[string][]byte{}, map[string][]byte{}, body
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	newBlock := func(start int64, data string) *common.Block {
+		blk := &common.Block{Id: common.GetBlockID(common.BlockIDLength), StartIndex: start, EndIndex: start + int64(len(data)), Data: []byte(data)}
+		blk.Flags.Set(common.DirtyBlock)
+		return blk
+	}
+
+	// Write and flush a small file
+	bol := &common.BlockOffsetList{BlockList: []*common.Block{newBlock(0, "hello")}}
+	bol.Flags.Set(common.SmallFile)
+	err = bb.StageAndCommit("file", bol, 0)
+	s.assert.Nil(err)
+	s.assert.Equal([]byte("hello"), content)
+
+	// Append after the flush, the block uploaded with Put Blob is not committed so it is staged along
+	bol.BlockList = append(bol.BlockList, newBlock(5, "world"))
+	err = bb.StageAndCommit("file", bol, 0)
+	s.assert.Nil(err)
+	s.assert.Equal([]byte("helloworld"), content)
+	s.assert.False(bol.SmallFile())
+
+	// Overwrite part of the committed data, only the modified block is staged
+	blk := bol.BlockList[1]
+	copy(blk.Data[1:], "OR")
+	blk.Flags.Set(common.DirtyBlock)
+	err = bb.StageAndCommit("file", bol, 0)
+	s.assert.Nil(err)
+	s.assert.Equal([]byte("hellowORld"), content)
+	s.assert.Equal(1, stageCalls[bol.BlockList[0].Id])
+	s.assert.Equal(2, stageCalls[bol.BlockList[1].Id])
+
+	// Truncate to the first block, the removal is committed once
+	bol.BlockList = bol.BlockList[:1]
+	bol.BlockList[0].Flags.Set(common.RemovedBlocks)
+	err = bb.StageAndCommit("file", bol, 0)
+	s.assert.Nil(err)
+	s.assert.Equal([]byte("hello"), content)
+	s.assert.False(bol.BlockList[0].Removed())
+}
+
+func (s *azStorageTestSuite) TestImmutableContainerUpload() {
+	var lock sync.Mutex
+	policies := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		q := r.URL.Query()
+		switch {
+		case r.Method == http.MethodGet && q.Get("restype") == "container" && q.Get("comp") == "list":
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Blobs></Blobs><NextMarker/></EnumerationResults>`))
+		case r.Method == http.MethodGet && q.Get("restype") == "container":
+			w.Header().Set("x-ms-immutable-storage-with-versioning-enabled", "true")
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodHead:
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			if q.Get("comp") != "block" {
+				lock.Lock()
+				policies[strings.TrimPrefix(r.URL.Path, "/cont/")] = r.Header.Get("x-ms-immutability-policy-mode") + " " + r.Header.Get("x-ms-immutability-policy-until-date")
+				lock.Unlock()
+			}
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.Config.container = "cont"
+
+	// Without a configured policy the mount fails rather than every upload
+	err = bb.TestPipeline()
+	s.assert.NotNil(err)
+	s.assert.Contains(err.Error(), "immutability-period-days")
+	s.assert.False(bb.immutableContainer)
+
+	bb.Config.immutabilityPeriod = 2 * 24 * time.Hour
+	bb.Config.immutabilityMode = blob.ImmutabilityPolicySettingUnlocked
+	s.assert.Nil(bb.TestPipeline())
+	s.assert.True(bb.immutableContainer)
+
+	s.assert.Nil(bb.WriteFromBuffer("buffer", nil, []byte("data")))
+	s.assert.Nil(bb.CommitBlocks("committed", []string{}, nil, nil, "", ""))
+
+	lock.Lock()
+	defer lock.Unlock()
+	s.assert.Len(policies, 2)
+	for name, policy := range policies {
+		mode, until, _ := strings.Cut(policy, " ")
+		s.assert.Equal("Unlocked", mode, name)
+		expiry, err := time.Parse(time.RFC1123, until)
+		s.assert.Nil(err, name)
+		s.assert.WithinDuration(time.Now().Add(48*time.Hour), expiry, time.Minute, name)
+	}
+}
+
+// diskFullWriter : Writes to the file until limit bytes are written, then fails like a full disk
+type diskFullWriter struct {
+	lock    sync.Mutex
+	file    *os.File
+	limit   int64
+	written int64
+}
+
+func (w *diskFullWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.written+int64(len(p)) > w.limit {
+		return 0, &os.PathError{Op: "write", Path: w.file.Name(), Err: syscall.ENOSPC}
+	}
+	w.written += int64(len(p))
+	return w.file.WriteAt(p, off)
+}
+
+func (s *azStorageTestSuite) TestReadToFileDiskFull() {
+	size := 8 * int64(maxValidatedRange)
+	var gets atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gets.Add(1)
+		var start, end int64
+		_, _ = fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &start, &end)
+		w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(make([]byte, end-start+1))
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	// Download stops once a chunk can not be written instead of fetching the rest of the blob
+	f, err := os.CreateTemp("", "diskfull")
+	s.assert.Nil(err)
+	defer os.Remove(f.Name())
+	writer := &diskFullWriter{file: f, limit: 2 * maxValidatedRange}
+	err = bb.readRangeValidated("file", "", 0, size, writer, 1)
+	s.assert.True(isDiskFull(err))
+	s.assert.EqualValues(3, gets.Load())
+
+	// Partial file is removed by default and the download fails with ENOSPC
+	err = bb.discardPartialDownload("file", f, err)
+	s.assert.Equal(syscall.ENOSPC, err)
+	_, err = os.Stat(f.Name())
+	s.assert.True(os.IsNotExist(err))
+	f.Close()
+
+	// Partial file is left as it was when configured to keep it
+	f, err = os.CreateTemp("", "diskfull")
+	s.assert.Nil(err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	_, _ = f.Write([]byte("partial"))
+	bb.Config.keepPartialOnDiskFull = true
+	err = bb.discardPartialDownload("file", f, &os.PathError{Op: "write", Path: f.Name(), Err: syscall.ENOSPC})
+	s.assert.Equal(syscall.ENOSPC, err)
+	local, err := os.ReadFile(f.Name())
+	s.assert.Nil(err)
+	s.assert.Equal("partial", string(local))
+}
+
+func (s *azStorageTestSuite) TestLegalHoldAndImmutabilityPolicy() {
+	var lock sync.Mutex
+	legalHold := false
+	until, mode := "", ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		comp := r.URL.Query().Get("comp")
+		switch {
+		case r.Method == http.MethodPut && comp == "legalhold":
+			legalHold = r.Header.Get("x-ms-legal-hold") == "true"
+			w.Header().Set("x-ms-legal-hold", strconv.FormatBool(legalHold))
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPut && comp == "immutabilityPolicies":
+			until, mode = r.Header.Get("x-ms-immutability-policy-until-date"), r.Header.Get("x-ms-immutability-policy-mode")
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodDelete && comp == "immutabilityPolicies":
+			if mode == "Locked" {
+				w.Header().Set("x-ms-error-code", "ImmutabilityPolicyDeleteOnLockedPolicy")
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			until, mode = "", ""
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodDelete:
+			if legalHold {
+				w.Header().Set("x-ms-error-code", "BlobImmutableDueToLegalHold")
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodHead:
+			w.Header().Set("Content-Length", "0")
+			w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+			w.Header().Set("x-ms-legal-hold", strconv.FormatBool(legalHold))
+			if until != "" {
+				w.Header().Set("x-ms-immutability-policy-until-date", until)
+				w.Header().Set("x-ms-immutability-policy-mode", mode)
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	// Blob under legal hold reports it and can not be deleted
+	s.assert.Nil(bb.SetLegalHold("file", true))
+	attr, err := bb.GetAttr("file")
+	s.assert.Nil(err)
+	s.assert.Equal("true", *attr.Metadata[legalHoldKey])
+	s.assert.Equal(syscall.EPERM, bb.DeleteFile("file"))
+
+	// Released legal hold no longer blocks the delete
+	s.assert.Nil(bb.SetLegalHold("file", false))
+	attr, err = bb.GetAttr("file")
+	s.assert.Nil(err)
+	s.assert.Equal("false", *attr.Metadata[legalHoldKey])
+	s.assert.Nil(bb.DeleteFile("file"))
+
+	// Unlocked policy is reported and can be cleared
+	expiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.assert.Nil(bb.SetImmutabilityPolicy("file", expiry, "unlocked"))
+	attr, err = bb.GetAttr("file")
+	s.assert.Nil(err)
+	s.assert.Equal("2030-01-01T00:00:00Z", *attr.Metadata[immutableUntilKey])
+	s.assert.Equal("unlocked", *attr.Metadata[immutabilityModeKey])
+	s.assert.Nil(bb.ClearImmutabilityPolicy("file"))
+	attr, err = bb.GetAttr("file")
+	s.assert.Nil(err)
+	s.assert.NotContains(attr.Metadata, immutableUntilKey)
+
+	// Locked policy can not be cleared
+	s.assert.Nil(bb.SetImmutabilityPolicy("file", expiry, "locked"))
+	s.assert.Equal(syscall.EPERM, bb.ClearImmutabilityPolicy("file"))
+	s.assert.Equal(syscall.EINVAL, bb.SetImmutabilityPolicy("file", expiry, "mutable"))
+}
+
+func (s *azStorageTestSuite) TestUploadBlockBoundaries() {
+	var lock sync.Mutex
+	var content []byte
+	var committedBlocks int
+	staged := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		q := r.URL.Query()
+		switch {
+		case r.Method == http.MethodHead:
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		case q.Get("comp") == "block":
+			staged[q.Get("blockid")] = body
+			w.WriteHeader(http.StatusCreated)
+		case q.Get("comp") == "blocklist":
+			var list struct {
+				Latest []string `xml:"Latest"`
+			}
+			_ = xml.Unmarshal(body, &list)
+			content = make([]byte, 0)
+			for _, id := range list.Latest {
+				content = append(content, staged[id]...)
+			}
+			committedBlocks = len(list.Latest)
+			staged = map[string][]byte{}
+			w.WriteHeader(http.StatusCreated)
+		default:
+			content, committedBlocks = body, 0
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	const blockSize = 100
+	defer func(old int64) { singleUploadMaxBytes = old }(singleUploadMaxBytes)
+	singleUploadMaxBytes = 10
+
+	uploads := map[string]func(bb *BlockBlob, data []byte) error{
+		"file": func(bb *BlockBlob, data []byte) error {
+			f, err := os.CreateTemp("", "boundary")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+			if _, err = f.Write(data); err != nil {
+				return err
+			}
+			return bb.WriteFromFile(internal.CopyFromFileOptions{Name: "file", File: f})
+		},
+		"buffer": func(bb *BlockBlob, data []byte) error {
+			return bb.WriteFromBuffer("file", nil, data)
+		},
+	}
+
+	for _, streaming := range []bool{false, true} {
+		for name, upload := range uploads {
+			for _, size := range []int{blockSize, 2 * blockSize, blockSize + 1} {
+				bb, err := newTreeBlockBlob(srv)
+				s.assert.Nil(err)
+				bb.Config.blockSize = blockSize
+				bb.Config.maxConcurrency = 4
+				bb.Config.streamingWrite = streaming
+				// Buffers are staged here only when md5 is validated, the sdk uploads them otherwise
+				bb.Config.validateMD5 = true
+
+				data := make([]byte, size)
+				_, _ = rand.Read(data)
+				s.assert.Nil(upload(bb, data), name, size)
+
+				lock.Lock()
+				s.assert.Equal(data, content, "%s of %d bytes, streaming %t", name, size, streaming)
+				s.assert.Equal((size+blockSize-1)/blockSize, committedBlocks, "%s of %d bytes, streaming %t", name, size, streaming)
+				lock.Unlock()
+			}
+		}
+	}
+}
+
+func (s *azStorageTestSuite) TestReadToFileRanges() {
+	const blockSize = 100
+	for _, size := range []int{blockSize, 10*blockSize + 50, 3*blockSize - 1} {
+		content := make([]byte, size)
+		_, _ = rand.Read(content)
+		var ranges atomic.Int32
+		srv := newRangeServer(content, 0, &ranges)
+
+		bb, err := newTreeBlockBlob(srv)
+		s.assert.Nil(err)
+		bb.Config.maxConcurrency = 4
+		bb.downloadOptions = &blob.DownloadFileOptions{BlockSize: blockSize, Concurrency: 4}
+
+		f, err := os.CreateTemp("", "ranges")
+		s.assert.Nil(err)
+		err = bb.ReadToFile(internal.CopyToFileOptions{Name: "file", File: f})
+		s.assert.Nil(err, size)
+
+		local, _ := os.ReadFile(f.Name())
+		s.assert.Equal(content, local, size)
+		s.assert.EqualValues((size+blockSize-1)/blockSize, ranges.Load(), size)
+
+		f.Close()
+		os.Remove(f.Name())
+		srv.Close()
+	}
+}
+
+func (s *azStorageTestSuite) TestTestPipelineTokenFileUnreadable() {
+	bb := &BlockBlob{}
+	bb.Config.authConfig.AuthMode = EAuthType.WORKLOADIDENTITY()
+	bb.Config.authConfig.TokenFilePath = filepath.Join(s.T().TempDir(), "missing")
+
+	err := bb.TestPipeline()
+	s.assert.NotNil(err)
+	s.assert.Contains(err.Error(), "token-file-path")
+
+	s.assert.Nil(os.WriteFile(bb.Config.authConfig.TokenFilePath, []byte(""), 0600))
+	err = bb.TestPipeline()
+	s.assert.NotNil(err)
+	s.assert.Contains(err.Error(), "is empty")
+}
+
+func (s *azStorageTestSuite) TestReadBufferOverMaxBufferBytes() {
+	bb := &BlockBlob{}
+	bb.Config.maxBufferBytes = 1024
+
+	buff, err := bb.ReadBuffer("file", 0, 2048)
+	s.assert.NotNil(err)
+	s.assert.Equal(syscall.EINVAL, err)
+	s.assert.Nil(buff)
+}
+
+func (s *azStorageTestSuite) TestCheckBlockLimit() {
+	bb := &BlockBlob{}
+	blockSize := int64(1 * MB)
+
+	// Fits within the block limit
+	size, err := bb.checkBlockLimit("file", blockSize*blockblob.MaxBlocks, blockSize)
+	s.assert.Nil(err)
+	s.assert.Equal(blockSize, size)
+
+	// Needs more than 50,000 blocks, block size is increased
+	fileSize := 100 * 1024 * int64(MB)
+	size, err = bb.checkBlockLimit("file", fileSize, blockSize)
+	s.assert.Nil(err)
+	s.assert.Greater(size, blockSize)
+	s.assert.LessOrEqual(fileSize, size*blockblob.MaxBlocks)
+
+	// Strict block size fails early
+	bb.Config.strictBlockSize = true
+	_, err = bb.checkBlockLimit("file", fileSize, blockSize)
+	s.assert.Equal(syscall.EFBIG, err)
+
+	// Beyond max blob size can never be uploaded
+	bb.Config.strictBlockSize = false
+	_, err = bb.checkBlockLimit("file", MaxBlobSize+1, blockSize)
+	s.assert.Equal(syscall.EFBIG, err)
+}
+
+// Deep scan of a 100k object prefix (1000 directories of 100 files), walking the tree with hierarchical listing
+// against a single flat enumeration: go test -run NONE -bench BenchmarkList ./component/azstorage/
+func benchmarkTree() []string {
+	names := make([]string, 0, 1000*101)
+	for d := 0; d < 1000; d++ {
+		dir := fmt.Sprintf("data/d%04d", d)
+		names = append(names, dir+"/")
+		for f := 0; f < 100; f++ {
+			names = append(names, fmt.Sprintf("%s/f%04d", dir, f))
+		}
+	}
+	return names
+}
+
+func BenchmarkListHierarchical(b *testing.B) {
+	srv := newTreeServer(benchmarkTree())
+	defer srv.Close()
+	bb, _ := newTreeBlockBlob(srv)
+
+	var walk func(prefix string) int
+	walk = func(prefix string) int {
+		total := 0
+		var marker *string
+		for {
+			list, next, err := bb.List(prefix, marker, common.MaxDirListCount)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, attr := range list {
+				total++
+				if attr.IsDir() {
+					total += walk(attr.Path + "/")
+				}
+			}
+			if next == nil || *next == "" {
+				return total
+			}
+			marker = next
+		}
+	}
+
+	for i := 0; i < b.N; i++ {
+		if total := walk("data/"); total != 1000*101 {
+			b.Fatalf("listed %d objects", total)
+		}
+	}
+}
+
+func BenchmarkListFlat(b *testing.B) {
+	srv := newTreeServer(benchmarkTree())
+	defer srv.Close()
+	bb, _ := newTreeBlockBlob(srv)
+
+	for i := 0; i < b.N; i++ {
+		total := 0
+		var marker *string
+		for {
+			list, next, err := bb.ListFlat("data/", marker, common.MaxDirListCount)
+			if err != nil {
+				b.Fatal(err)
+			}
+			total += len(list)
+			if next == nil || *next == "" {
+				break
+			}
+			marker = next
+		}
+		if total != 1000*101 {
+			b.Fatalf("listed %d objects", total)
+		}
+	}
+}
+
+// Flush of a 1 GiB file made of 4 MiB dirty blocks, every staged block costs a round trip to the server
+func BenchmarkStageAndCommit(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Query().Get("comp") == "block":
+			_, _ = io.Copy(io.Discard, r.Body)
+			time.Sleep(5 * time.Millisecond)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			_, _ = io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	if err != nil {
+		b.Fatal(err)
+	}
+	bb.Config.maxConcurrency = 32
+
+	const blockSize = 4 * common.MbToBytes
+	data := make([]byte, blockSize)
+
+	for _, concurrency := range []uint16{1, 32} {
+		b.Run(strconv.Itoa(int(concurrency)), func(b *testing.B) {
+			b.SetBytes(1024 * common.MbToBytes)
+			for i := 0; i < b.N; i++ {
+				bol := &common.BlockOffsetList{}
+				for j := int64(0); j < 256; j++ {
+					blk := &common.Block{
+						StartIndex: j * blockSize,
+						EndIndex:   (j + 1) * blockSize,
+						Id:         common.GetBlockID(common.BlockIDLength),
+						Data:       data,
+					}
+					blk.Flags.Set(common.DirtyBlock)
+					bol.BlockList = append(bol.BlockList, blk)
+				}
+
+				if err := bb.StageAndCommit("file", bol, concurrency); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Download of a 64 MiB blob in 1 MiB ranges, every range costs a round trip to the server
+func BenchmarkReadToFile(b *testing.B) {
+	_ = log.SetDefaultLogger("silent", common.LogConfig{})
+	content := make([]byte, 64*common.MbToBytes)
+	var ranges atomic.Int32
+	srv := newRangeServer(content, 5*time.Millisecond, &ranges)
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	if err != nil {
+		b.Fatal(err)
+	}
+	bb.Config.maxConcurrency = 32
+	bb.downloadOptions = &blob.DownloadFileOptions{BlockSize: common.MbToBytes}
+
+	f, err := os.CreateTemp("", "benchmark")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	for _, concurrency := range []uint16{1, 32} {
+		b.Run(strconv.Itoa(int(concurrency)), func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+			for i := 0; i < b.N; i++ {
+				err := bb.ReadToFile(internal.CopyToFileOptions{Name: "file", File: f, Concurrency: concurrency})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func (s *azStorageTestSuite) TestReadInBufferBeyondEndOfBlob() {
+	var ranges atomic.Int32
+	srv := newRangeServer([]byte("hello"), 0, &ranges)
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	// Region past the end of blob reads as zeros, buffer beyond the requested length is not touched
+	data := bytes.Repeat([]byte{0xff}, 16)
+	err = bb.ReadInBuffer("a", 0, 10, data, nil)
+	s.assert.Nil(err)
+	s.assert.Equal([]byte("hello"), data[:5])
+	s.assert.Equal(make([]byte, 5), data[5:10])
+	s.assert.Equal(bytes.Repeat([]byte{0xff}, 6), data[10:])
+
+	// Requested length larger than the buffer is bounded by the buffer
+	data = bytes.Repeat([]byte{0xff}, 8)
+	err = bb.ReadInBuffer("a", 0, 10, data, nil)
+	s.assert.Nil(err)
+	s.assert.Equal([]byte("hello"), data[:5])
+	s.assert.Equal(make([]byte, 3), data[5:])
+}
+
+func (s *azStorageTestSuite) TestFlushArchiveTier() {
+	var lock sync.Mutex
+	requests := make([]string, 0)
+	headStatus := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Query().Get("comp"))
+		if r.Method == http.MethodHead {
+			w.Header().Set("x-ms-access-tier", "Archive")
+			w.WriteHeader(headStatus)
+			return
+		}
+		w.Header().Set("x-ms-error-code", "BlobArchived")
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	newList := func() *common.BlockOffsetList {
+		bol := &common.BlockOffsetList{}
+		for i := int64(0); i < 2; i++ {
+			blk := &common.Block{Id: common.GetBlockID(common.BlockIDLength), StartIndex: i * 5, EndIndex: (i + 1) * 5, Data: []byte("hello")}
+			blk.Flags.Set(common.DirtyBlock)
+			bol.BlockList = append(bol.BlockList, blk)
+		}
+		return bol
+	}
+
+	// Tier is not looked up by default, the rejected stage is reported as EPERM
+	err = bb.StageAndCommit("file", newList(), 0)
+	s.assert.Equal(syscall.EPERM, err)
+	s.assert.NotContains(requests, "HEAD ")
+
+	// With check-archive-tier nothing is staged for an archived blob
+	bb.Config.checkArchiveTier = true
+	requests = requests[:0]
+	err = bb.StageAndCommit("file", newList(), 0)
+	s.assert.Equal(syscall.EPERM, err)
+	s.assert.Equal([]string{"HEAD "}, requests)
+
+	// Failure to look up the tier is not ignored
+	headStatus = http.StatusInternalServerError
+	requests = requests[:0]
+	err = bb.StageAndCommit("file", newList(), 0)
+	s.assert.NotNil(err)
+	s.assert.NotEqual(syscall.EPERM, err)
+	s.assert.Equal([]string{"HEAD "}, requests)
+}
+
+func (s *azStorageTestSuite) TestRenameFileWithCPK() {
+	key := "a2V5"
+	content := []byte("encrypted data")
+	var lock sync.Mutex
+	var requests []string
+	var body []byte
+	var metadata string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("x-ms-encryption-key"))
+		if r.Method != http.MethodDelete && r.Header.Get("x-ms-encryption-key") != key {
+			// Service can not decrypt the object without the key it was written with
+			w.Header().Set("x-ms-error-code", "BlobUsesCustomerSpecifiedEncryption")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("ETag", "\"etag\"")
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Header().Set("x-ms-meta-owner", "me")
+			w.WriteHeader(http.StatusOK)
+		case http.MethodGet:
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(content)
+		case http.MethodPut:
+			if r.URL.Query().Get("comp") == "block" {
+				body = append(body, data...)
+			} else if r.URL.Query().Get("comp") == "blocklist" {
+				metadata = r.Header.Get("x-ms-meta-owner")
+			} else {
+				body = data
+				metadata = r.Header.Get("x-ms-meta-owner")
+			}
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	bb.blobCPKOpt = &blob.CPKInfo{
+		EncryptionKey:       to.Ptr(key),
+		EncryptionKeySHA256: to.Ptr("sha"),
+		EncryptionAlgorithm: to.Ptr(blob.EncryptionAlgorithmTypeAES256),
+	}
+
+	// Source is read and target written with the configured key, no server side copy is started
+	attr := &internal.ObjAttr{}
+	err = bb.RenameFile("src", "dst", attr)
+	s.assert.Nil(err)
+	s.assert.Equal(content, body)
+	s.assert.Equal("me", metadata)
+	s.assert.Equal("etag", attr.ETag)
+	for _, req := range requests {
+		if !strings.HasPrefix(req, http.MethodDelete) {
+			s.assert.True(strings.HasSuffix(req, " "+key), req)
+		}
+	}
+	s.assert.Contains(requests, "HEAD /cont/src "+key)
+	s.assert.Contains(requests, "GET /cont/src "+key)
+	s.assert.Contains(requests, "DELETE /cont/src ")
+
+	// Key which differs from the one the source was written with is a permission error, source is kept
+	requests = requests[:0]
+	bb.blobCPKOpt.EncryptionKey = to.Ptr("b3RoZXI=")
+	err = bb.RenameFile("src", "dst", attr)
+	s.assert.Equal(syscall.EACCES, err)
+	s.assert.Equal([]string{"HEAD /cont/src b3RoZXI="}, requests)
+}
+
+func (s *azStorageTestSuite) TestWriteFetchesOnlyOverlappingBlocks() {
+	content := []byte("testdatates1dat1tes2dat2tes3dat3tes4dat4")
+	var lock sync.Mutex
+	var ranges []string
+	var staged []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("ETag", `"etag"`)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("comp") == "blocklist":
+			// Blob is made of ten committed blocks of 4 bytes each
+			list := `<?xml version="1.0" encoding="utf-8"?><BlockList><CommittedBlocks>`
+			for i := 0; i < len(content)/4; i++ {
+				id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%02d", i)))
+				list += fmt.Sprintf("<Block><Name>%s</Name><Size>4</Size></Block>", id)
+			}
+			list += `</CommittedBlocks><UncommittedBlocks/></BlockList>`
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(list))
+		case r.Method == http.MethodGet:
+			ranges = append(ranges, r.Header.Get("x-ms-range"))
+			var start, end int
+			_, _ = fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &start, &end)
+			w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(content[start : end+1])
+		case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "block":
+			staged = append(staged, string(data))
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+
+	// Write of 4 bytes at offset 14 straddles the blocks [12,16) and [16,20), only those are downloaded and staged again
+	err = bb.Write(internal.WriteFileOptions{Handle: handlemap.NewHandle("file"), Offset: 14, Data: []byte("cake")})
+	s.assert.Nil(err)
+	s.assert.Equal([]string{"bytes=12-19"}, ranges)
+	s.assert.ElementsMatch([]string{"daca", "kes2"}, staged)
+
+	// Write ending on a block boundary does not touch the block starting there
+	ranges, staged = ranges[:0], staged[:0]
+	err = bb.Write(internal.WriteFileOptions{Handle: handlemap.NewHandle("file"), Offset: 12, Data: []byte("cakecake")})
+	s.assert.Nil(err)
+	s.assert.Equal([]string{"bytes=12-19"}, ranges)
+	s.assert.ElementsMatch([]string{"cake", "cake"}, staged)
+}
+
+func (s *azStorageTestSuite) TestCreateImmutableProtectionWithUpload() {
+	var lock sync.Mutex
+	var requests []string
+	var holds, policies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Query().Get("comp"))
+		if r.URL.Query().Get("comp") != "block" {
+			holds = append(holds, r.Header.Get("x-ms-legal-hold"))
+			policies = append(policies, r.Header.Get("x-ms-immutability-policy-until-date"))
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	expiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Small blob carries legal hold and policy on the single upload, nothing is set afterwards
+	err = bb.CreateImmutable("small", []byte("data"), true, &expiry)
+	s.assert.Nil(err)
+	s.assert.Equal([]string{"PUT "}, requests)
+	s.assert.Equal([]string{"true"}, holds)
+	s.assert.Equal([]string{expiry.Format(http.TimeFormat)}, policies)
+
+	// Larger blob is staged and carries them on the commit
+	defer func(old int64) { singleUploadMaxBytes = old }(singleUploadMaxBytes)
+	singleUploadMaxBytes = 4
+	bb.Config.blockSize = 4
+	requests, holds, policies = requests[:0], holds[:0], policies[:0]
+	err = bb.CreateImmutable("large", []byte("datadata"), true, &expiry)
+	s.assert.Nil(err)
+	s.assert.ElementsMatch([]string{"PUT block", "PUT block", "PUT blocklist"}, requests)
+	s.assert.Equal([]string{"true"}, holds)
+	s.assert.Equal([]string{expiry.Format(http.TimeFormat)}, policies)
+}
+
 // In order for 'go test' to run this suite, we need to create
 // a normal test function and pass our suite to suite.Run
 func TestBlockBlob(t *testing.T) {
@@ -36,10 +36,15 @@ package azstorage
 import (
 	"errors"
 	"fmt"
+	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/config"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
 	"github.com/vibhansa-msft/blobfilter"
@@ -124,6 +129,37 @@ func (a *AccountType) Parse(s string) error {
 // default value for maximum results returned by a list API call
 const DefaultMaxResultsForList int32 = 2
 
+// default duration for which result of a health check is cached
+const DefaultHealthCheckInterval = 30 * time.Second
+
+// Longest a ping waits for the service when the caller does not set a deadline
+const defaultPingTimeout = 5 * time.Second
+
+// default number of times a download resumes the remaining range after the stream breaks midway
+const DefaultReadStreamRetries = 3
+
+// Sources of mtime, in order, for blobs which do not report a last modified time
+const (
+	MtimeFallbackCreationTime = "creation-time"
+	MtimeFallbackNow          = "now"
+	MtimeFallbackNone         = "none"
+)
+
+var DefaultMtimeFallback = []string{MtimeFallbackCreationTime, MtimeFallbackNow}
+
+// Encodings of a symlink written by CreateLink, links in any of them are detected on read
+const (
+	SymlinkFormatMetadata    = "metadata"     // is_symlink metadata flag, target in the content
+	SymlinkFormatSuffix      = "suffix"       // target in the content of a blob named <link>.symlink
+	SymlinkFormatContentOnly = "content-only" // target in the content, inode/symlink content type and no metadata
+)
+
+// Smallest block size used for uploads unless allow-tiny-blocks is set, smaller blocks only multiply the number of requests
+const MinBlockSize = 64 * 1024
+
+// default permissions for a blob whose mode in metadata can not be parsed, with store-unix-permissions
+const DefaultUnixMode = 0644
+
 // Environment variable names
 // Here we are not reading MSI_ENDPOINT and MSI_SECRET as they are read by go-sdk directly
 // https://github.com/Azure/go-autorest/blob/a46566dfcbdc41e736295f94e9f690ceaf50094a/autorest/adal/token.go#L788
@@ -141,6 +177,8 @@ const (
 	EnvAzStorageSpnClientSecret          = "AZURE_STORAGE_SPN_CLIENT_SECRET"
 	EnvAzStorageSpnOAuthTokenFilePath    = "AZURE_OAUTH_TOKEN_FILE"
 	EnvAzStorageSpnWorkloadIdentityToken = "WORKLOAD_IDENTITY_TOKEN"
+	EnvAzStorageFederatedTokenFile       = "AZURE_FEDERATED_TOKEN_FILE"
+	EnvAzStorageTokenCachePassphrase     = "BLOBFUSE2_TOKEN_CACHE_PASSPHRASE"
 	EnvAzStorageAadEndpoint              = "AZURE_STORAGE_AAD_ENDPOINT"
 	EnvAzStorageAuthType                 = "AZURE_STORAGE_AUTH_TYPE"
 	EnvAzStorageBlobEndpoint             = "AZURE_STORAGE_BLOB_ENDPOINT"
@@ -157,6 +195,7 @@ type AzStorageOptions struct {
 	AccountName             string `config:"account-name" yaml:"account-name,omitempty"`
 	AccountKey              string `config:"account-key" yaml:"account-key,omitempty"`
 	SaSKey                  string `config:"sas" yaml:"sas,omitempty"`
+	SasRenewCommand         string `config:"sas-renew-command" yaml:"sas-renew-command,omitempty"`
 	ApplicationID           string `config:"appid" yaml:"appid,omitempty"`
 	ResourceID              string `config:"resid" yaml:"resid,omitempty"`
 	ObjectID                string `config:"objid" yaml:"objid,omitempty"`
@@ -165,6 +204,11 @@ type AzStorageOptions struct {
 	ClientSecret            string `config:"clientsecret" yaml:"clientsecret,omitempty"`
 	OAuthTokenFilePath      string `config:"oauth-token-path" yaml:"oauth-token-path,omitempty"`
 	WorkloadIdentityToken   string `config:"workload-identity-token" yaml:"workload-identity-token,omitempty"`
+	TokenFilePath           string `config:"token-file-path" yaml:"token-file-path,omitempty"`
+	ClientCertPath          string `config:"client-cert-path" yaml:"client-cert-path,omitempty"`
+	ClientCertPassword      string `config:"client-cert-password" yaml:"client-cert-password,omitempty"`
+	TokenCachePath          string `config:"token-cache-path" yaml:"token-cache-path,omitempty"`
+	TokenCachePassphrase    string `config:"token-cache-passphrase" yaml:"token-cache-passphrase,omitempty"`
 	ActiveDirectoryEndpoint string `config:"aadendpoint" yaml:"aadendpoint,omitempty"`
 	Endpoint                string `config:"endpoint" yaml:"endpoint,omitempty"`
 	AuthMode                string `config:"mode" yaml:"mode,omitempty"`
@@ -178,23 +222,69 @@ type AzStorageOptions struct {
 	MaxTimeout              int32  `config:"max-retry-timeout-sec" yaml:"max-retry-timeout-sec,omitempty"`
 	BackoffTime             int32  `config:"retry-backoff-sec" yaml:"retry-backoff-sec,omitempty"`
 	MaxRetryDelay           int32  `config:"max-retry-delay-sec" yaml:"max-retry-delay-sec,omitempty"`
+	RetryJitter             bool   `config:"retry-jitter" yaml:"retry-jitter,omitempty"`
+	RetryOnStatus           string `config:"retry-on-status" yaml:"retry-on-status,omitempty"`
 	HttpProxyAddress        string `config:"http-proxy" yaml:"http-proxy,omitempty"`
 	HttpsProxyAddress       string `config:"https-proxy" yaml:"https-proxy,omitempty"`
 	FailUnsupportedOp       bool   `config:"fail-unsupported-op" yaml:"fail-unsupported-op,omitempty"`
 	AuthResourceString      string `config:"auth-resource" yaml:"auth-resource,omitempty"`
 	UpdateMD5               bool   `config:"update-md5" yaml:"update-md5"`
 	ValidateMD5             bool   `config:"validate-md5" yaml:"validate-md5"`
+	ValidateCRC64           bool   `config:"validate-crc64" yaml:"validate-crc64,omitempty"`
 	VirtualDirectory        bool   `config:"virtual-directory" yaml:"virtual-directory"`
 	MaxResultsForList       int32  `config:"max-results-for-list" yaml:"max-results-for-list"`
 	DisableCompression      bool   `config:"disable-compression" yaml:"disable-compression"`
 	Telemetry               string `config:"telemetry" yaml:"telemetry"`
 	HonourACL               bool   `config:"honour-acl" yaml:"honour-acl"`
+	ACLSingleCall           bool   `config:"acl-single-call" yaml:"acl-single-call,omitempty"`
 	CPKEnabled              bool   `config:"cpk-enabled" yaml:"cpk-enabled"`
 	CPKEncryptionKey        string `config:"cpk-encryption-key" yaml:"cpk-encryption-key"`
 	CPKEncryptionKeySha256  string `config:"cpk-encryption-key-sha256" yaml:"cpk-encryption-key-sha256"`
 	PreserveACL             bool   `config:"preserve-acl" yaml:"preserve-acl"`
 	Filter                  string `config:"filter" yaml:"filter"`
 	UserAssertion           string `config:"user-assertion" yaml:"user-assertions"`
+	HealthCheckInterval     uint32 `config:"health-check-interval-sec" yaml:"health-check-interval-sec,omitempty"`
+	MaxBufferBytes          int64  `config:"max-buffer-bytes" yaml:"max-buffer-bytes,omitempty"`
+	ReadStreamRetries       int32  `config:"read-stream-retries" yaml:"read-stream-retries,omitempty"`
+	BlockIDLength           int64  `config:"block-id-length" yaml:"block-id-length,omitempty"`
+	MtimeFallback           string `config:"mtime-fallback" yaml:"mtime-fallback,omitempty"`
+	SymlinkFormat           string `config:"symlink-format" yaml:"symlink-format,omitempty"`
+	XAttrNames              string `config:"xattr-names" yaml:"xattr-names,omitempty"`
+	DirContentType          string `config:"directory-content-type" yaml:"directory-content-type,omitempty"`
+	ContentTypeDetection    bool   `config:"content-type-detection" yaml:"content-type-detection,omitempty"`
+	StreamingWrite          bool   `config:"streaming-write" yaml:"streaming-write,omitempty"`
+	StoreUnixPermissions    bool   `config:"store-unix-permissions" yaml:"store-unix-permissions,omitempty"`
+	DefaultUnixMode         string `config:"default-unix-mode" yaml:"default-unix-mode,omitempty"`
+	AttrTimeout             int32  `config:"attr-timeout-sec" yaml:"attr-timeout-sec,omitempty"`
+	ReadTimeout             int32  `config:"read-timeout-sec" yaml:"read-timeout-sec,omitempty"`
+	WriteTimeout            int32  `config:"write-timeout-sec" yaml:"write-timeout-sec,omitempty"`
+	ReportCopyStatus        bool   `config:"report-copy-status" yaml:"report-copy-status,omitempty"`
+	FlushDeleted            string `config:"flush-deleted" yaml:"flush-deleted,omitempty"`
+	DeletedDirListing       string `config:"deleted-dir-listing" yaml:"deleted-dir-listing,omitempty"`
+	ImmutabilityPeriodDays  int32  `config:"immutability-period-days" yaml:"immutability-period-days,omitempty"`
+	ImmutabilityMode        string `config:"immutability-mode" yaml:"immutability-mode,omitempty"`
+	MaxPathDepth            int32  `config:"max-path-depth" yaml:"max-path-depth,omitempty"`
+	DiskFullAction          string `config:"disk-full-action" yaml:"disk-full-action,omitempty"`
+	AttrCacheTTL            uint32 `config:"attr-cache-ttl-sec" yaml:"attr-cache-ttl-sec,omitempty"`
+	ReadAheadKB             uint32 `config:"read-ahead-kb" yaml:"read-ahead-kb,omitempty"`
+	ListDirMarker           bool   `config:"list-dir-marker" yaml:"list-dir-marker,omitempty"`
+	StrictBlockSize         bool   `config:"strict-block-size" yaml:"strict-block-size,omitempty"`
+	AllowTinyBlocks         bool   `config:"allow-tiny-blocks" yaml:"allow-tiny-blocks,omitempty"`
+	ListDeleted             bool   `config:"list-deleted" yaml:"list-deleted,omitempty"`
+	RejectArchiveTier       bool   `config:"reject-archive-tier" yaml:"reject-archive-tier,omitempty"`
+	CheckArchiveTier        bool   `config:"check-archive-tier" yaml:"check-archive-tier,omitempty"`
+	DeleteDirBestEffort     bool   `config:"delete-dir-best-effort" yaml:"delete-dir-best-effort,omitempty"`
+	FailoverEndpoint        string `config:"failover-endpoint" yaml:"failover-endpoint,omitempty"`
+	FailoverContainer       string `config:"failover-container" yaml:"failover-container,omitempty"`
+	FailoverMode            string `config:"failover-mode" yaml:"failover-mode,omitempty"`
+	FailoverThreshold       uint32 `config:"failover-threshold" yaml:"failover-threshold,omitempty"`
+	FailoverCooldown        uint32 `config:"failover-cooldown-sec" yaml:"failover-cooldown-sec,omitempty"`
+
+	// Log level per operation, keyed on method name
+	OperationLogLevel map[string]string `config:"operation-log-level" yaml:"operation-log-level,omitempty"`
+
+	// Content type per file extension, overrides the detected one
+	ContentTypeMap map[string]string `config:"content-type-map" yaml:"content-type-map,omitempty"`
 
 	// v1 support
 	UseAdls        bool   `config:"use-adls" yaml:"-"`
@@ -220,6 +310,8 @@ func RegisterEnvVariables() {
 	config.BindEnv("azstorage.clientsecret", EnvAzStorageSpnClientSecret)
 	config.BindEnv("azstorage.oauth-token-path", EnvAzStorageSpnOAuthTokenFilePath)
 	config.BindEnv("azstorage.workload-identity-token", EnvAzStorageSpnWorkloadIdentityToken)
+	config.BindEnv("azstorage.token-file-path", EnvAzStorageFederatedTokenFile)
+	config.BindEnv("azstorage.token-cache-passphrase", EnvAzStorageTokenCachePassphrase)
 
 	config.BindEnv("azstorage.objid", EnvAzStorageIdentityObjectId)
 
@@ -269,14 +361,10 @@ func formatEndpointProtocol(endpoint string, http bool) string {
 func formatEndpointAccountType(endpoint string, account AccountType) string {
 	// TODO : Modify this method when file share support is merged
 	correctedEndpoint := endpoint
-	if strings.Contains(correctedEndpoint, ".blob.") {
-		if account == EAccountType.ADLS() {
-			correctedEndpoint = strings.Replace(correctedEndpoint, ".blob.", ".dfs.", 1)
-		}
-	} else if strings.Contains(correctedEndpoint, ".dfs.") {
-		if account == EAccountType.BLOCK() {
-			correctedEndpoint = strings.Replace(correctedEndpoint, ".dfs.", ".blob.", 1)
-		}
+	if account == EAccountType.ADLS() {
+		correctedEndpoint, _ = swapEndpointService(correctedEndpoint, "blob", "dfs")
+	} else if account == EAccountType.BLOCK() {
+		correctedEndpoint, _ = swapEndpointService(correctedEndpoint, "dfs", "blob")
 	}
 
 	return correctedEndpoint
@@ -343,6 +431,187 @@ func ParseAndValidateConfig(az *AzStorage, opt AzStorageOptions) error {
 		}
 		az.stConfig.blockSize = opt.BlockSize * 1024 * 1024
 	}
+	az.stConfig.allowTinyBlocks = opt.AllowTinyBlocks
+	az.stConfig.blockSize = clampBlockSize(az.stConfig.blockSize, az.stConfig.allowTinyBlocks)
+
+	if opt.MaxBufferBytes < 0 {
+		log.Err("ParseAndValidateConfig : max-buffer-bytes can not be negative")
+		return errors.New("invalid max-buffer-bytes")
+	}
+	az.stConfig.maxBufferBytes = opt.MaxBufferBytes
+
+	if opt.ReadStreamRetries < 0 {
+		log.Err("ParseAndValidateConfig : read-stream-retries can not be negative")
+		return errors.New("invalid read-stream-retries")
+	} else if opt.ReadStreamRetries > 0 {
+		az.stConfig.readStreamRetries = opt.ReadStreamRetries
+	} else {
+		az.stConfig.readStreamRetries = DefaultReadStreamRetries
+	}
+
+	// Comma separated extended attributes persisted in blob metadata, each under its own prefixed key
+	az.stConfig.xattrKeys = make(map[string]string)
+	keys := make(map[string]string)
+	for _, attr := range strings.Split(opt.XAttrNames, ",") {
+		attr = strings.TrimSpace(attr)
+		if attr == "" {
+			continue
+		}
+		key := xattrMetadataKey(attr)
+		if other, found := keys[key]; found && other != attr {
+			log.Err("ParseAndValidateConfig : Extended attributes %s and %s map to same metadata key %s", other, attr, key)
+			return fmt.Errorf("invalid xattr-names, %s and %s map to same metadata key", other, attr)
+		}
+		keys[key] = attr
+		az.stConfig.xattrKeys[attr] = key
+	}
+
+	switch opt.SymlinkFormat {
+	case "", SymlinkFormatMetadata:
+		az.stConfig.symlinkFormat = SymlinkFormatMetadata
+	case SymlinkFormatSuffix, SymlinkFormatContentOnly:
+		az.stConfig.symlinkFormat = opt.SymlinkFormat
+	default:
+		log.Err("ParseAndValidateConfig : Invalid symlink-format %s, supported values are metadata, suffix and content-only", opt.SymlinkFormat)
+		return errors.New("invalid symlink-format")
+	}
+
+	// Comma separated order of sources used for mtime when a blob has no last modified time
+	az.stConfig.mtimeFallback = DefaultMtimeFallback
+	if opt.MtimeFallback == MtimeFallbackNone {
+		az.stConfig.mtimeFallback = []string{}
+	} else if opt.MtimeFallback != "" {
+		az.stConfig.mtimeFallback = []string{}
+		for _, source := range strings.Split(opt.MtimeFallback, ",") {
+			source = strings.ToLower(strings.TrimSpace(source))
+			if source != MtimeFallbackCreationTime && source != MtimeFallbackNow {
+				log.Err("ParseAndValidateConfig : Invalid mtime-fallback source %s", source)
+				return errors.New("invalid mtime-fallback")
+			}
+			az.stConfig.mtimeFallback = append(az.stConfig.mtimeFallback, source)
+		}
+	}
+
+	az.stConfig.streamingWrite = opt.StreamingWrite
+
+	// Content type of directory markers, also used to detect markers created by other tools
+	az.stConfig.dirContentType = strings.TrimSpace(opt.DirContentType)
+	if az.stConfig.dirContentType != "" && !strings.Contains(az.stConfig.dirContentType, "/") {
+		log.Err("ParseAndValidateConfig : Invalid directory-content-type %s", opt.DirContentType)
+		return errors.New("invalid directory-content-type")
+	}
+
+	// Content type of uploads detected from the extension, configured map takes precedence over the system one
+	az.stConfig.contentTypeDetection = opt.ContentTypeDetection
+	az.stConfig.contentTypeMap = make(map[string]string, len(opt.ContentTypeMap))
+	for ext, value := range opt.ContentTypeMap {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			log.Err("ParseAndValidateConfig : Invalid content type %s for extension %s", value, ext)
+			return fmt.Errorf("invalid content-type-map entry %s for %s", value, ext)
+		}
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		az.stConfig.contentTypeMap[ext] = value
+	}
+
+	// Mode of block blobs saved in metadata, parse failures fall back to default-unix-mode
+	az.stConfig.storeUnixPermissions = opt.StoreUnixPermissions
+	az.stConfig.defaultUnixMode = DefaultUnixMode
+	if opt.DefaultUnixMode != "" {
+		mode, err := strconv.ParseUint(opt.DefaultUnixMode, 8, 32)
+		if err != nil || mode > 0o777 {
+			log.Err("ParseAndValidateConfig : Invalid default-unix-mode %s", opt.DefaultUnixMode)
+			return errors.New("invalid default-unix-mode")
+		}
+		az.stConfig.defaultUnixMode = os.FileMode(mode)
+	}
+
+	// Deadline of a whole operation including its retries, 0 keeps the operation bounded by retry policy alone
+	if opt.AttrTimeout < 0 || opt.ReadTimeout < 0 || opt.WriteTimeout < 0 {
+		log.Err("ParseAndValidateConfig : Operation timeouts can not be negative")
+		return errors.New("invalid operation timeout")
+	}
+	az.stConfig.attrTimeout = opt.AttrTimeout
+	az.stConfig.readTimeout = opt.ReadTimeout
+	az.stConfig.writeTimeout = opt.WriteTimeout
+
+	az.stConfig.reportCopyStatus = opt.ReportCopyStatus
+
+	// Flush of a file whose blob was deleted by another client either recreates the blob or fails
+	switch opt.FlushDeleted {
+	case "", "recreate":
+		az.stConfig.strictFlush = false
+	case "fail":
+		az.stConfig.strictFlush = true
+	default:
+		log.Err("ParseAndValidateConfig : Invalid flush-deleted %s, supported values are recreate and fail", opt.FlushDeleted)
+		return errors.New("invalid flush-deleted")
+	}
+
+	// Listing of a directory deleted between two pages either fails or ends with the objects listed so far
+	switch opt.DeletedDirListing {
+	case "", "fail":
+		az.stConfig.endDeletedList = false
+	case "end":
+		az.stConfig.endDeletedList = true
+	default:
+		log.Err("ParseAndValidateConfig : Invalid deleted-dir-listing %s, supported values are fail and end", opt.DeletedDirListing)
+		return errors.New("invalid deleted-dir-listing")
+	}
+
+	// Uploads to a container with an immutability policy carry a policy expiring this long after the upload
+	if opt.ImmutabilityPeriodDays < 0 {
+		log.Err("ParseAndValidateConfig : Invalid immutability-period-days %d", opt.ImmutabilityPeriodDays)
+		return errors.New("invalid immutability-period-days")
+	}
+	az.stConfig.immutabilityPeriod = time.Duration(opt.ImmutabilityPeriodDays) * 24 * time.Hour
+
+	switch opt.ImmutabilityMode {
+	case "", "unlocked":
+		az.stConfig.immutabilityMode = blob.ImmutabilityPolicySettingUnlocked
+	case "locked":
+		az.stConfig.immutabilityMode = blob.ImmutabilityPolicySettingLocked
+	default:
+		log.Err("ParseAndValidateConfig : Invalid immutability-mode %s, supported values are unlocked and locked", opt.ImmutabilityMode)
+		return errors.New("invalid immutability-mode")
+	}
+
+	// GetAttr of a path with more segments than this fails with ENAMETOOLONG, 0 means no limit
+	if opt.MaxPathDepth < 0 {
+		log.Err("ParseAndValidateConfig : Invalid max-path-depth %d", opt.MaxPathDepth)
+		return errors.New("invalid max-path-depth")
+	}
+	az.stConfig.maxPathDepth = opt.MaxPathDepth
+
+	// Download which fails because the local disk is full either removes the partial file or leaves it in place
+	switch opt.DiskFullAction {
+	case "", "remove":
+		az.stConfig.keepPartialOnDiskFull = false
+	case "keep":
+		az.stConfig.keepPartialOnDiskFull = true
+	default:
+		log.Err("ParseAndValidateConfig : Invalid disk-full-action %s, supported values are remove and keep", opt.DiskFullAction)
+		return errors.New("invalid disk-full-action")
+	}
+
+	// Attributes returned by GetAttr are kept this long and revalidated with their ETag, 0 means not cached
+	az.stConfig.attrCacheTTL = time.Duration(opt.AttrCacheTTL) * time.Second
+
+	// Sequential reads through a handle fetch this much ahead in one request, 0 means no read-ahead
+	az.stConfig.readAheadSize = int64(opt.ReadAheadKB) * 1024
+
+	// Block ids of a blob must all be of same length, blocks staged by other tools may use a different one
+	if opt.BlockIDLength == 0 {
+		common.BlockIDLength = common.DefaultBlockIDLength
+	} else if opt.BlockIDLength < common.MinBlockIDLength || opt.BlockIDLength > common.MaxBlockIDLength {
+		log.Err("ParseAndValidateConfig : block-id-length %d is out of range [%d, %d]", opt.BlockIDLength, common.MinBlockIDLength, common.MaxBlockIDLength)
+		return errors.New("invalid block-id-length")
+	} else {
+		common.BlockIDLength = opt.BlockIDLength
+	}
 
 	// Validate container name is present or not
 	err := config.UnmarshalKey("mount-all-containers", &az.stConfig.mountAllContainers)
@@ -387,7 +656,7 @@ func ParseAndValidateConfig(az *AzStorage, opt AzStorageOptions) error {
 	az.stConfig.authConfig.ActiveDirectoryEndpoint = formatEndpointProtocol(az.stConfig.authConfig.ActiveDirectoryEndpoint, false)
 
 	// If subdirectory is mounted, take the prefix path
-	az.stConfig.prefixPath = removeLeadingSlashes(opt.PrefixPath)
+	az.stConfig.prefixPath = normalizePrefixPath(opt.PrefixPath)
 
 	// Block list call on mount for given amount of time
 	az.stConfig.cancelListForSeconds = opt.CancelListForSeconds
@@ -449,6 +718,15 @@ func ParseAndValidateConfig(az *AzStorage, opt AzStorageOptions) error {
 		az.stConfig.authConfig.AccountKey = opt.AccountKey
 	case EAuthType.SAS():
 		az.stConfig.authConfig.AuthMode = EAuthType.SAS()
+		az.stConfig.sasRenewCommand = opt.SasRenewCommand
+		if opt.SaSKey == "" && opt.SasRenewCommand != "" {
+			// First SAS is fetched the same way as the renewed ones
+			sas, err := runSASRenewCommand(opt.SasRenewCommand)
+			if err != nil {
+				return fmt.Errorf("failed to get SAS from sas-renew-command [%s]", err.Error())
+			}
+			opt.SaSKey = sas
+		}
 		if opt.SaSKey == "" {
 			return errors.New("SAS key not provided")
 		}
@@ -463,10 +741,15 @@ func ParseAndValidateConfig(az *AzStorage, opt AzStorageOptions) error {
 		az.stConfig.authConfig.ResourceID = opt.ResourceID
 	case EAuthType.SPN():
 		az.stConfig.authConfig.AuthMode = EAuthType.SPN()
-		if opt.ClientID == "" || (opt.ClientSecret == "" && opt.OAuthTokenFilePath == "" && opt.WorkloadIdentityToken == "") || opt.TenantID == "" {
+		if opt.ClientID == "" || (opt.ClientSecret == "" && opt.OAuthTokenFilePath == "" && opt.WorkloadIdentityToken == "" && opt.ClientCertPath == "") || opt.TenantID == "" {
 			//lint:ignore ST1005 ignore
 			return errors.New("Client ID, Tenant ID or Client Secret, OAuthTokenFilePath, WorkloadIdentityToken not provided")
 		}
+		if opt.ClientSecret != "" && opt.ClientCertPath != "" {
+			return errors.New("both client secret and client-cert-path provided for SPN, configure only one of them")
+		}
+		az.stConfig.authConfig.ClientCertPath = common.ExpandPath(opt.ClientCertPath)
+		az.stConfig.authConfig.ClientCertPassword = opt.ClientCertPassword
 		az.stConfig.authConfig.ClientID = opt.ClientID
 		az.stConfig.authConfig.ClientSecret = opt.ClientSecret
 		az.stConfig.authConfig.TenantID = opt.TenantID
@@ -474,9 +757,12 @@ func ParseAndValidateConfig(az *AzStorage, opt AzStorageOptions) error {
 		az.stConfig.authConfig.WorkloadIdentityToken = opt.WorkloadIdentityToken
 	case EAuthType.AZCLI():
 		az.stConfig.authConfig.AuthMode = EAuthType.AZCLI()
+		// Picks the tenant to get the token for when the CLI is logged in to multiple tenants
+		az.stConfig.authConfig.TenantID = opt.TenantID
 	case EAuthType.WORKLOADIDENTITY():
 		az.stConfig.authConfig.AuthMode = EAuthType.WORKLOADIDENTITY()
-		if opt.ClientID == "" || opt.TenantID == "" || opt.ApplicationID == "" {
+		// With a federated token file the assertion comes from the file instead of the managed identity in appid
+		if opt.ClientID == "" || opt.TenantID == "" || (opt.ApplicationID == "" && opt.TokenFilePath == "") {
 			return errors.New("Client ID, Tenant ID or Application ID not provided")
 		}
 
@@ -484,6 +770,7 @@ func ParseAndValidateConfig(az *AzStorage, opt AzStorageOptions) error {
 		az.stConfig.authConfig.TenantID = opt.TenantID
 		az.stConfig.authConfig.ApplicationID = opt.ApplicationID
 		az.stConfig.authConfig.UserAssertion = opt.UserAssertion
+		az.stConfig.authConfig.TokenFilePath = opt.TokenFilePath
 
 	default:
 		log.Err("ParseAndValidateConfig : Invalid auth mode %s", opt.AuthMode)
@@ -491,6 +778,17 @@ func ParseAndValidateConfig(az *AzStorage, opt AzStorageOptions) error {
 	}
 	az.stConfig.authConfig.AuthResource = opt.AuthResourceString
 
+	if opt.TokenCachePath != "" {
+		if az.stConfig.authConfig.AuthMode != EAuthType.MSI() && az.stConfig.authConfig.AuthMode != EAuthType.SPN() {
+			log.Warn("ParseAndValidateConfig : token-cache-path is supported only for msi and spn auth modes, ignoring it")
+		} else if opt.TokenCachePassphrase == "" {
+			return errors.New("token-cache-passphrase not provided to encrypt the token cache")
+		} else {
+			az.stConfig.authConfig.TokenCachePath = common.ExpandPath(opt.TokenCachePath)
+			az.stConfig.authConfig.TokenCachePassphrase = opt.TokenCachePassphrase
+		}
+	}
+
 	// Retry policy configuration
 	// A user provided value of 0 doesn't make sense for MaxRetries, MaxTimeout, BackoffTime, or MaxRetryDelay.
 
@@ -512,6 +810,21 @@ func ParseAndValidateConfig(az *AzStorage, opt AzStorageOptions) error {
 		az.stConfig.maxRetryDelay = opt.MaxRetryDelay
 	}
 
+	// Status codes retried on top of the ones SDK retries by default
+	az.stConfig.retryJitter = opt.RetryJitter
+	az.stConfig.retryStatusCodes = nil
+	if opt.RetryOnStatus != "" {
+		az.stConfig.retryStatusCodes = append([]int{}, defaultRetryStatusCodes...)
+		for _, code := range strings.Split(opt.RetryOnStatus, ",") {
+			status, err := strconv.Atoi(strings.TrimSpace(code))
+			if err != nil || status < 100 || status > 599 {
+				log.Err("ParseAndValidateConfig : Invalid status code %s in retry-on-status", code)
+				return errors.New("invalid retry-on-status")
+			}
+			az.stConfig.retryStatusCodes = append(az.stConfig.retryStatusCodes, status)
+		}
+	}
+
 	if config.IsSet(compName + ".set-content-type") {
 		log.Warn("unsupported v1 CLI parameter: set-content-type is always true in blobfuse2.")
 	}
@@ -530,6 +843,11 @@ func ParseAndValidateConfig(az *AzStorage, opt AzStorageOptions) error {
 		}
 	}
 
+	err = parseFailoverConfig(az, opt)
+	if err != nil {
+		return err
+	}
+
 	log.Crit("ParseAndValidateConfig : account %s, container %s, account-type %s, auth %s, prefix %s, endpoint %s, MD5 %v %v, virtual-directory %v, disable-compression %v, CPK %v",
 		az.stConfig.authConfig.AccountName, az.stConfig.container, az.stConfig.authConfig.AccountType, az.stConfig.authConfig.AuthMode,
 		az.stConfig.prefixPath, az.stConfig.authConfig.Endpoint, az.stConfig.validateMD5, az.stConfig.updateMD5, az.stConfig.virtualDirectory, az.stConfig.disableCompression, az.stConfig.cpkEnabled)
@@ -542,6 +860,49 @@ func ParseAndValidateConfig(az *AzStorage, opt AzStorageOptions) error {
 	return nil
 }
 
+func parseFailoverConfig(az *AzStorage, opt AzStorageOptions) error {
+	if opt.FailoverEndpoint == "" && opt.FailoverContainer == "" {
+		return nil
+	}
+
+	if opt.FailoverEndpoint != "" {
+		az.stConfig.failoverEndpoint = formatEndpointProtocol(opt.FailoverEndpoint, opt.UseHTTP)
+		az.stConfig.failoverEndpoint = formatEndpointAccountType(az.stConfig.failoverEndpoint, az.stConfig.authConfig.AccountType)
+	} else {
+		az.stConfig.failoverEndpoint = az.stConfig.authConfig.Endpoint
+	}
+
+	az.stConfig.failoverContainer = opt.FailoverContainer
+	if az.stConfig.failoverContainer == "" {
+		az.stConfig.failoverContainer = az.stConfig.container
+	}
+
+	switch opt.FailoverMode {
+	case "", "read":
+		az.stConfig.failoverWrites = false
+	case "readwrite":
+		az.stConfig.failoverWrites = true
+	default:
+		log.Err("parseFailoverConfig : Invalid failover-mode %s, supported values are read and readwrite", opt.FailoverMode)
+		return errors.New("invalid failover-mode")
+	}
+
+	az.stConfig.failoverThreshold = DefaultFailoverThreshold
+	if opt.FailoverThreshold != 0 {
+		az.stConfig.failoverThreshold = opt.FailoverThreshold
+	}
+
+	az.stConfig.failoverCooldown = DefaultFailoverCooldown
+	if opt.FailoverCooldown != 0 {
+		az.stConfig.failoverCooldown = time.Duration(opt.FailoverCooldown) * time.Second
+	}
+
+	log.Crit("ParseAndValidateConfig : Failover endpoint %s, container %s, writes %v, threshold %d, cooldown %v",
+		az.stConfig.failoverEndpoint, az.stConfig.failoverContainer, az.stConfig.failoverWrites, az.stConfig.failoverThreshold, az.stConfig.failoverCooldown)
+
+	return nil
+}
+
 func configureBlobFilter(azStorage *AzStorage, opt AzStorageOptions) error {
 	readonly := false
 	_ = config.UnmarshalKey("read-only", &readonly)
@@ -556,6 +917,7 @@ func configureBlobFilter(azStorage *AzStorage, opt AzStorageOptions) error {
 		log.Err("configureBlobFilter : Failed to configure blob filter %s", err.Error())
 		return errors.New("failed to configure blob filter")
 	}
+	azStorage.stConfig.filterFingerprint = getFilterFingerprint(opt.Filter)
 
 	log.Crit("configureBlobFilter : Blob filter configured %s", opt.Filter)
 	return nil
@@ -568,7 +930,7 @@ func ParseAndReadDynamicConfig(az *AzStorage, opt AzStorageOptions, reload bool)
 	// If block size and max concurrency is configured use those
 	// A user provided value of 0 doesn't make sense for BlockSize, or MaxConcurrency.
 	if opt.BlockSize != 0 {
-		az.stConfig.blockSize = opt.BlockSize * 1024 * 1024
+		az.stConfig.blockSize = clampBlockSize(opt.BlockSize*1024*1024, az.stConfig.allowTinyBlocks)
 	}
 
 	if opt.MaxConcurrency != 0 {
@@ -582,7 +944,31 @@ func ParseAndReadDynamicConfig(az *AzStorage, opt AzStorageOptions, reload bool)
 
 	az.stConfig.ignoreAccessModifiers = !opt.FailUnsupportedOp
 	az.stConfig.validateMD5 = opt.ValidateMD5
+	az.stConfig.validateCRC64 = opt.ValidateCRC64
 	az.stConfig.updateMD5 = opt.UpdateMD5
+	az.stConfig.listDirMarker = opt.ListDirMarker
+	az.stConfig.strictBlockSize = opt.StrictBlockSize
+	az.stConfig.rejectArchiveTier = opt.RejectArchiveTier
+	az.stConfig.checkArchiveTier = opt.CheckArchiveTier
+	az.stConfig.deleteDirBestEffort = opt.DeleteDirBestEffort
+	az.stConfig.listDeleted = opt.ListDeleted
+
+	// Per operation log level overrides, applied to all log lines of that method name
+	levels := make(map[string]common.LogLevel, len(opt.OperationLogLevel))
+	for op, value := range opt.OperationLogLevel {
+		var lvl common.LogLevel
+		err := lvl.Parse(value)
+		if err != nil || lvl == common.ELogLevel.INVALID() {
+			log.Err("ParseAndReadDynamicConfig : Invalid log level %s for operation %s", value, op)
+			return fmt.Errorf("invalid operation-log-level %s for %s", value, op)
+		}
+		levels[op] = lvl
+	}
+	log.SetOperationLogLevels(levels)
+
+	if az.stConfig.defaultTier != nil && *az.stConfig.defaultTier == blob.AccessTierArchive {
+		log.Warn("ParseAndReadDynamicConfig : Default tier is archive, uploaded data will not be readable till it is rehydrated")
+	}
 
 	if config.IsSet(compName + ".virtual-directory") {
 		az.stConfig.virtualDirectory = opt.VirtualDirectory
@@ -602,20 +988,41 @@ func ParseAndReadDynamicConfig(az *AzStorage, opt AzStorageOptions, reload bool)
 		az.stConfig.disableCompression = DisableCompression
 	}
 
+	if opt.HealthCheckInterval != 0 {
+		az.stConfig.healthCheckInterval = time.Duration(opt.HealthCheckInterval) * time.Second
+	} else {
+		az.stConfig.healthCheckInterval = DefaultHealthCheckInterval
+	}
+
 	if config.IsSet(compName + ".honour-acl") {
 		az.stConfig.honourACL = opt.HonourACL
 	} else {
 		az.stConfig.honourACL = false
 	}
+	az.stConfig.aclSingleCall = opt.ACLSingleCall
 
 	// Auth related reconfig
 	switch opt.AuthMode {
+	case "key":
+		if reload && opt.AccountKey != "" && opt.AccountKey != az.stConfig.authConfig.AccountKey {
+			if err := az.UpdateAccountKey(opt.AccountKey); err != nil {
+				return errors.New("account key update failure")
+			}
+		}
 	case "sas":
 		az.stConfig.authConfig.AuthMode = EAuthType.SAS()
+		if opt.SasRenewCommand != "" && (reload || opt.SaSKey == "") {
+			// SAS is owned by the renew command once mounted, the one in config is only the initial SAS
+			// and going back to it would replace a renewed SAS with one which is likely expired
+			break
+		}
 		if opt.SaSKey == "" {
 			return errors.New("SAS key not provided")
 		}
 
+		az.sasLock.Lock()
+		defer az.sasLock.Unlock()
+
 		oldSas := az.stConfig.authConfig.SASKey
 		az.stConfig.authConfig.SASKey = sanitizeSASKey(opt.SaSKey)
 
@@ -632,3 +1039,13 @@ func ParseAndReadDynamicConfig(az *AzStorage, opt AzStorageOptions, reload bool)
 
 	return nil
 }
+
+// clampBlockSize : Raise a block size below MinBlockSize to the minimum, 0 is kept as it lets the size be computed per file
+func clampBlockSize(blockSize int64, allowTiny bool) int64 {
+	if blockSize == 0 || blockSize >= MinBlockSize || (allowTiny && blockSize > 0) {
+		return blockSize
+	}
+
+	log.Warn("clampBlockSize : Block size %d is below the minimum of %d bytes, using %d", blockSize, MinBlockSize, MinBlockSize)
+	return MinBlockSize
+}
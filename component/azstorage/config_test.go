@@ -35,7 +35,9 @@ package azstorage
 
 import (
 	"testing"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/config"
@@ -288,6 +290,20 @@ func (s *configTestSuite) TestAuthModeSAS() {
 	opt.SaSKey = "abc"
 	err = ParseAndValidateConfig(az, opt)
 	assert.Nil(err)
+
+	// First SAS comes from the renew command when not configured
+	sas := "sv=2022-11-02&se=" + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + "&sig=abc"
+	opt.SaSKey = ""
+	opt.SasRenewCommand = "echo '?" + sas + "'"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Equal("?"+sas, az.stConfig.authConfig.SASKey)
+	assert.Equal(opt.SasRenewCommand, az.stConfig.sasRenewCommand)
+
+	opt.SasRenewCommand = "exit 1"
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "sas-renew-command")
 }
 
 func (s *configTestSuite) TestAuthModeMSI() {
@@ -355,6 +371,66 @@ func (s *configTestSuite) TestAuthModeSPN() {
 	assert.Equal(az.stConfig.authConfig.ClientID, opt.ClientID)
 	assert.Equal(az.stConfig.authConfig.ClientSecret, opt.ClientSecret)
 	assert.Equal(az.stConfig.authConfig.TenantID, opt.TenantID)
+
+	// Certificate and secret are mutually exclusive
+	opt.ClientCertPath = "/etc/spn.pem"
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "both client secret and client-cert-path provided")
+
+	opt.ClientSecret = ""
+	opt.ClientCertPassword = "pass"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Equal("/etc/spn.pem", az.stConfig.authConfig.ClientCertPath)
+	assert.Equal("pass", az.stConfig.authConfig.ClientCertPassword)
+}
+
+func (s *configTestSuite) TestAuthModeWorkloadIdentityTokenFile() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.Container = "abcd"
+	opt.AuthMode = "workloadidentity"
+	opt.ClientID = "abc"
+	opt.TenantID = "xyz"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "Client ID, Tenant ID or Application ID not provided")
+
+	// Token file takes the place of the application id
+	opt.TokenFilePath = "/var/run/secrets/azure/tokens/azure-identity-token"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Equal(EAuthType.WORKLOADIDENTITY(), az.stConfig.authConfig.AuthMode)
+	assert.Equal(opt.TokenFilePath, az.stConfig.authConfig.TokenFilePath)
+}
+
+func (s *configTestSuite) TestTokenCacheConfig() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.Container = "abcd"
+	opt.AuthMode = "spn"
+	opt.ClientID = "abc"
+	opt.ClientSecret = "123"
+	opt.TenantID = "xyz"
+	opt.TokenCachePath = "/tmp/tokens"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "token-cache-passphrase not provided")
+
+	opt.TokenCachePassphrase = "secret"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Equal("/tmp/tokens", az.stConfig.authConfig.TokenCachePath)
+	assert.Equal("secret", az.stConfig.authConfig.TokenCachePassphrase)
 }
 
 func (s *configTestSuite) TestOtherFlags() {
@@ -437,6 +513,509 @@ func (s *configTestSuite) TestSASRefresh() {
 	assert.Nil(err)
 }
 
+func (s *configTestSuite) TestFailoverConfig() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Empty(az.stConfig.failoverContainer)
+
+	opt.FailoverEndpoint = "abcd-secondary.blob.core.windows.net"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Equal("https://abcd-secondary.blob.core.windows.net/", az.stConfig.failoverEndpoint)
+	assert.Equal("abcd", az.stConfig.failoverContainer)
+	assert.False(az.stConfig.failoverWrites)
+	assert.EqualValues(DefaultFailoverThreshold, az.stConfig.failoverThreshold)
+	assert.Equal(DefaultFailoverCooldown, az.stConfig.failoverCooldown)
+
+	opt.FailoverContainer = "backup"
+	opt.FailoverMode = "readwrite"
+	opt.FailoverThreshold = 2
+	opt.FailoverCooldown = 10
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Equal("backup", az.stConfig.failoverContainer)
+	assert.True(az.stConfig.failoverWrites)
+	assert.EqualValues(2, az.stConfig.failoverThreshold)
+	assert.Equal(10*time.Second, az.stConfig.failoverCooldown)
+
+	opt.FailoverMode = "write"
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid failover-mode")
+}
+
+func (s *configTestSuite) TestOperationLogLevel() {
+	defer config.ResetConfig()
+	defer log.SetOperationLogLevels(nil)
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	opt.OperationLogLevel = map[string]string{"list": "log_debug", "getattr": "LOG_ERR"}
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+
+	opt.OperationLogLevel = map[string]string{"list": "verbose"}
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid operation-log-level")
+}
+
+func (s *configTestSuite) TestBlockIDLength() {
+	defer config.ResetConfig()
+	defer func() { common.BlockIDLength = common.DefaultBlockIDLength }()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.EqualValues(common.DefaultBlockIDLength, common.BlockIDLength)
+
+	opt.BlockIDLength = 20
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.EqualValues(20, common.BlockIDLength)
+	assert.EqualValues(20, common.GetIdLength(common.GetBlockID(common.BlockIDLength)))
+
+	for _, length := range []int64{4, 65} {
+		opt.BlockIDLength = length
+		err = ParseAndValidateConfig(az, opt)
+		assert.NotNil(err)
+		assert.Contains(err.Error(), "invalid block-id-length")
+	}
+}
+
+func (s *configTestSuite) TestMtimeFallback() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Equal(DefaultMtimeFallback, az.stConfig.mtimeFallback)
+
+	opt.MtimeFallback = "now, creation-time"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Equal([]string{MtimeFallbackNow, MtimeFallbackCreationTime}, az.stConfig.mtimeFallback)
+
+	opt.MtimeFallback = MtimeFallbackNone
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Empty(az.stConfig.mtimeFallback)
+
+	opt.MtimeFallback = "creation-time,epoch"
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid mtime-fallback")
+}
+
+func (s *configTestSuite) TestDefaultUnixMode() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+	opt.StoreUnixPermissions = true
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.True(az.stConfig.storeUnixPermissions)
+	assert.EqualValues(DefaultUnixMode, az.stConfig.defaultUnixMode)
+
+	opt.DefaultUnixMode = "0600"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.EqualValues(0600, az.stConfig.defaultUnixMode)
+
+	opt.DefaultUnixMode = "rw-r--r--"
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid default-unix-mode")
+}
+
+func (s *configTestSuite) TestDirContentType() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Empty(az.stConfig.dirContentType)
+
+	opt.DirContentType = "application/directory"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Equal("application/directory", az.stConfig.dirContentType)
+
+	opt.DirContentType = "directory"
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid directory-content-type")
+}
+
+func (s *configTestSuite) TestOperationTimeouts() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.EqualValues(0, az.stConfig.attrTimeout)
+	assert.EqualValues(0, az.stConfig.readTimeout)
+	assert.EqualValues(0, az.stConfig.writeTimeout)
+
+	opt.AttrTimeout = 5
+	opt.ReadTimeout = 600
+	opt.WriteTimeout = 1200
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.EqualValues(5, az.stConfig.attrTimeout)
+	assert.EqualValues(600, az.stConfig.readTimeout)
+	assert.EqualValues(1200, az.stConfig.writeTimeout)
+
+	opt.AttrTimeout = -1
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid operation timeout")
+}
+
+func (s *configTestSuite) TestRetryOnStatus() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.False(az.stConfig.retryJitter)
+	assert.Nil(az.stConfig.retryStatusCodes)
+
+	opt.RetryJitter = true
+	opt.RetryOnStatus = "409, 412"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.True(az.stConfig.retryJitter)
+	assert.Equal([]int{408, 429, 500, 502, 503, 504, 409, 412}, az.stConfig.retryStatusCodes)
+
+	opt.RetryOnStatus = "409,conflict"
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid retry-on-status")
+}
+
+func (s *configTestSuite) TestMinBlockSize() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	opt.BlockSize = -1
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.EqualValues(MinBlockSize, az.stConfig.blockSize)
+	assert.False(az.stConfig.allowTinyBlocks)
+
+	opt.BlockSize = 1
+	opt.AllowTinyBlocks = true
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.EqualValues(1024*1024, az.stConfig.blockSize)
+	assert.True(az.stConfig.allowTinyBlocks)
+}
+
+func (s *configTestSuite) TestFlushDeleted() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.False(az.stConfig.strictFlush)
+
+	opt.FlushDeleted = "fail"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.True(az.stConfig.strictFlush)
+
+	opt.FlushDeleted = "recreate"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.False(az.stConfig.strictFlush)
+
+	opt.FlushDeleted = "ignore"
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid flush-deleted")
+}
+
+func (s *configTestSuite) TestContentTypeMap() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+	opt.ContentTypeDetection = true
+	opt.ContentTypeMap = map[string]string{"WebManifest": "application/manifest+json", ".md": " text/markdown "}
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.True(az.stConfig.contentTypeDetection)
+	assert.Equal(map[string]string{".webmanifest": "application/manifest+json", ".md": "text/markdown"}, az.stConfig.contentTypeMap)
+
+	opt.ContentTypeMap = map[string]string{".md": "markdown"}
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid content-type-map")
+}
+
+func (s *configTestSuite) TestXAttrNames() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Empty(az.stConfig.xattrKeys)
+
+	opt.XAttrNames = "user.app.tag, user.Checksum"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Equal(map[string]string{"user.app.tag": "xattr_user_app_tag", "user.Checksum": "xattr_user_checksum"}, az.stConfig.xattrKeys)
+
+	opt.XAttrNames = "user.app.tag,user.app-tag"
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid xattr-names")
+}
+
+func (s *configTestSuite) TestSymlinkFormat() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Equal(SymlinkFormatMetadata, az.stConfig.symlinkFormat)
+
+	opt.SymlinkFormat = "suffix"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Equal(SymlinkFormatSuffix, az.stConfig.symlinkFormat)
+
+	opt.SymlinkFormat = "hardlink"
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid symlink-format")
+}
+
+func (s *configTestSuite) TestDeletedDirListing() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.False(az.stConfig.endDeletedList)
+
+	opt.DeletedDirListing = "end"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.True(az.stConfig.endDeletedList)
+
+	opt.DeletedDirListing = "skip"
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid deleted-dir-listing")
+}
+
+func (s *configTestSuite) TestImmutabilityPolicy() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+	opt.ImmutabilityPeriodDays = 7
+	opt.ImmutabilityMode = "locked"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Equal(7*24*time.Hour, az.stConfig.immutabilityPeriod)
+	assert.Equal(blob.ImmutabilityPolicySettingLocked, az.stConfig.immutabilityMode)
+
+	opt.ImmutabilityMode = "frozen"
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid immutability-mode")
+
+	opt.ImmutabilityMode = ""
+	opt.ImmutabilityPeriodDays = -1
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid immutability-period-days")
+}
+
+func (s *configTestSuite) TestMaxPathDepth() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+	opt.MaxPathDepth = 64
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.EqualValues(64, az.stConfig.maxPathDepth)
+
+	opt.MaxPathDepth = -1
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid max-path-depth")
+}
+
+func (s *configTestSuite) TestDiskFullAction() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.False(az.stConfig.keepPartialOnDiskFull)
+
+	opt.DiskFullAction = "keep"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.True(az.stConfig.keepPartialOnDiskFull)
+
+	opt.DiskFullAction = "remove"
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.False(az.stConfig.keepPartialOnDiskFull)
+
+	opt.DiskFullAction = "mark"
+	err = ParseAndValidateConfig(az, opt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid disk-full-action")
+}
+
+func (s *configTestSuite) TestAttrCacheTTL() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.EqualValues(0, az.stConfig.attrCacheTTL)
+
+	opt.AttrCacheTTL = 30
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.Equal(30*time.Second, az.stConfig.attrCacheTTL)
+}
+
+func (s *configTestSuite) TestReadAheadKB() {
+	defer config.ResetConfig()
+	assert := assert.New(s.T())
+	az := &AzStorage{}
+	opt := AzStorageOptions{}
+	opt.AccountName = "abcd"
+	opt.AccountKey = "abc"
+	opt.Container = "abcd"
+	opt.AuthMode = "key"
+
+	err := ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.EqualValues(0, az.stConfig.readAheadSize)
+
+	opt.ReadAheadKB = 4096
+	err = ParseAndValidateConfig(az, opt)
+	assert.Nil(err)
+	assert.EqualValues(4*1024*1024, az.stConfig.readAheadSize)
+}
+
 func TestConfigTestSuite(t *testing.T) {
 	suite.Run(t, new(configTestSuite))
 }
@@ -34,7 +34,9 @@
 package azstorage
 
 import (
+	"context"
 	"os"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-storage-fuse/v2/common"
@@ -53,6 +55,12 @@ type AzStorageConfig struct {
 	blockSize      int64
 	maxConcurrency uint16
 
+	// Keep block sizes below MinBlockSize, meant only for tests
+	allowTinyBlocks bool
+
+	// Include soft-deleted blobs in listing
+	listDeleted bool
+
 	// tier to be set on every upload
 	defaultTier *blob.AccessTier
 
@@ -64,19 +72,23 @@ type AzStorageConfig struct {
 	maxTimeout            int32
 	backoffTime           int32
 	maxRetryDelay         int32
+	retryJitter           bool
+	retryStatusCodes      []int
 	proxyAddress          string
 	ignoreAccessModifiers bool
 	mountAllContainers    bool
 
 	updateMD5          bool
 	validateMD5        bool
+	validateCRC64      bool
 	virtualDirectory   bool
 	maxResultsForList  int32
 	disableCompression bool
 
-	telemetry   string
-	honourACL   bool
-	preserveACL bool
+	telemetry     string
+	honourACL     bool
+	aclSingleCall bool
+	preserveACL   bool
 
 	// CPK related config
 	cpkEnabled             bool
@@ -84,7 +96,111 @@ type AzStorageConfig struct {
 	cpkEncryptionKeySha256 string
 
 	// Blob filters
-	filter *blobfilter.BlobFilter
+	filter            *blobfilter.BlobFilter
+	filterFingerprint string
+
+	// Duration for which result of last health check is served
+	healthCheckInterval time.Duration
+
+	// Largest buffer ReadBuffer is allowed to allocate, 0 means no limit
+	maxBufferBytes int64
+
+	// Times a download resumes the remaining range when the body stream fails midway
+	readStreamRetries int32
+
+	// Sources of mtime, in order, when a blob has no last modified time. nil means the default order
+	mtimeFallback []string
+
+	// Upload files block by block reusing at most max-concurrency buffers, instead of streaming sections of the file
+	streamingWrite bool
+
+	// Content type set on directory markers, blobs with it are treated as directories
+	dirContentType string
+
+	// Encoding of symlinks created by this mount
+	symlinkFormat string
+
+	// Extended attributes which are persisted, keyed on xattr name with the metadata key as value
+	xattrKeys map[string]string
+
+	// Set content type of uploads from the file extension, using the map first and then the system types
+	contentTypeDetection bool
+	contentTypeMap       map[string]string
+
+	// Keep mode of block blobs in metadata, and the permissions used when it is malformed
+	storeUnixPermissions bool
+	defaultUnixMode      os.FileMode
+
+	// Per operation timeouts in seconds for GetAttr, downloads and uploads, 0 means not set
+	attrTimeout  int32
+	readTimeout  int32
+	writeTimeout int32
+
+	// Report state of the copy which created the blob in the metadata returned by GetAttr
+	reportCopyStatus bool
+
+	// Fail the flush with ENOENT instead of recreating the blob when it was deleted since it was opened
+	strictFlush bool
+
+	// End the listing instead of failing with ENOENT when the directory is deleted between two pages
+	endDeletedList bool
+
+	// Immutability policy applied to uploads when the container requires one, zero period means none is configured
+	immutabilityPeriod time.Duration
+	immutabilityMode   blob.ImmutabilityPolicySetting
+
+	// Most segments a path given to GetAttr may have, 0 means no limit
+	maxPathDepth int32
+
+	// Leave the partially downloaded file in place instead of removing it when the local disk fills up
+	keepPartialOnDiskFull bool
+
+	// Time attributes of a path are kept by GetAttr and revalidated with a conditional request, 0 means not cached
+	attrCacheTTL time.Duration
+
+	// Bytes fetched in one request when reads through a handle are sequential, 0 means no read-ahead
+	readAheadSize int64
+
+	// Fail the upload instead of increasing block size when file needs more than 50,000 blocks
+	strictBlockSize bool
+
+	// Fail uploads instead of warning when default tier is archive
+	rejectArchiveTier bool
+
+	// Look up the tier of a blob before writing to it, so a write to an archived blob fails before any data is sent
+	checkArchiveTier bool
+
+	// Delete children left under a directory on DeleteDir, continuing past failures
+	deleteDirBestEffort bool
+
+	// Command printing a fresh SAS on stdout, run before the current SAS expires
+	sasRenewCommand string
+
+	// Return the marker blob of a directory (e.g. "dir/") as a child of that directory in listing
+	listDirMarker bool
+
+	// Secondary endpoint/container to be used when primary is failing
+	failoverEndpoint  string
+	failoverContainer string
+	failoverWrites    bool
+	failoverThreshold uint32
+	failoverCooldown  time.Duration
+}
+
+// ContainerInfo : Properties and metadata of a container as returned by the container listing
+type ContainerInfo struct {
+	Name         string
+	LastModified time.Time
+	ETag         string
+	PublicAccess string // "container", "blob" or empty when the container is private
+	Metadata     map[string]*string
+}
+
+// RecursiveACLResult : Number of paths of a directory tree an access control change succeeded and failed on
+type RecursiveACLResult struct {
+	Directories int32
+	Files       int32
+	Failures    int32
 }
 
 type AzStorageConnection struct {
@@ -97,45 +213,60 @@ type AzConnection interface {
 
 	SetupPipeline() error
 	TestPipeline() error
+	Ping(ctx context.Context) error
 	IsAccountADLS() bool
 
-	ListContainers() ([]string, error)
+	ListContainers(prefix string) ([]string, error)
+	ListContainersDetailed() ([]ContainerInfo, error)
 
 	// This is just for test, shall not be used otherwise
 	SetPrefixPath(string) error
 
-	CreateFile(name string, mode os.FileMode) error
+	CreateFile(name string, mode os.FileMode, contentType string) error
 	CreateDirectory(name string) error
 	CreateLink(source string, target string) error
 
 	DeleteFile(name string) error
+	UndeleteFile(name string) error
 	DeleteDirectory(name string) error
 
 	RenameFile(string, string, *internal.ObjAttr) error
 	RenameDirectory(string, string) error
 
 	GetAttr(name string) (attr *internal.ObjAttr, err error)
+	GetAttrIfModified(name string, etag string) (attr *internal.ObjAttr, err error)
 
 	// Standard operations to be supported by any account type
 	List(prefix string, marker *string, count int32) ([]*internal.ObjAttr, *string, error)
+	ListFlat(prefix string, marker *string, count int32) ([]*internal.ObjAttr, *string, error)
+	GetDirUsage(ctx context.Context, name string) (size int64, fileCount int64, dirCount int64, err error)
 
-	ReadToFile(name string, offset int64, count int64, fi *os.File) error
+	ReadToFile(options internal.CopyToFileOptions) error
 	ReadBuffer(name string, offset int64, len int64) ([]byte, error)
 	ReadInBuffer(name string, offset int64, len int64, data []byte, etag *string) error
+	ReadVersionInBuffer(name string, versionID string, offset int64, len int64, data []byte) error
 
-	WriteFromFile(name string, metadata map[string]*string, fi *os.File) error
+	WriteFromFile(options internal.CopyFromFileOptions) error
 	WriteFromBuffer(name string, metadata map[string]*string, data []byte) error
+	CreateImmutable(name string, data []byte, legalHold bool, expiry *time.Time) error
+	SetImmutabilityPolicy(name string, until time.Time, mode string) error
+	ClearImmutabilityPolicy(name string) error
+	SetLegalHold(name string, on bool) error
 	Write(options internal.WriteFileOptions) error
 	GetFileBlockOffsets(name string) (*common.BlockOffsetList, error)
 
 	ChangeMod(string, os.FileMode) error
+	ChmodRecursive(name string, mode os.FileMode) (RecursiveACLResult, error)
 	ChangeOwner(string, int, int) error
+	SetMetadataKey(name string, key string, value *string) error
+	GetMetadataKey(name string, key string) (*string, error)
+	SetTier(name string, tier blob.AccessTier) error
 	TruncateFile(string, int64) error
-	StageAndCommit(name string, bol *common.BlockOffsetList) error
+	StageAndCommit(name string, bol *common.BlockOffsetList, concurrency uint16) error
 
 	GetCommittedBlockList(string) (*internal.CommittedBlockList, error)
 	StageBlock(string, []byte, string) error
-	CommitBlocks(string, []string, *string) error
+	CommitBlocks(string, []string, *string, map[string]string, string, string) error
 
 	UpdateServiceClient(_, _ string) error
 
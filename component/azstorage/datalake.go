@@ -37,11 +37,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
@@ -50,6 +54,7 @@ import (
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azdatalake/directory"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azdatalake/file"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azdatalake/filesystem"
@@ -61,6 +66,7 @@ type Datalake struct {
 	Auth           azAuth
 	Service        *service.Client
 	Filesystem     *filesystem.Client
+	clientLock     sync.RWMutex // guards Service and Filesystem, replaced on credential rotation
 	BlockBlob      BlockBlob
 	datalakeCPKOpt *file.CPKInfo
 }
@@ -72,17 +78,16 @@ var _ AzConnection = &Datalake{}
 // Users must set an endpoint to allow blobfuse to
 // 1. support Azure clouds (ex: Public, Zonal DNS, China, Germany, Gov, etc)
 // 2. direct REST APIs to a truly custom endpoint (ex: www dot custom-domain dot com)
-// We can handle case 1 by simply replacing the .dfs. to .blob. and blobfuse will work fine.
+// We can handle case 1 by replacing the dfs service label of the host with blob, whatever the cloud suffix is, and blobfuse will work fine.
 // However, case 2 will not work since the endpoint likely only redirects to the dfs endpoint and not the blob endpoint, so we don't know what endpoint to use when we call blob endpoints.
 // This is also a known problem with the SDKs.
 func transformAccountEndpoint(potentialDfsEndpoint string) string {
-	if strings.Contains(potentialDfsEndpoint, ".dfs.") {
-		return strings.Replace(potentialDfsEndpoint, ".dfs.", ".blob.", -1)
-	} else {
+	blobEndpoint, ok := swapEndpointService(potentialDfsEndpoint, "dfs", "blob")
+	if !ok {
 		// Should we just throw here?
 		log.Warn("Datalake::transformAccountEndpoint : Detected use of a custom endpoint. Not all operations are guaranteed to work.")
 	}
-	return potentialDfsEndpoint
+	return blobEndpoint
 }
 
 // transformConfig transforms the adls config to a blob config
@@ -114,18 +119,23 @@ func (dl *Datalake) Configure(cfg AzStorageConfig) error {
 
 // For dynamic config update the config here
 func (dl *Datalake) UpdateConfig(cfg AzStorageConfig) error {
-	dl.Config.blockSize = cfg.blockSize
+	dl.Config.blockSize = clampBlockSize(cfg.blockSize, cfg.allowTinyBlocks)
 	dl.Config.maxConcurrency = cfg.maxConcurrency
 	dl.Config.defaultTier = cfg.defaultTier
 	dl.Config.ignoreAccessModifiers = cfg.ignoreAccessModifiers
+	dl.Config.listDirMarker = cfg.listDirMarker
 	return dl.BlockBlob.UpdateConfig(cfg)
 }
 
-// UpdateServiceClient : Update the SAS specified by the user and create new service client
+// UpdateServiceClient : Update the SAS or account key specified by the user and create new service client
+// Operations already in flight complete on the old client, new ones pick up the rebuilt client.
 func (dl *Datalake) UpdateServiceClient(key, value string) (err error) {
-	if key == "saskey" {
+	if key == "saskey" || key == "accountkey" {
+		dl.clientLock.Lock()
+		defer dl.clientLock.Unlock()
+
 		dl.Auth.setOption(key, value)
-		// get the service client with updated SAS
+		// get the service client with updated credential
 		svcClient, err := dl.Auth.getServiceClient(&dl.Config)
 		if err != nil {
 			log.Err("Datalake::UpdateServiceClient : Failed to get service client [%s]", err.Error())
@@ -141,6 +151,20 @@ func (dl *Datalake) UpdateServiceClient(key, value string) (err error) {
 	return dl.BlockBlob.UpdateServiceClient(key, value)
 }
 
+// serviceClient : Service client in use, it is replaced when the credential is rotated
+func (dl *Datalake) serviceClient() *service.Client {
+	dl.clientLock.RLock()
+	defer dl.clientLock.RUnlock()
+	return dl.Service
+}
+
+// filesystemClient : Filesystem client in use, it is replaced when the credential is rotated
+func (dl *Datalake) filesystemClient() *filesystem.Client {
+	dl.clientLock.RLock()
+	defer dl.clientLock.RUnlock()
+	return dl.Filesystem
+}
+
 // createServiceClient : Create the service client
 func (dl *Datalake) createServiceClient() (*service.Client, error) {
 	log.Trace("Datalake::createServiceClient : Getting service client")
@@ -178,21 +202,34 @@ func (dl *Datalake) SetupPipeline() error {
 	return dl.BlockBlob.SetupPipeline()
 }
 
+// Ping : Confirm the service is reachable and still accepts the credentials
+func (dl *Datalake) Ping(ctx context.Context) error {
+	return dl.BlockBlob.Ping(ctx)
+}
+
 // TestPipeline : Validate the credentials specified in the auth config
 func (dl *Datalake) TestPipeline() error {
 	log.Trace("Datalake::TestPipeline : Validating")
 
+	if dl.Config.authConfig.AuthMode == EAuthType.WORKLOADIDENTITY() && dl.Config.authConfig.TokenFilePath != "" {
+		err := validateTokenFile(dl.Config.authConfig.TokenFilePath)
+		if err != nil {
+			log.Err("Datalake::TestPipeline : %s", err.Error())
+			return err
+		}
+	}
+
 	if dl.Config.mountAllContainers {
 		return nil
 	}
 
-	if dl.Filesystem == nil || dl.Filesystem.DFSURL() == "" || dl.Filesystem.BlobURL() == "" {
+	if dl.filesystemClient() == nil || dl.filesystemClient().DFSURL() == "" || dl.filesystemClient().BlobURL() == "" {
 		log.Err("Datalake::TestPipeline : Filesystem Client is not built, check your credentials")
 		return nil
 	}
 
 	maxResults := int32(2)
-	listPathPager := dl.Filesystem.NewListPathsPager(false, &filesystem.ListPathsOptions{
+	listPathPager := dl.filesystemClient().NewListPathsPager(false, &filesystem.ListPathsOptions{
 		MaxResults: &maxResults,
 		Prefix:     &dl.Config.prefixPath,
 	})
@@ -217,21 +254,26 @@ func (dl *Datalake) IsAccountADLS() bool {
 	return dl.BlockBlob.IsAccountADLS()
 }
 
-func (dl *Datalake) ListContainers() ([]string, error) {
-	log.Trace("Datalake::ListContainers : Listing containers")
-	return dl.BlockBlob.ListContainers()
+func (dl *Datalake) ListContainers(prefix string) ([]string, error) {
+	log.Trace("Datalake::ListContainers : Listing containers with prefix %s", prefix)
+	return dl.BlockBlob.ListContainers(prefix)
+}
+
+func (dl *Datalake) ListContainersDetailed() ([]ContainerInfo, error) {
+	log.Trace("Datalake::ListContainersDetailed : Listing containers")
+	return dl.BlockBlob.ListContainersDetailed()
 }
 
 func (dl *Datalake) SetPrefixPath(path string) error {
 	log.Trace("Datalake::SetPrefixPath : path %s", path)
-	dl.Config.prefixPath = path
+	dl.Config.prefixPath = normalizePrefixPath(path)
 	return dl.BlockBlob.SetPrefixPath(path)
 }
 
 // CreateFile : Create a new file in the filesystem/directory
-func (dl *Datalake) CreateFile(name string, mode os.FileMode) error {
+func (dl *Datalake) CreateFile(name string, mode os.FileMode, contentType string) error {
 	log.Trace("Datalake::CreateFile : name %s", name)
-	err := dl.BlockBlob.CreateFile(name, mode)
+	err := dl.BlockBlob.CreateFile(name, mode, contentType)
 	if err != nil {
 		log.Err("Datalake::CreateFile : Failed to create file %s [%s]", name, err.Error())
 		return err
@@ -249,7 +291,7 @@ func (dl *Datalake) CreateFile(name string, mode os.FileMode) error {
 func (dl *Datalake) CreateDirectory(name string) error {
 	log.Trace("Datalake::CreateDirectory : name %s", name)
 
-	directoryURL := dl.Filesystem.NewDirectoryClient(filepath.Join(dl.Config.prefixPath, name))
+	directoryURL := dl.filesystemClient().NewDirectoryClient(joinPrefixPath(dl.Config.prefixPath, name))
 	_, err := directoryURL.Create(context.Background(), &directory.CreateOptions{
 		CPKInfo: dl.datalakeCPKOpt,
 		AccessConditions: &directory.AccessConditions{
@@ -265,6 +307,12 @@ func (dl *Datalake) CreateDirectory(name string) error {
 			log.Err("Datalake::CreateDirectory : Insufficient permissions for %s [%s]", name, err.Error())
 			return syscall.EACCES
 		} else if serr == ErrFileAlreadyExists {
+			// Directory may have been created concurrently by another mount, which is not a failure
+			attr, attrErr := dl.GetAttr(name)
+			if attrErr == nil && attr.IsDir() {
+				log.Debug("Datalake::CreateDirectory : Directory %s already exists", name)
+				return nil
+			}
 			log.Err("Datalake::CreateDirectory : Path already exists for %s [%s]", name, err.Error())
 			return syscall.EEXIST
 		} else {
@@ -285,7 +333,7 @@ func (dl *Datalake) CreateLink(source string, target string) error {
 // DeleteFile : Delete a file in the filesystem/directory
 func (dl *Datalake) DeleteFile(name string) (err error) {
 	log.Trace("Datalake::DeleteFile : name %s", name)
-	fileClient := dl.Filesystem.NewFileClient(filepath.Join(dl.Config.prefixPath, name))
+	fileClient := dl.filesystemClient().NewFileClient(joinPrefixPath(dl.Config.prefixPath, name))
 	_, err = fileClient.Delete(context.Background(), nil)
 	if err != nil {
 		serr := storeDatalakeErrToErr(err)
@@ -307,11 +355,16 @@ func (dl *Datalake) DeleteFile(name string) (err error) {
 	return nil
 }
 
+// UndeleteFile : Restore a soft-deleted file, soft delete is managed through the blob endpoint
+func (dl *Datalake) UndeleteFile(name string) error {
+	return dl.BlockBlob.UndeleteFile(name)
+}
+
 // DeleteDirectory : Delete a directory in the filesystem/directory
 func (dl *Datalake) DeleteDirectory(name string) (err error) {
 	log.Trace("Datalake::DeleteDirectory : name %s", name)
 
-	directoryClient := dl.Filesystem.NewDirectoryClient(filepath.Join(dl.Config.prefixPath, name))
+	directoryClient := dl.filesystemClient().NewDirectoryClient(joinPrefixPath(dl.Config.prefixPath, name))
 	_, err = directoryClient.Delete(context.Background(), nil)
 	// TODO : There is an ability to pass a continuation token here for recursive delete, should we implement this logic to follow continuation token? The SDK does not currently do this.
 	if err != nil {
@@ -334,9 +387,14 @@ func (dl *Datalake) DeleteDirectory(name string) (err error) {
 func (dl *Datalake) RenameFile(source string, target string, srcAttr *internal.ObjAttr) error {
 	log.Trace("Datalake::RenameFile : %s -> %s", source, target)
 
-	fileClient := dl.Filesystem.NewFileClient(url.PathEscape(filepath.Join(dl.Config.prefixPath, source)))
+	if !renameTargetSupported(joinPrefixPath(dl.Config.prefixPath, target)) {
+		log.Err("Datalake::RenameFile : Can not rename %s to %s, target has white space at its ends or a '?'", source, target)
+		return syscall.EINVAL
+	}
+
+	fileClient := dl.filesystemClient().NewFileClient(url.PathEscape(joinPrefixPath(dl.Config.prefixPath, source)))
 
-	renameResponse, err := fileClient.Rename(context.Background(), filepath.Join(dl.Config.prefixPath, target), &file.RenameOptions{
+	renameResponse, err := fileClient.Rename(context.Background(), joinPrefixPath(dl.Config.prefixPath, target), &file.RenameOptions{
 		CPKInfo: dl.datalakeCPKOpt,
 	})
 	if err != nil {
@@ -344,6 +402,10 @@ func (dl *Datalake) RenameFile(source string, target string, srcAttr *internal.O
 		if serr == ErrFileNotFound {
 			log.Err("Datalake::RenameFile : %s does not exist", source)
 			return syscall.ENOENT
+		} else if serr == CPKMismatch {
+			// Source was written with a customer provided key which differs from the one this mount is configured with
+			log.Err("Datalake::RenameFile : Configured CPK can not decrypt %s, it was written with a different key [%s]", source, err.Error())
+			return syscall.EACCES
 		} else {
 			log.Err("Datalake::RenameFile : Failed to rename file %s to %s [%s]", source, target, err.Error())
 			return err
@@ -357,8 +419,13 @@ func (dl *Datalake) RenameFile(source string, target string, srcAttr *internal.O
 func (dl *Datalake) RenameDirectory(source string, target string) error {
 	log.Trace("Datalake::RenameDirectory : %s -> %s", source, target)
 
-	directoryClient := dl.Filesystem.NewDirectoryClient(url.PathEscape(filepath.Join(dl.Config.prefixPath, source)))
-	_, err := directoryClient.Rename(context.Background(), filepath.Join(dl.Config.prefixPath, target), &directory.RenameOptions{
+	if !renameTargetSupported(joinPrefixPath(dl.Config.prefixPath, target)) {
+		log.Err("Datalake::RenameDirectory : Can not rename %s to %s, target has white space at its ends or a '?'", source, target)
+		return syscall.EINVAL
+	}
+
+	directoryClient := dl.filesystemClient().NewDirectoryClient(url.PathEscape(joinPrefixPath(dl.Config.prefixPath, source)))
+	_, err := directoryClient.Rename(context.Background(), joinPrefixPath(dl.Config.prefixPath, target), &directory.RenameOptions{
 		CPKInfo: dl.datalakeCPKOpt,
 	})
 	if err != nil {
@@ -366,6 +433,9 @@ func (dl *Datalake) RenameDirectory(source string, target string) error {
 		if serr == ErrFileNotFound {
 			log.Err("Datalake::RenameDirectory : %s does not exist", source)
 			return syscall.ENOENT
+		} else if serr == CPKMismatch {
+			log.Err("Datalake::RenameDirectory : Configured CPK can not decrypt paths under %s [%s]", source, err.Error())
+			return dl.renameDirCPKErr(source, target)
 		} else {
 			log.Err("Datalake::RenameDirectory : Failed to rename directory %s to %s [%s]", source, target, err.Error())
 			return err
@@ -375,65 +445,161 @@ func (dl *Datalake) RenameDirectory(source string, target string) error {
 	return nil
 }
 
+// renameDirCPKErr : Rename failure does not say which children hold it back, so every file under the source is
+// checked with the configured key and the ones it can not access are reported
+func (dl *Datalake) renameDirCPKErr(source string, target string) error {
+	dirPath := joinPrefixPath(dl.Config.prefixPath, source)
+	pager := dl.filesystemClient().NewListPathsPager(true, &filesystem.ListPathsOptions{
+		Prefix: &dirPath,
+	})
+
+	var paths []string
+	for pager.More() {
+		listPathResp, err := pager.NextPage(context.Background())
+		if err != nil {
+			log.Err("Datalake::RenameDirectory : Failed to list paths under %s [%s]", source, err.Error())
+			return syscall.EACCES
+		}
+
+		for _, pathInfo := range listPathResp.Paths {
+			if pathInfo.Name == nil || (pathInfo.IsDirectory != nil && *pathInfo.IsDirectory) {
+				continue
+			}
+			fileClient := dl.filesystemClient().NewFileClient(*pathInfo.Name)
+			_, err := fileClient.GetProperties(context.Background(), &file.GetPropertiesOptions{
+				CPKInfo: dl.datalakeCPKOpt,
+			})
+			if err != nil && (storeBlobErrToErr(err) == CPKMismatch || storeDatalakeErrToErr(err) == CPKMismatch) {
+				paths = append(paths, removePrefixPath(dl.Config.prefixPath, *pathInfo.Name))
+			}
+		}
+	}
+
+	if len(paths) == 0 {
+		return syscall.EACCES
+	}
+	sort.Strings(paths)
+	log.Err("Datalake::RenameDirectory : %d path(s) under %s are encrypted with a different key: %s", len(paths), source, strings.Join(paths, ", "))
+	return &RenameDirCPKError{Source: source, Target: target, Paths: paths}
+}
+
+// GetAttrIfModified : Retrieve attributes of the path only if its ETag differs from the given one,
+// otherwise ErrNotModified is returned
+func (dl *Datalake) GetAttrIfModified(name string, etag string) (*internal.ObjAttr, error) {
+	log.Trace("Datalake::GetAttrIfModified : name %s", name)
+	return dl.getAttr(name, etag)
+}
+
 // GetAttr : Retrieve attributes of the path
 func (dl *Datalake) GetAttr(name string) (blobAttr *internal.ObjAttr, err error) {
 	log.Trace("Datalake::GetAttr : name %s", name)
+	return dl.getAttr(name, "")
+}
 
-	fileClient := dl.Filesystem.NewFileClient(filepath.Join(dl.Config.prefixPath, name))
-	prop, err := fileClient.GetProperties(context.Background(), &file.GetPropertiesOptions{
+// getAttr : Retrieve attributes of the path, conditioned on its ETag when one is given.
+// Unchanged path returns ErrNotModified, otherwise attributes come from the same response.
+func (dl *Datalake) getAttr(name string, etag string) (blobAttr *internal.ObjAttr, err error) {
+	fileClient := dl.filesystemClient().NewFileClient(joinPrefixPath(dl.Config.prefixPath, name))
+
+	withACL := dl.Config.honourACL && dl.Config.authConfig.ObjectID != ""
+	var aclDone chan struct{}
+	var acl file.GetAccessControlResponse
+	var aclErr error
+	if withACL && dl.Config.aclSingleCall && etag == "" {
+		// Access control response does not carry size, resource type nor properties of the path,
+		// so it is only requested alongside the properties instead of after them
+		aclDone = make(chan struct{})
+		go func() {
+			defer close(aclDone)
+			acl, aclErr = fileClient.GetAccessControl(context.Background(), nil)
+		}()
+	}
+
+	ctx, cancel := operationContext(dl.Config.attrTimeout, 0)
+	defer cancel()
+
+	opts := &file.GetPropertiesOptions{
 		CPKInfo: dl.datalakeCPKOpt,
-	})
+	}
+	if etag != "" {
+		opts.AccessConditions = &file.AccessConditions{
+			ModifiedAccessConditions: &file.ModifiedAccessConditions{
+				IfNoneMatch: to.Ptr(azcore.ETag(`"` + etag + `"`)),
+			},
+		}
+	}
+
+	prop, err := fileClient.GetProperties(ctx, opts)
 	if err != nil {
+		var respErr *azcore.ResponseError
+		if etag != "" && errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotModified {
+			return nil, internal.ErrNotModified
+		}
+
 		e := storeDatalakeErrToErr(err)
 		if e == ErrFileNotFound {
 			return blobAttr, syscall.ENOENT
 		} else if e == InvalidPermission {
 			log.Err("Datalake::GetAttr : Insufficient permissions for %s [%s]", name, err.Error())
 			return blobAttr, syscall.EACCES
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			log.Err("Datalake::GetAttr : Timed out getting path properties for %s [%s]", name, err.Error())
+			return blobAttr, syscall.ETIMEDOUT
+		} else if e == CPKMismatch {
+			log.Err("Datalake::GetAttr : %s is encrypted with a customer provided key, cpk-encryption-key it was written with is required [%s]", name, err.Error())
+			return blobAttr, syscall.EACCES
+		} else if e == EncryptionKeyUnavailable {
+			log.Err("Datalake::GetAttr : Encryption key of %s is not accessible [%s]", name, err.Error())
+			return blobAttr, syscall.EACCES
 		} else {
 			log.Err("Datalake::GetAttr : Failed to get path properties for %s [%s]", name, err.Error())
 			return blobAttr, err
 		}
 	}
 
-	mode, err := getFileMode(*prop.Permissions)
-	if err != nil {
-		log.Err("Datalake::GetAttr : Failed to get file mode for %s [%s]", name, err.Error())
-		return blobAttr, err
-	}
-
+	mtime := dl.BlockBlob.lastModifiedTime(name, prop.LastModified, prop.CreationTime)
 	blobAttr = &internal.ObjAttr{
 		Path:   name,
 		Name:   filepath.Base(name),
 		Size:   *prop.ContentLength,
-		Mode:   mode,
-		Mtime:  *prop.LastModified,
-		Atime:  *prop.LastModified,
-		Ctime:  *prop.LastModified,
-		Crtime: *prop.LastModified,
+		Mtime:  mtime,
+		Atime:  mtime,
+		Ctime:  mtime,
+		Crtime: mtime,
 		Flags:  internal.NewFileBitMap(),
 		ETag:   sanitizeEtag(prop.ETag),
 	}
+
+	// Permissions are an enrichment over the core attributes, failure to parse them shall not fail the GetAttr
+	modeDefault := false
+	if prop.Permissions == nil {
+		log.Warn("Datalake::GetAttr : No permissions returned for %s, using default mode", name)
+		modeDefault = true
+	} else {
+		blobAttr.Mode, err = getFileMode(*prop.Permissions)
+		if err != nil {
+			log.Err("Datalake::GetAttr : Failed to get file mode for %s, using default mode [%s]", name, err.Error())
+			modeDefault = true
+		}
+	}
+
 	parseMetadata(blobAttr, prop.Metadata)
 
-	if *prop.ResourceType == "directory" {
+	if prop.ResourceType != nil && *prop.ResourceType == "directory" {
 		blobAttr.Flags = internal.NewDirBitMap()
 		blobAttr.Mode = blobAttr.Mode | os.ModeDir
 	}
+	dl.BlockBlob.applySymlinkFormat(blobAttr, prop.ContentType)
 
-	if dl.Config.honourACL && dl.Config.authConfig.ObjectID != "" {
-		acl, err := fileClient.GetAccessControl(context.Background(), nil)
-		if err != nil {
-			// Just ignore the error here as rest of the attributes have been retrieved
-			log.Err("Datalake::GetAttr : Failed to get ACL for %s [%s]", name, err.Error())
-		} else {
-			mode, err := getFileModeFromACL(dl.Config.authConfig.ObjectID, *acl.ACL, *acl.Owner)
-			if err != nil {
-				log.Err("Datalake::GetAttr : Failed to get file mode from ACL for %s [%s]", name, err.Error())
-			} else {
-				blobAttr.Mode = mode
-			}
-		}
+	if modeDefault {
+		blobAttr.Flags.Set(internal.PropFlagModeDefault)
+	}
+
+	if aclDone != nil {
+		<-aclDone
+		dl.applyACL(blobAttr, acl, aclErr)
+	} else if withACL {
+		dl.enrichAttrWithACL(fileClient, blobAttr)
 	}
 
 	if dl.Config.filter != nil {
@@ -450,16 +616,87 @@ func (dl *Datalake) GetAttr(name string) (blobAttr *internal.ObjAttr, err error)
 	return blobAttr, nil
 }
 
+// enrichAttrWithACL : Update the mode of the path based on the ACL set for the authenticated object id.
+// Any failure here is logged and ignored so that the core attributes already retrieved are still returned.
+func (dl *Datalake) enrichAttrWithACL(fileClient *file.Client, attr *internal.ObjAttr) {
+	acl, err := fileClient.GetAccessControl(context.Background(), nil)
+	dl.applyACL(attr, acl, err)
+}
+
+// applyACL : Update the mode of the path from an access control response, a failed request leaves the mode as is
+func (dl *Datalake) applyACL(attr *internal.ObjAttr, acl file.GetAccessControlResponse, err error) {
+	if err != nil {
+		log.Err("Datalake::applyACL : Failed to get ACL for %s [%s]", attr.Path, err.Error())
+		return
+	}
+
+	if acl.ACL == nil || acl.Owner == nil {
+		log.Err("Datalake::applyACL : Empty ACL or owner returned for %s", attr.Path)
+		return
+	}
+
+	mode, err := getFileModeFromACL(dl.Config.authConfig.ObjectID, *acl.ACL, *acl.Owner)
+	if err != nil {
+		log.Err("Datalake::applyACL : Failed to get file mode from ACL for %s [%s]", attr.Path, err.Error())
+		return
+	}
+
+	attr.Mode = mode | (attr.Mode & os.ModeDir)
+	attr.Flags.Clear(internal.PropFlagModeDefault)
+}
+
 // List : Get a list of path matching the given prefix
 // This fetches the list using a marker so the caller code should handle marker logic
 // If count=0 - fetch max entries
+// List : Listing goes through the blob endpoint, so the marker blob of the directory itself is skipped the same way
+// unless list-dir-marker is set
 func (dl *Datalake) List(prefix string, marker *string, count int32) ([]*internal.ObjAttr, *string, error) {
 	return dl.BlockBlob.List(prefix, marker, count)
 }
 
+// GetDirUsage : Total size and number of files and directories under a directory, from a recursive path enumeration
+func (dl *Datalake) GetDirUsage(ctx context.Context, name string) (size int64, fileCount int64, dirCount int64, err error) {
+	log.Trace("Datalake::GetDirUsage : name %s", name)
+
+	dirPath := joinPrefixPath(dl.Config.prefixPath, name)
+	pager := dl.filesystemClient().NewListPathsPager(true, &filesystem.ListPathsOptions{
+		Prefix: &dirPath,
+	})
+
+	for pager.More() {
+		listPathResp, err := pager.NextPage(ctx)
+		if err != nil {
+			serr := storeDatalakeErrToErr(err)
+			if serr == ErrFileNotFound {
+				log.Err("Datalake::GetDirUsage : %s does not exist", name)
+				return 0, 0, 0, syscall.ENOENT
+			}
+			log.Err("Datalake::GetDirUsage : Failed to list paths under %s [%s]", name, err.Error())
+			return 0, 0, 0, err
+		}
+
+		for _, pathInfo := range listPathResp.Paths {
+			if pathInfo.IsDirectory != nil && *pathInfo.IsDirectory {
+				dirCount++
+			} else {
+				fileCount++
+				if pathInfo.ContentLength != nil {
+					size += *pathInfo.ContentLength
+				}
+			}
+		}
+	}
+
+	return size, fileCount, dirCount, nil
+}
+
+func (dl *Datalake) ListFlat(prefix string, marker *string, count int32) ([]*internal.ObjAttr, *string, error) {
+	return dl.BlockBlob.ListFlat(prefix, marker, count)
+}
+
 // ReadToFile : Download a file to a local file
-func (dl *Datalake) ReadToFile(name string, offset int64, count int64, fi *os.File) (err error) {
-	return dl.BlockBlob.ReadToFile(name, offset, count, fi)
+func (dl *Datalake) ReadToFile(options internal.CopyToFileOptions) (err error) {
+	return dl.BlockBlob.ReadToFile(options)
 }
 
 // ReadBuffer : Download a specific range from a file to a buffer
@@ -472,16 +709,27 @@ func (dl *Datalake) ReadInBuffer(name string, offset int64, len int64, data []by
 	return dl.BlockBlob.ReadInBuffer(name, offset, len, data, etag)
 }
 
+// ReadVersionInBuffer : Download specific range from a version of the file to a user provided buffer
+func (dl *Datalake) ReadVersionInBuffer(name string, versionID string, offset int64, len int64, data []byte) error {
+	return dl.BlockBlob.ReadVersionInBuffer(name, versionID, offset, len, data)
+}
+
 // WriteFromFile : Upload local file to file
-func (dl *Datalake) WriteFromFile(name string, metadata map[string]*string, fi *os.File) (err error) {
+func (dl *Datalake) WriteFromFile(options internal.CopyFromFileOptions) (err error) {
 	// File in DataLake may have permissions and ACL set. Just uploading the file will override them.
 	// So, we need to get the existing permissions and ACL and set them back after uploading the file.
 
+	name := options.Name
+	if len(options.Tags) > 0 {
+		log.Err("Datalake::WriteFromFile : Blob index tags are not supported on HNS accounts, %s", name)
+		return syscall.ENOTSUP
+	}
+
 	var acl string = ""
 	var fileClient *file.Client = nil
 
 	if dl.Config.preserveACL {
-		fileClient = dl.Filesystem.NewFileClient(filepath.Join(dl.Config.prefixPath, name))
+		fileClient = dl.filesystemClient().NewFileClient(joinPrefixPath(dl.Config.prefixPath, name))
 		resp, err := fileClient.GetAccessControl(context.Background(), nil)
 		if err != nil {
 			log.Err("Datalake::getACL : Failed to get ACLs for file %s [%s]", name, err.Error())
@@ -491,7 +739,7 @@ func (dl *Datalake) WriteFromFile(name string, metadata map[string]*string, fi *
 	}
 
 	// Upload the file, which will override the permissions and ACL
-	retCode := dl.BlockBlob.WriteFromFile(name, metadata, fi)
+	retCode := dl.BlockBlob.WriteFromFile(options)
 
 	if acl != "" {
 		// Cannot set both permissions and ACL in one call. ACL includes permission as well so just setting those back
@@ -515,13 +763,33 @@ func (dl *Datalake) WriteFromBuffer(name string, metadata map[string]*string, da
 	return dl.BlockBlob.WriteFromBuffer(name, metadata, data)
 }
 
+// CreateImmutable : Create a write-once file, optionally under legal hold / immutability policy
+func (dl *Datalake) CreateImmutable(name string, data []byte, legalHold bool, expiry *time.Time) error {
+	return dl.BlockBlob.CreateImmutable(name, data, legalHold, expiry)
+}
+
+// SetImmutabilityPolicy : Keep the file immutable till the given time
+func (dl *Datalake) SetImmutabilityPolicy(name string, until time.Time, mode string) error {
+	return dl.BlockBlob.SetImmutabilityPolicy(name, until, mode)
+}
+
+// ClearImmutabilityPolicy : Remove the unlocked immutability policy of the file
+func (dl *Datalake) ClearImmutabilityPolicy(name string) error {
+	return dl.BlockBlob.ClearImmutabilityPolicy(name)
+}
+
+// SetLegalHold : Put the file under legal hold or release it
+func (dl *Datalake) SetLegalHold(name string, on bool) error {
+	return dl.BlockBlob.SetLegalHold(name, on)
+}
+
 // Write : Write to a file at given offset
 func (dl *Datalake) Write(options internal.WriteFileOptions) error {
 	return dl.BlockBlob.Write(options)
 }
 
-func (dl *Datalake) StageAndCommit(name string, bol *common.BlockOffsetList) error {
-	return dl.BlockBlob.StageAndCommit(name, bol)
+func (dl *Datalake) StageAndCommit(name string, bol *common.BlockOffsetList, concurrency uint16) error {
+	return dl.BlockBlob.StageAndCommit(name, bol, concurrency)
 }
 
 func (dl *Datalake) GetFileBlockOffsets(name string) (*common.BlockOffsetList, error) {
@@ -535,7 +803,7 @@ func (dl *Datalake) TruncateFile(name string, size int64) error {
 // ChangeMod : Change mode of a path
 func (dl *Datalake) ChangeMod(name string, mode os.FileMode) error {
 	log.Trace("Datalake::ChangeMod : Change mode of file %s to %s", name, mode)
-	fileClient := dl.Filesystem.NewFileClient(filepath.Join(dl.Config.prefixPath, name))
+	fileClient := dl.filesystemClient().NewFileClient(joinPrefixPath(dl.Config.prefixPath, name))
 
 	/*
 		// If we need to call the ACL set api then we need to get older acl string here
@@ -571,6 +839,110 @@ func (dl *Datalake) ChangeMod(name string, mode os.FileMode) error {
 	return nil
 }
 
+// GetACL : Get the ACL of a path, including named user and group entries
+func (dl *Datalake) GetACL(name string) (string, error) {
+	log.Trace("Datalake::GetACL : name %s", name)
+	fileClient := dl.filesystemClient().NewFileClient(joinPrefixPath(dl.Config.prefixPath, name))
+
+	resp, err := fileClient.GetAccessControl(context.Background(), nil)
+	if err != nil {
+		log.Err("Datalake::GetACL : Failed to get ACL of %s [%s]", name, err.Error())
+		e := storeDatalakeErrToErr(err)
+		if e == ErrFileNotFound {
+			return "", syscall.ENOENT
+		} else if e == InvalidPermission {
+			return "", syscall.EACCES
+		}
+		return "", err
+	}
+
+	if resp.ACL == nil {
+		return "", nil
+	}
+	return *resp.ACL, nil
+}
+
+// SetACL : Replace the ACL of a path, e.g. "user::rwx,user:<object id>:r-x,group::r-x,mask::r-x,other::---"
+func (dl *Datalake) SetACL(name string, acl string) error {
+	log.Trace("Datalake::SetACL : name %s, acl %s", name, acl)
+	fileClient := dl.filesystemClient().NewFileClient(joinPrefixPath(dl.Config.prefixPath, name))
+
+	_, err := fileClient.SetAccessControl(context.Background(), &file.SetAccessControlOptions{
+		ACL: &acl,
+	})
+	if err != nil {
+		log.Err("Datalake::SetACL : Failed to set ACL of %s [%s]", name, err.Error())
+		e := storeDatalakeErrToErr(err)
+		if e == ErrFileNotFound {
+			return syscall.ENOENT
+		} else if e == InvalidPermission {
+			return syscall.EACCES
+		}
+		return err
+	}
+
+	return nil
+}
+
+// SetACLRecursive : Merge the given ACL entries into the ACL of a directory and everything under it.
+// Entries not named in acl are kept, paths which could not be updated are logged and fail the call with EIO.
+func (dl *Datalake) SetACLRecursive(name string, acl string) error {
+	log.Trace("Datalake::SetACLRecursive : name %s, acl %s", name, acl)
+	_, err := dl.updateACLRecursive(name, acl)
+	return err
+}
+
+// ChmodRecursive : Change mode of a directory and everything under it, named ACL entries are kept
+func (dl *Datalake) ChmodRecursive(name string, mode os.FileMode) (RecursiveACLResult, error) {
+	log.Trace("Datalake::ChmodRecursive : Change mode of %s and its children to %s", name, mode)
+	return dl.updateACLRecursive(name, getACLFromMode(mode))
+}
+
+// updateACLRecursive : Service applies the ACL to the tree in batches, the continuation token returned with each
+// batch is followed until the whole tree is done. Failed paths do not stop the operation but fail it with EIO.
+func (dl *Datalake) updateACLRecursive(name string, acl string) (RecursiveACLResult, error) {
+	dirClient := dl.filesystemClient().NewDirectoryClient(joinPrefixPath(dl.Config.prefixPath, name))
+
+	var result RecursiveACLResult
+	resp, err := dirClient.UpdateAccessControlRecursive(context.Background(), acl, &directory.UpdateAccessControlRecursiveOptions{
+		ContinueOnFailure: to.Ptr(true),
+	})
+	if resp.DirectoriesSuccessful != nil {
+		result.Directories = *resp.DirectoriesSuccessful
+	}
+	if resp.FilesSuccessful != nil {
+		result.Files = *resp.FilesSuccessful
+	}
+	if resp.FailureCount != nil {
+		result.Failures = *resp.FailureCount
+	}
+
+	if err != nil {
+		log.Err("Datalake::updateACLRecursive : Failed to set ACL under %s after %d directories and %d files [%s]",
+			name, result.Directories, result.Files, err.Error())
+		e := storeDatalakeErrToErr(err)
+		if e == ErrFileNotFound {
+			return result, syscall.ENOENT
+		} else if e == InvalidPermission {
+			return result, syscall.EACCES
+		}
+		return result, err
+	}
+
+	if result.Failures > 0 {
+		for _, entry := range resp.FailedEntries {
+			if entry.Name != nil && entry.ErrorMessage != nil {
+				log.Err("Datalake::updateACLRecursive : Failed to set ACL of %s [%s]", *entry.Name, *entry.ErrorMessage)
+			}
+		}
+		log.Err("Datalake::updateACLRecursive : ACL could not be set on %d paths under %s", result.Failures, name)
+		return result, syscall.EIO
+	}
+
+	log.Debug("Datalake::updateACLRecursive : ACL set on %d directories and %d files under %s", result.Directories, result.Files, name)
+	return result, nil
+}
+
 // ChangeOwner : Change owner of a path
 func (dl *Datalake) ChangeOwner(name string, _ int, _ int) error {
 	log.Trace("Datalake::ChangeOwner : name %s", name)
@@ -582,7 +954,7 @@ func (dl *Datalake) ChangeOwner(name string, _ int, _ int) error {
 	}
 
 	// TODO: This is not supported for now.
-	// fileURL := dl.Filesystem.NewRootDirectoryURL().NewFileURL(filepath.Join(dl.Config.prefixPath, name))
+	// fileURL := dl.filesystemClient().NewRootDirectoryURL().NewFileURL(joinPrefixPath(dl.Config.prefixPath, name))
 	// group := strconv.Itoa(gid)
 	// owner := strconv.Itoa(uid)
 	// _, err := fileURL.SetAccessControl(context.Background(), azbfs.BlobFSAccessControl{Group: group, Owner: owner})
@@ -596,6 +968,48 @@ func (dl *Datalake) ChangeOwner(name string, _ int, _ int) error {
 	return syscall.ENOTSUP
 }
 
+// SetMetadataKey : Metadata of a path is managed through the blob endpoint
+func (dl *Datalake) SetMetadataKey(name string, key string, value *string) error {
+	return dl.BlockBlob.SetMetadataKey(name, key, value)
+}
+
+// GetMetadataKey : Metadata of a path is managed through the blob endpoint
+func (dl *Datalake) GetMetadataKey(name string, key string) (*string, error) {
+	return dl.BlockBlob.GetMetadataKey(name, key)
+}
+
+// SetTier : Change the access tier of a file, tiers are managed through the blob endpoint
+func (dl *Datalake) SetTier(name string, tier blob.AccessTier) error {
+	err := dl.BlockBlob.SetTier(name, tier)
+
+	// Accounts with hierarchical namespace which do not support blob tiering reject the request
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && (respErr.StatusCode == http.StatusBadRequest || respErr.StatusCode == http.StatusConflict) {
+		log.Err("Datalake::SetTier : Access tier is not supported for %s [%s]", name, respErr.ErrorCode)
+		return syscall.ENOTSUP
+	}
+
+	return err
+}
+
+// CreateSnapshot : Snapshots are not supported on accounts with hierarchical namespace
+func (dl *Datalake) CreateSnapshot(name string) (string, error) {
+	log.Err("Datalake::CreateSnapshot : Snapshots are not supported, can not snapshot %s", name)
+	return "", syscall.ENOTSUP
+}
+
+// ListSnapshots : Snapshots are not supported on accounts with hierarchical namespace
+func (dl *Datalake) ListSnapshots(name string) ([]SnapshotInfo, error) {
+	log.Err("Datalake::ListSnapshots : Snapshots are not supported, can not list snapshots of %s", name)
+	return nil, syscall.ENOTSUP
+}
+
+// DeleteSnapshot : Snapshots are not supported on accounts with hierarchical namespace
+func (dl *Datalake) DeleteSnapshot(name string, snapshotID string) error {
+	log.Err("Datalake::DeleteSnapshot : Snapshots are not supported, can not delete snapshot %s of %s", snapshotID, name)
+	return syscall.ENOTSUP
+}
+
 // GetCommittedBlockList : Get the list of committed blocks
 func (dl *Datalake) GetCommittedBlockList(name string) (*internal.CommittedBlockList, error) {
 	return dl.BlockBlob.GetCommittedBlockList(name)
@@ -607,8 +1021,12 @@ func (dl *Datalake) StageBlock(name string, data []byte, id string) error {
 }
 
 // CommitBlocks : persists the block list
-func (dl *Datalake) CommitBlocks(name string, blockList []string, newEtag *string) error {
-	return dl.BlockBlob.CommitBlocks(name, blockList, newEtag)
+func (dl *Datalake) CommitBlocks(name string, blockList []string, newEtag *string, tags map[string]string, ifMatch string, contentType string) error {
+	if len(tags) > 0 {
+		log.Err("Datalake::CommitBlocks : Blob index tags are not supported on HNS accounts, %s", name)
+		return syscall.ENOTSUP
+	}
+	return dl.BlockBlob.CommitBlocks(name, blockList, newEtag, nil, ifMatch, contentType)
 }
 
 func (dl *Datalake) SetFilter(filter string) error {
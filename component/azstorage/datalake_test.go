@@ -43,16 +43,22 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
@@ -238,7 +244,7 @@ func (s *datalakeTestSuite) TestListContainers() {
 		defer f.Delete(ctx, nil)
 	}
 
-	containers, err := s.az.ListContainers()
+	containers, err := s.az.ListContainers("")
 
 	s.assert.Nil(err)
 	s.assert.NotNil(containers)
@@ -309,6 +315,40 @@ func (s *datalakeTestSuite) TestCreateDirWithCPKEnabled() {
 	}
 }
 
+func (s *datalakeTestSuite) TestCreateDirConcurrent() {
+	defer s.cleanupTest()
+	name := generateDirectoryName()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.az.CreateDir(internal.CreateDirOptions{Name: name})
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		s.assert.Nil(err)
+	}
+	attr, err := s.az.GetAttr(internal.GetAttrOptions{Name: name})
+	s.assert.Nil(err)
+	s.assert.True(attr.IsDir())
+}
+
+func (s *datalakeTestSuite) TestCreateDirOverFile() {
+	defer s.cleanupTest()
+	name := generateFileName()
+	_, err := s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	s.assert.Nil(err)
+
+	err = s.az.CreateDir(internal.CreateDirOptions{Name: name})
+	s.assert.NotNil(err)
+	s.assert.EqualValues(syscall.EEXIST, err)
+}
+
 func (s *datalakeTestSuite) TestDeleteDir() {
 	defer s.cleanupTest()
 	// Testing dir and dir/
@@ -1267,6 +1307,38 @@ func (s *datalakeTestSuite) TestDeleteFileError() {
 	s.assert.NotNil(err)
 }
 
+func (s *datalakeTestSuite) TestPercentSignNameRoundTrip() {
+	defer s.cleanupTest()
+	// Names are passed to the service as is, a literal percent sign or something that looks
+	// like an escape sequence shall come back unchanged
+	dir := generateDirectoryName()
+	s.az.CreateDir(internal.CreateDirOptions{Name: dir})
+	src := dir + "/50%off" + randomString(4) + ".txt"
+	dst := dir + "/100%25" + randomString(4) + ".txt"
+
+	h, err := s.az.CreateFile(internal.CreateFileOptions{Name: src})
+	s.assert.Nil(err)
+	s.assert.NotNil(h)
+
+	attr, err := s.az.GetAttr(internal.GetAttrOptions{Name: src})
+	s.assert.Nil(err)
+	s.assert.Equal(src, attr.Path)
+
+	entries, err := s.az.ReadDir(internal.ReadDirOptions{Name: dir})
+	s.assert.Nil(err)
+	s.assert.Len(entries, 1)
+	s.assert.Equal(src, entries[0].Path)
+
+	err = s.az.RenameFile(internal.RenameFileOptions{Src: src, Dst: dst})
+	s.assert.Nil(err)
+
+	_, err = s.az.GetAttr(internal.GetAttrOptions{Name: src})
+	s.assert.Equal(syscall.ENOENT, err)
+	attr, err = s.az.GetAttr(internal.GetAttrOptions{Name: dst})
+	s.assert.Nil(err)
+	s.assert.Equal(dst, attr.Path)
+}
+
 func (s *datalakeTestSuite) TestRenameFile() {
 	defer s.cleanupTest()
 	// Setup
@@ -1339,6 +1411,50 @@ func (s *datalakeTestSuite) TestRenameFileWithCPKenabled() {
 	s.assert.Nil(err)
 }
 
+func (s *datalakeTestSuite) TestRenameFileWithRotatedCPK() {
+	defer s.cleanupTest()
+	oldKey, oldKeySHA256 := generateCPKInfo()
+	newKey, newKeySHA256 := generateCPKInfo()
+
+	// Mount is configured with the new key while the source was written with the old one
+	config := fmt.Sprintf("azstorage:\n  account-name: %s\n  endpoint: https://%s.dfs.core.windows.net/\n  type: adls\n  account-key: %s\n  mode: key\n  container: %s\n  cpk-enabled: true\n  cpk-encryption-key: %s\n  cpk-encryption-key-sha256: %s\n",
+		storageTestConfigurationParameters.AdlsAccount, storageTestConfigurationParameters.AdlsAccount, storageTestConfigurationParameters.AdlsKey, s.container, newKey, newKeySHA256)
+	s.setupTestHelper(config, s.container, false)
+
+	src := generateFileName()
+	dst := generateFileName()
+
+	data := []byte("test data")
+	err := uploadReaderAtToBlockBlob(
+		ctx, bytes.NewReader(data),
+		int64(len(data)),
+		100,
+		s.az.storage.(*Datalake).BlockBlob.Container.NewBlockBlobClient(src),
+		&blockblob.UploadBufferOptions{
+			CPKInfo: &blob.CPKInfo{
+				EncryptionKey:       &oldKey,
+				EncryptionKeySHA256: &oldKeySHA256,
+				EncryptionAlgorithm: to.Ptr(blob.EncryptionAlgorithmTypeAES256),
+			},
+		})
+	s.assert.Nil(err)
+
+	err = s.az.RenameFile(internal.RenameFileOptions{Src: src, Dst: dst})
+	s.assert.NotNil(err)
+	s.assert.EqualValues(syscall.EACCES, err)
+
+	// Src should still be in the account with the old key
+	source := s.containerClient.NewFileClient(src)
+	_, err = source.GetProperties(ctx, &file.GetPropertiesOptions{
+		CPKInfo: &file.CPKInfo{
+			EncryptionKey:       &oldKey,
+			EncryptionKeySHA256: &oldKeySHA256,
+			EncryptionAlgorithm: to.Ptr(file.EncryptionAlgorithmTypeAES256),
+		},
+	})
+	s.assert.Nil(err)
+}
+
 func (s *datalakeTestSuite) TestRenameFileMetadataConservation() {
 	defer s.cleanupTest()
 	// Setup
@@ -1873,6 +1989,40 @@ func (s *datalakeTestSuite) TestGetAttrLink() {
 	s.assert.True(checkMetadata(props.Metadata, symlinkKey, "true"))
 }
 
+func (s *datalakeTestSuite) TestGetAttrFileWithTrailingSlash() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	dirName := generateDirectoryName()
+	s.az.CreateDir(internal.CreateDirOptions{Name: dirName})
+
+	_, err := s.az.GetAttr(internal.GetAttrOptions{Name: name + "/"})
+	s.assert.NotNil(err)
+	s.assert.EqualValues(syscall.ENOTDIR, err)
+
+	attr, err := s.az.GetAttr(internal.GetAttrOptions{Name: dirName + "/"})
+	s.assert.Nil(err)
+	s.assert.True(attr.IsDir())
+}
+
+func (s *datalakeTestSuite) TestGetAttrLinkSize() {
+	defer s.cleanupTest()
+	// Setup
+	target := generateFileName()
+	h, _ := s.az.CreateFile(internal.CreateFileOptions{Name: target})
+	data := []byte("symlink target has more data than its name")
+	s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: data})
+	name := generateFileName()
+	s.az.CreateLink(internal.CreateLinkOptions{Name: name, Target: target})
+
+	props, err := s.az.GetAttr(internal.GetAttrOptions{Name: name})
+	s.assert.Nil(err)
+	s.assert.NotNil(props)
+	s.assert.True(props.IsSymlink())
+	s.assert.EqualValues(len(target), props.Size)
+}
+
 func (s *datalakeTestSuite) TestGetAttrFileSize() {
 	defer s.cleanupTest()
 	// Setup
@@ -1952,6 +2102,36 @@ func (s *datalakeTestSuite) TestChmodError() {
 	s.assert.EqualValues(syscall.ENOENT, err)
 }
 
+func (s *datalakeTestSuite) TestChmodRecursive() {
+	defer s.cleanupTest()
+	// Setup
+	base := generateDirectoryName()
+	a, ab, ac := s.setupHierarchy(base)
+
+	result, err := s.az.ChmodRecursive(base, 0750)
+	s.assert.Nil(err)
+	s.assert.EqualValues(2, result.Directories)
+	s.assert.EqualValues(2, result.Files)
+	s.assert.EqualValues(0, result.Failures)
+
+	// Every path under the directory changed, siblings sharing its name as prefix did not
+	for p := a.Front(); p != nil; p = p.Next() {
+		acl, err := getACL(s.az.storage.(*Datalake), p.Value.(string))
+		s.assert.Nil(err)
+		s.assert.EqualValues("user::rwx,group::r-x,other::---", acl, p.Value.(string))
+	}
+	for _, l := range []*list.List{ab, ac} {
+		for p := l.Front(); p != nil; p = p.Next() {
+			acl, err := getACL(s.az.storage.(*Datalake), p.Value.(string))
+			s.assert.Nil(err)
+			s.assert.NotEqualValues("user::rwx,group::r-x,other::---", acl, p.Value.(string))
+		}
+	}
+
+	_, err = s.az.ChmodRecursive(generateDirectoryName(), 0750)
+	s.assert.EqualValues(syscall.ENOENT, err)
+}
+
 // If support for chown or chmod are ever added to blob, add tests for error cases and modify the following tests.
 func (s *datalakeTestSuite) TestChown() {
 	defer s.cleanupTest()
@@ -2559,7 +2739,7 @@ func (s *datalakeTestSuite) TestDownloadWithCPKEnabled() {
 	s.assert.Nil(err)
 	s.assert.NotNil(f)
 
-	err = s.az.storage.ReadToFile(name, 0, int64(len(data)), f)
+	err = s.az.storage.ReadToFile(internal.CopyToFileOptions{Name: name, Offset: 0, Count: int64(len(data)), File: f})
 	s.assert.Nil(err)
 	fileData, err := os.ReadFile(name)
 	s.assert.Nil(err)
@@ -2603,7 +2783,7 @@ func (s *datalakeTestSuite) TestUploadWithCPKEnabled() {
 	s.assert.Nil(err)
 	_, _ = f.Seek(0, 0)
 
-	err = s.az.storage.WriteFromFile(name1, nil, f)
+	err = s.az.storage.WriteFromFile(internal.CopyFromFileOptions{Name: name1, File: f})
 	s.assert.Nil(err)
 
 	// Blob should have updated data
@@ -2649,14 +2829,7 @@ func (s *datalakeTestSuite) TestUploadWithCPKEnabled() {
 }
 
 func getACL(dl *Datalake, name string) (string, error) {
-	fileClient := dl.Filesystem.NewFileClient(filepath.Join(dl.Config.prefixPath, name))
-	acl, err := fileClient.GetAccessControl(context.Background(), nil)
-
-	if err != nil || acl.ACL == nil {
-		return "", err
-	}
-
-	return *acl.ACL, nil
+	return dl.GetACL(name)
 }
 
 func (s *datalakeTestSuite) createFileWithData(name string, data []byte, mode os.FileMode) {
@@ -2770,6 +2943,31 @@ func (s *datalakeTestSuite) TestPermissionPreservationWithCommit() {
 	s.assert.Contains(acl, "other::rwx")
 }
 
+func (s *datalakeTestSuite) TestCommitDataWithTags() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	data := []byte("123123")
+
+	id := base64.StdEncoding.EncodeToString(common.NewUUIDWithLength(16))
+	err := s.az.StageData(internal.StageDataOptions{
+		Name:   name,
+		Id:     id,
+		Data:   data,
+		Offset: 0,
+	})
+	s.assert.Nil(err)
+
+	err = s.az.CommitData(internal.CommitDataOptions{
+		Name:      name,
+		List:      []string{id},
+		BlockSize: 1,
+		Tags:      map[string]string{"project": "blobfuse"},
+	})
+	s.assert.NotNil(err)
+	s.assert.EqualValues(syscall.ENOTSUP, err)
+}
+
 func (s *datalakeTestSuite) TestBlobFilters() {
 	defer s.cleanupTest()
 	// Setup
@@ -2895,6 +3093,55 @@ func (s *datalakeTestSuite) TestList() {
 	s.assert.NotEqual(0, blobList[0].Mode)
 }
 
+// Named ACL entries take the object id of the principal, it does not need to exist in the tenant
+const testACLObjectID = "a9c1d2e3-0f4b-4c5d-8e6f-7a8b9c0d1e2f"
+
+func (s *datalakeTestSuite) TestSetACLNamedUser() {
+	defer s.cleanupTest()
+	name := generateFileName()
+	s.createFileWithData(name, []byte("test data"), fs.FileMode(0750))
+	dl := s.az.storage.(*Datalake)
+
+	err := dl.SetACL(name, "user::rwx,user:"+testACLObjectID+":r-x,group::r-x,mask::r-x,other::---")
+	s.assert.Nil(err)
+
+	acl, err := getACL(dl, name)
+	s.assert.Nil(err)
+	s.assert.Contains(acl, "user:"+testACLObjectID+":r-x")
+	s.assert.Contains(acl, "user::rwx")
+	s.assert.Contains(acl, "other::---")
+
+	_, err = dl.GetACL(generateFileName())
+	s.assert.Equal(syscall.ENOENT, err)
+	err = dl.SetACL(generateFileName(), "user::rwx,group::r-x,other::---")
+	s.assert.Equal(syscall.ENOENT, err)
+}
+
+func (s *datalakeTestSuite) TestSetACLRecursive() {
+	defer s.cleanupTest()
+	dirName := generateDirectoryName()
+	s.assert.Nil(s.az.CreateDir(internal.CreateDirOptions{Name: dirName}))
+	subDir := dirName + "/sub"
+	s.assert.Nil(s.az.CreateDir(internal.CreateDirOptions{Name: subDir}))
+	fileName := subDir + "/" + generateFileName()
+	s.createFileWithData(fileName, []byte("test data"), fs.FileMode(0640))
+	dl := s.az.storage.(*Datalake)
+
+	err := dl.SetACLRecursive(dirName, "user:"+testACLObjectID+":rwx")
+	s.assert.Nil(err)
+
+	for _, path := range []string{dirName, subDir, fileName} {
+		acl, err := getACL(dl, path)
+		s.assert.Nil(err)
+		s.assert.Contains(acl, "user:"+testACLObjectID+":rwx", path)
+	}
+
+	// Entries which are not named are left as they were
+	acl, err := getACL(dl, fileName)
+	s.assert.Nil(err)
+	s.assert.Contains(acl, "other::---")
+}
+
 // func (s *datalakeTestSuite) TestRAGRS() {
 // 	defer s.cleanupTest()
 // 	// Setup
@@ -2922,6 +3169,362 @@ func (s *datalakeTestSuite) TestList() {
 // 	s.az.CloseFile(internal.CloseFileOptions{Handle: h})
 // }
 
+// newUnreachableFileClient : datalake file client pointing to an endpoint where nothing is listening
+func newUnreachableFileClient() *file.Client {
+	fileClient, _ := file.NewClientWithNoCredential("http://127.0.0.1:1/fs/file", &file.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	return fileClient
+}
+
+func (s *azStorageTestSuite) TestEnrichAttrWithACLFailure() {
+	dl := &Datalake{}
+	dl.Config.honourACL = true
+	dl.Config.authConfig.ObjectID = "objid"
+
+	mtime := time.Now()
+	attr := &internal.ObjAttr{
+		Path:  "file",
+		Name:  "file",
+		Size:  100,
+		Mode:  0644,
+		Mtime: mtime,
+		Flags: internal.NewFileBitMap(),
+	}
+
+	dl.enrichAttrWithACL(newUnreachableFileClient(), attr)
+	s.assert.EqualValues(100, attr.Size)
+	s.assert.Equal(mtime, attr.Mtime)
+	s.assert.EqualValues(0644, attr.Mode)
+}
+
+func (s *azStorageTestSuite) TestGetAttrWithACLSingleCall() {
+	var lock sync.Mutex
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests = append(requests, r)
+		lock.Unlock()
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", "\"0x8D\"")
+		w.Header().Set("x-ms-owner", "objid")
+		if r.URL.Query().Get("action") == "getAccessControl" {
+			// Access control response carries the ACL only, no size, resource type nor properties
+			w.Header().Set("Content-Length", "0")
+			w.Header().Set("x-ms-permissions", "rw-r-----")
+			w.Header().Set("x-ms-acl", "user::rw-,group::r--,other::---")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Length", "100")
+		w.Header().Set("x-ms-resource-type", "file")
+		w.Header().Set("x-ms-permissions", "rw-------")
+		w.Header().Set("x-ms-meta-foo", "bar")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	fsClient, err := filesystem.NewClientWithNoCredential(srv.URL+"/fs", &filesystem.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	s.assert.Nil(err)
+
+	dl := &Datalake{Filesystem: fsClient}
+	dl.Config.honourACL = true
+	dl.Config.aclSingleCall = true
+	dl.Config.authConfig.ObjectID = "objid"
+
+	// Core attributes come from the properties, mode from the ACL requested alongside them
+	attr, err := dl.GetAttr("file")
+	s.assert.Nil(err)
+	s.assert.NotNil(attr)
+	s.assert.EqualValues(100, attr.Size)
+	s.assert.EqualValues(0640, attr.Mode)
+	s.assert.False(attr.IsDir())
+	s.assert.Equal("bar", *attr.Metadata["Foo"])
+
+	s.assert.Len(requests, 2)
+	actions := []string{requests[0].URL.Query().Get("action"), requests[1].URL.Query().Get("action")}
+	s.assert.ElementsMatch([]string{"", "getAccessControl"}, actions)
+}
+
+func (s *azStorageTestSuite) TestGetDirUsageDatalake() {
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("continuation") == "" {
+			w.Header().Set("x-ms-continuation", "page2")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"paths":[{"name":"base/dir/a","contentLength":"10","isDirectory":"false"},` +
+				`{"name":"base/dir/sub","contentLength":"0","isDirectory":"true"}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"paths":[{"name":"base/dir/sub/b","contentLength":"32","isDirectory":"false"}]}`))
+	}))
+	defer srv.Close()
+
+	fsClient, err := filesystem.NewClientWithNoCredential(srv.URL+"/fs", &filesystem.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	s.assert.Nil(err)
+	dl := &Datalake{Filesystem: fsClient}
+	dl.Config.prefixPath = "base"
+
+	size, files, dirs, err := dl.GetDirUsage(context.Background(), "dir")
+	s.assert.Nil(err)
+	s.assert.EqualValues(42, size)
+	s.assert.EqualValues(2, files)
+	s.assert.EqualValues(1, dirs)
+
+	s.assert.Len(requests, 2)
+	s.assert.Equal("true", requests[0].URL.Query().Get("recursive"))
+	s.assert.Equal("base/dir", requests[0].URL.Query().Get("directory"))
+}
+
+func (s *azStorageTestSuite) TestDatalakeListSkipsOwnMarker() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="cont"><Blobs>` +
+			`<Blob><Name>dir/</Name><Properties><Content-Length>0</Content-Length><Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified></Properties></Blob>` +
+			`<Blob><Name>dir/file</Name><Properties><Content-Length>10</Content-Length><Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified></Properties></Blob>` +
+			`</Blobs><NextMarker/></EnumerationResults>`))
+	}))
+	defer srv.Close()
+
+	bb, err := newTreeBlockBlob(srv)
+	s.assert.Nil(err)
+	dl := &Datalake{}
+	dl.BlockBlob.Container = bb.Container
+	dl.BlockBlob.listDetails = bb.listDetails
+
+	list, _, err := dl.List("dir/", nil, 0)
+	s.assert.Nil(err)
+	s.assert.Len(list, 1)
+	s.assert.Equal("dir/file", list[0].Path)
+
+	// Raw view set through a config reload returns the marker as well
+	cfg := dl.Config
+	cfg.listDirMarker = true
+	s.assert.Nil(dl.UpdateConfig(cfg))
+	list, _, err = dl.List("dir/", nil, 0)
+	s.assert.Nil(err)
+	s.assert.Len(list, 2)
+}
+
+func (s *azStorageTestSuite) TestDatalakeGetAttrIfModifiedSingleRequest() {
+	var requests atomic.Int32
+	etag := "etag1"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("ETag", `"`+etag+`"`)
+		if r.Header.Get("If-None-Match") == `"`+etag+`"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Length", "42")
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("x-ms-permissions", "rwxr-x---")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	fsClient, err := filesystem.NewClientWithNoCredential(srv.URL+"/fs", &filesystem.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	s.assert.Nil(err)
+	dl := &Datalake{Filesystem: fsClient}
+
+	attr, err := dl.GetAttrIfModified("file", "etag1")
+	s.assert.Equal(internal.ErrNotModified, err)
+	s.assert.Nil(attr)
+	s.assert.EqualValues(1, requests.Load())
+
+	// Changed path is returned from the conditional response itself
+	etag = "etag2"
+	attr, err = dl.GetAttrIfModified("file", "etag1")
+	s.assert.Nil(err)
+	s.assert.EqualValues(2, requests.Load())
+	s.assert.Equal("etag2", attr.ETag)
+	s.assert.EqualValues(42, attr.Size)
+	s.assert.Equal(os.FileMode(0750), attr.Mode)
+}
+
+func (s *azStorageTestSuite) TestRenameDirCPKChild() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut:
+			// Service refuses the rename as a child is encrypted with a key this mount does not have
+			w.Header().Set("x-ms-error-code", "PathUsesCustomerSpecifiedEncryption")
+			w.WriteHeader(http.StatusConflict)
+		case r.Method == http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"paths":[{"name":"src/a","contentLength":"1","isDirectory":"false"},` +
+				`{"name":"src/sub","contentLength":"0","isDirectory":"true"},` +
+				`{"name":"src/sub/secret","contentLength":"1","isDirectory":"false"},` +
+				`{"name":"src/locked","contentLength":"1","isDirectory":"false"}]}`))
+		case r.URL.Path == "/fs/src/sub/secret" || r.URL.Path == "/fs/src/locked":
+			w.Header().Set("x-ms-error-code", "BlobUsesCustomerSpecifiedEncryption")
+			w.WriteHeader(http.StatusConflict)
+		default:
+			w.Header().Set("Content-Length", "1")
+			w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+
+	fsClient, err := filesystem.NewClientWithNoCredential(srv.URL+"/fs", &filesystem.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	s.assert.Nil(err)
+	dl := &Datalake{Filesystem: fsClient}
+
+	err = dl.RenameDirectory("src", "dst")
+	s.assert.ErrorIs(err, syscall.EACCES)
+	var cpkErr *RenameDirCPKError
+	s.assert.True(errors.As(err, &cpkErr))
+	s.assert.Equal("src", cpkErr.Source)
+	s.assert.Equal("dst", cpkErr.Target)
+	s.assert.Equal([]string{"src/locked", "src/sub/secret"}, cpkErr.Paths)
+	s.assert.Equal("failed to rename src to dst, 2 path(s) are encrypted with a different customer provided key: src/locked, src/sub/secret", err.Error())
+}
+
+func (s *azStorageTestSuite) TestDatalakeNamedUserACL() {
+	var lock sync.Mutex
+	acls := map[string]string{"/fs/dir": "user::rwx,group::r-x,other::---", "/fs/dir/file": "user::rw-,group::r--,other::---"}
+	failRecursive := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		acl, ok := acls[r.URL.Path]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "PathNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.URL.Query().Get("action") {
+		case "getAccessControl":
+			w.Header().Set("x-ms-acl", acl)
+			w.WriteHeader(http.StatusOK)
+		case "setAccessControl":
+			acls[r.URL.Path] = r.Header.Get("x-ms-acl")
+			w.WriteHeader(http.StatusOK)
+		case "setAccessControlRecursive":
+			s.assert.Equal("modify", r.URL.Query().Get("mode"))
+			w.Header().Set("Content-Type", "application/json")
+			if failRecursive {
+				_, _ = w.Write([]byte(`{"directoriesSuccessful":1,"filesSuccessful":0,"failureCount":1,"failedEntries":[{"name":"dir/file","type":"FILE","errorMessage":"denied"}]}`))
+				return
+			}
+			for path := range acls {
+				if strings.HasPrefix(path, r.URL.Path) {
+					acls[path] += "," + r.Header.Get("x-ms-acl")
+				}
+			}
+			_, _ = w.Write([]byte(`{"directoriesSuccessful":1,"filesSuccessful":1,"failureCount":0,"failedEntries":[]}`))
+		}
+	}))
+	defer srv.Close()
+
+	fsClient, err := filesystem.NewClientWithNoCredential(srv.URL+"/fs", &filesystem.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	s.assert.Nil(err)
+	dl := &Datalake{Filesystem: fsClient}
+
+	err = dl.SetACL("dir/file", "user::rw-,user:alice:rwx,group::r--,mask::rwx,other::---")
+	s.assert.Nil(err)
+	acl, err := dl.GetACL("dir/file")
+	s.assert.Nil(err)
+	s.assert.Contains(acl, "user:alice:rwx")
+
+	err = dl.SetACLRecursive("dir", "user:bob:r-x")
+	s.assert.Nil(err)
+	for _, name := range []string{"dir", "dir/file"} {
+		acl, err = dl.GetACL(name)
+		s.assert.Nil(err)
+		s.assert.Contains(acl, "user:bob:r-x", name)
+	}
+
+	lock.Lock()
+	failRecursive = true
+	lock.Unlock()
+	err = dl.SetACLRecursive("dir", "user:carol:r-x")
+	s.assert.Equal(syscall.EIO, err)
+
+	_, err = dl.GetACL("missing")
+	s.assert.Equal(syscall.ENOENT, err)
+	err = dl.SetACL("missing", "user::rwx,group::---,other::---")
+	s.assert.Equal(syscall.ENOENT, err)
+}
+
+func (s *azStorageTestSuite) TestDatalakeChmodRecursive() {
+	var acl string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/fs/dir" {
+			w.Header().Set("x-ms-error-code", "PathNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		acl = r.Header.Get("x-ms-acl")
+		w.Header().Set("Content-Type", "application/json")
+		// Tree is done in two batches, the second one asked for with the continuation of the first
+		if r.URL.Query().Get("continuation") == "" {
+			w.Header().Set("x-ms-continuation", "next")
+			_, _ = w.Write([]byte(`{"directoriesSuccessful":2,"filesSuccessful":3,"failureCount":0,"failedEntries":[]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"directoriesSuccessful":1,"filesSuccessful":4,"failureCount":1,"failedEntries":[{"name":"dir/locked","type":"FILE","errorMessage":"denied"}]}`))
+	}))
+	defer srv.Close()
+
+	fsClient, err := filesystem.NewClientWithNoCredential(srv.URL+"/fs", &filesystem.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	s.assert.Nil(err)
+	dl := &Datalake{Filesystem: fsClient}
+
+	result, err := dl.ChmodRecursive("dir", 0750)
+	s.assert.Equal(syscall.EIO, err)
+	s.assert.Equal(RecursiveACLResult{Directories: 3, Files: 7, Failures: 1}, result)
+	s.assert.Equal("user::rwx,group::r-x,other::---", acl)
+
+	_, err = dl.ChmodRecursive("missing", 0750)
+	s.assert.Equal(syscall.ENOENT, err)
+
+	_, err = (&BlockBlob{}).ChmodRecursive("dir", 0750)
+	s.assert.Equal(syscall.ENOTSUP, err)
+}
+
+func (s *azStorageTestSuite) TestDatalakeRenameUnsupportedTarget() {
+	// Rejected before any request is made, no client is needed
+	dl := &Datalake{}
+	for _, target := range []string{"dir/a ", " a", "a?b"} {
+		s.assert.Equal(syscall.EINVAL, dl.RenameFile("src", target, nil), target)
+		s.assert.Equal(syscall.EINVAL, dl.RenameDirectory("src", target), target)
+	}
+	s.assert.True(renameTargetSupported("dir/a#b%20c."))
+}
+
 // In order for 'go test' to run this suite, we need to create
 // a normal test function and pass our suite to suite.Run
 func TestDatalake(t *testing.T) {
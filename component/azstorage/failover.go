@@ -0,0 +1,501 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2025 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package azstorage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+)
+
+const (
+	DefaultFailoverThreshold = 5
+	DefaultFailoverCooldown  = 60 * time.Second
+)
+
+// failoverConnection : AzConnection which redirects operations to a secondary endpoint/container once
+// the primary has returned given number of consecutive hard failures. After the cooldown the primary is
+// probed and operations fail back to it if it is reachable again.
+// Connections are not embedded, so every operation has to be routed here explicitly.
+type failoverConnection struct {
+	primary   AzConnection
+	secondary AzConnection
+
+	writes    bool // redirect the write operations as well, otherwise only reads are redirected
+	threshold uint32
+	cooldown  time.Duration
+
+	lock         sync.Mutex
+	failures     uint32
+	failedOver   bool
+	failedOverAt time.Time
+	probing      bool // primary is being probed, only one caller probes it at a time
+}
+
+// Verify that failoverConnection implements AzConnection interface
+var _ AzConnection = &failoverConnection{}
+
+func newFailoverConnection(primary AzConnection, secondary AzConnection, cfg AzStorageConfig) *failoverConnection {
+	return &failoverConnection{
+		primary:   primary,
+		secondary: secondary,
+		writes:    cfg.failoverWrites,
+		threshold: cfg.failoverThreshold,
+		cooldown:  cfg.failoverCooldown,
+	}
+}
+
+// isHardFailure : Errors which indicate the endpoint itself is unhealthy (transport errors, throttling and 5xx).
+// Errors mapped to a syscall error (ENOENT, EEXIST etc.) or other 4xx responses are valid outcomes of the call.
+func isHardFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return false
+	}
+
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode >= http.StatusInternalServerError || respErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	return true
+}
+
+// target : Decide whether the operation shall be served by primary or secondary
+func (f *failoverConnection) target(write bool) AzConnection {
+	f.lock.Lock()
+	probe := f.failedOver && !f.probing && time.Since(f.failedOverAt) >= f.cooldown
+	if probe {
+		f.probing = true
+	}
+	f.lock.Unlock()
+
+	if probe {
+		f.probe()
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.failedOver && (!write || f.writes) {
+		return f.secondary
+	}
+
+	return f.primary
+}
+
+// probe : Check whether the primary is reachable again and fail back to it. The probe is a network call,
+// so it runs without the lock and operations arriving meanwhile keep going to the secondary.
+func (f *failoverConnection) probe() {
+	err := f.primary.TestPipeline()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.probing = false
+	if err == nil {
+		log.Info("failoverConnection::probe : Primary is reachable again, failing back")
+		f.failedOver = false
+		f.failures = 0
+	} else {
+		log.Warn("failoverConnection::probe : Primary is still not reachable [%s]", err.Error())
+		f.failedOverAt = time.Now()
+	}
+}
+
+// record : Track consecutive hard failures of the primary and fail over once threshold is reached
+func (f *failoverConnection) record(conn AzConnection, err error) {
+	if conn != f.primary {
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !isHardFailure(err) {
+		f.failures = 0
+		return
+	}
+
+	f.failures++
+	if !f.failedOver && f.failures >= f.threshold {
+		log.Warn("failoverConnection::record : Primary failed %d consecutive times, failing over to secondary [%s]", f.failures, err.Error())
+		f.failedOver = true
+		f.failedOverAt = time.Now()
+	}
+}
+
+func (f *failoverConnection) read(op func(AzConnection) error) error {
+	conn := f.target(false)
+	err := op(conn)
+	f.record(conn, err)
+	return err
+}
+
+func (f *failoverConnection) write(op func(AzConnection) error) error {
+	conn := f.target(true)
+	err := op(conn)
+	f.record(conn, err)
+	return err
+}
+
+// ------------------------- Configuration applied to both connections -------------------------
+
+func (f *failoverConnection) Configure(cfg AzStorageConfig) error {
+	if err := f.primary.Configure(cfg); err != nil {
+		return err
+	}
+	return f.secondary.Configure(cfg)
+}
+
+func (f *failoverConnection) UpdateConfig(cfg AzStorageConfig) error {
+	if err := f.primary.UpdateConfig(cfg); err != nil {
+		return err
+	}
+	return f.secondary.UpdateConfig(cfg)
+}
+
+func (f *failoverConnection) Ping(ctx context.Context) error {
+	return f.read(func(c AzConnection) error {
+		return c.Ping(ctx)
+	})
+}
+
+func (f *failoverConnection) SetupPipeline() error {
+	if err := f.primary.SetupPipeline(); err != nil {
+		return err
+	}
+	return f.secondary.SetupPipeline()
+}
+
+// ------------------------- Mount time checks served by the primary -------------------------
+
+func (f *failoverConnection) TestPipeline() error {
+	return f.primary.TestPipeline()
+}
+
+func (f *failoverConnection) IsAccountADLS() bool {
+	return f.primary.IsAccountADLS()
+}
+
+func (f *failoverConnection) SetPrefixPath(path string) error {
+	if err := f.primary.SetPrefixPath(path); err != nil {
+		return err
+	}
+	return f.secondary.SetPrefixPath(path)
+}
+
+func (f *failoverConnection) UpdateServiceClient(key, value string) error {
+	if err := f.primary.UpdateServiceClient(key, value); err != nil {
+		return err
+	}
+	return f.secondary.UpdateServiceClient(key, value)
+}
+
+func (f *failoverConnection) SetFilter(filter string) error {
+	if err := f.primary.SetFilter(filter); err != nil {
+		return err
+	}
+	return f.secondary.SetFilter(filter)
+}
+
+// ------------------------- Read operations -------------------------
+
+func (f *failoverConnection) ListContainers(prefix string) (list []string, err error) {
+	err = f.read(func(c AzConnection) error {
+		list, err = c.ListContainers(prefix)
+		return err
+	})
+	return list, err
+}
+
+func (f *failoverConnection) ListContainersDetailed() (list []ContainerInfo, err error) {
+	err = f.read(func(c AzConnection) error {
+		list, err = c.ListContainersDetailed()
+		return err
+	})
+	return list, err
+}
+
+func (f *failoverConnection) GetAttr(name string) (attr *internal.ObjAttr, err error) {
+	err = f.read(func(c AzConnection) error {
+		attr, err = c.GetAttr(name)
+		return err
+	})
+	return attr, err
+}
+
+func (f *failoverConnection) GetAttrIfModified(name string, etag string) (attr *internal.ObjAttr, err error) {
+	err = f.read(func(c AzConnection) error {
+		attr, err = c.GetAttrIfModified(name, etag)
+		return err
+	})
+	return attr, err
+}
+
+func (f *failoverConnection) List(prefix string, marker *string, count int32) (list []*internal.ObjAttr, next *string, err error) {
+	err = f.read(func(c AzConnection) error {
+		list, next, err = c.List(prefix, marker, count)
+		return err
+	})
+	return list, next, err
+}
+
+func (f *failoverConnection) GetDirUsage(ctx context.Context, name string) (size int64, fileCount int64, dirCount int64, err error) {
+	err = f.read(func(c AzConnection) error {
+		size, fileCount, dirCount, err = c.GetDirUsage(ctx, name)
+		return err
+	})
+	return size, fileCount, dirCount, err
+}
+
+func (f *failoverConnection) ListFlat(prefix string, marker *string, count int32) (list []*internal.ObjAttr, next *string, err error) {
+	err = f.read(func(c AzConnection) error {
+		list, next, err = c.ListFlat(prefix, marker, count)
+		return err
+	})
+	return list, next, err
+}
+
+func (f *failoverConnection) ReadToFile(options internal.CopyToFileOptions) error {
+	return f.read(func(c AzConnection) error {
+		return c.ReadToFile(options)
+	})
+}
+
+func (f *failoverConnection) ReadBuffer(name string, offset int64, len int64) (data []byte, err error) {
+	err = f.read(func(c AzConnection) error {
+		data, err = c.ReadBuffer(name, offset, len)
+		return err
+	})
+	return data, err
+}
+
+func (f *failoverConnection) ReadVersionInBuffer(name string, versionID string, offset int64, len int64, data []byte) error {
+	return f.read(func(c AzConnection) error {
+		return c.ReadVersionInBuffer(name, versionID, offset, len, data)
+	})
+}
+
+func (f *failoverConnection) ReadInBuffer(name string, offset int64, len int64, data []byte, etag *string) error {
+	return f.read(func(c AzConnection) error {
+		return c.ReadInBuffer(name, offset, len, data, etag)
+	})
+}
+
+func (f *failoverConnection) GetFileBlockOffsets(name string) (bol *common.BlockOffsetList, err error) {
+	err = f.read(func(c AzConnection) error {
+		bol, err = c.GetFileBlockOffsets(name)
+		return err
+	})
+	return bol, err
+}
+
+func (f *failoverConnection) GetMetadataKey(name string, key string) (value *string, err error) {
+	err = f.read(func(c AzConnection) error {
+		value, err = c.GetMetadataKey(name, key)
+		return err
+	})
+	return value, err
+}
+
+func (f *failoverConnection) GetCommittedBlockList(name string) (list *internal.CommittedBlockList, err error) {
+	err = f.read(func(c AzConnection) error {
+		list, err = c.GetCommittedBlockList(name)
+		return err
+	})
+	return list, err
+}
+
+// ------------------------- Write operations -------------------------
+
+func (f *failoverConnection) CreateFile(name string, mode os.FileMode, contentType string) error {
+	return f.write(func(c AzConnection) error {
+		return c.CreateFile(name, mode, contentType)
+	})
+}
+
+func (f *failoverConnection) CreateDirectory(name string) error {
+	return f.write(func(c AzConnection) error {
+		return c.CreateDirectory(name)
+	})
+}
+
+func (f *failoverConnection) CreateLink(source string, target string) error {
+	return f.write(func(c AzConnection) error {
+		return c.CreateLink(source, target)
+	})
+}
+
+func (f *failoverConnection) DeleteFile(name string) error {
+	return f.write(func(c AzConnection) error {
+		return c.DeleteFile(name)
+	})
+}
+
+func (f *failoverConnection) UndeleteFile(name string) error {
+	return f.write(func(c AzConnection) error {
+		return c.UndeleteFile(name)
+	})
+}
+
+func (f *failoverConnection) DeleteDirectory(name string) error {
+	return f.write(func(c AzConnection) error {
+		return c.DeleteDirectory(name)
+	})
+}
+
+func (f *failoverConnection) RenameFile(source string, target string, srcAttr *internal.ObjAttr) error {
+	return f.write(func(c AzConnection) error {
+		return c.RenameFile(source, target, srcAttr)
+	})
+}
+
+func (f *failoverConnection) RenameDirectory(source string, target string) error {
+	return f.write(func(c AzConnection) error {
+		return c.RenameDirectory(source, target)
+	})
+}
+
+func (f *failoverConnection) WriteFromFile(options internal.CopyFromFileOptions) error {
+	return f.write(func(c AzConnection) error {
+		return c.WriteFromFile(options)
+	})
+}
+
+func (f *failoverConnection) WriteFromBuffer(name string, metadata map[string]*string, data []byte) error {
+	return f.write(func(c AzConnection) error {
+		return c.WriteFromBuffer(name, metadata, data)
+	})
+}
+
+func (f *failoverConnection) CreateImmutable(name string, data []byte, legalHold bool, expiry *time.Time) error {
+	return f.write(func(c AzConnection) error {
+		return c.CreateImmutable(name, data, legalHold, expiry)
+	})
+}
+
+func (f *failoverConnection) SetImmutabilityPolicy(name string, until time.Time, mode string) error {
+	return f.write(func(c AzConnection) error {
+		return c.SetImmutabilityPolicy(name, until, mode)
+	})
+}
+
+func (f *failoverConnection) ClearImmutabilityPolicy(name string) error {
+	return f.write(func(c AzConnection) error {
+		return c.ClearImmutabilityPolicy(name)
+	})
+}
+
+func (f *failoverConnection) SetLegalHold(name string, on bool) error {
+	return f.write(func(c AzConnection) error {
+		return c.SetLegalHold(name, on)
+	})
+}
+
+func (f *failoverConnection) Write(options internal.WriteFileOptions) error {
+	return f.write(func(c AzConnection) error {
+		return c.Write(options)
+	})
+}
+
+func (f *failoverConnection) ChangeMod(name string, mode os.FileMode) error {
+	return f.write(func(c AzConnection) error {
+		return c.ChangeMod(name, mode)
+	})
+}
+
+func (f *failoverConnection) ChmodRecursive(name string, mode os.FileMode) (result RecursiveACLResult, err error) {
+	err = f.write(func(c AzConnection) error {
+		result, err = c.ChmodRecursive(name, mode)
+		return err
+	})
+	return result, err
+}
+
+func (f *failoverConnection) ChangeOwner(name string, uid int, gid int) error {
+	return f.write(func(c AzConnection) error {
+		return c.ChangeOwner(name, uid, gid)
+	})
+}
+
+func (f *failoverConnection) SetMetadataKey(name string, key string, value *string) error {
+	return f.write(func(c AzConnection) error {
+		return c.SetMetadataKey(name, key, value)
+	})
+}
+
+func (f *failoverConnection) SetTier(name string, tier blob.AccessTier) error {
+	return f.write(func(c AzConnection) error {
+		return c.SetTier(name, tier)
+	})
+}
+
+func (f *failoverConnection) TruncateFile(name string, size int64) error {
+	return f.write(func(c AzConnection) error {
+		return c.TruncateFile(name, size)
+	})
+}
+
+func (f *failoverConnection) StageAndCommit(name string, bol *common.BlockOffsetList, concurrency uint16) error {
+	return f.write(func(c AzConnection) error {
+		return c.StageAndCommit(name, bol, concurrency)
+	})
+}
+
+func (f *failoverConnection) StageBlock(name string, data []byte, id string) error {
+	return f.write(func(c AzConnection) error {
+		return c.StageBlock(name, data, id)
+	})
+}
+
+func (f *failoverConnection) CommitBlocks(name string, blockList []string, newEtag *string, tags map[string]string, ifMatch string, contentType string) error {
+	return f.write(func(c AzConnection) error {
+		return c.CommitBlocks(name, blockList, newEtag, tags, ifMatch, contentType)
+	})
+}
@@ -0,0 +1,211 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2025 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package azstorage
+
+import (
+	"errors"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type failoverTestSuite struct {
+	suite.Suite
+	assert    *assert.Assertions
+	primary   *fakeConnection
+	secondary *fakeConnection
+	conn      *failoverConnection
+}
+
+func (s *failoverTestSuite) SetupTest() {
+	err := log.SetDefaultLogger("silent", common.LogConfig{Level: common.ELogLevel.LOG_DEBUG()})
+	if err != nil {
+		panic("Unable to set silent logger as default.")
+	}
+	s.assert = assert.New(s.T())
+
+	attrs := map[string]*internal.ObjAttr{"file": {Path: "file", Name: "file"}}
+	s.primary = &fakeConnection{attrs: attrs}
+	s.secondary = &fakeConnection{attrs: attrs}
+	s.conn = newFailoverConnection(s.primary, s.secondary, AzStorageConfig{
+		failoverThreshold: 3,
+		failoverCooldown:  time.Minute,
+	})
+}
+
+func (s *failoverTestSuite) TestIsHardFailure() {
+	s.assert.False(isHardFailure(nil))
+	s.assert.False(isHardFailure(syscall.ENOENT))
+	s.assert.False(isHardFailure(&azcore.ResponseError{StatusCode: http.StatusNotFound}))
+	s.assert.True(isHardFailure(&azcore.ResponseError{StatusCode: http.StatusServiceUnavailable}))
+	s.assert.True(isHardFailure(&azcore.ResponseError{StatusCode: http.StatusTooManyRequests}))
+	s.assert.True(isHardFailure(errors.New("connection refused")))
+}
+
+func (s *failoverTestSuite) TestFailoverAfterThreshold() {
+	s.primary.getAttrErr = errors.New("connection refused")
+
+	for i := 0; i < 3; i++ {
+		_, err := s.conn.GetAttr("file")
+		s.assert.NotNil(err)
+	}
+	s.assert.Equal(3, s.primary.getAttrCalls)
+	s.assert.Equal(0, s.secondary.getAttrCalls)
+
+	attr, err := s.conn.GetAttr("file")
+	s.assert.Nil(err)
+	s.assert.Equal("file", attr.Name)
+	s.assert.Equal(3, s.primary.getAttrCalls)
+	s.assert.Equal(1, s.secondary.getAttrCalls)
+}
+
+func (s *failoverTestSuite) TestNoFailoverOnSoftErrors() {
+	for i := 0; i < 5; i++ {
+		_, err := s.conn.GetAttr("missing")
+		s.assert.Equal(syscall.ENOENT, err)
+	}
+	s.assert.Equal(5, s.primary.getAttrCalls)
+	s.assert.Equal(0, s.secondary.getAttrCalls)
+}
+
+func (s *failoverTestSuite) TestFailuresMustBeConsecutive() {
+	s.primary.getAttrErr = errors.New("connection reset")
+	_, _ = s.conn.GetAttr("file")
+	_, _ = s.conn.GetAttr("file")
+
+	s.primary.getAttrErr = nil
+	_, err := s.conn.GetAttr("file")
+	s.assert.Nil(err)
+
+	s.primary.getAttrErr = errors.New("connection reset")
+	_, _ = s.conn.GetAttr("file")
+	_, _ = s.conn.GetAttr("file")
+	s.assert.False(s.conn.failedOver)
+	s.assert.Equal(0, s.secondary.getAttrCalls)
+}
+
+func (s *failoverTestSuite) TestFailBackAfterCooldown() {
+	s.conn.cooldown = 10 * time.Millisecond
+	s.primary.getAttrErr = errors.New("connection refused")
+	for i := 0; i < 3; i++ {
+		_, _ = s.conn.GetAttr("file")
+	}
+	s.assert.True(s.conn.failedOver)
+
+	// Primary still down after cooldown, stay on secondary
+	s.primary.testPipelineErr = errors.New("connection refused")
+	time.Sleep(20 * time.Millisecond)
+	_, err := s.conn.GetAttr("file")
+	s.assert.Nil(err)
+	s.assert.True(s.conn.failedOver)
+	s.assert.Equal(1, s.primary.testPipelineCalls)
+
+	// Primary recovered, fail back
+	s.primary.testPipelineErr = nil
+	s.primary.getAttrErr = nil
+	time.Sleep(20 * time.Millisecond)
+	_, err = s.conn.GetAttr("file")
+	s.assert.Nil(err)
+	s.assert.False(s.conn.failedOver)
+	s.assert.Equal(4, s.primary.getAttrCalls)
+	s.assert.Equal(1, s.secondary.getAttrCalls)
+}
+
+func (s *failoverTestSuite) TestWritesStayOnPrimaryInReadMode() {
+	s.conn.failedOver = true
+	s.conn.failedOverAt = time.Now()
+
+	s.assert.Equal(s.secondary, s.conn.target(false))
+	s.assert.Equal(s.primary, s.conn.target(true))
+
+	s.conn.writes = true
+	s.assert.Equal(s.secondary, s.conn.target(true))
+}
+
+func (s *failoverTestSuite) TestFailBackProbeOutsideLock() {
+	s.conn.cooldown = 10 * time.Millisecond
+	s.primary.getAttrErr = errors.New("connection refused")
+	for i := 0; i < 3; i++ {
+		_, _ = s.conn.GetAttr("file")
+	}
+	s.assert.True(s.conn.failedOver)
+	time.Sleep(20 * time.Millisecond)
+
+	// First caller after the cooldown probes the primary and is held up by it
+	wait := make(chan struct{})
+	s.primary.testPipelineWait = wait
+	done := make(chan error)
+	go func() {
+		_, err := s.conn.GetAttr("file")
+		done <- err
+	}()
+	s.assert.Eventually(func() bool { return s.primary.pipelineCalls() == 1 }, time.Second, time.Millisecond)
+
+	// Others are served by the secondary meanwhile without probing again
+	_, err := s.conn.GetAttr("file")
+	s.assert.Nil(err)
+	s.assert.Equal(1, s.primary.pipelineCalls())
+
+	s.primary.getAttrErr = nil
+	close(wait)
+	s.assert.Nil(<-done)
+	s.assert.False(s.conn.failedOver)
+}
+
+func (s *failoverTestSuite) TestListContainersFailover() {
+	s.primary.containers = []string{"primary"}
+	s.secondary.containers = []string{"secondary"}
+
+	list, err := s.conn.ListContainers("")
+	s.assert.Nil(err)
+	s.assert.Equal([]string{"primary"}, list)
+
+	s.conn.failedOver = true
+	s.conn.failedOverAt = time.Now()
+	list, err = s.conn.ListContainers("")
+	s.assert.Nil(err)
+	s.assert.Equal([]string{"secondary"}, list)
+}
+
+func TestFailoverTestSuite(t *testing.T) {
+	suite.Run(t, new(failoverTestSuite))
+}
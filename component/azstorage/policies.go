@@ -35,7 +35,9 @@ package azstorage
 
 import (
 	"fmt"
+	"math/rand"
 	"net/http"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-storage-fuse/v2/common"
@@ -79,3 +81,79 @@ func (r *serviceVersionPolicy) Do(req *policy.Request) (*http.Response, error) {
 	req.Raw().Header["x-ms-version"] = []string{r.serviceApiVersion}
 	return req.Next()
 }
+
+// ---------------------------------------------------------------------------------------------------------------------------------------------------
+// Policies to add a random delay before each retry, so that clients failing together do not retry together.
+// SDK retry delay is exponential with only a small jitter, hence clients hit by the same outage retry in lockstep.
+
+// Status codes retried by the SDK when RetryOptions.StatusCodes is not set
+var defaultRetryStatusCodes = []int{
+	http.StatusRequestTimeout,      // 408
+	http.StatusTooManyRequests,     // 429
+	http.StatusInternalServerError, // 500
+	http.StatusBadGateway,          // 502
+	http.StatusServiceUnavailable,  // 503
+	http.StatusGatewayTimeout,      // 504
+}
+
+// retryAttempts counts tries of one operation, shared by all the clones retry policy makes of the request
+type retryAttempts struct {
+	tries int32
+}
+
+// retryCounterPolicy is added in PerCallPolicies, it runs once per operation before the SDK's retry policy
+type retryCounterPolicy struct{}
+
+func (p *retryCounterPolicy) Do(req *policy.Request) (*http.Response, error) {
+	req.SetOperationValue(&retryAttempts{})
+	return req.Next()
+}
+
+// retryJitterPolicy is added in PerRetryPolicies, it runs for every try and sleeps before all but the first one
+type retryJitterPolicy struct {
+	delay    time.Duration
+	maxDelay time.Duration
+}
+
+// newRetryJitterPolicies creates the per call and per retry policies waiting up to the retry delay of the options, doubling
+// every retry and capped at their max retry delay. Jitter replaces the backoff of the SDK, so its delay is cut to the minimum
+// in the options. A zero or negative delay is not used as the SDK then waits the full max retry delay. Max retry delay
+// is kept as the SDK also uses it to cap a Retry-After sent by the service.
+func newRetryJitterPolicies(retryOptions *policy.RetryOptions) (policy.Policy, policy.Policy) {
+	jitter := &retryJitterPolicy{delay: retryOptions.RetryDelay, maxDelay: retryOptions.MaxRetryDelay}
+	retryOptions.RetryDelay = time.Nanosecond
+	return &retryCounterPolicy{}, jitter
+}
+
+// jitter : Random delay before the given retry, retry is >= 1
+func (p *retryJitterPolicy) jitter(retry int32) time.Duration {
+	window := p.delay
+	for i := int32(1); i < retry && window < p.maxDelay; i++ {
+		window *= 2
+	}
+	window = min(window, p.maxDelay)
+	if window <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(window)))
+}
+
+func (p *retryJitterPolicy) Do(req *policy.Request) (*http.Response, error) {
+	var attempts *retryAttempts
+	if !req.OperationValue(&attempts) || attempts == nil {
+		return req.Next()
+	}
+
+	attempts.tries++
+	if attempts.tries > 1 {
+		timer := time.NewTimer(p.jitter(attempts.tries - 1))
+		select {
+		case <-timer.C:
+		case <-req.Raw().Context().Done():
+			timer.Stop()
+			return nil, req.Raw().Context().Err()
+		}
+	}
+
+	return req.Next()
+}
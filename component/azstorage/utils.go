@@ -34,21 +34,27 @@
 package azstorage
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
 	azlog "github.com/Azure/azure-sdk-for-go/sdk/azcore/log"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
@@ -88,6 +94,7 @@ func getAzStorageClientOptions(conf *AzStorageConfig) (azcore.ClientOptions, err
 		TryTimeout:    time.Second * time.Duration(conf.maxTimeout),    // Maximum time allowed for any single try
 		RetryDelay:    time.Second * time.Duration(conf.backoffTime),   // Backoff amount for each retry (exponential or linear)
 		MaxRetryDelay: time.Second * time.Duration(conf.maxRetryDelay), // Max delay between retries
+		StatusCodes:   conf.retryStatusCodes,                           // nil retries the SDK default status codes
 	}
 
 	telemetryValue := conf.telemetry
@@ -112,11 +119,19 @@ func getAzStorageClientOptions(conf *AzStorageConfig) (azcore.ClientOptions, err
 		perCallPolicies = append(perCallPolicies, newServiceVersionPolicy(serviceApiVersion))
 	}
 
+	var perRetryPolicies []policy.Policy
+	if conf.retryJitter {
+		counter, jitter := newRetryJitterPolicies(&retryOptions)
+		perCallPolicies = append(perCallPolicies, counter)
+		perRetryPolicies = append(perRetryPolicies, jitter)
+	}
+
 	return azcore.ClientOptions{
-		Retry:           retryOptions,
-		Logging:         logOptions,
-		PerCallPolicies: perCallPolicies,
-		Transport:       transportOptions,
+		Retry:            retryOptions,
+		Logging:          logOptions,
+		PerCallPolicies:  perCallPolicies,
+		PerRetryPolicies: perRetryPolicies,
+		Transport:        transportOptions,
 	}, err
 }
 
@@ -222,8 +237,36 @@ const (
 	InvalidRange
 	BlobIsUnderLease
 	InvalidPermission
+	CPKMismatch
+	MD5Mismatch
+	EncryptionKeyUnavailable
+	BlobImmutable
+)
+
+// Error codes of objects encrypted with a key the mount can not use which the sdk does not define
+const (
+	errCodeBlobDoesNotUseCPK       = "BlobDoesNotUseCustomerSpecifiedEncryption"
+	errCodeKeyVaultKeyNotFound     = "KeyVaultEncryptionKeyNotFound"
+	errCodeKeyVaultTokenFailure    = "KeyVaultAccessTokenCannotBeAcquired"
+	errCodeKeyVaultVaultNotFound   = "KeyVaultVaultNotFound"
+	errCodeEncryptionScopeDisabled = "EncryptionScopeDisabled"
 )
 
+// Error code of a write to a blob under legal hold which the sdk does not define
+const errCodeBlobImmutableDueToLegalHold = "BlobImmutableDueToLegalHold"
+
+// encryptionErrToErr : Classify errors of an object which exists but is encrypted with a key this mount does not have
+func encryptionErrToErr(code string) uint16 {
+	switch code {
+	case errCodeBlobDoesNotUseCPK:
+		return CPKMismatch
+	case errCodeKeyVaultKeyNotFound, errCodeKeyVaultTokenFailure, errCodeKeyVaultVaultNotFound, errCodeEncryptionScopeDisabled:
+		return EncryptionKeyUnavailable
+	default:
+		return ErrUnknown
+	}
+}
+
 // For detailed error list refer below link,
 // https://github.com/Azure/azure-sdk-for-go/blob/main/sdk/storage/azblob/bloberror/error_codes.go
 // Convert blob storage error to common errors
@@ -243,8 +286,14 @@ func storeBlobErrToErr(err error) uint16 {
 			return BlobIsUnderLease
 		case bloberror.InsufficientAccountPermissions, bloberror.AuthorizationPermissionMismatch:
 			return InvalidPermission
+		case bloberror.BlobUsesCustomerSpecifiedEncryption:
+			return CPKMismatch
+		case bloberror.MD5Mismatch:
+			return MD5Mismatch
+		case bloberror.BlobImmutableDueToPolicy, errCodeBlobImmutableDueToLegalHold:
+			return BlobImmutable
 		default:
-			return ErrUnknown
+			return encryptionErrToErr(respErr.ErrorCode)
 		}
 	}
 	return ErrNoErr
@@ -267,15 +316,112 @@ func storeDatalakeErrToErr(err error) uint16 {
 			return BlobIsUnderLease
 		case datalakeerror.AuthorizationPermissionMismatch:
 			return InvalidPermission
+		case datalakeerror.PathUsesCustomerSpecifiedEncryption:
+			return CPKMismatch
 		default:
-			return ErrUnknown
+			return encryptionErrToErr(respErr.ErrorCode)
 		}
 	}
 	return ErrNoErr
 }
 
+// DeleteDirError : Returned by a best-effort directory delete, lists every path that could not be deleted
+type DeleteDirError struct {
+	Dir    string
+	Failed map[string]error
+}
+
+func (e *DeleteDirError) Error() string {
+	paths := make([]string, 0, len(e.Failed))
+	for path := range e.Failed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return fmt.Sprintf("failed to delete %d path(s) under %s: %s", len(paths), e.Dir, strings.Join(paths, ", "))
+}
+
+// RenameDirCPKError : Returned when a directory rename is refused because paths under it are encrypted with a
+// customer provided key other than the configured one, lists every such path
+type RenameDirCPKError struct {
+	Source string
+	Target string
+	Paths  []string
+}
+
+func (e *RenameDirCPKError) Error() string {
+	return fmt.Sprintf("failed to rename %s to %s, %d path(s) are encrypted with a different customer provided key: %s",
+		e.Source, e.Target, len(e.Paths), strings.Join(e.Paths, ", "))
+}
+
+// Unwrap : Callers checking only the errno see a permission error
+func (e *RenameDirCPKError) Unwrap() error {
+	return syscall.EACCES
+}
+
+// Unwrap : Expose the individual failures so errors.Is / errors.As work on the aggregate
+func (e *DeleteDirError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Time by which rehydration from archive is expected to complete, as documented for each rehydrate priority
+var rehydrateEstimate = map[string]time.Duration{
+	string(blob.RehydratePriorityHigh):     1 * time.Hour,
+	string(blob.RehydratePriorityStandard): 15 * time.Hour,
+}
+
+// RehydratePendingError : Returned by a read of an archived blob which is being rehydrated to an online tier.
+// It is EAGAIN for errors.Is, Estimate tells callers how long they may have to keep polling.
+type RehydratePendingError struct {
+	Name     string
+	Priority string
+	Estimate time.Duration
+}
+
+func newRehydratePendingError(name string, priority *string) *RehydratePendingError {
+	e := &RehydratePendingError{Name: name, Priority: string(blob.RehydratePriorityStandard)}
+	if priority != nil && *priority != "" {
+		e.Priority = *priority
+	}
+	e.Estimate = rehydrateEstimate[e.Priority]
+	if e.Estimate == 0 {
+		e.Estimate = rehydrateEstimate[string(blob.RehydratePriorityStandard)]
+	}
+	return e
+}
+
+func (e *RehydratePendingError) Error() string {
+	return fmt.Sprintf("%s is being rehydrated from archive with %s priority, expected to complete within %s", e.Name, e.Priority, e.Estimate)
+}
+
+func (e *RehydratePendingError) Unwrap() error {
+	return syscall.EAGAIN
+}
+
 //	----------- Metadata handling  ---------------
 //
+// parseDFSProperties : Convert the x-ms-properties header of the dfs endpoint ("key=base64(value),...") to metadata
+func parseDFSProperties(properties string) map[string]*string {
+	metadata := make(map[string]*string)
+	for _, kv := range strings.Split(properties, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(kv), "=")
+		if !found || key == "" {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			log.Warn("parseDFSProperties : Failed to decode value of %s [%s]", key, err.Error())
+			continue
+		}
+		metadata[key] = to.Ptr(string(decoded))
+	}
+	return metadata
+}
+
 // parseMetadata : Parse the metadata of a given path and populate its attributes
 func parseMetadata(attr *internal.ObjAttr, metadata map[string]*string) {
 	// Save the metadata in attributes so that later if someone wants to add anything it can work
@@ -293,6 +439,20 @@ func parseMetadata(attr *internal.ObjAttr, metadata map[string]*string) {
 	}
 }
 
+// Prefix of metadata keys holding extended attributes
+const xattrKeyPrefix = "xattr_"
+
+// xattrMetadataKey : Metadata key of an extended attribute, characters not allowed in a key (e.g. "." of user.tag) become "_"
+func xattrMetadataKey(attr string) string {
+	key := []byte(strings.ToLower(attr))
+	for i, c := range key {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			key[i] = '_'
+		}
+	}
+	return xattrKeyPrefix + string(key)
+}
+
 //    ----------- Content-type handling  ---------------
 
 // ContentTypeMap : Store file extension to content-type mapping
@@ -432,6 +592,12 @@ func getACLPermissions(mode os.FileMode) string {
 	return sb.String()
 }
 
+// getACLFromMode : Owner, owning group and other entries of an ACL granting the permissions of mode
+func getACLFromMode(mode os.FileMode) string {
+	perm := getACLPermissions(mode)
+	return "user::" + perm[0:3] + ",group::" + perm[3:6] + ",other::" + perm[6:9]
+}
+
 func writePermission(sb *strings.Builder, permitted bool, permission rune) {
 	if permitted {
 		sb.WriteRune(permission)
@@ -530,6 +696,154 @@ func removePrefixPath(prefixPath, path string) string {
 	return path
 }
 
+// normalizePrefixPath collapses duplicate slashes and strips leading and trailing
+// slashes from the prefix path, so that "dir/", "/dir" and "dir//" all mean "dir".
+func normalizePrefixPath(prefixPath string) string {
+	for strings.Contains(prefixPath, "//") {
+		prefixPath = strings.ReplaceAll(prefixPath, "//", "/")
+	}
+	return strings.TrimSuffix(removeLeadingSlashes(prefixPath), "/")
+}
+
+// joinPrefixPath joins the prefix path with the name of an object to get the blob name.
+// All blob names shall be constructed using this method so that no "//" ends up in a blob name.
+func joinPrefixPath(prefixPath, name string) string {
+	return filepath.Join(prefixPath, name)
+}
+
+// isMissingCopySource : Service reports a copy from a source which does not exist as a failure to verify the source
+func isMissingCopySource(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound &&
+		bloberror.HasCode(err, bloberror.CannotVerifyCopySource)
+}
+
+// renameTargetSupported : Datalake rename of the sdk trims white space around the target path and takes a '?' as
+// start of a query, such a target would end up under a different name than asked for
+func renameTargetSupported(target string) bool {
+	return strings.TrimSpace(target) == target && !strings.Contains(target, "?")
+}
+
+// isDiskFull : Write to the local file failed because the disk or the quota of the user is full
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+// pathDepth : Number of non empty segments in a path, root has none
+func pathDepth(name string) int {
+	depth := 0
+	for _, segment := range strings.Split(name, "/") {
+		if segment != "" {
+			depth++
+		}
+	}
+	return depth
+}
+
+// Prefix of list markers issued while a blob filter is active
+const filterMarkerPrefix = "bf:"
+
+// getFilterFingerprint : Short hash identifying the blob filter, empty when no filter is set
+func getFilterFingerprint(filter string) string {
+	if filter == "" {
+		return ""
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(filter))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// encodeListMarker : Embed the filter fingerprint in the marker returned to the caller
+func encodeListMarker(marker *string, fingerprint string) *string {
+	if marker == nil || *marker == "" || fingerprint == "" {
+		return marker
+	}
+	encoded := filterMarkerPrefix + fingerprint + ":" + *marker
+	return &encoded
+}
+
+// decodeListMarker : Extract the service marker and validate it was issued with the same filter
+func decodeListMarker(marker *string, fingerprint string) (*string, error) {
+	if marker == nil || *marker == "" {
+		return marker, nil
+	}
+
+	issuedWith := ""
+	serviceMarker := *marker
+	if strings.HasPrefix(serviceMarker, filterMarkerPrefix) {
+		parts := strings.SplitN(strings.TrimPrefix(serviceMarker, filterMarkerPrefix), ":", 2)
+		if len(parts) == 2 {
+			issuedWith, serviceMarker = parts[0], parts[1]
+		}
+	}
+
+	if issuedWith != fingerprint {
+		return nil, fmt.Errorf("blob filter changed since list marker was issued, restart the listing")
+	}
+
+	return &serviceMarker, nil
+}
+
+// validateContainerName : Check the container name against azure naming rules, returning the rule it violates.
+// Names are 3 to 63 characters of lower case letters, digits and hyphens, starting and ending with a letter or
+// digit and without consecutive hyphens. $root, $logs and $web are reserved names which are allowed.
+func validateContainerName(name string) error {
+	switch name {
+	case "$root", "$logs", "$web":
+		return nil
+	}
+
+	if len(name) < 3 || len(name) > 63 {
+		return fmt.Errorf("container name %q must be 3 to 63 characters long", name)
+	}
+
+	for _, c := range name {
+		if c >= 'A' && c <= 'Z' {
+			return fmt.Errorf("container name %q must be lower case", name)
+		}
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' {
+			return fmt.Errorf("container name %q can only contain letters, digits and hyphens, found %q", name, c)
+		}
+	}
+
+	if name[0] == '-' || name[len(name)-1] == '-' {
+		return fmt.Errorf("container name %q must start and end with a letter or digit", name)
+	}
+
+	if strings.Contains(name, "--") {
+		return fmt.Errorf("container name %q can not have consecutive hyphens", name)
+	}
+
+	return nil
+}
+
+// swapEndpointService : Replace the storage service label (e.g. "dfs" -> "blob") in the host of an account endpoint.
+// Only the first matching label after the account name is replaced, so any cloud suffix works
+// (core.windows.net, core.usgovcloudapi.net, core.chinacloudapi.cn, azure stack, zonal dns ...).
+// Returns false when the host has no such label, which is the case for truly custom endpoints.
+func swapEndpointService(endpoint string, from string, to string) (string, bool) {
+	hostStart := 0
+	if idx := strings.Index(endpoint, "://"); idx != -1 {
+		hostStart = idx + 3
+	}
+
+	hostEnd := len(endpoint)
+	if idx := strings.IndexAny(endpoint[hostStart:], "/?"); idx != -1 {
+		hostEnd = hostStart + idx
+	}
+
+	// First label is the account name, it can never be the service
+	labels := strings.Split(endpoint[hostStart:hostEnd], ".")
+	for i := 1; i < len(labels)-1; i++ {
+		if strings.EqualFold(labels[i], from) {
+			labels[i] = to
+			return endpoint[:hostStart] + strings.Join(labels, ".") + endpoint[hostEnd:], true
+		}
+	}
+
+	return endpoint, false
+}
+
 func sanitizeSASKey(key string) string {
 	if key == "" {
 		return key
@@ -572,6 +886,43 @@ func modifyLMTandEtag(attr *internal.ObjAttr, lmt *time.Time, ETag string) {
 	}
 }
 
+// bufferPool : Buffers of one size reused across uploads and downloads instead of allocating one per call.
+// A buffer handed to the sdk goes back to the pool only once the request using it has returned.
+// Nil pool allocates every buffer, so connections created without configuration still work.
+type bufferPool struct {
+	size int64
+	pool sync.Pool
+}
+
+func newBufferPool(size int64) *bufferPool {
+	if size <= 0 {
+		return nil
+	}
+	p := &bufferPool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+// get : Buffer of length bytes, content is whatever its previous user left in it
+func (p *bufferPool) get(length int64) []byte {
+	if p == nil || length > p.size {
+		return make([]byte, length)
+	}
+	return (*p.pool.Get().(*[]byte))[:length]
+}
+
+// put : Return a buffer taken with get, buffers not of the pool size are left to the garbage collector
+func (p *bufferPool) put(buf []byte) {
+	if p == nil || int64(cap(buf)) != p.size {
+		return
+	}
+	buf = buf[:cap(buf)]
+	p.pool.Put(&buf)
+}
+
 func sanitizeEtag(ETag *azcore.ETag) string {
 	if ETag != nil {
 		return strings.Trim(string(*ETag), `"`)
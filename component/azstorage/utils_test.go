@@ -34,14 +34,24 @@
 package azstorage
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azdatalake/datalakeerror"
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
 	"github.com/stretchr/testify/assert"
@@ -393,6 +403,69 @@ func (s *utilsTestSuite) TestFormatEndpointAccountType() {
 	}
 }
 
+func (s *utilsTestSuite) TestValidateContainerName() {
+	assert := assert.New(s.T())
+
+	for _, name := range []string{"abc", "my-container-1", "0data", "$root", "$logs", "$web", strings.Repeat("a", 63)} {
+		assert.Nil(validateContainerName(name), name)
+	}
+
+	inputs := []struct {
+		name string
+		msg  string
+	}{
+		{name: "ab", msg: `container name "ab" must be 3 to 63 characters long`},
+		{name: strings.Repeat("a", 64), msg: "must be 3 to 63 characters long"},
+		{name: "MyContainer", msg: `container name "MyContainer" must be lower case`},
+		{name: "my_container", msg: `container name "my_container" can only contain letters, digits and hyphens, found '_'`},
+		{name: "my.container", msg: `can only contain letters, digits and hyphens, found '.'`},
+		{name: "$other", msg: `can only contain letters, digits and hyphens, found '$'`},
+		{name: "-container", msg: `container name "-container" must start and end with a letter or digit`},
+		{name: "container-", msg: "must start and end with a letter or digit"},
+		{name: "my--container", msg: `container name "my--container" can not have consecutive hyphens`},
+	}
+
+	for _, i := range inputs {
+		s.Run(i.name, func() {
+			err := validateContainerName(i.name)
+			assert.NotNil(err)
+			assert.Contains(err.Error(), i.msg)
+		})
+	}
+}
+
+func (s *utilsTestSuite) TestTransformAccountEndpoint() {
+	assert := assert.New(s.T())
+	var inputs = []struct {
+		endpoint string
+		result   string
+	}{
+		{endpoint: "https://account.dfs.core.windows.net/", result: "https://account.blob.core.windows.net/"},
+		{endpoint: "https://dfs.dfs.core.windows.net/", result: "https://dfs.blob.core.windows.net/"},
+		{endpoint: "https://account.privatelink.dfs.core.windows.net/", result: "https://account.privatelink.blob.core.windows.net/"},
+
+		// Government and China cloud
+		{endpoint: "https://account.dfs.core.usgovcloudapi.net/", result: "https://account.blob.core.usgovcloudapi.net/"},
+		{endpoint: "https://account.z01.dfs.core.usgovcloudapi.net/", result: "https://account.z01.blob.core.usgovcloudapi.net/"},
+		{endpoint: "https://account.dfs.core.chinacloudapi.cn/", result: "https://account.blob.core.chinacloudapi.cn/"},
+		{endpoint: "https://account.z01.dfs.core.chinacloudapi.cn/", result: "https://account.z01.blob.core.chinacloudapi.cn/"},
+
+		// Custom suffixes, only the service label of the host is changed
+		{endpoint: "https://account.dfs.local.azurestack.external/", result: "https://account.blob.local.azurestack.external/"},
+		{endpoint: "https://account.dfs.storage.contoso.dfs.example/", result: "https://account.blob.storage.contoso.dfs.example/"},
+		{endpoint: "http://account.dfs.core.windows.net:8080/path.dfs.x/", result: "http://account.blob.core.windows.net:8080/path.dfs.x/"},
+
+		// Nothing to transform
+		{endpoint: "https://account.blob.core.usgovcloudapi.net/", result: "https://account.blob.core.usgovcloudapi.net/"},
+		{endpoint: "https://mycustom.endpoint/", result: "https://mycustom.endpoint/"},
+	}
+	for _, i := range inputs {
+		s.Run(i.endpoint, func() {
+			assert.EqualValues(i.result, transformAccountEndpoint(i.endpoint))
+		})
+	}
+}
+
 type endpointProtocol struct {
 	endpoint string
 	ustHttp  bool
@@ -485,6 +558,56 @@ func (s *utilsTestSuite) TestRemoveLeadingSlashes() {
 	}
 }
 
+func (s *utilsTestSuite) TestNormalizePrefixPath() {
+	assert := assert.New(s.T())
+	var inputs = []struct {
+		subdirectory string
+		result       string
+	}{
+		{subdirectory: "dir/", result: "dir"},
+		{subdirectory: "/dir", result: "dir"},
+		{subdirectory: "dir//", result: "dir"},
+		{subdirectory: "//dir//subdir///", result: "dir/subdir"},
+		{subdirectory: "dir", result: "dir"},
+		{subdirectory: "/", result: ""},
+		{subdirectory: "", result: ""},
+	}
+
+	for _, i := range inputs {
+		assert.Equal(i.result, normalizePrefixPath(i.subdirectory))
+	}
+}
+
+func (s *utilsTestSuite) TestJoinPrefixPath() {
+	assert := assert.New(s.T())
+	var inputs = []struct {
+		prefixPath string
+		name       string
+		result     string
+	}{
+		{prefixPath: "dir/", name: "file", result: "dir/file"},
+		{prefixPath: "/dir", name: "file", result: "dir/file"},
+		{prefixPath: "dir//", name: "file", result: "dir/file"},
+		{prefixPath: "dir//", name: "/sub//file", result: "dir/sub/file"},
+		{prefixPath: "", name: "file", result: "file"},
+		{prefixPath: "", name: "", result: ""},
+	}
+
+	for _, i := range inputs {
+		output := joinPrefixPath(normalizePrefixPath(i.prefixPath), i.name)
+		assert.Equal(i.result, output)
+		assert.NotContains(output, "//")
+	}
+
+	// Blob names listed back from the service shall map back to the same object name
+	bb := &BlockBlob{}
+	for _, prefix := range []string{"dir/", "/dir", "dir//"} {
+		_ = bb.SetPrefixPath(prefix)
+		assert.Equal("dir", bb.Config.prefixPath)
+		assert.Equal("file", removePrefixPath(bb.Config.prefixPath, joinPrefixPath(bb.Config.prefixPath, "file")))
+	}
+}
+
 func (suite *utilsTestSuite) TestRemovePrefixPath() {
 	assert := assert.New(suite.T())
 
@@ -512,6 +635,190 @@ func (suite *utilsTestSuite) TestRemovePrefixPath() {
 	}
 }
 
+func (s *utilsTestSuite) TestGetConcurrency() {
+	assert := assert.New(s.T())
+	bb := &BlockBlob{}
+	bb.Config.maxConcurrency = 32
+
+	var inputs = []struct {
+		override uint16
+		result   uint16
+	}{
+		{override: 0, result: 32},
+		{override: 1, result: 1},
+		{override: 128, result: 128},
+		{override: uint16(MaxConnsPerHost), result: uint16(MaxConnsPerHost)},
+		{override: uint16(MaxConnsPerHost) + 1, result: uint16(MaxConnsPerHost)},
+		{override: 65535, result: uint16(MaxConnsPerHost)},
+	}
+
+	for _, i := range inputs {
+		assert.Equal(i.result, bb.getConcurrency(i.override))
+	}
+}
+
+func (s *utilsTestSuite) TestListMarkerFilterFingerprint() {
+	assert := assert.New(s.T())
+
+	assert.Empty(getFilterFingerprint(""))
+	fp1 := getFilterFingerprint("name=^abcd.*")
+	fp2 := getFilterFingerprint("name=^bla.*")
+	assert.NotEmpty(fp1)
+	assert.NotEqual(fp1, fp2)
+	assert.Equal(fp1, getFilterFingerprint("name=^abcd.*"))
+
+	// No filter, marker is passed as is
+	marker := to.Ptr("2!96!MDAwMDE")
+	assert.Equal(marker, encodeListMarker(marker, ""))
+	decoded, err := decodeListMarker(marker, "")
+	assert.Nil(err)
+	assert.Equal("2!96!MDAwMDE", *decoded)
+
+	// Empty marker is never encoded and always valid
+	assert.Equal("", *encodeListMarker(to.Ptr(""), fp1))
+	_, err = decodeListMarker(to.Ptr(""), fp2)
+	assert.Nil(err)
+
+	// Round trip with same filter
+	encoded := encodeListMarker(marker, fp1)
+	assert.NotEqual(*marker, *encoded)
+	decoded, err = decodeListMarker(encoded, fp1)
+	assert.Nil(err)
+	assert.Equal(*marker, *decoded)
+
+	// Filter changed, removed or added since marker was issued
+	_, err = decodeListMarker(encoded, fp2)
+	assert.NotNil(err)
+	_, err = decodeListMarker(encoded, "")
+	assert.NotNil(err)
+	_, err = decodeListMarker(marker, fp1)
+	assert.NotNil(err)
+}
+
 func TestUtilsTestSuite(t *testing.T) {
 	suite.Run(t, new(utilsTestSuite))
 }
+
+func (s *utilsTestSuite) TestCPKMismatchErrorMapping() {
+	assert := assert.New(s.T())
+
+	blobErr := &azcore.ResponseError{ErrorCode: string(bloberror.BlobUsesCustomerSpecifiedEncryption)}
+	assert.EqualValues(CPKMismatch, storeBlobErrToErr(blobErr))
+
+	dlErr := &azcore.ResponseError{ErrorCode: string(datalakeerror.PathUsesCustomerSpecifiedEncryption)}
+	assert.EqualValues(CPKMismatch, storeDatalakeErrToErr(dlErr))
+}
+
+func (s *utilsTestSuite) TestRetryJitter() {
+	assert := assert.New(s.T())
+
+	// Every blob fails with 503 until its last try, arrival time of each try is recorded per blob
+	const tries = 5
+	var lock sync.Mutex
+	arrivals := map[string][]time.Time{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		arrivals[r.URL.Path] = append(arrivals[r.URL.Path], time.Now())
+		count := len(arrivals[r.URL.Path])
+		lock.Unlock()
+
+		if count < tries {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	// Jitter replaces the delay of the SDK, so the gap between tries comes from jitter alone
+	const maxDelay = 400 * time.Millisecond
+	retryOptions := policy.RetryOptions{MaxRetries: tries - 1, RetryDelay: 100 * time.Millisecond, MaxRetryDelay: maxDelay}
+	counter, jitter := newRetryJitterPolicies(&retryOptions)
+	assert.Equal(time.Nanosecond, retryOptions.RetryDelay)
+	containerClient, err := container.NewClientWithNoCredential(srv.URL+"/cont", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry:            retryOptions,
+			PerCallPolicies:  []policy.Policy{counter},
+			PerRetryPolicies: []policy.Policy{jitter},
+		},
+	})
+	assert.Nil(err)
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			_, err := containerClient.NewBlobClient(name).GetProperties(context.Background(), nil)
+			assert.True(bloberror.HasCode(err, bloberror.BlobNotFound))
+		}(name)
+	}
+	wg.Wait()
+
+	a, b := arrivals["/cont/a"], arrivals["/cont/b"]
+	assert.Len(a, tries)
+	assert.Len(b, tries)
+
+	// Two clients failing together shall not retry together
+	lockstep := true
+	for i := 1; i < tries; i++ {
+		gapA, gapB := a[i].Sub(a[i-1]), b[i].Sub(b[i-1])
+		// Small margin covers the time taken by the request itself
+		assert.Less(gapA, maxDelay+50*time.Millisecond)
+		assert.Less(gapB, maxDelay+50*time.Millisecond)
+		if (gapA - gapB).Abs() > 10*time.Millisecond {
+			lockstep = false
+		}
+	}
+	assert.False(lockstep)
+}
+
+func (s *utilsTestSuite) TestRetryOnStatusOptions() {
+	assert := assert.New(s.T())
+
+	opt, err := getAzBlobServiceClientOptions(&AzStorageConfig{})
+	assert.Nil(err)
+	assert.Nil(opt.Retry.StatusCodes)
+	assert.Empty(opt.PerRetryPolicies)
+
+	opt, err = getAzBlobServiceClientOptions(&AzStorageConfig{retryJitter: true, retryStatusCodes: []int{408, 409}, backoffTime: 4, maxRetryDelay: 60})
+	assert.Nil(err)
+	assert.Equal([]int{408, 409}, opt.Retry.StatusCodes)
+	assert.Len(opt.PerRetryPolicies, 1)
+	// Backoff of the SDK is replaced by the jitter, the max still caps a Retry-After of the service
+	assert.Equal(time.Nanosecond, opt.Retry.RetryDelay)
+	assert.Equal(60*time.Second, opt.Retry.MaxRetryDelay)
+}
+
+func (s *utilsTestSuite) TestPathDepth() {
+	assert := assert.New(s.T())
+
+	assert.Equal(0, pathDepth(""))
+	assert.Equal(0, pathDepth("/"))
+	assert.Equal(1, pathDepth("file"))
+	assert.Equal(3, pathDepth("a/b/c"))
+	assert.Equal(3, pathDepth("/a//b/c/"))
+}
+
+func (s *utilsTestSuite) TestBufferPool() {
+	assert := assert.New(s.T())
+
+	// Nil pool still hands out buffers
+	var empty *bufferPool
+	assert.Nil(newBufferPool(0))
+	assert.Len(empty.get(10), 10)
+	empty.put(make([]byte, 10))
+
+	pool := newBufferPool(16)
+	buf := pool.get(10)
+	assert.Len(buf, 10)
+	assert.Equal(16, cap(buf))
+	pool.put(buf)
+
+	// Larger than the pool size is allocated as is and not pooled
+	big := pool.get(32)
+	assert.Len(big, 32)
+	pool.put(big)
+	assert.Equal(16, cap(pool.get(16)))
+}
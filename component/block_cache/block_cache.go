@@ -89,6 +89,7 @@ type BlockCache struct {
 	lazyWrite       bool           // Flag to indicate if lazy write is enabled
 	fileCloseOpt    sync.WaitGroup // Wait group to wait for all async close operations to complete
 	cleanupOnStart  bool           // Clear temp directory on startup
+	optimisticLock  bool           // Commit only if the blob is unchanged since it was opened or last committed
 }
 
 // Structure defining your config parameters
@@ -103,6 +104,7 @@ type BlockCacheOptions struct {
 	PrefetchOnOpen bool    `config:"prefetch-on-open" yaml:"prefetch-on-open,omitempty"`
 	Consistency    bool    `config:"consistency" yaml:"consistency,omitempty"`
 	CleanupOnStart bool    `config:"cleanup-on-start" yaml:"cleanup-on-start,omitempty"`
+	OptimisticLock bool    `config:"optimistic-concurrency" yaml:"optimistic-concurrency,omitempty"`
 }
 
 const (
@@ -289,6 +291,7 @@ func (bc *BlockCache) Configure(_ bool) error {
 
 	bc.tmpPath = common.ExpandPath(conf.TmpPath)
 	bc.cleanupOnStart = conf.CleanupOnStart
+	bc.optimisticLock = conf.OptimisticLock
 
 	if bc.tmpPath != "" {
 		//check mnt path is not same as temp path
@@ -471,9 +474,25 @@ func (bc *BlockCache) validateBlockList(handle *handlemap.Handle, options intern
 			size:      block.Size,
 		}
 	}
+
+	// New blocks of this file must use the id length of the blocks already committed
+	if listLen > 0 {
+		idLen := common.GetIdLength((*blockList)[0].Id)
+		if idLen >= common.MinBlockIDLength && idLen <= common.MaxBlockIDLength {
+			handle.SetValue("blockIdLength", idLen)
+		}
+	}
 	return true
 }
 
+// getBlockID : Generate a new block id for this file
+func (bc *BlockCache) getBlockID(handle *handlemap.Handle) string {
+	if length, found := handle.GetValue("blockIdLength"); found {
+		return common.GetBlockID(length.(int64))
+	}
+	return common.GetBlockID(common.BlockIDLength)
+}
+
 func (bc *BlockCache) prepareHandleForBlockCache(handle *handlemap.Handle) {
 	// Allocate a block pool object for this handle
 	// Actual linked list to hold the nodes
@@ -1407,7 +1426,7 @@ func shouldCommitAndDownload(blockID int64, handle *handlemap.Handle) (bool, boo
 
 // lineupUpload : Create a work item and schedule the upload
 func (bc *BlockCache) lineupUpload(handle *handlemap.Handle, block *Block, listMap map[int64]*blockInfo) {
-	id := common.GetBlockID(common.BlockIDLength)
+	id := bc.getBlockID(handle)
 	listMap[block.id] = &blockInfo{
 		id:        id,
 		committed: false,
@@ -1602,9 +1621,18 @@ func (bc *BlockCache) commitBlocks(handle *handlemap.Handle) error {
 
 	log.Debug("BlockCache::commitBlocks : Committing blocks for %s", handle.Path)
 
+	// With optimistic concurrency the commit is conditional on the etag seen at open or after the last commit,
+	// so updates made by another writer in between are not overwritten
+	ifMatch := ""
+	if bc.optimisticLock {
+		if etag, found := handle.GetValue("ETAG"); found {
+			ifMatch = etag.(string)
+		}
+	}
+
 	// Commit the block list now
 	var newEtag string = ""
-	err = bc.NextComponent().CommitData(internal.CommitDataOptions{Name: handle.Path, List: blockIDList, BlockSize: bc.blockSize, NewETag: &newEtag})
+	err = bc.NextComponent().CommitData(internal.CommitDataOptions{Name: handle.Path, List: blockIDList, BlockSize: bc.blockSize, NewETag: &newEtag, Size: handle.Size, IfMatch: ifMatch})
 	if err != nil {
 		log.Err("BlockCache::commitBlocks : Failed to commit blocks for %s [%s]", handle.Path, err.Error())
 		return err
@@ -1683,7 +1711,7 @@ func (bc *BlockCache) getBlockIDList(handle *handlemap.Handle) ([]string, []stri
 				// Now we have written data beyond that point and its no longer the last block
 				// In such case we need to fill the gap with zero blocks
 				// For simplicity we will fill the gap with a new block and later merge both these blocks in one block
-				id := common.GetBlockID(common.BlockIDLength)
+				id := bc.getBlockID(handle)
 				fillerSize := (bc.blockSize - listMap[offsets[i]].size)
 				fillerOffset := uint64(offsets[i]*int64(bc.blockSize)) + listMap[offsets[i]].size
 
@@ -1753,7 +1781,7 @@ func (bc *BlockCache) stageZeroBlock(handle *handlemap.Handle, tryCnt int) (stri
 		return "", fmt.Errorf("3 attempts to upload zero block have failed for %v=>%v", handle.ID, handle.Path)
 	}
 
-	id := common.GetBlockID(common.BlockIDLength)
+	id := bc.getBlockID(handle)
 
 	log.Debug("BlockCache::stageZeroBlock : Staging zero block for %v=>%v, try = %v", handle.ID, handle.Path, tryCnt)
 	err := bc.NextComponent().StageData(internal.StageDataOptions{
@@ -1834,6 +1862,8 @@ func (bc *BlockCache) DeleteDir(options internal.DeleteDirOptions) error {
 	if err != nil {
 		log.Err("BlockCache::DeleteDir : %s failed", options.Name)
 		return err
+	} else if options.DryRun {
+		return nil
 	}
 
 	bc.invalidateDirectory(options.Name)
@@ -1848,6 +1878,8 @@ func (bc *BlockCache) RenameDir(options internal.RenameDirOptions) error {
 	if err != nil {
 		log.Err("BlockCache::RenameDir : error %s [%s]", options.Src, err.Error())
 		return err
+	} else if options.DryRun {
+		return nil
 	}
 
 	bc.invalidateDirectory(options.Src)
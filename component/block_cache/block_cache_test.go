@@ -449,6 +449,9 @@ func (suite *blockCacheTestSuite) TestValidateBlockList() {
 	valid := tobj.blockCache.validateBlockList(h, options, &blockLst)
 	suite.assert.True(valid)
 
+	// New blocks follow the id length of the committed ones
+	suite.assert.EqualValues(32, common.GetIdLength(tobj.blockCache.getBlockID(h)))
+
 	//Generate blocklist, blocks with size equal to configured block size and last block size <= config's block size
 	blockLst = nil
 	startOffset = 0
@@ -2950,6 +2953,103 @@ func (suite *blockCacheTestSuite) TestReadCommittedLastBlocksOverwrite() {
 	suite.assert.Equal(h.Size, int64((15*_1MB)+(_1MB/2)))
 }
 
+func (suite *blockCacheTestSuite) TestWriteFileReusedBuffer() {
+	tobj, err := setupPipeline("")
+	defer tobj.cleanupPipeline()
+	suite.assert.Nil(err)
+
+	path := getTestFileName(suite.T().Name())
+	h, err := tobj.blockCache.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0777})
+	suite.assert.Nil(err)
+
+	// Caller reuses one buffer for every write, full blocks are staged in background while it is overwritten
+	buf := make([]byte, _1MB)
+	expected := make([]byte, 0, 4*_1MB)
+	for i := 0; i < 4; i++ {
+		for j := range buf {
+			buf[j] = byte(i + 1)
+		}
+		n, err := tobj.blockCache.WriteFile(internal.WriteFileOptions{Handle: h, Offset: int64(i) * int64(_1MB), Data: buf})
+		suite.assert.Nil(err)
+		suite.assert.Equal(len(buf), n)
+		expected = append(expected, buf...)
+
+		for j := range buf {
+			buf[j] = 0xff
+		}
+	}
+
+	err = tobj.blockCache.CloseFile(internal.CloseFileOptions{Handle: h})
+	suite.assert.Nil(err)
+
+	data, err := os.ReadFile(filepath.Join(tobj.fake_storage_path, path))
+	suite.assert.Nil(err)
+	suite.assert.Equal(expected, data)
+}
+
+// etagStorage : Storage whose blob has an etag, commit conditional on a stale etag is refused
+type etagStorage struct {
+	internal.Component
+	etag    string
+	ifMatch []string
+}
+
+func (e *etagStorage) CommitData(options internal.CommitDataOptions) error {
+	e.ifMatch = append(e.ifMatch, options.IfMatch)
+	if options.IfMatch != "" && options.IfMatch != e.etag {
+		return syscall.EBUSY
+	}
+
+	err := e.Component.CommitData(options)
+	if err == nil {
+		e.etag = randomString(8)
+		*options.NewETag = e.etag
+	}
+	return err
+}
+
+func (suite *blockCacheTestSuite) TestOptimisticConcurrency() {
+	cfg := "block_cache:\n  block-size-mb: 1\n  mem-size-mb: 20\n  optimistic-concurrency: true\n"
+	tobj, err := setupPipeline(cfg)
+	defer tobj.cleanupPipeline()
+	suite.assert.Nil(err)
+	suite.assert.True(tobj.blockCache.optimisticLock)
+
+	storage := &etagStorage{Component: tobj.loopback, etag: "opened"}
+	tobj.blockCache.BaseComponent = internal.BaseComponent{}
+	tobj.blockCache.SetNextComponent(storage)
+
+	path := getTestFileName(suite.T().Name())
+	h, err := tobj.blockCache.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0777})
+	suite.assert.Nil(err)
+	h.SetValue("ETAG", "opened")
+
+	// Blob is unchanged since the etag was seen, commit is conditional on it and the new etag is tracked
+	_, err = tobj.blockCache.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: []byte("Hello")})
+	suite.assert.Nil(err)
+	err = tobj.blockCache.FlushFile(internal.FlushFileOptions{Handle: h})
+	suite.assert.Nil(err)
+	etag, _ := h.GetValue("ETAG")
+	suite.assert.Equal(storage.etag, etag)
+
+	// Another writer updates the blob before the next flush
+	storage.etag = "other-writer"
+	_, err = tobj.blockCache.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 5, Data: []byte(" World")})
+	suite.assert.Nil(err)
+	err = tobj.blockCache.FlushFile(internal.FlushFileOptions{Handle: h})
+	suite.assert.Equal(syscall.EBUSY, err)
+	suite.assert.Equal([]string{"opened", etag.(string)}, storage.ifMatch)
+
+	// Without the config commit is unconditional
+	tobj.blockCache.optimisticLock = false
+	err = tobj.blockCache.FlushFile(internal.FlushFileOptions{Handle: h})
+	suite.assert.Nil(err)
+	suite.assert.Empty(storage.ifMatch[len(storage.ifMatch)-1])
+
+	err = tobj.blockCache.CloseFile(internal.CloseFileOptions{Handle: h})
+	suite.assert.Nil(err)
+}
+
 // In order for 'go test' to run this suite, we need to create
 // a normal test function and pass our suite to suite.Run
 func TestBlockCacheTestSuite(t *testing.T) {
@@ -480,6 +480,10 @@ func (fc *FileCache) invalidateDirectory(name string) {
 func (fc *FileCache) DeleteDir(options internal.DeleteDirOptions) error {
 	log.Trace("FileCache::DeleteDir : %s", options.Name)
 
+	if options.DryRun {
+		return fc.NextComponent().DeleteDir(options)
+	}
+
 	err := fc.NextComponent().DeleteDir(options)
 	if err != nil {
 		log.Err("FileCache::DeleteDir : %s failed", options.Name)
@@ -699,6 +703,8 @@ func (fc *FileCache) RenameDir(options internal.RenameDirOptions) error {
 	if err != nil {
 		log.Err("FileCache::RenameDir : error %s [%s]", options.Src, err.Error())
 		return err
+	} else if options.DryRun {
+		return nil
 	}
 
 	go fc.invalidateDirectory(options.Src)
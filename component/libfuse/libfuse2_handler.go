@@ -1001,6 +1001,93 @@ func libfuse_readlink(path *C.char, buf *C.char, size C.size_t) C.int {
 	return 0
 }
 
+// libfuse_setxattr sets an extended attribute of a file
+//
+//export libfuse_setxattr
+func libfuse_setxattr(path *C.char, attr *C.char, value *C.char, size C.size_t, flags C.int) C.int {
+	// ENOSYS makes the kernel stop sending setxattr for the rest of the mount
+	if !fuseFS.xattrEnabled {
+		return -C.ENOSYS
+	}
+
+	name := trimFusePath(path)
+	name = common.NormalizeObjectName(name)
+	attrName := C.GoString(attr)
+	log.Trace("Libfuse::libfuse_setxattr : %s of %s", attrName, name)
+
+	err := fuseFS.NextComponent().SetXAttr(
+		internal.SetXAttrOptions{
+			Name:  name,
+			Attr:  attrName,
+			Value: C.GoBytes(unsafe.Pointer(value), C.int(size)),
+			Flags: int(flags),
+		})
+	if err != nil {
+		log.Err("Libfuse::libfuse_setxattr : error setting %s of %s [%s]", attrName, name, err.Error())
+		return xattrErrno(err)
+	}
+
+	return 0
+}
+
+// libfuse_getxattr reads an extended attribute of a file
+//
+//export libfuse_getxattr
+func libfuse_getxattr(path *C.char, attr *C.char, value *C.char, size C.size_t) C.int {
+	// ENOSYS makes the kernel stop sending getxattr for the rest of the mount
+	if !fuseFS.xattrEnabled {
+		return -C.ENOSYS
+	}
+
+	name := trimFusePath(path)
+	name = common.NormalizeObjectName(name)
+	attrName := C.GoString(attr)
+	//log.Trace("Libfuse::libfuse_getxattr : %s of %s", attrName, name)
+
+	data, err := fuseFS.NextComponent().GetXAttr(internal.GetXAttrOptions{Name: name, Attr: attrName})
+	if err != nil {
+		if err != syscall.ENODATA {
+			log.Err("Libfuse::libfuse_getxattr : error getting %s of %s [%s]", attrName, name, err.Error())
+		}
+		return xattrErrno(err)
+	}
+
+	// Size 0 only asks for the length of the value
+	if size == 0 {
+		return C.int(len(data))
+	}
+	if int(size) < len(data) {
+		return -C.ERANGE
+	}
+	buf := (*[1 << 30]byte)(unsafe.Pointer(value))
+	copy(buf[:size], data)
+
+	return C.int(len(data))
+}
+
+// xattrErrno converts the error of an extended attribute operation to the errno fuse expects
+func xattrErrno(err error) C.int {
+	switch err {
+	case syscall.ENODATA:
+		return -C.ENODATA
+	case syscall.ENOTSUP:
+		return -C.ENOTSUP
+	case syscall.EEXIST:
+		return -C.EEXIST
+	case syscall.EINVAL:
+		return -C.EINVAL
+	case syscall.EAGAIN:
+		return -C.EAGAIN
+	}
+
+	if os.IsNotExist(err) {
+		return -C.ENOENT
+	} else if os.IsPermission(err) {
+		return -C.EACCES
+	}
+	return -C.EIO
+}
+
 // libfuse_fsync synchronizes file contents
 //
 //export libfuse_fsync
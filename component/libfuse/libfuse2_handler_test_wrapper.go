@@ -686,3 +686,109 @@ func testUtimens(suite *libfuseTestSuite) {
 	err := libfuse2_utimens(path, nil)
 	suite.assert.Equal(C.int(0), err)
 }
+
+var xattrConfig = "azstorage:\n  xattr-names: user.app.tag\n"
+
+func testSetXAttrNotConfigured(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	path := C.CString("/path")
+	defer C.free(unsafe.Pointer(path))
+	attr := C.CString("security.capability")
+	defer C.free(unsafe.Pointer(attr))
+	value := C.CString("v1")
+	defer C.free(unsafe.Pointer(value))
+
+	// Nothing reaches the next component
+	err := libfuse_setxattr(path, attr, value, 2, 0)
+	suite.assert.Equal(C.int(-C.ENOSYS), err)
+}
+
+func testSetXAttr(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	suite.cleanupTest()
+	suite.setupTestHelper(xattrConfig)
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	attr := C.CString("user.app.tag")
+	defer C.free(unsafe.Pointer(attr))
+	value := C.CString("v1")
+	defer C.free(unsafe.Pointer(value))
+	options := internal.SetXAttrOptions{Name: name, Attr: "user.app.tag", Value: []byte("v1")}
+	suite.mock.EXPECT().SetXAttr(options).Return(nil)
+
+	err := libfuse_setxattr(path, attr, value, 2, 0)
+	suite.assert.Equal(C.int(0), err)
+}
+
+func testSetXAttrError(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	suite.cleanupTest()
+	suite.setupTestHelper(xattrConfig)
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	attr := C.CString("user.other")
+	defer C.free(unsafe.Pointer(attr))
+	value := C.CString("v1")
+	defer C.free(unsafe.Pointer(value))
+	options := internal.SetXAttrOptions{Name: name, Attr: "user.other", Value: []byte("v1")}
+	suite.mock.EXPECT().SetXAttr(options).Return(syscall.ENOTSUP)
+
+	err := libfuse_setxattr(path, attr, value, 2, 0)
+	suite.assert.Equal(C.int(-C.ENOTSUP), err)
+}
+
+func testGetXAttrNotConfigured(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	path := C.CString("/path")
+	defer C.free(unsafe.Pointer(path))
+	attr := C.CString("security.capability")
+	defer C.free(unsafe.Pointer(attr))
+
+	// Nothing reaches the next component
+	err := libfuse_getxattr(path, attr, nil, 0)
+	suite.assert.Equal(C.int(-C.ENOSYS), err)
+}
+
+func testGetXAttr(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	suite.cleanupTest()
+	suite.setupTestHelper(xattrConfig)
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	attr := C.CString("user.app.tag")
+	defer C.free(unsafe.Pointer(attr))
+	options := internal.GetXAttrOptions{Name: name, Attr: "user.app.tag"}
+	suite.mock.EXPECT().GetXAttr(options).Return([]byte("v1"), nil).Times(3)
+
+	// Size 0 only asks for the length
+	err := libfuse_getxattr(path, attr, nil, 0)
+	suite.assert.Equal(C.int(2), err)
+
+	buf := (*C.char)(C.malloc(8))
+	defer C.free(unsafe.Pointer(buf))
+	err = libfuse_getxattr(path, attr, buf, 8)
+	suite.assert.Equal(C.int(2), err)
+	suite.assert.Equal([]byte("v1"), C.GoBytes(unsafe.Pointer(buf), 2))
+
+	err = libfuse_getxattr(path, attr, buf, 1)
+	suite.assert.Equal(C.int(-C.ERANGE), err)
+}
+
+func testGetXAttrNotExists(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	suite.cleanupTest()
+	suite.setupTestHelper(xattrConfig)
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	attr := C.CString("user.app.tag")
+	defer C.free(unsafe.Pointer(attr))
+	options := internal.GetXAttrOptions{Name: name, Attr: "user.app.tag"}
+	suite.mock.EXPECT().GetXAttr(options).Return(nil, syscall.ENODATA)
+
+	err := libfuse_getxattr(path, attr, nil, 0)
+	suite.assert.Equal(C.int(-C.ENODATA), err)
+}
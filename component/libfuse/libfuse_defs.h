@@ -113,6 +113,9 @@ extern int libfuse_unlink(char *path);
 extern int libfuse_symlink(char *from, char *to);
 extern int libfuse_readlink(char *path, char *buf, size_t size);
 
+extern int libfuse_setxattr(char *path, char *name, char *value, size_t size, int flags);
+extern int libfuse_getxattr(char *path, char *name, char *value, size_t size);
+
 extern int libfuse_fsync(char *path, int, fuse_file_info_t *fi);
 extern int libfuse_fsyncdir(char *path, int, fuse_file_info_t *);
 
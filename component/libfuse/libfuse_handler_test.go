@@ -153,6 +153,21 @@ func (suite *libfuseTestSuite) TestIgnoreAppendFlag() {
 	suite.assert.True(suite.libfuse.ignoreOpenFlags)
 }
 
+func (suite *libfuseTestSuite) TestXAttrConfig() {
+	defer suite.cleanupTest()
+	suite.assert.False(suite.libfuse.xattrEnabled)
+
+	suite.cleanupTest() // clean up the default libfuse generated
+	config := "azstorage:\n  xattr-names: \" , \"\n"
+	suite.setupTestHelper(config) // setup a new libfuse with a custom config (clean up will occur after the test as usual)
+	suite.assert.False(suite.libfuse.xattrEnabled)
+
+	suite.cleanupTest() // clean up the default libfuse generated
+	config = "azstorage:\n  xattr-names: user.app.tag,user.owner\n"
+	suite.setupTestHelper(config) // setup a new libfuse with a custom config (clean up will occur after the test as usual)
+	suite.assert.True(suite.libfuse.xattrEnabled)
+}
+
 // getattr
 
 func (suite *libfuseTestSuite) TestMkDir() {
@@ -307,6 +322,30 @@ func (suite *libfuseTestSuite) TestUtimens() {
 	testUtimens(suite)
 }
 
+func (suite *libfuseTestSuite) TestSetXAttrNotConfigured() {
+	testSetXAttrNotConfigured(suite)
+}
+
+func (suite *libfuseTestSuite) TestSetXAttr() {
+	testSetXAttr(suite)
+}
+
+func (suite *libfuseTestSuite) TestSetXAttrError() {
+	testSetXAttrError(suite)
+}
+
+func (suite *libfuseTestSuite) TestGetXAttrNotConfigured() {
+	testGetXAttrNotConfigured(suite)
+}
+
+func (suite *libfuseTestSuite) TestGetXAttr() {
+	testGetXAttr(suite)
+}
+
+func (suite *libfuseTestSuite) TestGetXAttrNotExists() {
+	testGetXAttrNotExists(suite)
+}
+
 // In order for 'go test' to run this suite, we need to create
 // a normal test function and pass our suite to suite.Run
 func TestLibfuseTestSuite(t *testing.T) {
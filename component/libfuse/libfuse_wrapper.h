@@ -90,6 +90,9 @@ static int populate_callbacks(fuse_operations_t *opt)
     opt->symlink    = (int (*)(const char *from, const char *to))libfuse_symlink;
     opt->readlink   = (int (*)(const char *path, char *buf, size_t size))libfuse_readlink;
 
+    opt->setxattr   = (int (*)(const char *path, const char *name, const char *value, size_t size, int flags))libfuse_setxattr;
+    opt->getxattr   = (int (*)(const char *path, const char *name, char *value, size_t size))libfuse_getxattr;
+
     opt->fsync      = (int (*)(const char *path, int, fuse_file_info_t *fi))libfuse_fsync;
     opt->fsyncdir   = (int (*)(const char *path, int, fuse_file_info_t *))libfuse_fsyncdir;
 
@@ -115,6 +115,9 @@ func (lfs *LoopbackFS) CreateDir(options internal.CreateDirOptions) error {
 
 func (lfs *LoopbackFS) DeleteDir(options internal.DeleteDirOptions) error {
 	log.Trace("LoopbackFS::DeleteDir : name=%s", options.Name)
+	if options.DryRun {
+		return syscall.ENOTSUP
+	}
 	dirPath := filepath.Join(lfs.path, options.Name)
 	return os.Remove(dirPath)
 }
@@ -223,6 +226,9 @@ func computeMD5(path string) ([]byte, error) {
 
 func (lfs *LoopbackFS) RenameDir(options internal.RenameDirOptions) error {
 	log.Trace("LoopbackFS::RenameDir : %s -> %s", options.Src, options.Dst)
+	if options.DryRun {
+		return syscall.ENOTSUP
+	}
 	oldPath := filepath.Join(lfs.path, options.Src)
 	newPath := filepath.Join(lfs.path, options.Dst)
 
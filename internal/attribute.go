@@ -34,12 +34,16 @@
 package internal
 
 import (
+	"errors"
 	"os"
 	"time"
 
 	"github.com/Azure/azure-storage-fuse/v2/common"
 )
 
+// ErrNotModified : Returned by GetAttr when IfNoneMatch is set and the object still has the same ETag
+var ErrNotModified = errors.New("object not modified")
+
 func NewDirBitMap() common.BitMap16 {
 	bm := common.BitMap16(0)
 	bm.Set(PropFlagIsDir)
@@ -65,6 +69,7 @@ const (
 	PropFlagEmptyDir
 	PropFlagSymlink
 	PropFlagModeDefault // TODO: Does this sound better as ModeDefault or DefaultMode? The getter would be IsModeDefault or IsDefaultMode
+	PropFlagDeleted
 )
 
 // ObjAttr : Attributes of any file/directory
@@ -98,3 +103,8 @@ func (attr *ObjAttr) IsSymlink() bool {
 func (attr *ObjAttr) IsModeDefault() bool {
 	return attr.Flags.IsSet(PropFlagModeDefault)
 }
+
+// IsDeleted : Test blob is soft-deleted, such blobs are listed only when asked for
+func (attr *ObjAttr) IsDeleted() bool {
+	return attr.Flags.IsSet(PropFlagDeleted)
+}
@@ -321,6 +321,20 @@ func (base *BaseComponent) Chown(options ChownOptions) error {
 	return nil
 }
 
+func (base *BaseComponent) SetXAttr(options SetXAttrOptions) error {
+	if base.next != nil {
+		return base.next.SetXAttr(options)
+	}
+	return nil
+}
+
+func (base *BaseComponent) GetXAttr(options GetXAttrOptions) ([]byte, error) {
+	if base.next != nil {
+		return base.next.GetXAttr(options)
+	}
+	return nil, nil
+}
+
 func (base *BaseComponent) FileUsed(name string) error {
 	if base.next != nil {
 		return base.next.FileUsed(name)
@@ -135,6 +135,11 @@ type Component interface {
 
 	Chmod(ChmodOptions) error
 	Chown(ChownOptions) error
+
+	// Extended attribute operations
+	SetXAttr(SetXAttrOptions) error
+	GetXAttr(GetXAttrOptions) ([]byte, error)
+
 	GetFileBlockOffsets(options GetFileBlockOffsetsOptions) (*common.BlockOffsetList, error)
 
 	FileUsed(name string) error
@@ -44,8 +44,16 @@ type CreateDirOptions struct {
 	Mode os.FileMode
 }
 
+// DirOpPreview : Paths a directory delete or rename affects, the directory itself included, and the bytes in them
+type DirOpPreview struct {
+	Paths      []string
+	TotalBytes int64
+}
+
 type DeleteDirOptions struct {
-	Name string
+	Name    string
+	DryRun  bool          // nothing is deleted, affected paths are enumerated into Preview instead
+	Preview *DirOpPreview // required with DryRun
 }
 
 type IsDirEmptyOptions struct {
@@ -72,13 +80,16 @@ type CloseDirOptions struct {
 }
 
 type RenameDirOptions struct {
-	Src string
-	Dst string
+	Src     string
+	Dst     string
+	DryRun  bool          // nothing is renamed, affected paths under Src are enumerated into Preview instead
+	Preview *DirOpPreview // required with DryRun
 }
 
 type CreateFileOptions struct {
-	Name string
-	Mode os.FileMode
+	Name        string
+	Mode        os.FileMode
+	ContentType string // content type to create the blob with, empty detects it from the name
 }
 
 type DeleteFileOptions struct {
@@ -113,12 +124,14 @@ type ReadInBufferOptions struct {
 	Data   []byte
 	Path   string
 	Size   int64
+
+	VersionID string // read this version of the blob instead of the current one
 }
 
 type WriteFileOptions struct {
 	Handle   *handlemap.Handle
 	Offset   int64
-	Data     []byte
+	Data     []byte // owned by the caller, components staging it after WriteFile returns must copy it first
 	Metadata map[string]*string
 }
 
@@ -132,21 +145,27 @@ type TruncateFileOptions struct {
 }
 
 type CopyToFileOptions struct {
-	Name   string
-	Offset int64
-	Count  int64
-	File   *os.File
+	Name        string
+	Offset      int64
+	Count       int64
+	File        *os.File
+	Concurrency uint16 // overrides the configured max-concurrency for this call, 0 means use configured value
+	VersionID   string // read this version of the blob instead of the current one
 }
 
 type CopyFromFileOptions struct {
-	Name     string
-	File     *os.File
-	Metadata map[string]*string
+	Name        string
+	File        *os.File
+	Metadata    map[string]*string
+	Concurrency uint16            // overrides the configured max-concurrency for this call, 0 means use configured value
+	Tags        map[string]string // blob index tags to be set along with the upload
+	ContentType string            // content type to upload the blob with, empty detects it from the name
 }
 
 type FlushFileOptions struct {
 	Handle          *handlemap.Handle
 	CloseInProgress bool
+	Concurrency     uint16 // overrides the configured max-concurrency for this call, 0 means use configured value
 }
 
 type SyncFileOptions struct {
@@ -175,9 +194,29 @@ type ReadLinkOptions struct {
 	Size int64
 }
 
+// Flags of SetXAttrOptions, same values as XATTR_CREATE and XATTR_REPLACE of setxattr(2)
+const (
+	XAttrCreate  = 1
+	XAttrReplace = 2
+)
+
+type SetXAttrOptions struct {
+	Name  string
+	Attr  string
+	Value []byte
+	Flags int // XAttrCreate fails if the attribute exists, XAttrReplace fails if it does not
+}
+
+type GetXAttrOptions struct {
+	Name string
+	Attr string
+}
+
 type GetAttrOptions struct {
 	Name             string
 	RetrieveMetadata bool
+	IfNoneMatch      string // ETag of the cached attributes, ErrNotModified is returned if object is unchanged
+	FollowSymlink    bool   // Return attributes of the link target, only one level of links is resolved
 }
 
 type SetAttrOptions struct {
@@ -204,10 +243,14 @@ type StageDataOptions struct {
 }
 
 type CommitDataOptions struct {
-	Name      string
-	List      []string
-	BlockSize uint64
-	NewETag   *string
+	Name        string
+	List        []string
+	BlockSize   uint64
+	NewETag     *string
+	Tags        map[string]string // blob index tags to be set in the same commit
+	Size        int64             // size of the file the list is expected to make up, empty list is refused when non zero
+	IfMatch     string            // commit only if the blob still has this etag, empty commits unconditionally
+	ContentType string            // content type to commit the blob with, empty detects it from the name
 }
 
 type CommittedBlock struct {
@@ -51,10 +51,11 @@ const InvalidHandleID HandleID = 0
 
 // Flags represented in BitMap for various flags in the handle
 const (
-	HandleFlagUnknown uint16 = iota
-	HandleFlagDirty          // File has been modified with write operation or is a new file
-	HandleFlagFSynced        // User has called fsync on the file explicitly
-	HandleFlagCached         // File is cached in the local system by blobfuse2
+	HandleFlagUnknown  uint16 = iota
+	HandleFlagDirty           // File has been modified with write operation or is a new file
+	HandleFlagFSynced         // User has called fsync on the file explicitly
+	HandleFlagCached          // File is cached in the local system by blobfuse2
+	HandleFlagReadOnly        // File was opened for reading only
 )
 
 // Structure to hold in memory cache for streaming layer
@@ -64,6 +65,7 @@ type Cache struct {
 	*common.BlockOffsetList
 	StreamOnly  bool
 	HandleCount int64
+	NextOffset  int64 // Offset the next read starts at when the handle is read sequentially
 }
 
 type Buffers struct {
@@ -115,6 +117,11 @@ func (handle *Handle) Cached() bool {
 	return handle.Flags.IsSet(HandleFlagCached)
 }
 
+// ReadOnly : File was opened for reading only or not
+func (handle *Handle) ReadOnly() bool {
+	return handle.Flags.IsSet(HandleFlagReadOnly)
+}
+
 // GetFileObject : Get the OS.File handle stored within
 func (handle *Handle) GetFileObject() *os.File {
 	return handle.FObj
@@ -183,6 +190,7 @@ func CreateCacheObject(capacity int64, handle *Handle) {
 		&common.BlockOffsetList{},
 		false,
 		0,
+		0,
 	}
 }
 
@@ -101,6 +101,35 @@ func (mr *MockComponentMockRecorder) Chown(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Chown", reflect.TypeOf((*MockComponent)(nil).Chown), arg0)
 }
 
+// SetXAttr mocks base method.
+func (m *MockComponent) SetXAttr(arg0 SetXAttrOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetXAttr", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetXAttr indicates an expected call of SetXAttr.
+func (mr *MockComponentMockRecorder) SetXAttr(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetXAttr", reflect.TypeOf((*MockComponent)(nil).SetXAttr), arg0)
+}
+
+// GetXAttr mocks base method.
+func (m *MockComponent) GetXAttr(arg0 GetXAttrOptions) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetXAttr", arg0)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetXAttr indicates an expected call of GetXAttr.
+func (mr *MockComponentMockRecorder) GetXAttr(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetXAttr", reflect.TypeOf((*MockComponent)(nil).GetXAttr), arg0)
+}
+
 // CloseDir mocks base method.
 func (m *MockComponent) CloseDir(arg0 CloseDirOptions) error {
 	m.ctrl.T.Helper()